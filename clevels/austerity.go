@@ -2,18 +2,30 @@ package clevels
 
 import (
 	"errors"
+	"fmt"
 	"io"
-	"io/ioutil"
+	"math"
 	"os"
+	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/DataDog/datadog-go/statsd"
 	"github.com/stripe/unilog/json"
 )
 
-var Stats *statsd.Client
+// StatsClient is the part of a statsd client (such as
+// *statsd.Client) that clevels uses.
+type StatsClient interface {
+	Count(name string, value int64, tags []string, rate float64) error
+	Gauge(name string, value float64, tags []string, rate float64) error
+}
+
+// Stats is the statsd client that austerity metrics are reported to.
+// If it is nil, no metrics are reported.
+var Stats StatsClient
 
 //go:generate stringer -type=AusterityLevel
 type AusterityLevel int
@@ -78,20 +90,49 @@ var SystemAusterityLevel = make(chan AusterityLevel, AusterityBuffer)
 // system austerity level.
 var AusterityFile string
 
+// EmergencyAusterityFile is the full path to an optional file that
+// operators can write an "emergency" austerity level to. The system
+// austerity level is the higher of this level and the one in
+// AusterityFile, so the emergency level can only ever increase
+// austerity. If the file is unset, missing or invalid, it has no
+// effect.
+var EmergencyAusterityFile string
+
 var InvalidAusterityLevel = errors.New("Invalid austerity level")
 
 // LoadLevel loads the AusterityFile and parses it to determine
 // the system austerity level. If it encounters an error, it will
 // return the DefaultAusterity.
 func LoadLevel() (AusterityLevel, error) {
-	f, err := os.Open(AusterityFile)
+	level, _, err := loadLevelFile(AusterityFile)
+	return level, err
+}
+
+// LoadEmergencyLevel loads the EmergencyAusterityFile. If there is
+// no emergency level, it returns Sheddable (the lowest level).
+func LoadEmergencyLevel() AusterityLevel {
+	level, _ := loadEmergencyLevel()
+	return level
+}
+
+func loadEmergencyLevel() (AusterityLevel, KeepRates) {
+	if EmergencyAusterityFile == "" {
+		return Sheddable, nil
+	}
+	level, rates, err := loadLevelFile(EmergencyAusterityFile)
+	if err != nil {
+		return Sheddable, nil
+	}
+	return level, rates
+}
+
+func loadLevelFile(path string) (AusterityLevel, KeepRates, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return DefaultAusterity, err
+		return DefaultAusterity, nil, err
 	}
 	defer f.Close()
-	level, err := ParseLevel(f)
-
-	return level, err
+	return ParseLevelTable(f)
 }
 
 var canonicalRegex = regexp.MustCompile(`(?i)CANONICAL-[-\w]+?-LINE`)
@@ -100,6 +141,39 @@ var cLevelChalkRegex = regexp.MustCompile(`\sclevel=(\w+?)\b`)
 
 var clevelRegexes = []*regexp.Regexp{cLevelRegex, cLevelChalkRegex}
 
+// customClevelRegexes are the patterns added with
+// AddCriticalityPattern. Unlike the built-in ones, they can match
+// numeric levels.
+var customClevelRegexes []*regexp.Regexp
+
+// AddCriticalityPattern adds a regular expression for Criticality to
+// find a text line's criticality level with, after the built-in
+// "[clevel: word]" and " clevel=word" ones. The pattern's first capture
+// group must match the level, either by name (like "critical") or as a
+// number from 0 (Sheddable) to 3 (CriticalPlus); for example,
+// `\bpri=(\d)\b`. (The built-in patterns only match levels by name.)
+//
+// AddCriticalityPattern isn't safe to call concurrently with
+// Criticality, so patterns should be added at startup.
+func AddCriticalityPattern(pattern string) error {
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	if r.NumSubexp() < 1 {
+		return fmt.Errorf("criticality pattern %q has no capture group", pattern)
+	}
+	customClevelRegexes = append(customClevelRegexes, r)
+	return nil
+}
+
+// FindCanonical returns the location of the marker of a canonical line
+// (such as CANONICAL-API-LINE) in line, as a pair of indexes like
+// regexp.FindStringIndex, or nil if line isn't a canonical line.
+func FindCanonical(line string) []int {
+	return canonicalRegex.FindStringIndex(line)
+}
+
 // criticality parses the criticality level
 // of a log line. Defaults to the value of DefaultCriticality.
 func Criticality(line string) AusterityLevel {
@@ -109,25 +183,44 @@ func Criticality(line string) AusterityLevel {
 	}
 
 	for _, r := range clevelRegexes {
-		matches := r.FindStringSubmatch(line)
-		if len(matches) < 2 {
-			continue
+		if level, ok := matchLevel(r, line, false); ok {
+			return level
 		}
-
-		level, err := ParseLevel(strings.NewReader(matches[1]))
-		// we don't really care about any error here
-		// and want to default to not dropping anything
-		if err != nil {
-			continue
+	}
+	for _, r := range customClevelRegexes {
+		if level, ok := matchLevel(r, line, true); ok {
+			return level
 		}
-		// if we've made it this far, we've succesfully parsed
-		// the clevel
-		return level
 	}
 
 	return DefaultCriticality
 }
 
+// matchLevel returns the level that r's first capture group matches
+// in line, if r matches and that's a level name (or, if numeric is
+// set, a number).
+func matchLevel(r *regexp.Regexp, line string, numeric bool) (AusterityLevel, bool) {
+	matches := r.FindStringSubmatch(line)
+	if len(matches) < 2 {
+		return 0, false
+	}
+
+	level, err := ParseLevel(strings.NewReader(matches[1]))
+	if err == nil {
+		return level, true
+	}
+	if !numeric {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(matches[1], 64)
+	// we don't really care about any error here
+	// and want to default to not dropping anything
+	if err != nil {
+		return 0, false
+	}
+	return numericLevel(n)
+}
+
 func JSONCriticality(line json.LogLine) AusterityLevel {
 	// Never drop JSON log lines declaring themselves canonical:
 	if canonicalI, ok := line["canonical"]; ok {
@@ -137,24 +230,59 @@ func JSONCriticality(line json.LogLine) AusterityLevel {
 	}
 
 	if clevelI, ok := line["clevel"]; ok {
-		if clevel, ok := clevelI.(string); ok {
+		switch clevel := clevelI.(type) {
+		case string:
 			level, err := ParseLevel(strings.NewReader(clevel))
-			if err == nil {
+			if err != nil {
 				return DefaultCriticality
 			}
 			return level
+		case float64:
+			// JSON numbers decode as float64
+			if level, ok := numericLevel(clevel); ok {
+				return level
+			}
+		case int:
+			level, _ := numericLevel(float64(clevel))
+			return level
 		}
 	}
 	return DefaultCriticality
 }
 
-func ParseLevel(r io.Reader) (AusterityLevel, error) {
-	bts, err := ioutil.ReadAll(r)
-	if err != nil {
-		return DefaultAusterity, err
+// numericLevel converts a numeric clevel (0 for Sheddable through 3
+// for CriticalPlus) to an AusterityLevel. Fractional values are rounded
+// to the nearest integer, with halves rounded up (so 2.5 is
+// CriticalPlus); out-of-range values are then clamped to the nearest
+// valid level, and counted in the unilog.clevel.clamped metric. It
+// returns false for NaN and infinities, which aren't levels at all.
+func numericLevel(n float64) (AusterityLevel, bool) {
+	if math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0, false
 	}
+	rounded := math.Round(n)
+	level := AusterityLevel(rounded)
+	if rounded < float64(Sheddable) {
+		level = Sheddable
+	} else if rounded > float64(CriticalPlus) {
+		level = CriticalPlus
+	}
+	if float64(level) != rounded && Stats != nil {
+		Stats.Count("unilog.clevel.clamped", 1, nil, 1)
+	}
+	return level, true
+}
 
-	level := strings.ToLower(strings.TrimSpace(string(bts)))
+// ParseLevel parses an austerity level name (case-insensitively). It
+// also accepts the contents of an austerity file with a keep-rate
+// table (see ParseLevelTable), and returns just its level.
+func ParseLevel(r io.Reader) (AusterityLevel, error) {
+	level, _, err := ParseLevelTable(r)
+	return level, err
+}
+
+func parseLevelName(name string) (AusterityLevel, error) {
+	level := strings.ToLower(strings.TrimSpace(name))
 	switch level {
 	case strings.ToLower(Sheddable.String()):
 		return Sheddable, nil
@@ -168,6 +296,26 @@ func ParseLevel(r io.Reader) (AusterityLevel, error) {
 	return DefaultAusterity, InvalidAusterityLevel
 }
 
+// SamplingRate returns the fraction of lines at the given criticality
+// level that are kept at the given austerity level. Lines at or above
+// the austerity level are always kept; below it, each level of
+// difference keeps ten times fewer lines:
+//
+//	                       criticality
+//	austerity      Sheddable  SheddablePlus  Critical  CriticalPlus
+//	Sheddable      1          1              1         1
+//	SheddablePlus  0.1        1              1         1
+//	Critical       0.01       0.1            1         1
+//	CriticalPlus   0.001      0.01           0.1       1
+func SamplingRate(austerityLevel, criticalityLevel AusterityLevel) float64 {
+	if criticalityLevel >= austerityLevel {
+		return 1
+	}
+
+	levelDiff := austerityLevel - criticalityLevel
+	return math.Pow(10, float64(-levelDiff))
+}
+
 func reportLoadStatus(err error) {
 	if Stats != nil {
 		metric := 0
@@ -178,49 +326,129 @@ func reportLoadStatus(err error) {
 	}
 }
 
+// SendSystemAusterityLevel continuously sends the system austerity
+// level on SystemAusterityLevel, reloading it from AusterityFile every
+// CacheInterval.
 func SendSystemAusterityLevel() {
+	sendAusterityLevels(time.Tick(CacheInterval), SystemAusterityLevel, ReportAusterity)
+}
+
+// sendAusterityLevels continuously sends the current austerity level
+// on levels, reloading it from AusterityFile (and
+// EmergencyAusterityFile) whenever reload
+// fires, until reload is closed. Each reload takes effect before the
+// next level is sent, so tests can drive reloads deterministically.
+// The level is passed to report (ReportAusterity, outside of tests)
+// after the first reload, and then whenever it (or its keep rates)
+// changes.
+func sendAusterityLevels(reload <-chan time.Time, levels chan<- AusterityLevel, report func(AusterityLevel)) {
 	// This is the cached austerity level
 	// that will be sent anytime Unilog requests the austerity level,
 	// so that there is never any delay.
 	// By default, there is no austerity.
 	var currentLevel = Sheddable
-
-	// shadow this variable so we can override it in tests, if needed.
-	// multiple goroutines write to SystemAusterityLevel in tests, so this shadowing
-	// gives us the ability to separate the channels in tests.
-	var _SystemAusterityLevel = SystemAusterityLevel
-
-	newLevelCh := make(chan AusterityLevel)
-	go func(updatedLevel chan<- AusterityLevel) {
-		c := time.Tick(CacheInterval)
-		for _ = range c {
-			l, err := LoadLevel()
-			reportLoadStatus(err)
-			if err != nil {
-				continue
-			}
-			updatedLevel <- l
-		}
-	}(newLevelCh)
+	// The last level (and keep rates) successfully loaded from
+	// AusterityFile
+	var fileLevel = Sheddable
+	var fileRates KeepRates
+	// The keep rates last reported, and whether anything has been:
+	// the gauges are only reported again when the level or its keep
+	// rates change
+	var reported bool
+	var reportedRates KeepRates
 
 	// Loop forever on this
 	for {
 		// This select statement will be on a hotpath
-		// so it should never block for long
+		// so it should never block for long. Reloading only
+		// reads a tiny file, and levels is buffered, so
+		// readers won't notice it.
 		select {
 		// Continuously send the current austerity level
 		// to whoever asks for it
-		case _SystemAusterityLevel <- currentLevel:
+		case levels <- currentLevel:
 
-		case newLevel := <-newLevelCh:
-			go ReportAusterity(newLevel)
+		case _, ok := <-reload:
+			if !ok {
+				return
+			}
+			l, lr, err := loadLevelFile(AusterityFile)
+			reportLoadStatus(err)
+			if err == nil {
+				fileLevel, fileRates = l, lr
+			}
+			emergencyLevel, emergencyRates := loadEmergencyLevel()
+			newLevel := mergeLevels(fileLevel, emergencyLevel)
+			// The keep rates come from whichever file set the
+			// level
+			rates := emergencyRates
+			if newLevel == fileLevel {
+				rates = fileRates
+			}
+			setKeepRates(newLevel, rates)
+			if err != nil && newLevel == currentLevel {
+				continue
+			}
+			if !reported || newLevel != currentLevel || !reflect.DeepEqual(rates, reportedRates) {
+				go report(newLevel)
+				reported, reportedRates = true, rates
+			}
+			if newLevel != currentLevel {
+				notifyLevelChange(currentLevel, newLevel)
+			}
 			currentLevel = newLevel
 		}
 	}
 }
 
+var (
+	levelChangeMtx       sync.Mutex
+	levelChangeCallbacks []func(old, new AusterityLevel)
+)
+
+// OnLevelChange registers f to be called whenever the system austerity
+// level changes, with the old and the new level. This lets embedders
+// observe transitions without reading from SystemAusterityLevel, which
+// the shedding code consumes.
+//
+// Callbacks run in their own goroutine, so they never hold up the
+// austerity level from being sent; as a result, they may run
+// concurrently with each other and out of order.
+func OnLevelChange(f func(old, new AusterityLevel)) {
+	levelChangeMtx.Lock()
+	defer levelChangeMtx.Unlock()
+	levelChangeCallbacks = append(levelChangeCallbacks, f)
+}
+
+func notifyLevelChange(old, new AusterityLevel) {
+	levelChangeMtx.Lock()
+	defer levelChangeMtx.Unlock()
+	for _, f := range levelChangeCallbacks {
+		go f(old, new)
+	}
+}
+
+// mergeLevels returns the more austere of the file-derived and
+// emergency austerity levels.
+func mergeLevels(fileLevel, emergencyLevel AusterityLevel) AusterityLevel {
+	if emergencyLevel > fileLevel {
+		return emergencyLevel
+	}
+	return fileLevel
+}
+
 func ReportAusterity(l AusterityLevel) {
 	if Stats != nil {
 		Stats.Gauge("unilog.austerity.box", float64(l), nil, 1)
+		reportSamplingRates(Stats.Gauge, l)
+	}
+}
+
+// reportSamplingRates reports the fraction of lines that will be kept
+// at austerity level l, for each criticality level.
+func reportSamplingRates(gauge func(name string, value float64, tags []string, rate float64) error, l AusterityLevel) {
+	for c := Sheddable; c <= CriticalPlus; c++ {
+		tags := []string{"criticality:" + strings.ToLower(c.String())}
+		gauge("unilog.sampling_rate", KeepRate(l, c), tags, 1)
 	}
 }
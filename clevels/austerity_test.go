@@ -1,13 +1,21 @@
 package clevels
 
 import (
+	encjson "encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/unilog/json"
 )
 
 func TestParseAusterityLevel(t *testing.T) {
@@ -88,6 +96,199 @@ func TestLoadLevelError(t *testing.T) {
 	}
 }
 
+func TestReloadAusterityLevel(t *testing.T) {
+	f, err := ioutil.TempFile("", "austerity")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	f.Close()
+
+	oldFile := AusterityFile
+	AusterityFile = f.Name()
+	defer func() { AusterityFile = oldFile }()
+
+	reload := make(chan time.Time)
+	defer close(reload)
+	levels := make(chan AusterityLevel)
+	go sendAusterityLevels(reload, levels, ReportAusterity)
+
+	// Before the first reload, there is no austerity
+	assert.Equal(t, Sheddable, <-levels)
+
+	require.NoError(t, ioutil.WriteFile(f.Name(), []byte("critical\n"), 0644))
+	// Nothing changes until the file is reloaded...
+	assert.Equal(t, Sheddable, <-levels)
+	// ...and after exactly one reload, the new level is in effect
+	reload <- time.Now()
+	assert.Equal(t, Critical, <-levels)
+	assert.Equal(t, Critical, <-levels)
+
+	// An unparseable file leaves the level unchanged
+	require.NoError(t, ioutil.WriteFile(f.Name(), []byte("sleddable\n"), 0644))
+	reload <- time.Now()
+	assert.Equal(t, Critical, <-levels)
+}
+
+func TestOnLevelChange(t *testing.T) {
+	f, err := ioutil.TempFile("", "austerity")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	f.Close()
+
+	oldFile := AusterityFile
+	AusterityFile = f.Name()
+	defer func() { AusterityFile = oldFile }()
+
+	type change struct{ old, new AusterityLevel }
+	changes := make(chan change, 10)
+	OnLevelChange(func(old, new AusterityLevel) { changes <- change{old, new} })
+	defer func() { levelChangeCallbacks = nil }()
+
+	reload := make(chan time.Time)
+	defer close(reload)
+	levels := make(chan AusterityLevel)
+	go sendAusterityLevels(reload, levels, ReportAusterity)
+
+	require.NoError(t, ioutil.WriteFile(f.Name(), []byte("critical\n"), 0644))
+	reload <- time.Now()
+	assert.Equal(t, Critical, <-levels)
+	assert.Equal(t, change{Sheddable, Critical}, <-changes)
+
+	// Reloading the same level isn't a change
+	reload <- time.Now()
+	require.NoError(t, ioutil.WriteFile(f.Name(), []byte("sheddableplus\n"), 0644))
+	reload <- time.Now()
+	assert.Equal(t, SheddablePlus, <-levels)
+	assert.Equal(t, change{Critical, SheddablePlus}, <-changes)
+
+	select {
+	case c := <-changes:
+		t.Errorf("unexpected change %v", c)
+	default:
+	}
+}
+
+func TestReloadReportsChanges(t *testing.T) {
+	f, err := ioutil.TempFile("", "austerity")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	f.Close()
+
+	oldFile := AusterityFile
+	AusterityFile = f.Name()
+	defer func() { AusterityFile = oldFile }()
+
+	reload := make(chan time.Time)
+	defer close(reload)
+	levels := make(chan AusterityLevel)
+	reports := make(chan AusterityLevel, 10)
+	go sendAusterityLevels(reload, levels, func(l AusterityLevel) { reports <- l })
+	next := func() AusterityLevel {
+		select {
+		case l := <-reports:
+			return l
+		case <-time.After(5 * time.Second):
+			t.Fatal("austerity level not reported")
+			return Sheddable
+		}
+	}
+
+	// The first reload is reported, even without a change
+	require.NoError(t, ioutil.WriteFile(f.Name(), []byte("sheddable\n"), 0644))
+	reload <- time.Now()
+	assert.Equal(t, Sheddable, next())
+
+	require.NoError(t, ioutil.WriteFile(f.Name(), []byte("critical\n"), 0644))
+	reload <- time.Now()
+	assert.Equal(t, Critical, next())
+
+	// Reloading the same level isn't reported again...
+	reload <- time.Now()
+	reload <- time.Now()
+	// ...unless its keep rates changed
+	require.NoError(t, ioutil.WriteFile(f.Name(), []byte("critical\nsheddable=0.5\n"), 0644))
+	reload <- time.Now()
+	assert.Equal(t, Critical, next())
+	reload <- time.Now()
+
+	require.NoError(t, ioutil.WriteFile(f.Name(), []byte("sheddableplus\n"), 0644))
+	reload <- time.Now()
+	assert.Equal(t, SheddablePlus, next())
+	assert.Equal(t, SheddablePlus, <-levels)
+	select {
+	case l := <-reports:
+		t.Errorf("unexpected report of %v", l)
+	default:
+	}
+}
+
+func TestReportSamplingRates(t *testing.T) {
+	rates := map[string]float64{}
+	gauge := func(name string, value float64, tags []string, rate float64) error {
+		assert.Equal(t, "unilog.sampling_rate", name)
+		rates[strings.Join(tags, ",")] = value
+		return nil
+	}
+	reportSamplingRates(gauge, Critical)
+	assert.Equal(t, map[string]float64{
+		"criticality:sheddable":     0.01,
+		"criticality:sheddableplus": 0.1,
+		"criticality:critical":      1,
+		"criticality:criticalplus":  1,
+	}, rates)
+}
+
+func TestMergeLevels(t *testing.T) {
+	levels := []AusterityLevel{Sheddable, SheddablePlus, Critical, CriticalPlus}
+	for _, file := range levels {
+		for _, emergency := range levels {
+			merged := mergeLevels(file, emergency)
+			assert.True(t, merged >= file && merged >= emergency)
+			assert.True(t, merged == file || merged == emergency)
+		}
+	}
+}
+
+func TestEmergencyAusterityLevel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "austerity")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	oldFile, oldEmergency := AusterityFile, EmergencyAusterityFile
+	AusterityFile = filepath.Join(dir, "austerity")
+	EmergencyAusterityFile = filepath.Join(dir, "emergency")
+	defer func() { AusterityFile, EmergencyAusterityFile = oldFile, oldEmergency }()
+
+	reload := make(chan time.Time)
+	defer close(reload)
+	levels := make(chan AusterityLevel)
+	go sendAusterityLevels(reload, levels, ReportAusterity)
+
+	cases := []struct {
+		file, emergency string
+		expected        AusterityLevel
+	}{
+		// A missing emergency file has no effect
+		{"sheddableplus", "", SheddablePlus},
+		// The emergency level can raise austerity...
+		{"sheddableplus", "critical", Critical},
+		// ...but never lower it
+		{"criticalplus", "sheddable", CriticalPlus},
+		// Even if the austerity file is stale/broken
+		{"bogus", "sheddable", CriticalPlus},
+		{"bogus", "", CriticalPlus},
+		{"sheddable", "bogus", Sheddable},
+	}
+	for _, tc := range cases {
+		require.NoError(t, ioutil.WriteFile(AusterityFile, []byte(tc.file), 0644))
+		os.Remove(EmergencyAusterityFile)
+		if tc.emergency != "" {
+			require.NoError(t, ioutil.WriteFile(EmergencyAusterityFile, []byte(tc.emergency), 0644))
+		}
+		reload <- time.Now()
+		assert.Equal(t, tc.expected, <-levels, "file=%q emergency=%q", tc.file, tc.emergency)
+	}
+}
+
 func TestCriticality(t *testing.T) {
 	type CriticalityTestCase struct {
 		name  string
@@ -159,3 +360,100 @@ func TestCriticality(t *testing.T) {
 		})
 	}
 }
+
+// String clevels used to be read the wrong way around: a valid name
+// got DefaultCriticality, and an invalid one whatever ParseLevel
+// returned with its error. They now get the level they name.
+func TestJSONCriticalityString(t *testing.T) {
+	assert.Equal(t, Sheddable, JSONCriticality(json.LogLine{"clevel": "sheddable"}))
+	assert.Equal(t, Critical, JSONCriticality(json.LogLine{"clevel": "critical"}))
+	assert.Equal(t, CriticalPlus, JSONCriticality(json.LogLine{"clevel": "criticalplus"}))
+	assert.Equal(t, DefaultCriticality, JSONCriticality(json.LogLine{"clevel": "sleddable"}))
+}
+
+func TestAddCriticalityPattern(t *testing.T) {
+	defer func(old []*regexp.Regexp) { customClevelRegexes = old }(customClevelRegexes)
+
+	assert.Error(t, AddCriticalityPattern(`severity=(\w+`))
+	assert.Error(t, AddCriticalityPattern(`severity=\w+`))
+	require.NoError(t, AddCriticalityPattern(`\bseverity=(\w+)`))
+	require.NoError(t, AddCriticalityPattern(`\bpri=(\S+)`))
+
+	cases := []struct {
+		line  string
+		level AusterityLevel
+	}{
+		{"charge failed severity=critical", Critical},
+		{"charge failed severity=Sheddable", Sheddable},
+		{"charge failed pri=3", CriticalPlus},
+		{"charge failed pri=0", Sheddable},
+		{"charge failed pri=9", CriticalPlus},
+		{"charge failed pri=urgent", DefaultCriticality},
+		{"charge failed pri=nan", DefaultCriticality},
+		{"charge failed pri=-Inf", DefaultCriticality},
+		// The built-in patterns come first
+		{"charge failed clevel=sheddable severity=critical", Sheddable},
+		// An unparseable level falls through to the next pattern
+		{"charge failed severity=high pri=2", Critical},
+		{"charge failed", DefaultCriticality},
+		// The built-in patterns still only match level names
+		{"charge failed [clevel: 3]", DefaultCriticality},
+		{"charge failed clevel=0", DefaultCriticality},
+		{"charge failed clevel=0 pri=3", CriticalPlus},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.level.String(), Criticality(tc.line).String(), tc.line)
+	}
+}
+
+func TestJSONCriticality(t *testing.T) {
+	cases := []struct {
+		name  string
+		line  string
+		level AusterityLevel
+	}{
+		{"no clevel", `{"message":"hi"}`, DefaultCriticality},
+		{"integer", `{"clevel":0}`, Sheddable},
+		{"integer criticalplus", `{"clevel":3}`, CriticalPlus},
+		{"float", `{"clevel":2.0}`, Critical},
+		{"fraction rounds down", `{"clevel":1.4}`, SheddablePlus},
+		{"fraction rounds up", `{"clevel":1.6}`, Critical},
+		{"half rounds up", `{"clevel":2.5}`, CriticalPlus},
+		{"rounds into range", `{"clevel":3.4}`, CriticalPlus},
+		{"rounds into range low", `{"clevel":-0.4}`, Sheddable},
+		{"huge", `{"clevel":1e300}`, CriticalPlus},
+		{"too high", `{"clevel":7}`, CriticalPlus},
+		{"too low", `{"clevel":-1}`, Sheddable},
+		{"canonical", `{"clevel":0,"canonical":true}`, CriticalPlus},
+		{"wrong type", `{"clevel":true}`, DefaultCriticality},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var line json.LogLine
+			require.NoError(t, encjson.Unmarshal([]byte(tc.line), &line))
+			assert.Equal(t, tc.level.String(), JSONCriticality(line).String())
+		})
+	}
+
+	assert.Equal(t, Critical, JSONCriticality(json.LogLine{"clevel": 2}))
+	assert.Equal(t, DefaultCriticality, JSONCriticality(json.LogLine{"clevel": math.NaN()}))
+}
+
+func TestSamplingRate(t *testing.T) {
+	levels := []AusterityLevel{Sheddable, SheddablePlus, Critical, CriticalPlus}
+	// expected[austerity][criticality]
+	expected := [][]float64{
+		{1, 1, 1, 1},
+		{0.1, 1, 1, 1},
+		{0.01, 0.1, 1, 1},
+		{0.001, 0.01, 0.1, 1},
+	}
+	for _, austerity := range levels {
+		for _, criticality := range levels {
+			name := fmt.Sprintf("%s/%s", austerity, criticality)
+			t.Run(name, func(t *testing.T) {
+				assert.Equal(t, expected[austerity][criticality], SamplingRate(austerity, criticality))
+			})
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package clevels
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// KeepRates maps criticality levels to the fraction of their lines
+// (from 0 to 1) to keep, overriding SamplingRate's curve.
+type KeepRates map[AusterityLevel]float64
+
+// ParseLevelTable parses the contents of an austerity file. The first
+// line is the austerity level, which may optionally be followed by a
+// keep-rate table, one criticality=rate line per criticality level.
+// For example,
+//
+//	critical
+//	sheddable=0
+//	sheddableplus=1
+//
+// sheds all sheddable lines while keeping all sheddableplus ones;
+// criticality levels that aren't listed (here, critical and
+// criticalplus) are kept according to SamplingRate, as if there was
+// no table. criticalplus lines are never shed, so the table can't
+// have a rate for them. Blank lines are ignored. If there is no table,
+// the returned KeepRates is nil.
+func ParseLevelTable(r io.Reader) (AusterityLevel, KeepRates, error) {
+	bts, err := ioutil.ReadAll(r)
+	if err != nil {
+		return DefaultAusterity, nil, err
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(bts), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return DefaultAusterity, nil, InvalidAusterityLevel
+	}
+	level, err := parseLevelName(lines[0])
+	if err != nil {
+		return DefaultAusterity, nil, err
+	}
+	if len(lines) == 1 {
+		return level, nil, nil
+	}
+
+	rates := make(KeepRates, len(lines)-1)
+	for _, line := range lines[1:] {
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return DefaultAusterity, nil, fmt.Errorf("%w: keep rate %q is not criticality=rate", InvalidAusterityLevel, line)
+		}
+		criticality, err := parseLevelName(kv[0])
+		if err != nil {
+			return DefaultAusterity, nil, fmt.Errorf("%w: unknown criticality in keep rate %q", InvalidAusterityLevel, line)
+		}
+		if criticality == CriticalPlus {
+			return DefaultAusterity, nil, fmt.Errorf("%w: criticalplus lines are never shed, so keep rate %q isn't allowed", InvalidAusterityLevel, line)
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return DefaultAusterity, nil, fmt.Errorf("%w: keep rate %q is not between 0 and 1", InvalidAusterityLevel, line)
+		}
+		rates[criticality] = rate
+	}
+	return level, rates, nil
+}
+
+// keepRates is the keep-rate table of the current system austerity
+// level, if any.
+type keepRates struct {
+	level AusterityLevel
+	rates KeepRates
+}
+
+var currentKeepRates atomic.Value
+
+func setKeepRates(level AusterityLevel, rates KeepRates) {
+	currentKeepRates.Store(keepRates{level, rates})
+}
+
+// KeepRate returns the fraction of lines at the given criticality
+// level that are kept at the given austerity level. That's the rate
+// in the keep-rate table loaded with the system austerity level, if
+// austerityLevel is that level and the table has a rate for
+// criticalityLevel, and SamplingRate otherwise.
+func KeepRate(austerityLevel, criticalityLevel AusterityLevel) float64 {
+	if kr, ok := currentKeepRates.Load().(keepRates); ok && kr.level == austerityLevel {
+		if rate, ok := kr.rates[criticalityLevel]; ok {
+			return rate
+		}
+	}
+	return SamplingRate(austerityLevel, criticalityLevel)
+}
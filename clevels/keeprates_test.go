@@ -0,0 +1,106 @@
+package clevels
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLevelTable(t *testing.T) {
+	cases := []struct {
+		name     string
+		contents string
+		level    AusterityLevel
+		rates    KeepRates
+		err      bool
+	}{
+		{"level only", "critical\n", Critical, nil, false},
+		{"table", "critical\nsheddable=0\nSheddablePlus = 1\n", Critical, KeepRates{Sheddable: 0, SheddablePlus: 1}, false},
+		{"blank lines", "\ncriticalplus\n\ncritical=0.5\n\n", CriticalPlus, KeepRates{Critical: .5}, false},
+		{"empty", "", Sheddable, nil, true},
+		{"table without level", "sheddable=0\n", Sheddable, nil, true},
+		{"unknown criticality", "critical\nsleddable=0\n", Sheddable, nil, true},
+		{"no rate", "critical\nsheddable\n", Sheddable, nil, true},
+		{"rate too high", "critical\nsheddable=2\n", Sheddable, nil, true},
+		{"negative rate", "critical\nsheddable=-0.5\n", Sheddable, nil, true},
+		{"rate not a number", "critical\nsheddable=half\n", Sheddable, nil, true},
+		{"criticalplus rate", "critical\ncriticalplus=0\n", Sheddable, nil, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			level, rates, err := ParseLevelTable(strings.NewReader(tc.contents))
+			if tc.err {
+				assert.True(t, errors.Is(err, InvalidAusterityLevel), "got %v", err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.level, level)
+			assert.Equal(t, tc.rates, rates)
+
+			// ParseLevel accepts the same files
+			level, err = ParseLevel(strings.NewReader(tc.contents))
+			assert.Equal(t, tc.err, err != nil)
+			assert.Equal(t, tc.level, level)
+		})
+	}
+}
+
+func TestKeepRate(t *testing.T) {
+	defer setKeepRates(Sheddable, nil)
+
+	assert.Equal(t, 0.01, KeepRate(Critical, Sheddable))
+
+	setKeepRates(Critical, KeepRates{Sheddable: 0, SheddablePlus: 1})
+	assert.Equal(t, 0.0, KeepRate(Critical, Sheddable))
+	assert.Equal(t, 1.0, KeepRate(Critical, SheddablePlus))
+	// Levels without a rate follow the curve
+	assert.Equal(t, 1.0, KeepRate(Critical, CriticalPlus))
+	// The table only applies to the level it was loaded with
+	assert.Equal(t, 0.001, KeepRate(CriticalPlus, Sheddable))
+}
+
+func TestReloadKeepRates(t *testing.T) {
+	defer setKeepRates(Sheddable, nil)
+	f, err := ioutil.TempFile("", "austerity")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	f.Close()
+	e, err := ioutil.TempFile("", "emergency")
+	require.NoError(t, err)
+	defer os.Remove(e.Name())
+	e.Close()
+
+	oldFile, oldEmergency := AusterityFile, EmergencyAusterityFile
+	AusterityFile, EmergencyAusterityFile = f.Name(), e.Name()
+	defer func() { AusterityFile, EmergencyAusterityFile = oldFile, oldEmergency }()
+
+	reload := make(chan time.Time)
+	defer close(reload)
+	levels := make(chan AusterityLevel)
+	go sendAusterityLevels(reload, levels, ReportAusterity)
+
+	require.NoError(t, ioutil.WriteFile(f.Name(), []byte("critical\nsheddable=0\nsheddableplus=1\n"), 0644))
+	reload <- time.Now()
+	assert.Equal(t, Critical, <-levels)
+	assert.Equal(t, 0.0, KeepRate(Critical, Sheddable))
+	assert.Equal(t, 1.0, KeepRate(Critical, SheddablePlus))
+
+	// Changing just the table takes effect too
+	require.NoError(t, ioutil.WriteFile(f.Name(), []byte("critical\nsheddableplus=0.5\n"), 0644))
+	reload <- time.Now()
+	assert.Equal(t, Critical, <-levels)
+	assert.Equal(t, 0.01, KeepRate(Critical, Sheddable))
+	assert.Equal(t, 0.5, KeepRate(Critical, SheddablePlus))
+
+	// A higher emergency level brings its own table (or none)
+	require.NoError(t, ioutil.WriteFile(e.Name(), []byte("criticalplus\n"), 0644))
+	reload <- time.Now()
+	assert.Equal(t, CriticalPlus, <-levels)
+	assert.Equal(t, 0.01, KeepRate(CriticalPlus, SheddablePlus))
+}
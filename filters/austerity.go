@@ -1,12 +1,15 @@
 package filters
 
 import (
-	"math"
 	"math/rand"
+	"strings"
 	"sync"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/stripe/unilog/clevels"
 	"github.com/stripe/unilog/json"
+	flag "launchpad.net/gnuflag"
 )
 
 var startSystemAusterityLevel sync.Once
@@ -17,7 +20,34 @@ var startSystemAusterityLevel sync.Once
 // "shedded"=true attribude in JSON mode.
 //
 // Shedding log lines retains their time stamps.
-type AusterityFilter struct{}
+//
+// Lines from services in NeverShedServices are never shed. In JSON
+// mode, a line's service is its "service" field; in text mode, a
+// line matches if it contains one of the services as a whole word (so
+// "api" matches "service=api" but not "rapid").
+//
+// Lines can be shed more (or less) aggressively than the system
+// austerity level calls for, depending on their category: a line's
+// category is its CategoryField field in JSON mode, or the value of a
+// CategoryField=value word in text mode, and CategoryOffsets maps
+// categories to an offset added to the austerity level for their
+// lines. An offset of 1 sheds lines as if the austerity level was one
+// higher, so a category with offset 1 is shed 10 times as much. Lines
+// in categories without an offset are shed as usual, and the
+// effective austerity level never exceeds CriticalPlus, so
+// criticalplus lines are never shed.
+type AusterityFilter struct {
+	NeverShedServices []string
+	CategoryField     string
+	CategoryOffsets   map[string]int
+}
+
+// AddFlags adds austerity related flags to the CLI options
+func (a *AusterityFilter) AddFlags() {
+	flag.Var((*stringList)(&a.NeverShedServices), "never-shed", "Comma-separated services whose lines are never shed (matches the JSON \"service\" field, or a word in text lines)")
+	flag.StringVar(&a.CategoryField, "austerity-category-field", "category", "Field (or key=value word, in text lines) holding a line's category for -austerity-offset")
+	flag.Var((*offsetMap)(&a.CategoryOffsets), "austerity-offset", "category=N: shed lines in category as if the austerity level was N higher (or lower, if negative); may be repeated")
+}
 
 // AusteritySetup starts the parser for the system austerity level. It is
 // exported so that tests can call it with testing=true in test setup,
@@ -32,19 +62,75 @@ func AusteritySetup(testing bool) {
 	})
 }
 
+// ShedLine is what AusterityFilter replaces shed text lines with.
+const ShedLine = "(shedded)"
+
 // FilterLine applies shedding to a text event
 func (a *AusterityFilter) FilterLine(line string) string {
+	for _, service := range a.NeverShedServices {
+		if containsWord(line, service) {
+			return line
+		}
+	}
 	AusteritySetup(false)
-	if ShouldShed(clevels.Criticality(line)) {
-		return "(shedded)"
+	if shouldShed(clevels.Criticality(line), a.CategoryOffsets[a.textCategory(line)]) {
+		return ShedLine
 	}
 	return line
 }
 
+// containsWord reports whether word occurs in line without a word
+// character (a letter, digit, _ or -) right before or after it.
+func containsWord(line, word string) bool {
+	if word == "" {
+		return false
+	}
+	for i := 0; ; {
+		j := strings.Index(line[i:], word)
+		if j < 0 {
+			return false
+		}
+		start, end := i+j, i+j+len(word)
+		before, _ := utf8.DecodeLastRuneInString(line[:start])
+		after, _ := utf8.DecodeRuneInString(line[end:])
+		if !isWordRune(before) && !isWordRune(after) {
+			return true
+		}
+		i = start + 1
+	}
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || r == '-' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// textCategory returns the value of the first CategoryField=value
+// word in line.
+func (a *AusterityFilter) textCategory(line string) string {
+	if len(a.CategoryOffsets) == 0 || a.CategoryField == "" {
+		return ""
+	}
+	prefix := a.CategoryField + "="
+	for _, word := range strings.Fields(line) {
+		if strings.HasPrefix(word, prefix) {
+			return word[len(prefix):]
+		}
+	}
+	return ""
+}
+
 // FilterJSON applies shedding to a JSON event
 func (a *AusterityFilter) FilterJSON(line *json.LogLine) {
+	if service, ok := (*line)["service"].(string); ok {
+		for _, s := range a.NeverShedServices {
+			if service == s {
+				return
+			}
+		}
+	}
 	AusteritySetup(false)
-	if ShouldShed(clevels.JSONCriticality(*line)) {
+	category, _ := (*line)[a.CategoryField].(string)
+	if shouldShed(clevels.JSONCriticality(*line), a.CategoryOffsets[category]) {
 		// clear the line:
 		newLine := map[string]interface{}{}
 		if ts, ok := (*line)["ts"]; ok {
@@ -58,24 +144,23 @@ func (a *AusterityFilter) FilterJSON(line *json.LogLine) {
 // ShouldShed returns true if the given criticalityLevel indicates a log
 // should be shed, according to the system austerity level
 func ShouldShed(criticalityLevel clevels.AusterityLevel) bool {
-	austerityLevel := <-clevels.SystemAusterityLevel
-	if criticalityLevel >= austerityLevel {
-		return false
-	}
-
-	return rand.Float64() > samplingRate(austerityLevel, criticalityLevel)
+	return shouldShed(criticalityLevel, 0)
 }
 
-// samplingRate calculates the rate at which loglines will be sampled for the
-// given criticality level and austerity level. For example, if the austerity level
-// is Critical (3), then lines that are Sheddable (0) will be sampled at .001.
-func samplingRate(austerityLevel, criticalityLevel clevels.AusterityLevel) float64 {
-	if criticalityLevel > austerityLevel {
-		return 1
+// shouldShed is ShouldShed with offset added to the system austerity
+// level (up to CriticalPlus).
+func shouldShed(criticalityLevel clevels.AusterityLevel, offset int) bool {
+	austerityLevel := <-clevels.SystemAusterityLevel
+	if offset != 0 {
+		austerityLevel += clevels.AusterityLevel(offset)
+		if austerityLevel > clevels.CriticalPlus {
+			austerityLevel = clevels.CriticalPlus
+		}
+	}
+	rate := clevels.KeepRate(austerityLevel, criticalityLevel)
+	if rate >= 1 {
+		return false
 	}
 
-	levelDiff := austerityLevel - criticalityLevel
-	samplingRate := math.Pow(10, float64(-levelDiff))
-
-	return samplingRate
+	return rand.Float64() > rate
 }
@@ -11,49 +11,6 @@ import (
 	"github.com/stripe/unilog/json"
 )
 
-func TestCalculateSamplingRate(t *testing.T) {
-	type CalculateSamplingLevel struct {
-		Name        string
-		Austerity   clevels.AusterityLevel
-		Criticality clevels.AusterityLevel
-		Expected    float64
-	}
-
-	cases := []CalculateSamplingLevel{
-		{
-			Name:        "log level higher than austerity",
-			Austerity:   clevels.Sheddable,
-			Criticality: clevels.SheddablePlus,
-			Expected:    1.0,
-		},
-		{
-			Name:        "log level one lower than austerity",
-			Austerity:   clevels.Critical,
-			Criticality: clevels.SheddablePlus,
-			Expected:    0.1,
-		},
-		{
-			Name:        "log level two lower than austerity",
-			Austerity:   clevels.Critical,
-			Criticality: clevels.Sheddable,
-			Expected:    0.01,
-		},
-		{
-			Name:        "log level three lower than austerity",
-			Austerity:   clevels.CriticalPlus,
-			Criticality: clevels.Sheddable,
-			Expected:    0.001,
-		},
-	}
-
-	for _, tc := range cases {
-		t.Run(tc.Name, func(t *testing.T) {
-			samplingRate := samplingRate(tc.Austerity, tc.Criticality)
-			assert.Equal(t, tc.Expected, samplingRate)
-		})
-	}
-}
-
 func TestAusterityFilter(t *testing.T) {
 	// Make sure SendSystemAusterityLevel is called before we override
 	// the underlying channel below
@@ -134,3 +91,111 @@ func TestAusterityJSON(t *testing.T) {
 	assert.Equal(t, 8983, dropped)
 	kill <- struct{}{}
 }
+
+func TestAusterityNeverShed(t *testing.T) {
+	a := AusterityFilter{NeverShedServices: []string{"audit"}}
+	AusteritySetup(true)
+	clevels.SystemAusterityLevel = make(chan clevels.AusterityLevel)
+	kill := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case clevels.SystemAusterityLevel <- clevels.CriticalPlus:
+			case <-kill:
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 1000; i++ {
+		line := "service=audit user logged in clevel=sheddable"
+		assert.Equal(t, line, a.FilterLine(line))
+
+		jsonLine := json.LogLine{"message": "user logged in", "service": "audit", "clevel": "sheddable"}
+		a.FilterJSON(&jsonLine)
+		assert.Equal(t, "user logged in", jsonLine["message"])
+	}
+
+	// Other services are still shed:
+	rand.Seed(17)
+	assert.Equal(t, "(shedded)", a.FilterLine("service=api clevel=sheddable"))
+	// including ones whose names contain a never-shed service
+	assert.Equal(t, "(shedded)", a.FilterLine("service=auditor clevel=sheddable"))
+	kill <- struct{}{}
+}
+
+func TestContainsWord(t *testing.T) {
+	assert.True(t, containsWord("service=api clevel=critical", "api"))
+	assert.True(t, containsWord("[api] hello", "api"))
+	assert.True(t, containsWord("api", "api"))
+	assert.True(t, containsWord("rapid api", "api"))
+	assert.False(t, containsWord("rapid capital", "api"))
+	assert.False(t, containsWord("service=api-gateway", "api"))
+	assert.False(t, containsWord("service=api_v2", "api"))
+	assert.False(t, containsWord("anything", ""))
+}
+
+func TestAusterityCategoryOffsets(t *testing.T) {
+	a := AusterityFilter{
+		CategoryField:   "category",
+		CategoryOffsets: map[string]int{"debug": 1, "audit": -1, "noisy": 5},
+	}
+	AusteritySetup(true)
+	clevels.SystemAusterityLevel = make(chan clevels.AusterityLevel)
+	kill := make(chan struct{})
+	defer close(kill)
+
+	go func() {
+		for {
+			select {
+			case clevels.SystemAusterityLevel <- clevels.Critical:
+			case <-kill:
+				return
+			}
+		}
+	}()
+
+	rand.Seed(17)
+	shedRate := func(line string) float64 {
+		dropped := 0
+		for i := 0; i < 10000; i++ {
+			if a.FilterLine(line) == "(shedded)" {
+				dropped++
+			}
+			jsonLine := json.LogLine{"message": line, "clevel": "sheddableplus"}
+			for _, word := range strings.Fields(line) {
+				if strings.HasPrefix(word, "category=") {
+					jsonLine["category"] = strings.TrimPrefix(word, "category=")
+				}
+			}
+			a.FilterJSON(&jsonLine)
+			if _, ok := jsonLine["message"]; !ok {
+				dropped++
+			}
+		}
+		return float64(dropped) / 20000
+	}
+
+	// No offset: 1 in 10 sheddableplus lines are kept at critical
+	assert.InDelta(t, .9, shedRate("hi clevel=sheddableplus"), .01)
+	assert.InDelta(t, .9, shedRate("hi clevel=sheddableplus category=info"), .01)
+	// One level more aggressive: 1 in 100 are kept
+	assert.InDelta(t, .99, shedRate("hi clevel=sheddableplus category=debug"), .005)
+	// One level less: none are shed
+	assert.Equal(t, 0.0, shedRate("hi clevel=sheddableplus category=audit"))
+	// Not even a large offset sheds criticalplus lines
+	for i := 0; i < 1000; i++ {
+		line := "hi clevel=criticalplus category=noisy"
+		assert.Equal(t, line, a.FilterLine(line))
+	}
+}
+
+func TestOffsetMap(t *testing.T) {
+	m := offsetMap{}
+	assert.NoError(t, m.Set("debug=1"))
+	assert.NoError(t, m.Set("audit=-2"))
+	assert.Error(t, m.Set("debug"))
+	assert.Error(t, m.Set("debug=lots"))
+	assert.Equal(t, "audit=-2,debug=1", m.String())
+}
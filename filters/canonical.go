@@ -0,0 +1,146 @@
+package filters
+
+import (
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/stripe/unilog/clevels"
+	"github.com/stripe/unilog/json"
+	flag "launchpad.net/gnuflag"
+)
+
+// CanonicalFilter turns canonical text lines (the ones that
+// clevels.FindCanonical matches, such as CANONICAL-API-LINE) into
+// structured JSON events; see ParseCanonical for the format. Other
+// lines are left untouched. It only does anything when Enabled is set.
+//
+// The JSON is written in place of the text line, so CanonicalFilter
+// should come after any filters that add to text lines, like
+// TimePrefixFilter (whose timestamp then becomes the event's).
+//
+// JSON lines are passed through unchanged.
+type CanonicalFilter struct {
+	Enabled bool
+}
+
+// AddFlags adds canonical line flags to the CLI options
+func (f *CanonicalFilter) AddFlags() {
+	flag.BoolVar(&f.Enabled, "structure-canonical", false, "Write canonical text lines (e.g. CANONICAL-API-LINE) as JSON events")
+}
+
+// FilterLine replaces a canonical line with its JSON encoding.
+func (f *CanonicalFilter) FilterLine(line string) string {
+	if !f.Enabled {
+		return line
+	}
+	event, ok := ParseCanonical(line)
+	if !ok {
+		return line
+	}
+	b, err := event.MarshalJSON()
+	if err != nil {
+		return line
+	}
+	return string(b)
+}
+
+// FilterJSON is a no-op: JSON lines are already structured.
+func (f *CanonicalFilter) FilterJSON(line *json.LogLine) {}
+
+// ParseCanonical parses a canonical line, such as
+//
+//	[2016-11-10 19:18:05.844100] [98381|host] CANONICAL-API-LINE: http_method=GET path="/v1/charges" status=200
+//
+// into a JSON log line with the line's key=value pairs as string
+// fields. Values may be double-quoted, with backslash escapes; words
+// without an "=" are skipped. The event also gets these fields, which
+// take precedence over pairs with the same key:
+//
+//   - canonical: true
+//   - canonical_line: the line's marker, e.g. "CANONICAL-API-LINE"
+//   - prefix: whatever came before the marker, minus a leading time
+//     prefix, if it's not empty
+//   - timestamp: the time of the leading time prefix (in
+//     TimePrefixFilter's default format), if there is one
+//
+// ParseCanonical reports whether line was a canonical line.
+func ParseCanonical(line string) (json.LogLine, bool) {
+	loc := clevels.FindCanonical(line)
+	if loc == nil {
+		return nil, false
+	}
+
+	event := parseKeyValues(strings.TrimLeft(line[loc[1]:], ": "))
+
+	prefix := line[:loc[0]]
+	if ts, ok := ParseTimePrefix(prefix); ok {
+		event["timestamp"] = ts.Format(time.RFC3339Nano)
+		prefix = prefix[len(defaultFormat)+2:]
+	}
+	if prefix = strings.TrimSpace(prefix); prefix != "" {
+		event["prefix"] = prefix
+	}
+	event["canonical"] = true
+	event["canonical_line"] = line[loc[0]:loc[1]]
+	return event, true
+}
+
+// parseKeyValues parses whitespace-separated key=value pairs, with
+// optionally quoted values.
+func parseKeyValues(s string) json.LogLine {
+	fields := json.LogLine{}
+	for {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		if s == "" {
+			return fields
+		}
+
+		end := strings.IndexFunc(s, func(r rune) bool { return r == '=' || unicode.IsSpace(r) })
+		if end < 0 {
+			// A trailing word without a value
+			return fields
+		}
+		if s[end] != '=' {
+			s = s[end:]
+			continue
+		}
+		key := s[:end]
+		s = s[end+1:]
+
+		var value string
+		value, s = parseValue(s)
+		if key != "" {
+			fields[key] = value
+		}
+	}
+}
+
+// parseValue parses a value at the start of s, and returns it and the
+// rest of s.
+func parseValue(s string) (string, string) {
+	if !strings.HasPrefix(s, `"`) {
+		end := strings.IndexFunc(s, unicode.IsSpace)
+		if end < 0 {
+			return s, ""
+		}
+		return s[:end], s[end:]
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), s[i+1:]
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	// Unterminated quotes run to the end of the line
+	return b.String(), ""
+}
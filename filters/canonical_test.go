@@ -0,0 +1,99 @@
+package filters
+
+import (
+	encjson "encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/unilog/json"
+)
+
+// Sample lines from clevels' TestCriticality
+const (
+	canonicalAPILine   = `[2016-11-10 19:18:05.844100] [98381|f1.northwest-1.apiori.com/EzBDuA4iNq-2631925524 85137cc252d87354>e9b8c49860f01f15] CANONICAL-API-LINE: api_method=AccountRetrieveMethod content_type="application/x-www-form-urlencoded" created=1478805073.5253563 http_method=GET ip="54.xxx.xxx.xxx" path="/v1/accounts/acct_xxxxxxxxxxxxxxxx" user_agent="Stripe/v1 RubyBindings/1.31.0" request_id=req_xxxxxxxxxxxxxx response_stripe_version="2016-03-07" status=200 merchant=acct_xxxxxxxxxxxxx`
+	canonicalAdminLine = `[2016-11-10 19:10:49.230930] [22560|adminbox--04ec81f3361370d7f.northwest.stripe.io/kUku-rmgfZ-349 0000000000000000>a020f53ed1dd83ef] CANONICAL-ADMIN-LINE: path="/fonts/glyphicons-halflings-regular.woff" http_method=GET referer="/css/bootstrap3.min.css" response_content_type="application/octet-stream" status=200`
+	monsterLine        = `[2016-11-10 19:10:49.230930] [22560|adminbox--04ec81f3361370d7f.northwest.stripe.io/kUku-rmgfZ-349 0000000000000000>a020f53ed1dd83ef] canonical-monster-line: path="/fonts/glyphicons-halflings-regular.woff" http_method=GET referer="/css/bootstrap3.min.css" response_content_type="application/octet-stream" status=200`
+	plainLine          = `[2016-11-10 19:01:02.461489] [21515|adminbox--04ec81f3361370d7f.northwest.stripe.io/kUku-wvrZK-28 0000000000000000>93e612b5bd9b69eb] HTTP response headers: Content-Type="text/html;charset=utf-8" Content-Length="10879" Set`
+	chalkLine          = `[2016-11-10 20:02:01.932272] [24607|adminbox--04ec81f3361370d7f.northwest.stripe.io/kUku-WdiJA-3204 0000000000000000>831e61790017a475] Showed info for merchant: merchant=acct_xxxxxxxxxxxxxxxxx tier=tier0 clevel=criticalplus`
+)
+
+func TestParseCanonical(t *testing.T) {
+	event, ok := ParseCanonical(canonicalAPILine)
+	require.True(t, ok)
+	assert.Equal(t, json.LogLine{
+		"timestamp":               time.Date(2016, 11, 10, 19, 18, 5, 844100000, time.Local).Format(time.RFC3339Nano),
+		"prefix":                  "[98381|f1.northwest-1.apiori.com/EzBDuA4iNq-2631925524 85137cc252d87354>e9b8c49860f01f15]",
+		"canonical":               true,
+		"canonical_line":          "CANONICAL-API-LINE",
+		"api_method":              "AccountRetrieveMethod",
+		"content_type":            "application/x-www-form-urlencoded",
+		"created":                 "1478805073.5253563",
+		"http_method":             "GET",
+		"ip":                      "54.xxx.xxx.xxx",
+		"path":                    "/v1/accounts/acct_xxxxxxxxxxxxxxxx",
+		"user_agent":              "Stripe/v1 RubyBindings/1.31.0",
+		"request_id":              "req_xxxxxxxxxxxxxx",
+		"response_stripe_version": "2016-03-07",
+		"status":                  "200",
+		"merchant":                "acct_xxxxxxxxxxxxx",
+	}, event)
+
+	event, ok = ParseCanonical(canonicalAdminLine)
+	require.True(t, ok)
+	assert.Equal(t, "CANONICAL-ADMIN-LINE", event["canonical_line"])
+	assert.Equal(t, "/css/bootstrap3.min.css", event["referer"])
+	assert.Equal(t, "200", event["status"])
+
+	// Case insensitive, like clevels.Criticality
+	event, ok = ParseCanonical(monsterLine)
+	require.True(t, ok)
+	assert.Equal(t, "canonical-monster-line", event["canonical_line"])
+
+	for _, line := range []string{plainLine, chalkLine, ""} {
+		_, ok := ParseCanonical(line)
+		assert.False(t, ok, line)
+	}
+}
+
+func TestParseCanonicalValues(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		fields json.LogLine
+	}{
+		{"no prefix", `CANONICAL-API-LINE: a=1`, json.LogLine{"a": "1"}},
+		{"escapes", `CANONICAL-API-LINE: msg="say \"hi\" \\ bye" b=2`, json.LogLine{"msg": `say "hi" \ bye`, "b": "2"}},
+		{"empty values", `CANONICAL-API-LINE: a= b="" c=3`, json.LogLine{"a": "", "b": "", "c": "3"}},
+		{"equals in values", `CANONICAL-API-LINE: q=a=b url="/x?y=z"`, json.LogLine{"q": "a=b", "url": "/x?y=z"}},
+		{"bare words", `CANONICAL-API-LINE: started a=1 done`, json.LogLine{"a": "1"}},
+		{"unterminated quote", `CANONICAL-API-LINE: a=1 msg="oops b=2`, json.LogLine{"a": "1", "msg": "oops b=2"}},
+		{"reserved keys", `CANONICAL-API-LINE: canonical=no prefix=x`, json.LogLine{"prefix": "x"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event, ok := ParseCanonical(test.line)
+			require.True(t, ok)
+			test.fields["canonical"] = true
+			test.fields["canonical_line"] = "CANONICAL-API-LINE"
+			assert.Equal(t, test.fields, event)
+		})
+	}
+}
+
+func TestCanonicalFilter(t *testing.T) {
+	f := &CanonicalFilter{}
+	assert.Equal(t, canonicalAPILine, f.FilterLine(canonicalAPILine))
+
+	f.Enabled = true
+	assert.Equal(t, plainLine, f.FilterLine(plainLine))
+
+	var event map[string]interface{}
+	require.NoError(t, encjson.Unmarshal([]byte(f.FilterLine(canonicalAdminLine)), &event))
+	assert.Equal(t, true, event["canonical"])
+	assert.Equal(t, "GET", event["http_method"])
+	// Timestamps are normalized to epochs
+	ts := time.Date(2016, 11, 10, 19, 10, 49, 230930000, time.Local)
+	assert.InDelta(t, float64(ts.UnixNano())/1e9, event["timestamp"], 1e-6)
+}
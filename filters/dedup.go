@@ -0,0 +1,90 @@
+package filters
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/stripe/unilog/json"
+	flag "launchpad.net/gnuflag"
+)
+
+// DedupFilter collapses runs of identical consecutive text lines, like
+// syslog's "last message repeated N times": the first line of a run is
+// written, the repeats are held back, and a summary line is written in
+// their place when a different line arrives, or Window after the
+// first repeat, whichever comes first. A Window of 0 disables it.
+//
+// It's a logger.HoldingFilter: FilterLine passes lines through
+// unchanged, and JSON lines aren't deduplicated.
+type DedupFilter struct {
+	Window time.Duration
+	// Now returns the current time (time.Now, if nil).
+	Now func() time.Time
+
+	last    string
+	seen    bool
+	repeats int
+	// when the first repeat was held back
+	heldAt time.Time
+}
+
+// AddFlags adds deduplication flags to the CLI options
+func (f *DedupFilter) AddFlags() {
+	flag.DurationVar(&f.Window, "dedup-window", 0, "(optional) Collapse identical consecutive text lines, writing how many times they repeated at most this long after the first repeat")
+}
+
+func (f *DedupFilter) now() time.Time {
+	if f.Now != nil {
+		return f.Now()
+	}
+	return time.Now()
+}
+
+// FilterLine passes line through; see FilterLines.
+func (f *DedupFilter) FilterLine(line string) string {
+	return line
+}
+
+// FilterJSON does nothing.
+func (f *DedupFilter) FilterJSON(line *json.LogLine) {}
+
+// FilterLines holds line back if it repeats the previous one;
+// otherwise it returns it, after the summary of the previous line's
+// repeats, if any.
+func (f *DedupFilter) FilterLines(line string) []string {
+	if f.Window <= 0 {
+		return []string{line}
+	}
+	if f.seen && line == f.last {
+		if f.repeats == 0 {
+			f.heldAt = f.now()
+		}
+		f.repeats++
+		return nil
+	}
+	lines := append(f.Flush(), line)
+	f.last, f.seen = line, true
+	return lines
+}
+
+// Flush returns the summary of the repeats held back, if any.
+func (f *DedupFilter) Flush() []string {
+	if f.repeats == 0 {
+		return nil
+	}
+	summary := fmt.Sprintf("last message repeated %d times", f.repeats)
+	if f.repeats == 1 {
+		summary = "last message repeated 1 time"
+	}
+	f.repeats = 0
+	return []string{summary}
+}
+
+// FlushAfter returns how long until the repeats held back are due to
+// be summarized, if there are any.
+func (f *DedupFilter) FlushAfter() (time.Duration, bool) {
+	if f.repeats == 0 {
+		return 0, false
+	}
+	return f.Window - f.now().Sub(f.heldAt), true
+}
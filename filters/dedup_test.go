@@ -0,0 +1,66 @@
+package filters
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// dedupAll passes lines through f, and returns what it emits.
+func dedupAll(f *DedupFilter, lines ...string) []string {
+	var out []string
+	for _, line := range lines {
+		out = append(out, f.FilterLines(line)...)
+	}
+	return out
+}
+
+func TestDedupRuns(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  []string
+	}{
+		{"run of 1", []string{"a", "b", "a"}, []string{"a", "b", "a"}},
+		{"run of 2", []string{"a", "a", "b"}, []string{"a", "last message repeated 1 time", "b"}},
+		{"many", []string{"a", "a", "a", "a", "a", "b", "b"}, []string{"a", "last message repeated 4 times", "b"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := &DedupFilter{Window: time.Minute}
+			assert.Equal(t, test.want, dedupAll(f, test.lines...))
+		})
+	}
+}
+
+func TestDedupFlush(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)}
+	f := &DedupFilter{Window: 10 * time.Second, Now: clock.Now}
+
+	assert.Equal(t, []string{"a"}, dedupAll(f, "a"))
+	_, pending := f.FlushAfter()
+	assert.False(t, pending)
+
+	clock.now = clock.now.Add(time.Second)
+	assert.Empty(t, dedupAll(f, "a", "a"))
+	clock.now = clock.now.Add(4 * time.Second)
+	d, pending := f.FlushAfter()
+	assert.True(t, pending)
+	assert.Equal(t, 6*time.Second, d)
+
+	assert.Equal(t, []string{"last message repeated 2 times"}, f.Flush())
+	_, pending = f.FlushAfter()
+	assert.False(t, pending)
+	assert.Empty(t, f.Flush())
+
+	// The run goes on after a flush
+	assert.Equal(t, []string{"last message repeated 1 time", "b"}, dedupAll(f, "a", "b"))
+}
+
+func TestDedupDisabled(t *testing.T) {
+	f := &DedupFilter{}
+	assert.Equal(t, []string{"a", "a", "a"}, dedupAll(f, "a", "a", "a"))
+	_, pending := f.FlushAfter()
+	assert.False(t, pending)
+}
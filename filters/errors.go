@@ -0,0 +1,119 @@
+package filters
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/stripe/unilog/json"
+	flag "launchpad.net/gnuflag"
+)
+
+// DefaultErrorPatterns are the patterns ErrorFilter recognizes error
+// lines with, if it has no Patterns: an exception or error class
+// followed by a message (like "java.io.IOException: disk full" or
+// "ValueError: bad value"), or an ERROR or FATAL marker followed by a
+// message.
+var DefaultErrorPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`\b(?P<class>(?:[A-Za-z_]\w*\.)*(?:[A-Z]\w*)?(?:Error|Exception)):\s+(?P<message>\S.*)$`),
+	regexp.MustCompile(`\b(?:ERROR|FATAL)\b\]?:?\s+(?P<message>\S.*)$`),
+}
+
+// ErrorFilter turns the messages of JSON lines that look like errors
+// into structured error objects, so that errors can be searched by
+// class and message. It is mostly useful with -wrap-json, which turns
+// text lines into JSON lines with just a message.
+//
+// A line's "message" is matched against each of the Patterns (or
+// DefaultErrorPatterns) in turn. The first one that matches sets the
+// line's "error" field to an object with the pattern's "message" group
+// and, if it matched, its "class" group:
+//
+//	{"message": "ERROR java.io.IOException: disk full",
+//	 "error": {"class": "java.io.IOException", "message": "disk full"}}
+//
+// Lines that don't match, that already have an "error" field or
+// whose message isn't a string are left untouched, as are text lines.
+// ErrorFilter only does anything when Enabled is set.
+type ErrorFilter struct {
+	Enabled  bool
+	Patterns []*regexp.Regexp
+}
+
+// AddFlags adds error parsing flags to the CLI options
+func (f *ErrorFilter) AddFlags() {
+	flag.BoolVar(&f.Enabled, "parse-errors", false, `Add structured "error" objects to JSON lines whose message looks like an error`)
+	flag.Var((*errorPatterns)(&f.Patterns), "error-pattern", `(optional) Regular expression with a "message" (and optionally a "class") named group to recognize error messages with, instead of the defaults; may be repeated`)
+}
+
+// FilterLine is a no-op: text lines have no fields to add the error
+// to.
+func (f *ErrorFilter) FilterLine(line string) string {
+	return line
+}
+
+// FilterJSON adds an error object to line, if its message is an error.
+func (f *ErrorFilter) FilterJSON(line *json.LogLine) {
+	if !f.Enabled {
+		return
+	}
+	if _, ok := (*line)["error"]; ok {
+		return
+	}
+	message, ok := (*line)["message"].(string)
+	if !ok {
+		return
+	}
+	if errorObject := f.Parse(message); errorObject != nil {
+		(*line)["error"] = errorObject
+	}
+}
+
+// Parse returns the error object for message, or nil if it doesn't
+// look like an error.
+func (f *ErrorFilter) Parse(message string) map[string]interface{} {
+	patterns := f.Patterns
+	if len(patterns) == 0 {
+		patterns = DefaultErrorPatterns
+	}
+	for _, r := range patterns {
+		matches := r.FindStringSubmatch(message)
+		if matches == nil {
+			continue
+		}
+		errorObject := map[string]interface{}{}
+		for i, name := range r.SubexpNames() {
+			if (name == "message" || name == "class") && matches[i] != "" {
+				errorObject[name] = matches[i]
+			}
+		}
+		return errorObject
+	}
+	return nil
+}
+
+// errorPatterns is a flag.Value that compiles error patterns, and can
+// be repeated.
+type errorPatterns []*regexp.Regexp
+
+func (p *errorPatterns) Set(value string) error {
+	r, err := regexp.Compile(value)
+	if err != nil {
+		return err
+	}
+	for _, name := range r.SubexpNames() {
+		if name == "message" {
+			*p = append(*p, r)
+			return nil
+		}
+	}
+	return fmt.Errorf("error pattern %q has no message group", value)
+}
+
+func (p *errorPatterns) String() string {
+	patterns := make([]string, len(*p))
+	for i, r := range *p {
+		patterns[i] = r.String()
+	}
+	return strings.Join(patterns, " ")
+}
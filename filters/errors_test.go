@@ -0,0 +1,85 @@
+package filters
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/unilog/json"
+)
+
+func TestErrorFilterParse(t *testing.T) {
+	f := &ErrorFilter{Enabled: true}
+	tests := []struct {
+		line  string
+		class string
+		msg   string
+	}{
+		{"java.lang.IllegalStateException: Queue full", "java.lang.IllegalStateException", "Queue full"},
+		{"2016-11-10 19:18:05 ERROR [main] c.s.Api - java.io.IOException: disk full", "java.io.IOException", "disk full"},
+		{"ValueError: invalid literal for int() with base 10: 'x'", "ValueError", "invalid literal for int() with base 10: 'x'"},
+		{"Error: ENOENT: no such file or directory", "Error", "ENOENT: no such file or directory"},
+		{"[ERROR] connection refused", "", "connection refused"},
+		{"FATAL: out of memory", "", "out of memory"},
+		{"2016-11-10 19:18:05 ERROR could not reach db", "", "could not reach db"},
+	}
+	for _, test := range tests {
+		t.Run(test.line, func(t *testing.T) {
+			want := map[string]interface{}{"message": test.msg}
+			if test.class != "" {
+				want["class"] = test.class
+			}
+			assert.Equal(t, want, f.Parse(test.line))
+		})
+	}
+
+	for _, line := range []string{
+		"user logged in",
+		"processed 3 requests, 0 errors",
+		"ErrorRate: 0.5",
+		"ERROR",
+		"",
+	} {
+		assert.Nil(t, f.Parse(line), line)
+	}
+}
+
+func TestErrorFilterJSON(t *testing.T) {
+	f := &ErrorFilter{}
+	line := json.LogLine{"message": "FATAL: out of memory"}
+	f.FilterJSON(&line)
+	assert.Equal(t, json.LogLine{"message": "FATAL: out of memory"}, line)
+
+	f.Enabled = true
+	f.FilterJSON(&line)
+	assert.Equal(t, json.LogLine{
+		"message": "FATAL: out of memory",
+		"error":   map[string]interface{}{"message": "out of memory"},
+	}, line)
+
+	// Only matching lines are transformed
+	line = json.LogLine{"message": "all good"}
+	f.FilterJSON(&line)
+	assert.Equal(t, json.LogLine{"message": "all good"}, line)
+	line = json.LogLine{"message": "FATAL: out of memory", "error": "oom"}
+	f.FilterJSON(&line)
+	assert.Equal(t, json.LogLine{"message": "FATAL: out of memory", "error": "oom"}, line)
+	line = json.LogLine{"message": 42.0}
+	f.FilterJSON(&line)
+	assert.Equal(t, json.LogLine{"message": 42.0}, line)
+
+	assert.Equal(t, "FATAL: out of memory", f.FilterLine("FATAL: out of memory"))
+}
+
+func TestErrorPatterns(t *testing.T) {
+	f := &ErrorFilter{Enabled: true}
+	p := (*errorPatterns)(&f.Patterns)
+	assert.Error(t, p.Set(`oops: (.*)`))
+	assert.Error(t, p.Set(`(?P<message>`))
+	require.NoError(t, p.Set(`^E\d+ (?P<class>\w+) (?P<message>.*)`))
+	assert.Equal(t, `^E\d+ (?P<class>\w+) (?P<message>.*)`, p.String())
+
+	assert.Equal(t, map[string]interface{}{"class": "timeout", "message": "after 5s"}, f.Parse("E1017 timeout after 5s"))
+	// The defaults no longer apply
+	assert.Nil(t, f.Parse("FATAL: out of memory"))
+}
@@ -0,0 +1,114 @@
+package filters
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// stringList is a flag.Value that collects comma-separated values,
+// and can be repeated.
+type stringList []string
+
+func (l *stringList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// repeatedList is a flag.Value that collects the whole value of each
+// time it's repeated, for values that may contain commas.
+type repeatedList []string
+
+func (l *repeatedList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func (l *repeatedList) String() string {
+	return strings.Join(*l, " ")
+}
+
+// regexpList is a flag.Value that compiles a regular expression each
+// time it's repeated.
+type regexpList []*regexp.Regexp
+
+func (l *regexpList) Set(value string) error {
+	r, err := regexp.Compile(value)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, r)
+	return nil
+}
+
+func (l *regexpList) String() string {
+	patterns := make([]string, len(*l))
+	for i, r := range *l {
+		patterns[i] = r.String()
+	}
+	return strings.Join(patterns, " ")
+}
+
+// fieldMap is a flag.Value that collects key=value pairs, and can be
+// repeated.
+type fieldMap map[string]string
+
+func (m *fieldMap) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("%q is not a key=value pair", value)
+	}
+	if *m == nil {
+		*m = make(fieldMap)
+	}
+	(*m)[kv[0]] = kv[1]
+	return nil
+}
+
+func (m *fieldMap) String() string {
+	pairs := make([]string, 0, len(*m))
+	for k, v := range *m {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// offsetMap is a flag.Value that collects key=N pairs, and can be
+// repeated.
+type offsetMap map[string]int
+
+func (m *offsetMap) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("%q is not a key=N pair", value)
+	}
+	n, err := strconv.Atoi(kv[1])
+	if err != nil {
+		return fmt.Errorf("%q is not a key=N pair", value)
+	}
+	if *m == nil {
+		*m = make(offsetMap)
+	}
+	(*m)[kv[0]] = n
+	return nil
+}
+
+func (m *offsetMap) String() string {
+	pairs := make([]string, 0, len(*m))
+	for k, n := range *m {
+		pairs = append(pairs, k+"="+strconv.Itoa(n))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
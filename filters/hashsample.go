@@ -0,0 +1,113 @@
+package filters
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+
+	"github.com/stripe/unilog/json"
+	flag "launchpad.net/gnuflag"
+)
+
+// HashSampleFilter keeps one in Rate lines, deciding by a hash of a
+// key (such as a trace ID), so that all lines with the same key are
+// either kept or dropped together. A line is kept iff the FNV-1a hash
+// of its key is divisible by Rate.
+//
+// In JSON mode, the key is the value of the Field field, and unsampled
+// lines are dropped. In text mode, the key is the first capture group
+// (or, without one, the whole match) of Pattern, and unsampled lines
+// are replaced with "(sampled out)", which retains their time stamps
+// like AusterityFilter's shedding does. Lines without a key are always
+// kept. A Rate of 1 or less keeps everything.
+//
+// The fraction of keyed lines kept so far is reported in the
+// unilog.hash_sample.keep_rate gauge.
+type HashSampleFilter struct {
+	Field   string
+	Pattern *regexp.Regexp
+	Rate    int
+
+	kept, total int64
+}
+
+// AddFlags adds hash sampling flags to the CLI options
+func (f *HashSampleFilter) AddFlags() {
+	flag.IntVar(&f.Rate, "hash-sample-rate", 0, "(optional) Keep 1 in this many lines, sampling consistently by a key (see -hash-sample-field and -hash-sample-pattern)")
+	flag.StringVar(&f.Field, "hash-sample-field", "trace_id", "The JSON field to sample by")
+	flag.Var(regexpValue{&f.Pattern}, "hash-sample-pattern", "(optional) Regular expression whose first capture group is the key to sample text lines by")
+}
+
+// SampledOutLine is what HashSampleFilter replaces unsampled text
+// lines with.
+const SampledOutLine = "(sampled out)"
+
+// FilterLine samples a text line by the key that Pattern matches.
+func (f *HashSampleFilter) FilterLine(line string) string {
+	if f.Rate <= 1 || f.Pattern == nil {
+		return line
+	}
+	m := f.Pattern.FindStringSubmatch(line)
+	if m == nil {
+		return line
+	}
+	key := m[0]
+	if len(m) > 1 {
+		key = m[1]
+	}
+	if !f.keep(key) {
+		return SampledOutLine
+	}
+	return line
+}
+
+// FilterJSON samples a JSON line by its Field field.
+func (f *HashSampleFilter) FilterJSON(line *json.LogLine) {
+	if f.Rate <= 1 || f.Field == "" {
+		return
+	}
+	v, ok := (*line)[f.Field]
+	if !ok || v == nil {
+		return
+	}
+	if !f.keep(fmt.Sprint(v)) {
+		*line = nil
+	}
+}
+
+// keep decides whether to keep lines with the given key.
+func (f *HashSampleFilter) keep(key string) bool {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	keep := h.Sum32()%uint32(f.Rate) == 0
+
+	f.total++
+	if keep {
+		f.kept++
+	}
+	if Stats != nil {
+		Stats.Gauge("unilog.hash_sample.keep_rate", float64(f.kept)/float64(f.total), nil, 0.01)
+	}
+	return keep
+}
+
+// regexpValue is a flag.Value that compiles a regular expression.
+type regexpValue struct {
+	re **regexp.Regexp
+}
+
+func (v regexpValue) Set(value string) error {
+	re, err := regexp.Compile(value)
+	if err != nil {
+		return err
+	}
+	*v.re = re
+	return nil
+}
+
+func (v regexpValue) String() string {
+	if v.re == nil || *v.re == nil {
+		return ""
+	}
+	return (*v.re).String()
+}
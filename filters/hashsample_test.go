@@ -0,0 +1,68 @@
+package filters
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/unilog/json"
+)
+
+func TestHashSampleConsistent(t *testing.T) {
+	f := &HashSampleFilter{Field: "trace_id", Rate: 4}
+
+	kept := 0
+	for i := 0; i < 1000; i++ {
+		traceID := fmt.Sprintf("trace-%d", i)
+		var decisions []bool
+		for j := 0; j < 3; j++ {
+			line := json.LogLine{"trace_id": traceID, "span": j}
+			f.FilterJSON(&line)
+			decisions = append(decisions, line != nil)
+		}
+		assert.Equal(t, decisions[0], decisions[1], traceID)
+		assert.Equal(t, decisions[0], decisions[2], traceID)
+		if decisions[0] {
+			kept++
+		}
+	}
+	// Roughly 1 in 4 traces are kept
+	assert.InDelta(t, 250, kept, 60)
+	assert.Equal(t, int64(3*kept), f.kept)
+	assert.Equal(t, int64(3000), f.total)
+
+	// Lines without the key are always kept
+	line := json.LogLine{"message": "hi"}
+	f.FilterJSON(&line)
+	assert.NotNil(t, line)
+}
+
+func TestHashSampleText(t *testing.T) {
+	f := &HashSampleFilter{Pattern: regexp.MustCompile(`trace=(\w+)`), Rate: 2}
+
+	var keptID, droppedID string
+	for i := 0; keptID == "" || droppedID == ""; i++ {
+		id := fmt.Sprintf("t%d", i)
+		if f.FilterLine("trace="+id) == "(sampled out)" {
+			droppedID = id
+		} else {
+			keptID = id
+		}
+	}
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "start trace="+keptID+" end", f.FilterLine("start trace="+keptID+" end"))
+		assert.Equal(t, "(sampled out)", f.FilterLine("start trace="+droppedID+" end"))
+	}
+	assert.Equal(t, "no trace here", f.FilterLine("no trace here"))
+}
+
+func TestHashSampleDisabled(t *testing.T) {
+	f := &HashSampleFilter{Field: "trace_id", Pattern: regexp.MustCompile(`.*`), Rate: 1}
+	for i := 0; i < 10; i++ {
+		line := json.LogLine{"trace_id": i}
+		f.FilterJSON(&line)
+		assert.NotNil(t, line)
+		assert.Equal(t, "hi", f.FilterLine("hi"))
+	}
+}
@@ -0,0 +1,119 @@
+package filters
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/stripe/unilog/json"
+	flag "launchpad.net/gnuflag"
+)
+
+// levels ranks the names of log levels used by syslog, Python's
+// logging module and the common Go logging libraries.
+var levels = map[string]int{
+	"trace":         0,
+	"debug":         10,
+	"info":          20,
+	"information":   20,
+	"informational": 20,
+	"notice":        30,
+	"warn":          40,
+	"warning":       40,
+	"err":           50,
+	"error":         50,
+	"crit":          60,
+	"critical":      60,
+	"fatal":         60,
+	"alert":         70,
+	"panic":         70,
+	"emerg":         80,
+	"emergency":     80,
+}
+
+// defaultLevelFields are the fields LevelFilter reads a line's level
+// from, in order, if Field isn't set.
+var defaultLevelFields = []string{"level", "severity"}
+
+// ParseLevel returns the rank of the log level name (case
+// insensitive), and whether it is a known level. Higher ranks are more
+// severe.
+func ParseLevel(name string) (int, bool) {
+	rank, ok := levels[strings.ToLower(name)]
+	return rank, ok
+}
+
+// LevelFilter drops JSON lines whose level is less severe than
+// MinLevel. A line's level is read from its Field field, or, if Field
+// isn't set, from its "level" or "severity" field. Level names are
+// matched case insensitively against the syslog, Python and Go
+// vocabularies (see ParseLevel); lines without a level, or with an
+// unknown one, are never dropped. Each dropped line is counted in the
+// unilog.lines.dropped_by_level metric.
+//
+// Text lines are passed through unchanged.
+type LevelFilter struct {
+	Field    string
+	MinLevel string
+}
+
+// AddFlags adds level filtering flags to the CLI options
+func (f *LevelFilter) AddFlags() {
+	flag.Var(levelValue{&f.MinLevel}, "min-level", "(optional) Drop JSON lines with a less severe level than this (e.g. info, warn)")
+	flag.StringVar(&f.Field, "level-field", "", "The JSON field to read lines' levels from (defaults to level, then severity)")
+}
+
+// FilterLine is a no-op: text lines have no level field.
+func (f *LevelFilter) FilterLine(line string) string {
+	return line
+}
+
+// FilterJSON drops line if its level is below the threshold.
+func (f *LevelFilter) FilterJSON(line *json.LogLine) {
+	if f.MinLevel == "" {
+		return
+	}
+	min, ok := ParseLevel(f.MinLevel)
+	if !ok {
+		return
+	}
+
+	fields := defaultLevelFields
+	if f.Field != "" {
+		fields = []string{f.Field}
+	}
+	for _, field := range fields {
+		name, ok := (*line)[field].(string)
+		if !ok {
+			continue
+		}
+		rank, ok := ParseLevel(name)
+		if !ok || rank >= min {
+			return
+		}
+		*line = nil
+		if Stats != nil {
+			Stats.Count("unilog.lines.dropped_by_level", 1, []string{"level:" + strings.ToLower(name)}, 1)
+		}
+		return
+	}
+}
+
+// levelValue is a flag.Value that only accepts known level names.
+type levelValue struct {
+	level *string
+}
+
+func (v levelValue) Set(value string) error {
+	if _, ok := ParseLevel(value); !ok {
+		return fmt.Errorf("unknown level %q", value)
+	}
+	*v.level = value
+	return nil
+}
+
+func (v levelValue) String() string {
+	if v.level == nil {
+		return ""
+	}
+	return *v.level
+}
@@ -0,0 +1,56 @@
+package filters
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/unilog/json"
+)
+
+func TestLevelFilter(t *testing.T) {
+	f := &LevelFilter{MinLevel: "warn"}
+	tests := []struct {
+		line json.LogLine
+		kept bool
+	}{
+		{json.LogLine{"level": "debug"}, false},
+		{json.LogLine{"level": "INFO"}, false},
+		{json.LogLine{"severity": "notice"}, false},
+		{json.LogLine{"level": "warning"}, true},
+		{json.LogLine{"level": "error"}, true},
+		{json.LogLine{"severity": "CRITICAL"}, true},
+		{json.LogLine{"level": "emerg"}, true},
+		// No level, or one we don't understand
+		{json.LogLine{"message": "hi"}, true},
+		{json.LogLine{"level": "verbose"}, true},
+		{json.LogLine{"level": 10}, true},
+	}
+	for _, test := range tests {
+		line := test.line
+		f.FilterJSON(&line)
+		assert.Equal(t, test.kept, line != nil, "%v", test.line)
+	}
+
+	assert.Equal(t, "level=debug", f.FilterLine("level=debug"))
+}
+
+func TestLevelFilterField(t *testing.T) {
+	f := &LevelFilter{MinLevel: "info", Field: "lvl"}
+
+	line := json.LogLine{"lvl": "trace", "level": "error"}
+	f.FilterJSON(&line)
+	assert.Nil(t, line)
+
+	line = json.LogLine{"level": "trace"}
+	f.FilterJSON(&line)
+	assert.NotNil(t, line)
+}
+
+func TestLevelValue(t *testing.T) {
+	var level string
+	v := levelValue{&level}
+	assert.NoError(t, v.Set("Warning"))
+	assert.Equal(t, "Warning", level)
+	assert.Error(t, v.Set("loud"))
+	assert.Equal(t, "Warning", level)
+}
@@ -0,0 +1,115 @@
+package filters
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/stripe/unilog/json"
+	flag "launchpad.net/gnuflag"
+)
+
+// MetadataFilter merges metadata from a secondary input stream into
+// every JSON line. The stream (typically an inherited file descriptor,
+// e.g. /dev/fd/3) is read in the background, one key=value pair per
+// line; each pair sets key until it is updated again, and "key=" with
+// an empty value removes it. Lines without an "=" are ignored.
+//
+// Ordering: the stream is read concurrently with unilog's input, so a
+// metadata update applies to the lines that are filtered after it has
+// been read, not to the lines written to stdin after it was sent. Lines
+// written around the same time as an update may get either the old or
+// the new value. Metadata is added with json.LogLine.Merge, so by
+// default, fields already present on a line take precedence over
+// metadata. When the stream reaches EOF, the last
+// metadata stays in effect.
+//
+// Text lines are passed through unchanged.
+type MetadataFilter struct {
+	// Path of the metadata stream. If empty, the filter does
+	// nothing.
+	Path string
+
+	once     sync.Once
+	mtx      sync.RWMutex
+	metadata map[string]string
+}
+
+// AddFlags adds metadata flags to the CLI options
+func (f *MetadataFilter) AddFlags() {
+	flag.StringVar(&f.Path, "metadata-file", "", "(optional) File or /dev/fd/N to read key=value metadata to add to each JSON line from")
+}
+
+// FilterLine is a no-op: text lines have no fields to merge into.
+func (f *MetadataFilter) FilterLine(line string) string {
+	return line
+}
+
+// FilterJSON adds the current metadata to line. The first call
+// starts reading the metadata stream.
+func (f *MetadataFilter) FilterJSON(line *json.LogLine) {
+	f.once.Do(f.start)
+
+	f.mtx.RLock()
+	defer f.mtx.RUnlock()
+	for k, v := range f.metadata {
+		line.Merge(k, v)
+	}
+}
+
+// start opens Path and reads it in the background. Opening it is
+// done in the background too, since it blocks on a FIFO until a
+// writer opens the other end (or on a slow mount).
+func (f *MetadataFilter) start() {
+	if f.Path == "" {
+		return
+	}
+	go func() {
+		r, err := os.Open(f.Path)
+		if err != nil {
+			f.readError(err)
+			return
+		}
+		defer r.Close()
+		f.read(r)
+	}()
+}
+
+// read applies the updates from r until it reaches EOF.
+func (f *MetadataFilter) read(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		f.update(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		f.readError(err)
+	}
+}
+
+func (f *MetadataFilter) update(pair string) {
+	kv := strings.SplitN(pair, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if kv[1] == "" {
+		delete(f.metadata, kv[0])
+		return
+	}
+	if f.metadata == nil {
+		f.metadata = make(map[string]string)
+	}
+	f.metadata[kv[0]] = kv[1]
+}
+
+func (f *MetadataFilter) readError(err error) {
+	fmt.Fprintf(os.Stderr, "Could not read metadata from %s: %s\n", f.Path, err)
+	if Stats != nil {
+		Stats.Count("unilog.metadata.errors", 1, nil, 1)
+	}
+}
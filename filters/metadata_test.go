@@ -0,0 +1,99 @@
+package filters
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/unilog/json"
+)
+
+func TestMetadataFilter(t *testing.T) {
+	f := MetadataFilter{}
+	f.read(strings.NewReader("request_id=abc\nhost=box1\nnonsense\n=empty\n"))
+
+	line := json.LogLine{"message": "hi", "host": "box2"}
+	f.FilterJSON(&line)
+	assert.Equal(t, json.LogLine{"message": "hi", "host": "box2", "request_id": "abc"}, line)
+
+	f.read(strings.NewReader("request_id=def\nhost=\n"))
+	line = json.LogLine{"message": "hi"}
+	f.FilterJSON(&line)
+	assert.Equal(t, json.LogLine{"message": "hi", "request_id": "def"}, line)
+
+	assert.Equal(t, "request_id=xyz", f.FilterLine("request_id=xyz"))
+}
+
+func TestMetadataFilterCollisions(t *testing.T) {
+	defer json.SetCollisionPolicy(json.CollisionFirstWins)
+	f := MetadataFilter{}
+	f.read(strings.NewReader("host=box1\n"))
+
+	require.NoError(t, json.SetCollisionPolicy(json.CollisionLastWins))
+	line := json.LogLine{"host": "box2"}
+	f.FilterJSON(&line)
+	assert.Equal(t, json.LogLine{"host": "box1"}, line)
+
+	require.NoError(t, json.SetCollisionPolicy(json.CollisionArrayMerge))
+	line = json.LogLine{"host": "box2"}
+	f.FilterJSON(&line)
+	assert.Equal(t, json.LogLine{"host": []interface{}{"box2", "box1"}}, line)
+}
+
+func TestMetadataFilterFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "metadata")
+	require.NoError(t, ioutil.WriteFile(path, []byte("request_id=abc\n"), 0644))
+
+	f := MetadataFilter{Path: path}
+	line := json.LogLine{}
+	f.FilterJSON(&line)
+
+	// The file is read in the background
+	assert.Eventually(t, func() bool {
+		line := json.LogLine{}
+		f.FilterJSON(&line)
+		return line["request_id"] == "abc"
+	}, time.Second, time.Millisecond)
+}
+
+func TestMetadataFilterFIFO(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "metadata")
+	require.NoError(t, syscall.Mkfifo(path, 0600))
+
+	// Filtering doesn't wait for a writer to open the FIFO
+	f := MetadataFilter{Path: path}
+	done := make(chan struct{})
+	go func() {
+		line := json.LogLine{}
+		f.FilterJSON(&line)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("FilterJSON blocked opening the FIFO")
+	}
+
+	w, err := os.OpenFile(path, os.O_WRONLY, 0)
+	require.NoError(t, err)
+	defer w.Close()
+	_, err = w.Write([]byte("request_id=abc\n"))
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		line := json.LogLine{}
+		f.FilterJSON(&line)
+		return line["request_id"] == "abc"
+	}, 5*time.Second, time.Millisecond)
+}
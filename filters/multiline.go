@@ -0,0 +1,123 @@
+package filters
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/stripe/unilog/json"
+	flag "launchpad.net/gnuflag"
+)
+
+// DefaultMultilineTimeout is how long MultilineFilter waits for more
+// continuation lines by default.
+const DefaultMultilineTimeout = time.Second
+
+// DefaultMultilineMaxLines is the default limit on the lines
+// MultilineFilter joins into one event.
+const DefaultMultilineMaxLines = 500
+
+// DefaultMultilineSeparator is what MultilineFilter joins lines with
+// by default: a literal \n escape, as a newline would make a joined
+// event more than one line again once it's written.
+const DefaultMultilineSeparator = `\n`
+
+// MultilineFilter joins text lines that match Pattern (continuation
+// lines, like the indented frames of a Java or Python stack trace)
+// onto the line before them, so the whole trace is written as one
+// event, with one timestamp. Lines are joined with Separator
+// (DefaultMultilineSeparator, if it's empty).
+//
+// Each event is held back until a line that isn't a continuation
+// arrives, no line has arrived for Timeout, or it reaches MaxLines
+// lines. A continuation line with no line before it starts an event
+// of its own. A nil Pattern disables it.
+//
+// It's a logger.HoldingFilter: FilterLine passes lines through
+// unchanged, and JSON lines aren't joined.
+type MultilineFilter struct {
+	Pattern   *regexp.Regexp
+	Separator string
+	Timeout   time.Duration
+	MaxLines  int
+	// Now returns the current time (time.Now, if nil).
+	Now func() time.Time
+
+	pending []string
+	// when the last line was added to pending
+	lastAt time.Time
+}
+
+// AddFlags adds multiline flags to the CLI options
+func (f *MultilineFilter) AddFlags() {
+	flag.Var(regexpValue{&f.Pattern}, "multiline-pattern", "(optional) Regular expression matching continuation lines (e.g. '^(\\s|Caused by:)') to join onto the line before them")
+	flag.StringVar(&f.Separator, "multiline-separator", DefaultMultilineSeparator, "String to join continuation lines with (not a newline, which would split them again)")
+	flag.DurationVar(&f.Timeout, "multiline-timeout", DefaultMultilineTimeout, "How long to wait for more continuation lines before writing a joined line")
+	flag.IntVar(&f.MaxLines, "multiline-max-lines", DefaultMultilineMaxLines, "Maximum number of lines to join into one")
+}
+
+func (f *MultilineFilter) now() time.Time {
+	if f.Now != nil {
+		return f.Now()
+	}
+	return time.Now()
+}
+
+// FilterLine passes line through; see FilterLines.
+func (f *MultilineFilter) FilterLine(line string) string {
+	return line
+}
+
+// FilterJSON does nothing.
+func (f *MultilineFilter) FilterJSON(line *json.LogLine) {}
+
+// FilterLines adds a continuation line to the event being held back,
+// or else holds line back as the start of a new event, returning the
+// previous one.
+func (f *MultilineFilter) FilterLines(line string) []string {
+	if f.Pattern == nil {
+		return []string{line}
+	}
+	var out []string
+	if len(f.pending) == 0 || !f.Pattern.MatchString(line) {
+		out = f.Flush()
+	}
+	f.pending = append(f.pending, line)
+	f.lastAt = f.now()
+	maxLines := f.MaxLines
+	if maxLines <= 0 {
+		maxLines = DefaultMultilineMaxLines
+	}
+	if len(f.pending) >= maxLines {
+		out = append(out, f.Flush()...)
+	}
+	return out
+}
+
+// Flush returns the event being held back, if any, joined into one
+// line.
+func (f *MultilineFilter) Flush() []string {
+	if len(f.pending) == 0 {
+		return nil
+	}
+	sep := f.Separator
+	if sep == "" {
+		sep = DefaultMultilineSeparator
+	}
+	joined := strings.Join(f.pending, sep)
+	f.pending = f.pending[:0]
+	return []string{joined}
+}
+
+// FlushAfter returns how long until the event being held back is due
+// to be written, if there is one.
+func (f *MultilineFilter) FlushAfter() (time.Duration, bool) {
+	if len(f.pending) == 0 {
+		return 0, false
+	}
+	timeout := f.Timeout
+	if timeout <= 0 {
+		timeout = DefaultMultilineTimeout
+	}
+	return timeout - f.now().Sub(f.lastAt), true
+}
@@ -0,0 +1,79 @@
+package filters
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var javaTrace = []string{
+	`Exception in thread "main" java.lang.IllegalStateException: boom`,
+	"\tat com.example.App.run(App.java:42)",
+	"\tat com.example.App.main(App.java:10)",
+	"Caused by: java.io.IOException: disk on fire",
+	"\tat com.example.Disk.read(Disk.java:7)",
+}
+
+func multilineAll(f *MultilineFilter, lines ...string) []string {
+	var out []string
+	for _, line := range lines {
+		out = append(out, f.FilterLines(line)...)
+	}
+	return out
+}
+
+func TestMultilineJavaTrace(t *testing.T) {
+	f := &MultilineFilter{Pattern: regexp.MustCompile(`^(\s|Caused by:)`)}
+
+	assert.Empty(t, multilineAll(f, javaTrace...))
+	assert.Equal(t, []string{
+		`Exception in thread "main" java.lang.IllegalStateException: boom\n` +
+			"\tat com.example.App.run(App.java:42)" + `\n` +
+			"\tat com.example.App.main(App.java:10)" + `\n` +
+			`Caused by: java.io.IOException: disk on fire\n` +
+			"\tat com.example.Disk.read(Disk.java:7)",
+	}, f.FilterLines("next event"))
+
+	// Lines that aren't part of a trace come through on their own
+	assert.Equal(t, []string{"next event"}, multilineAll(f, "another"))
+	assert.Equal(t, []string{"another"}, f.Flush())
+	assert.Empty(t, f.Flush())
+}
+
+func TestMultilineEdgeCases(t *testing.T) {
+	f := &MultilineFilter{Pattern: regexp.MustCompile(`^\s`), Separator: " | ", MaxLines: 3}
+
+	// A continuation line with nothing before it starts an event
+	assert.Empty(t, multilineAll(f, "  orphan", "  frame"))
+	assert.Equal(t, []string{"  orphan |   frame"}, f.FilterLines("start"))
+
+	// Events are cut off at MaxLines
+	assert.Equal(t, []string{"start |  a |  b"}, multilineAll(f, " a", " b"))
+	assert.Equal(t, []string{" c"}, multilineAll(f, " c", "end"))
+	assert.Equal(t, []string{"end"}, f.Flush())
+}
+
+func TestMultilineTimeout(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)}
+	f := &MultilineFilter{Pattern: regexp.MustCompile(`^\s`), Timeout: 5 * time.Second, Now: clock.Now}
+
+	_, pending := f.FlushAfter()
+	assert.False(t, pending)
+	multilineAll(f, "start")
+	clock.now = clock.now.Add(3 * time.Second)
+	multilineAll(f, " frame")
+	clock.now = clock.now.Add(time.Second)
+	// The timeout counts from the last line
+	d, pending := f.FlushAfter()
+	assert.True(t, pending)
+	assert.Equal(t, 4*time.Second, d)
+}
+
+func TestMultilineDisabled(t *testing.T) {
+	f := &MultilineFilter{}
+	assert.Equal(t, javaTrace, multilineAll(f, javaTrace...))
+	_, pending := f.FlushAfter()
+	assert.False(t, pending)
+}
@@ -0,0 +1,38 @@
+package filters
+
+import (
+	"github.com/stripe/unilog/json"
+	flag "launchpad.net/gnuflag"
+)
+
+// PrefixSuffixFilter wraps every text line in a fixed Prefix and
+// Suffix, for downstream tooling that keys on a static tag (e.g. a
+// container id) in each line. It runs after the other filters, so
+// the Prefix comes before unilog's timestamp.
+//
+// JSON lines have the Fields set on them instead; fields already
+// present on a line are handled as json.LogLine.Merge does.
+type PrefixSuffixFilter struct {
+	Prefix string
+	Suffix string
+	Fields map[string]string
+}
+
+// AddFlags adds prefix and suffix flags to the CLI options
+func (f *PrefixSuffixFilter) AddFlags() {
+	flag.StringVar(&f.Prefix, "line-prefix", "", "(optional) String to prepend to every text line")
+	flag.StringVar(&f.Suffix, "line-suffix", "", "(optional) String to append to every text line")
+	flag.Var((*fieldMap)(&f.Fields), "json-field", "(optional) key=value field to set on every JSON line; may be repeated")
+}
+
+// FilterLine wraps line in the Prefix and Suffix.
+func (f *PrefixSuffixFilter) FilterLine(line string) string {
+	return f.Prefix + line + f.Suffix
+}
+
+// FilterJSON sets the Fields on line.
+func (f *PrefixSuffixFilter) FilterJSON(line *json.LogLine) {
+	for k, v := range f.Fields {
+		line.Merge(k, v)
+	}
+}
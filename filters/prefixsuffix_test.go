@@ -0,0 +1,46 @@
+package filters
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/unilog/json"
+)
+
+func TestPrefixSuffixLine(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		suffix string
+		want   string
+	}{
+		{"empty", "", "", "hi there"},
+		{"prefix", "c0ffee ", "", "c0ffee hi there"},
+		{"suffix", "", " [c0ffee]", "hi there [c0ffee]"},
+		{"both", "<", ">", "<hi there>"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := &PrefixSuffixFilter{Prefix: test.prefix, Suffix: test.suffix}
+			assert.Equal(t, test.want, f.FilterLine("hi there"))
+		})
+	}
+}
+
+func TestPrefixSuffixJSON(t *testing.T) {
+	f := &PrefixSuffixFilter{Prefix: "c0ffee "}
+	line := json.LogLine{"message": "hi"}
+	f.FilterJSON(&line)
+	assert.Equal(t, json.LogLine{"message": "hi"}, line)
+
+	m := fieldMap{}
+	require.NoError(t, m.Set("container=c0ffee"))
+	require.NoError(t, m.Set("message=overridden?"))
+	assert.Error(t, m.Set("nonsense"))
+	assert.Equal(t, "container=c0ffee,message=overridden?", m.String())
+
+	f.Fields = m
+	f.FilterJSON(&line)
+	assert.Equal(t, json.LogLine{"message": "hi", "container": "c0ffee"}, line)
+}
@@ -0,0 +1,93 @@
+package filters
+
+import (
+	"time"
+
+	"github.com/stripe/unilog/json"
+	flag "launchpad.net/gnuflag"
+)
+
+// RateLimitedLine is what RateLimitFilter replaces text lines over
+// the limit with.
+const RateLimitedLine = "(rate-limited)"
+
+// RateLimitedField is set on JSON lines over the limit, whose other
+// fields (apart from ts) are cleared.
+const RateLimitedField = "rate_limited"
+
+// RateLimitFilter limits lines to Rate per second, with bursts of up
+// to Burst lines (Rate, by default), using a token bucket shared by
+// all lines. Lines over the limit have their contents dropped,
+// retaining their time stamps like AusterityFilter's shedding does:
+// text lines are replaced with "(rate-limited)", and JSON lines are
+// cleared apart from "ts", with rate_limited set to true. (unilog
+// counts them in unilog.lines.dropped, tagged reason:rate_limited.)
+//
+// A Rate of 0 or less disables the limit.
+type RateLimitFilter struct {
+	Rate  float64
+	Burst int
+	// Now returns the current time (time.Now, if nil).
+	Now func() time.Time
+
+	tokens float64
+	last   time.Time
+}
+
+// AddFlags adds rate limiting flags to the CLI options
+func (f *RateLimitFilter) AddFlags() {
+	flag.Float64Var(&f.Rate, "rate-limit", 0, "(optional) Maximum lines per second to write; lines over the limit are dropped")
+	flag.IntVar(&f.Burst, "rate-limit-burst", 0, "Lines that can be written at once under -rate-limit (defaults to -rate-limit)")
+}
+
+// allow reports whether a line may be written now, and if so, takes a
+// token for it. The bucket starts out full, and refills with the
+// time elapsed since the last line.
+func (f *RateLimitFilter) allow() bool {
+	if f.Rate <= 0 {
+		return true
+	}
+	burst := float64(f.Burst)
+	if f.Burst <= 0 {
+		burst = f.Rate
+	}
+	now := time.Now()
+	if f.Now != nil {
+		now = f.Now()
+	}
+	if f.last.IsZero() {
+		f.tokens = burst
+	} else if now.After(f.last) {
+		f.tokens += now.Sub(f.last).Seconds() * f.Rate
+		if f.tokens > burst {
+			f.tokens = burst
+		}
+	}
+	f.last = now
+	if f.tokens < 1 {
+		return false
+	}
+	f.tokens--
+	return true
+}
+
+// FilterLine replaces line with "(rate-limited)" if it's over the
+// limit.
+func (f *RateLimitFilter) FilterLine(line string) string {
+	if !f.allow() {
+		return RateLimitedLine
+	}
+	return line
+}
+
+// FilterJSON clears line if it's over the limit.
+func (f *RateLimitFilter) FilterJSON(line *json.LogLine) {
+	if f.allow() {
+		return
+	}
+	newLine := json.LogLine{RateLimitedField: true}
+	if ts, ok := (*line)["ts"]; ok {
+		newLine["ts"] = ts
+	}
+	*line = newLine
+}
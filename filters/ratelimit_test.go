@@ -0,0 +1,80 @@
+package filters
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/unilog/json"
+)
+
+// fakeClock is a clock for tests that only moves when told to.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func TestRateLimitLine(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)}
+	f := &RateLimitFilter{Rate: 100, Now: clock.Now}
+
+	passed := 0
+	for i := 0; i < 1000; i++ {
+		if f.FilterLine("spew") != RateLimitedLine {
+			passed++
+		}
+		// 1000 lines over a second
+		clock.now = clock.now.Add(time.Millisecond)
+	}
+	// The burst of 100, plus 100 more over the second
+	assert.InDelta(t, 200, passed, 2)
+
+	// The bucket refills while idle, but only up to the burst
+	clock.now = clock.now.Add(time.Minute)
+	passed = 0
+	for i := 0; i < 1000; i++ {
+		if f.FilterLine("spew") != RateLimitedLine {
+			passed++
+		}
+	}
+	assert.Equal(t, 100, passed)
+
+	// Lines at the rate all pass
+	passed = 0
+	for i := 0; i < 1000; i++ {
+		clock.now = clock.now.Add(10 * time.Millisecond)
+		if f.FilterLine("steady") != RateLimitedLine {
+			passed++
+		}
+	}
+	assert.Equal(t, 1000, passed)
+}
+
+func TestRateLimitJSON(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)}
+	f := &RateLimitFilter{Rate: 1, Burst: 2, Now: clock.Now}
+
+	for i := 0; i < 2; i++ {
+		line := json.LogLine{"ts": 1.5, "message": "hi"}
+		f.FilterJSON(&line)
+		assert.Equal(t, json.LogLine{"ts": 1.5, "message": "hi"}, line)
+	}
+	line := json.LogLine{"ts": 1.5, "message": "hi"}
+	f.FilterJSON(&line)
+	assert.Equal(t, json.LogLine{"ts": 1.5, RateLimitedField: true}, line)
+
+	clock.now = clock.now.Add(time.Second)
+	line = json.LogLine{"message": "hi"}
+	f.FilterJSON(&line)
+	assert.Equal(t, json.LogLine{"message": "hi"}, line)
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	f := &RateLimitFilter{}
+	for i := 0; i < 1000; i++ {
+		assert.Equal(t, "hi", f.FilterLine("hi"))
+	}
+}
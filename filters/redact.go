@@ -0,0 +1,124 @@
+package filters
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/stripe/unilog/json"
+	flag "launchpad.net/gnuflag"
+)
+
+// Redacted replaces values that KeyRedactFilter masks completely.
+const Redacted = "[REDACTED]"
+
+// KeyRedactFilter masks the values of JSON fields at the given key
+// paths, whatever they contain. Paths use dot notation to reach into
+// nested objects ("payment.card_number"); arrays along the way are
+// descended into element by element, and an array-valued field has
+// each of its elements masked.
+//
+// Values are replaced with Redacted, unless Visible is set, in which
+// case the last Visible characters of scalar values are kept behind a
+// "****" prefix (values no longer than that are masked completely).
+// Object values are always replaced with Redacted. With Delete, the
+// fields are removed instead. Each masked (or deleted) value is
+// counted in the unilog.redactions metric, tagged with its path.
+//
+// Text lines have no keys; instead, whatever matches one of Patterns
+// is replaced with Redacted, and counted in unilog.redactions tagged
+// with the pattern's index (pattern:0 for the first).
+type KeyRedactFilter struct {
+	Keys     []string
+	Visible  int
+	Delete   bool
+	Patterns []*regexp.Regexp
+}
+
+// AddFlags adds key redaction flags to the CLI options
+func (f *KeyRedactFilter) AddFlags() {
+	flag.Var((*stringList)(&f.Keys), "redact-keys", "Comma-separated JSON key paths (e.g. card_number,payment.ssn) whose values are always masked")
+	flag.IntVar(&f.Visible, "redact-visible", 0, "Number of trailing characters of redacted values to leave visible")
+	flag.BoolVar(&f.Delete, "redact-delete", false, "Delete the fields at -redact-keys instead of masking their values")
+	flag.Var((*regexpList)(&f.Patterns), "redact-pattern", "Regular expression to mask matches of in text lines (can be repeated)")
+}
+
+// FilterLine masks whatever matches f's Patterns in line.
+func (f *KeyRedactFilter) FilterLine(line string) string {
+	for i, p := range f.Patterns {
+		n := 0
+		line = p.ReplaceAllStringFunc(line, func(string) string {
+			n++
+			return Redacted
+		})
+		if n > 0 && Stats != nil {
+			Stats.Count("unilog.redactions", int64(n), []string{"pattern:" + strconv.Itoa(i)}, 1)
+		}
+	}
+	return line
+}
+
+// FilterJSON masks the values at f's key paths in line.
+func (f *KeyRedactFilter) FilterJSON(line *json.LogLine) {
+	for _, key := range f.Keys {
+		n := f.redact(map[string]interface{}(*line), strings.Split(key, "."))
+		if n > 0 && Stats != nil {
+			Stats.Count("unilog.redactions", int64(n), []string{"key:" + key}, 1)
+		}
+	}
+}
+
+// redact masks the values at path under v, and returns how many it
+// masked.
+func (f *KeyRedactFilter) redact(v interface{}, path []string) int {
+	switch v := v.(type) {
+	case []interface{}:
+		n := 0
+		for _, elt := range v {
+			n += f.redact(elt, path)
+		}
+		return n
+	case map[string]interface{}:
+		child, ok := v[path[0]]
+		if !ok {
+			return 0
+		}
+		if len(path) > 1 {
+			return f.redact(child, path[1:])
+		}
+		if f.Delete {
+			delete(v, path[0])
+			return 1
+		}
+		if elts, ok := child.([]interface{}); ok {
+			for i, elt := range elts {
+				elts[i] = f.mask(elt)
+			}
+			return len(elts)
+		}
+		v[path[0]] = f.mask(child)
+		return 1
+	}
+	return 0
+}
+
+func (f *KeyRedactFilter) mask(v interface{}) interface{} {
+	if f.Visible <= 0 || v == nil {
+		return Redacted
+	}
+	var s []rune
+	switch v := v.(type) {
+	case map[string]interface{}, []interface{}:
+		return Redacted
+	case float64:
+		// Don't let long numbers turn into exponents
+		s = []rune(strconv.FormatFloat(v, 'f', -1, 64))
+	default:
+		s = []rune(fmt.Sprint(v))
+	}
+	if len(s) <= f.Visible {
+		return Redacted
+	}
+	return "****" + string(s[len(s)-f.Visible:])
+}
@@ -0,0 +1,73 @@
+package filters
+
+import (
+	encjson "encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/unilog/json"
+)
+
+func redactJSON(t *testing.T, f *KeyRedactFilter, in string) string {
+	var line json.LogLine
+	require.NoError(t, encjson.Unmarshal([]byte(in), &line))
+	f.FilterJSON(&line)
+	out, err := encjson.Marshal(map[string]interface{}(line))
+	require.NoError(t, err)
+	return string(out)
+}
+
+func TestKeyRedactFilter(t *testing.T) {
+	f := &KeyRedactFilter{Keys: []string{"ssn", "payment.card_number", "missing.key"}}
+
+	assert.Equal(t,
+		`{"message":"hi","payment":{"amount":100,"card_number":"[REDACTED]"},"ssn":"[REDACTED]"}`,
+		redactJSON(t, f, `{"message":"hi","ssn":"123-45-6789","payment":{"amount":100,"card_number":"4242424242424242"}}`))
+
+	// Only whole path components match
+	assert.Equal(t,
+		`{"card_number":"4242424242424242","payment":"card"}`,
+		redactJSON(t, f, `{"payment":"card","card_number":"4242424242424242"}`))
+
+	f.Visible = 4
+	assert.Equal(t,
+		`{"payment":{"card_number":"****4242"},"ssn":"[REDACTED]"}`,
+		redactJSON(t, f, `{"ssn":"123","payment":{"card_number":4242424242424242}}`))
+
+	assert.Equal(t, "ssn=123", f.FilterLine("ssn=123"))
+}
+
+func TestKeyRedactFilterDelete(t *testing.T) {
+	f := &KeyRedactFilter{Keys: []string{"email", "user.card", "cards.number", "missing", "message.email"}, Delete: true}
+
+	assert.Equal(t,
+		`{"cards":[{"brand":"visa"},"bare"],"message":"hi","user":{"id":1}}`,
+		redactJSON(t, f, `{"message":"hi","email":"a@example.com","user":{"id":1,"card":{"number":"4242"}},"cards":[{"brand":"visa","number":"4242"},"bare"]}`))
+}
+
+func TestKeyRedactFilterPatterns(t *testing.T) {
+	f := &KeyRedactFilter{}
+	require.NoError(t, (*regexpList)(&f.Patterns).Set(`\b\d{3}-\d{2}-\d{4}\b`))
+	require.NoError(t, (*regexpList)(&f.Patterns).Set(`[\w.]+@[\w.]+`))
+	assert.Error(t, (*regexpList)(&f.Patterns).Set(`(`))
+
+	assert.Equal(t,
+		"user [REDACTED] has ssn [REDACTED] and [REDACTED]",
+		f.FilterLine("user a@example.com has ssn 123-45-6789 and 987-65-4321"))
+	assert.Equal(t, "nothing to see", f.FilterLine("nothing to see"))
+}
+
+func TestKeyRedactFilterArrays(t *testing.T) {
+	f := &KeyRedactFilter{Keys: []string{"cards.number", "ssns"}}
+
+	assert.Equal(t,
+		`{"cards":[{"brand":"visa","number":"[REDACTED]"},{"number":"[REDACTED]"},"bare"],"ssns":["[REDACTED]","[REDACTED]"]}`,
+		redactJSON(t, f, `{"cards":[{"brand":"visa","number":"4242"},{"number":"5555"},"bare"],"ssns":["1","2"]}`))
+
+	// Objects are always masked completely
+	f = &KeyRedactFilter{Keys: []string{"card"}, Visible: 4}
+	assert.Equal(t,
+		`{"card":"[REDACTED]"}`,
+		redactJSON(t, f, `{"card":{"number":"4242424242424242"}}`))
+}
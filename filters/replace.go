@@ -0,0 +1,72 @@
+package filters
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/stripe/unilog/json"
+	flag "launchpad.net/gnuflag"
+)
+
+// ReplaceRule replaces matches of Pattern with Replacement, which
+// can refer to capture groups as in regexp.Regexp.ReplaceAllString
+// ($1, or ${1} when followed by a letter, digit or underscore).
+type ReplaceRule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// RegexReplaceFilter applies its Rules, in order, to text lines and
+// to the "message" field of JSON lines (if it's a string), e.g. to
+// scrub API keys out of lines before they leave the box.
+type RegexReplaceFilter struct {
+	Rules []ReplaceRule
+}
+
+// AddFlags adds regex replacement flags to the CLI options
+func (f *RegexReplaceFilter) AddFlags() {
+	flag.Var((*replaceRules)(&f.Rules), "replace", "(optional) pattern=>replacement rule to apply to text lines and JSON messages; may be repeated")
+}
+
+// FilterLine applies the Rules to line.
+func (f *RegexReplaceFilter) FilterLine(line string) string {
+	for _, r := range f.Rules {
+		line = r.Pattern.ReplaceAllString(line, r.Replacement)
+	}
+	return line
+}
+
+// FilterJSON applies the Rules to line's message.
+func (f *RegexReplaceFilter) FilterJSON(line *json.LogLine) {
+	msg, ok := (*line)["message"].(string)
+	if !ok {
+		return
+	}
+	(*line)["message"] = f.FilterLine(msg)
+}
+
+// replaceRules is a flag.Value that compiles pattern=>replacement
+// rules, and can be repeated. The pattern ends at the first "=>".
+type replaceRules []ReplaceRule
+
+func (l *replaceRules) Set(value string) error {
+	parts := strings.SplitN(value, "=>", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("%q is not a pattern=>replacement rule", value)
+	}
+	r, err := regexp.Compile(parts[0])
+	if err != nil {
+		return fmt.Errorf("invalid pattern in %q: %s", value, err)
+	}
+	*l = append(*l, ReplaceRule{Pattern: r, Replacement: parts[1]})
+	return nil
+}
+
+func (l *replaceRules) String() string {
+	rules := make([]string, len(*l))
+	for i, r := range *l {
+		rules[i] = r.Pattern.String() + "=>" + r.Replacement
+	}
+	return strings.Join(rules, " ")
+}
@@ -0,0 +1,54 @@
+package filters
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/unilog/json"
+)
+
+func replaceFilter(t *testing.T, rules ...string) *RegexReplaceFilter {
+	f := &RegexReplaceFilter{}
+	for _, rule := range rules {
+		require.NoError(t, (*replaceRules)(&f.Rules).Set(rule))
+	}
+	return f
+}
+
+func TestRegexReplaceLine(t *testing.T) {
+	f := replaceFilter(t, `(\d{4})\d+(\d{4})=>$1****$2`, `sk_live_\w+=>sk_live_[SCRUBBED]`)
+	assert.Equal(t,
+		"charged 4242****4242 with sk_live_[SCRUBBED]",
+		f.FilterLine("charged 4242424242424242 with sk_live_abc123XYZ"))
+	assert.Equal(t, "nothing to see", f.FilterLine("nothing to see"))
+
+	// Rules apply in order
+	f = replaceFilter(t, `a=>b`, `b=>c`)
+	assert.Equal(t, "cc", f.FilterLine("ab"))
+
+	// The pattern ends at the first =>, and replacements may be empty
+	f = replaceFilter(t, `\s*\(debug\)=>`, `x=>=>`)
+	assert.Equal(t, "=>done", f.FilterLine("x (debug)done"))
+}
+
+func TestRegexReplaceJSON(t *testing.T) {
+	f := replaceFilter(t, `(\d{4})\d+(\d{4})=>$1****$2`)
+	line := json.LogLine{"message": "card 4242424242424242", "card": "4242424242424242"}
+	f.FilterJSON(&line)
+	assert.Equal(t, json.LogLine{"message": "card 4242****4242", "card": "4242424242424242"}, line)
+
+	line = json.LogLine{"message": 4242424242424242.0}
+	f.FilterJSON(&line)
+	assert.Equal(t, json.LogLine{"message": 4242424242424242.0}, line)
+}
+
+func TestReplaceRules(t *testing.T) {
+	var rules replaceRules
+	assert.EqualError(t, rules.Set("no arrow"), `"no arrow" is not a pattern=>replacement rule`)
+	assert.Error(t, rules.Set("=>empty pattern"))
+	err := rules.Set(`(unclosed=>x`)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid pattern")
+	assert.Empty(t, rules)
+}
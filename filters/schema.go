@@ -0,0 +1,107 @@
+package filters
+
+import (
+	encjson "encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/stripe/unilog/json"
+	flag "launchpad.net/gnuflag"
+)
+
+// Policies for handling JSON lines that fail schema validation.
+const (
+	// SchemaAnnotate writes invalid lines with a "_schema_error"
+	// field describing the problem.
+	SchemaAnnotate = "annotate"
+	// SchemaDrop drops invalid lines.
+	SchemaDrop = "drop"
+	// SchemaDeadLetter writes invalid lines (annotated like
+	// SchemaAnnotate) to the DeadLetter writer instead of the log.
+	SchemaDeadLetter = "deadletter"
+
+	// SchemaDeadLetterReason is the reason invalid lines are
+	// dead-lettered with.
+	SchemaDeadLetterReason = "schema_invalid"
+)
+
+// SchemaErrorField is the field that SchemaFilter annotates invalid
+// lines with.
+const SchemaErrorField = "_schema_error"
+
+// SchemaFilter validates that JSON lines contain all of the Required
+// fields, and handles lines that don't according to Policy (one of
+// SchemaAnnotate, the default, SchemaDrop or SchemaDeadLetter). Every
+// invalid line is counted in the unilog.schema.invalid metric.
+//
+// Under SchemaDeadLetter, invalid lines are passed to DeadLetter (which
+// is typically logger.Unilog's DeadLetter method) and dropped from the
+// log.
+//
+// Text lines are passed through unchanged.
+type SchemaFilter struct {
+	Required []string
+	Policy   string
+	// DeadLetter receives invalid lines, marshalled to JSON,
+	// under the SchemaDeadLetter policy.
+	DeadLetter func(line, reason string)
+}
+
+// AddFlags adds schema validation flags to the CLI options
+func (f *SchemaFilter) AddFlags() {
+	flag.Var((*stringList)(&f.Required), "schema-required", "Comma-separated fields that every JSON line must contain")
+	flag.StringVar(&f.Policy, "schema-policy", SchemaAnnotate, "What to do with JSON lines missing required fields: annotate, drop or deadletter")
+}
+
+// FilterLine is a no-op: there's no schema to validate text against.
+func (f *SchemaFilter) FilterLine(line string) string {
+	return line
+}
+
+// FilterJSON validates line and applies the filter's policy if it is
+// missing any required fields.
+func (f *SchemaFilter) FilterJSON(line *json.LogLine) {
+	var missing []string
+	for _, field := range f.Required {
+		if _, ok := (*line)[field]; !ok {
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	policy := f.Policy
+	if policy == "" {
+		policy = SchemaAnnotate
+	}
+	if Stats != nil {
+		Stats.Count("unilog.schema.invalid", 1, []string{"policy:" + policy}, 1)
+	}
+
+	switch policy {
+	case SchemaDrop:
+		*line = nil
+	case SchemaDeadLetter:
+		(*line)[SchemaErrorField] = schemaError(missing)
+		f.deadLetter(*line)
+		*line = nil
+	default:
+		(*line)[SchemaErrorField] = schemaError(missing)
+	}
+}
+
+func (f *SchemaFilter) deadLetter(line json.LogLine) {
+	if f.DeadLetter == nil {
+		return
+	}
+	b, err := encjson.Marshal(line)
+	if err != nil {
+		return
+	}
+	f.DeadLetter(string(b), SchemaDeadLetterReason)
+}
+
+func schemaError(missing []string) string {
+	return fmt.Sprintf("missing required fields: %s", strings.Join(missing, ", "))
+}
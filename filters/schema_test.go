@@ -0,0 +1,59 @@
+package filters
+
+import (
+	encjson "encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/unilog/json"
+)
+
+func TestSchemaValid(t *testing.T) {
+	f := SchemaFilter{Required: []string{"message", "service"}, Policy: SchemaDrop}
+	line := json.LogLine{"message": "hi", "service": "api"}
+	f.FilterJSON(&line)
+	assert.Equal(t, json.LogLine{"message": "hi", "service": "api"}, line)
+}
+
+func TestSchemaAnnotate(t *testing.T) {
+	f := SchemaFilter{Required: []string{"message", "service"}}
+	line := json.LogLine{"message": "hi"}
+	f.FilterJSON(&line)
+	assert.Equal(t, json.LogLine{
+		"message":        "hi",
+		SchemaErrorField: "missing required fields: service",
+	}, line)
+}
+
+func TestSchemaDrop(t *testing.T) {
+	f := SchemaFilter{Required: []string{"message", "service"}, Policy: SchemaDrop}
+	line := json.LogLine{"message": "hi"}
+	f.FilterJSON(&line)
+	assert.Nil(t, line)
+}
+
+func TestSchemaDeadLetter(t *testing.T) {
+	var deadLine, deadReason string
+	f := SchemaFilter{
+		Required: []string{"message", "service"},
+		Policy:   SchemaDeadLetter,
+		DeadLetter: func(line, reason string) {
+			deadLine, deadReason = line, reason
+		},
+	}
+	line := json.LogLine{"level": "info"}
+	f.FilterJSON(&line)
+	assert.Nil(t, line)
+	assert.Equal(t, SchemaDeadLetterReason, deadReason)
+
+	var dead json.LogLine
+	require.NoError(t, encjson.Unmarshal([]byte(deadLine), &dead))
+	assert.Equal(t, "info", dead["level"])
+	assert.Equal(t, "missing required fields: message, service", dead[SchemaErrorField])
+}
+
+func TestSchemaLine(t *testing.T) {
+	f := SchemaFilter{Required: []string{"message"}, Policy: SchemaDrop}
+	assert.Equal(t, "hi", f.FilterLine("hi"))
+}
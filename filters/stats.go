@@ -0,0 +1,12 @@
+package filters
+
+// StatsClient is the part of a statsd client (such as
+// *statsd.Client) that filters use.
+type StatsClient interface {
+	Count(name string, value int64, tags []string, rate float64) error
+	Gauge(name string, value float64, tags []string, rate float64) error
+}
+
+// Stats is the statsd client that filters report metrics to. If it
+// is nil, no metrics are reported.
+var Stats StatsClient
@@ -0,0 +1,154 @@
+package filters
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/stripe/unilog/json"
+	flag "launchpad.net/gnuflag"
+)
+
+// DefaultTimeLayouts are the layouts of the leading timestamps that
+// TimeNormalizeFilter recognizes, besides its Layouts. Fractional
+// seconds are accepted after the seconds of any layout, and layouts
+// without a time zone are in local time.
+var DefaultTimeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05Z07:00",
+	"2006/01/02 15:04:05",
+	// syslog (the year is taken to be the current one)
+	"Jan _2 15:04:05",
+}
+
+// TimeNormalizeFilter rewrites the leading timestamp of text lines,
+// which producers write in all sorts of formats, into a canonical
+// one, so that downstream time-based parsing only has to deal with
+// one format. Timestamps are recognized by the Layouts (Go time
+// layouts), and then DefaultTimeLayouts, either at the very start of
+// the line or in square brackets; they are replaced with the time in
+// Format (TimePrefixFilter's default format, if unset) in square
+// brackets, followed by a space and the rest of the line.
+//
+// Lines without a recognizable timestamp are left untouched, unless
+// Prepend is set, in which case the current time is prepended to them.
+// TimeNormalizeFilter only does anything when Enabled is set; as it
+// doesn't stop TimePrefixFilter from adding the time the line was
+// read, it is usually used together with -omit-timestamps.
+//
+// JSON lines are passed through unchanged; their timestamps are
+// normalized when they are written anyway.
+type TimeNormalizeFilter struct {
+	Enabled bool
+	Layouts []string
+	Format  string
+	Prepend bool
+}
+
+// AddFlags adds timestamp normalization flags to the CLI options
+func (f *TimeNormalizeFilter) AddFlags() {
+	flag.BoolVar(&f.Enabled, "normalize-timestamps", false, "Rewrite text lines' leading timestamps into the same format as unilog's own")
+	flag.Var((*repeatedList)(&f.Layouts), "normalize-timestamps-layout", `(optional) Go time layout (e.g. "02/Jan/2006:15:04:05 -0700") of leading timestamps to recognize, besides the defaults; may be repeated`)
+	flag.BoolVar(&f.Prepend, "normalize-timestamps-prepend", false, "Prepend the current time to text lines without a recognizable timestamp")
+}
+
+// FilterLine rewrites the leading timestamp of line.
+func (f *TimeNormalizeFilter) FilterLine(line string) string {
+	if !f.Enabled {
+		return line
+	}
+	ts, rest, ok := f.parseLeadingTime(line)
+	if !ok {
+		if !f.Prepend {
+			return line
+		}
+		ts, rest = time.Now(), line
+	}
+	return fmt.Sprintf("[%s] %s", ts.Local().Format(f.getTimeFormat()), rest)
+}
+
+// FilterJSON is a no-op: JSON timestamps are normalized by
+// json.LogLine.
+func (f *TimeNormalizeFilter) FilterJSON(line *json.LogLine) {}
+
+func (f *TimeNormalizeFilter) getTimeFormat() string {
+	if f.Format != "" {
+		return f.Format
+	}
+	return defaultFormat
+}
+
+// parseLeadingTime parses the timestamp at the start of line, and
+// returns it and the rest of the line after it (and any whitespace).
+func (f *TimeNormalizeFilter) parseLeadingTime(line string) (time.Time, string, bool) {
+	if strings.HasPrefix(line, "[") {
+		end := strings.IndexByte(line, ']')
+		if end < 0 {
+			return time.Time{}, "", false
+		}
+		ts, ok := f.parseTime(line[1:end])
+		return ts, strings.TrimLeftFunc(line[end+1:], unicode.IsSpace), ok
+	}
+
+	for _, layouts := range [][]string{f.Layouts, DefaultTimeLayouts} {
+		for _, layout := range layouts {
+			// Try the same number of words as the layout has
+			end := fieldsEnd(line, len(strings.Fields(layout)))
+			if end < 0 {
+				continue
+			}
+			if ts, ok := parseLayout(layout, line[:end]); ok {
+				return ts, strings.TrimLeftFunc(line[end:], unicode.IsSpace), true
+			}
+		}
+	}
+	return time.Time{}, "", false
+}
+
+// parseTime parses s, which should be just a timestamp.
+func (f *TimeNormalizeFilter) parseTime(s string) (time.Time, bool) {
+	for _, layouts := range [][]string{f.Layouts, DefaultTimeLayouts} {
+		for _, layout := range layouts {
+			if ts, ok := parseLayout(layout, s); ok {
+				return ts, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseLayout parses s according to layout, in local time unless the
+// layout has a time zone. A missing year is taken to be this year.
+func parseLayout(layout, s string) (time.Time, bool) {
+	ts, err := time.ParseInLocation(layout, s, time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if ts.Year() == 0 {
+		ts = ts.AddDate(time.Now().Year(), 0, 0)
+	}
+	return ts, true
+}
+
+// fieldsEnd returns the index in s just after its first n
+// whitespace-separated fields, or -1 if it has fewer than n.
+func fieldsEnd(s string, n int) int {
+	i := 0
+	for ; n > 0; n-- {
+		start := strings.IndexFunc(s[i:], func(r rune) bool { return !unicode.IsSpace(r) })
+		if start < 0 {
+			return -1
+		}
+		i += start
+		end := strings.IndexFunc(s[i:], unicode.IsSpace)
+		if end < 0 {
+			if n > 1 {
+				return -1
+			}
+			return len(s)
+		}
+		i += end
+	}
+	return i
+}
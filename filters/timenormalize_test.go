@@ -0,0 +1,83 @@
+package filters
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/unilog/json"
+)
+
+func TestTimeNormalizeLine(t *testing.T) {
+	f := &TimeNormalizeFilter{Enabled: true}
+	utc := time.Date(2016, 11, 10, 19, 18, 5, 844100000, time.UTC).Local().Format(defaultFormat)
+	year := time.Now().Year()
+
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"unilog prefix", `[2016-11-10 19:18:05.844100] [98381|host] CANONICAL-API-LINE: status=200`, `[2016-11-10 19:18:05.844100] [98381|host] CANONICAL-API-LINE: status=200`},
+		{"bracketed without fraction", `[2016-11-10 19:18:05] hi`, `[2016-11-10 19:18:05.000000] hi`},
+		{"bare", `2016-11-10 19:18:05.8441 hi there`, `[2016-11-10 19:18:05.844100] hi there`},
+		{"rfc3339", `2016-11-10T19:18:05.8441Z hi`, "[" + utc + "] hi"},
+		{"rfc3339 bracketed", `[2016-11-10T19:18:05.8441Z]  hi`, "[" + utc + "] hi"},
+		{"go log", `2016/11/10 19:18:05 hi`, `[2016-11-10 19:18:05.000000] hi`},
+		{"syslog", `Nov 10 19:18:05 host app[1]: hi`, "[" + time.Date(year, 11, 10, 19, 18, 5, 0, time.Local).Format(defaultFormat) + "] host app[1]: hi"},
+		{"syslog single digit day", `Nov  1 19:18:05 hi`, "[" + time.Date(year, 11, 1, 19, 18, 5, 0, time.Local).Format(defaultFormat) + "] hi"},
+		{"only a timestamp", `2016-11-10 19:18:05`, `[2016-11-10 19:18:05.000000] `},
+		{"no timestamp", `hi there`, `hi there`},
+		{"other brackets", `[98381|host] hi`, `[98381|host] hi`},
+		{"unterminated bracket", `[2016-11-10 19:18:05 hi`, `[2016-11-10 19:18:05 hi`},
+		{"timestamp later on", `hi at 2016-11-10 19:18:05`, `hi at 2016-11-10 19:18:05`},
+		{"empty", ``, ``},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, f.FilterLine(test.line))
+		})
+	}
+}
+
+func TestTimeNormalizeOptions(t *testing.T) {
+	f := &TimeNormalizeFilter{}
+	assert.Equal(t, "2016/11/10 19:18:05 hi", f.FilterLine("2016/11/10 19:18:05 hi"))
+
+	f.Enabled = true
+	line := `10/Nov/2016:19:18:05 +0000 "GET / HTTP/1.1" 200`
+	assert.Equal(t, line, f.FilterLine(line))
+	f.Layouts = []string{"02/Jan/2006:15:04:05 -0700"}
+	want := time.Date(2016, 11, 10, 19, 18, 5, 0, time.UTC).Local().Format(defaultFormat)
+	assert.Equal(t, "["+want+`] "GET / HTTP/1.1" 200`, f.FilterLine(line))
+
+	f.Format = time.RFC3339
+	assert.Equal(t, "[2016-11-10T19:18:05"+time.Date(2016, 11, 10, 19, 18, 5, 0, time.Local).Format("Z07:00")+"] hi", f.FilterLine("2016/11/10 19:18:05 hi"))
+
+	f.Format = ""
+	f.Prepend = true
+	low := time.Now()
+	out := f.FilterLine("hi")
+	require.Len(t, out, len(defaultFormat)+5)
+	assert.Equal(t, " hi", out[len(defaultFormat)+2:])
+	between(t, out[1:len(defaultFormat)+1], defaultFormat, low, time.Now())
+	ts, ok := ParseTimePrefix(out)
+	assert.True(t, ok)
+	assert.False(t, ts.IsZero())
+}
+
+func TestTimeNormalizeJSON(t *testing.T) {
+	f := &TimeNormalizeFilter{Enabled: true}
+	line := json.LogLine{"timestamp": "2016-11-10T19:18:05Z"}
+	f.FilterJSON(&line)
+	assert.Equal(t, json.LogLine{"timestamp": "2016-11-10T19:18:05Z"}, line)
+}
+
+func TestFieldsEnd(t *testing.T) {
+	assert.Equal(t, 3, fieldsEnd("abc def", 1))
+	assert.Equal(t, 7, fieldsEnd("abc def", 2))
+	assert.Equal(t, 8, fieldsEnd("abc  def ghi", 2))
+	assert.Equal(t, -1, fieldsEnd("abc def", 3))
+	assert.Equal(t, -1, fieldsEnd("", 1))
+}
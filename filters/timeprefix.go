@@ -11,7 +11,8 @@ import (
 const defaultFormat = "2006-01-02 15:04:05.000000"
 
 // TimePrefixFilter prepends a timestamp onto each event line using the specified
-// format string, plus an optional newline.
+// format string, plus an optional newline. Setting Omit (-omit-timestamps, or
+// the logger's legacy -notimestamp flag) disables it.
 type TimePrefixFilter struct {
 	Omit   bool
 	Format string
@@ -29,6 +30,20 @@ func (f *TimePrefixFilter) FilterLine(line string) string {
 // FilterJSON is a no-op - TimePrefixFilter does nothing on JSON logs (for now!).
 func (f *TimePrefixFilter) FilterJSON(line *json.LogLine) {}
 
+// ParseTimePrefix parses the time from a "[2006-01-02 15:04:05.000000] "
+// prefix (TimePrefixFilter's default format) at the start of line, in
+// local time, and reports whether there was one.
+func ParseTimePrefix(line string) (time.Time, bool) {
+	if len(line) < len(defaultFormat)+2 || line[0] != '[' || line[len(defaultFormat)+1] != ']' {
+		return time.Time{}, false
+	}
+	ts, err := time.ParseInLocation(defaultFormat, line[1:len(defaultFormat)+1], time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return ts, true
+}
+
 func (f *TimePrefixFilter) getTimeFormat() string {
 	if f.Format != "" {
 		return f.Format
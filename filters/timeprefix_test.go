@@ -56,3 +56,14 @@ func between(t *testing.T, check string, format string, bottom, high time.Time)
 	assert.True(t, (c.After(plow) || c.Equal(plow)), "Input (%q) was below lower bound (%q) by %dns", check, plow.Format(format), plow.Sub(c))
 	assert.True(t, (phigh.After(c) || c.Equal(phigh)), "Input (%q) was above upper bound (%q) by %dns", check, phigh.Format(format), c.Sub(phigh))
 }
+
+func TestParseTimePrefix(t *testing.T) {
+	ts, ok := ParseTimePrefix("[2019-02-18 12:46:02.283873] hi")
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2019, 2, 18, 12, 46, 2, 283873000, time.Local), ts)
+
+	for _, line := range []string{"", "hi", "[2019-02-18] hi", "[2019-02-18 12:46:02.28387x] hi"} {
+		_, ok := ParseTimePrefix(line)
+		assert.False(t, ok, line)
+	}
+}
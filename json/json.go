@@ -10,13 +10,25 @@
 // special (all are optional):
 //
 //    - timestamp: The time stamp of an event. Unilog understands both
-//      epoch timestamps as float, or RFC3339Nano-formatted
-//      timestamps. The timestamp will be normalized to
-//      nanosecond-resolution float "timestamp" fields.
+//      epoch timestamps as float (in seconds, or, if they're too large
+//      for that, in milliseconds, microseconds or nanoseconds), or
+//      strings in one of the TimestampLayouts (RFC3339Nano, by
+//      default, among others). The timestamp will be normalized to
+//      nanosecond-resolution float "timestamp" fields (the
+//      resolution can be lowered with SetTimestampPrecision, and the
+//      field renamed with SetTimestampField).
 //    - canonical: Identifies the log event as "canonical", i.e. the
 //      most important line a service can log. It is considered to have
 //      the highest criticality level.
-//    - clevel: The criticality level of the event.
+//    - clevel: The criticality level of the event, either as a name
+//      ("sheddable" through "criticalplus") or a number (0 through 3).
+//      Numbers are rounded to the nearest integer (2.5 rounds up to
+//      3) and then clamped to 0 through 3, so -1 is "sheddable" and
+//      7 is "criticalplus".
+//
+// Filters that add fields to log lines do so with LogLine.Merge, which
+// resolves collisions with existing fields according to a single
+// policy (see SetCollisionPolicy).
 //
 // Example
 //
@@ -28,6 +40,8 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"math"
+	"sort"
 	"time"
 )
 
@@ -35,45 +49,212 @@ import (
 // can destructure.
 type LogLine map[string]interface{}
 
-const timestampField = "timestamp"
+// DefaultTimestampField is the field MarshalJSON writes the timestamp
+// to, unless SetTimestampField changes it.
+const DefaultTimestampField = "timestamp"
+
+// timestampField is the field MarshalJSON writes the timestamp to.
+var timestampField = DefaultTimestampField
 
+// tsFields are the fields a timestamp is parsed from, in order: the
+// configured timestampField, then the defaults.
 var tsFields = []string{
-	timestampField,
+	DefaultTimestampField,
 	"ts",
 }
 
+// SetTimestampField configures the field that MarshalJSON writes the
+// timestamp to (DefaultTimestampField, by default), e.g. "@timestamp".
+// Timestamps are parsed from that field first, and then from
+// "timestamp" and "ts" as usual. Only the configured field is replaced
+// on output; other timestamp fields are written as they were.
+func SetTimestampField(field string) error {
+	if field == "" {
+		return fmt.Errorf("the timestamp field name can't be empty")
+	}
+	timestampField = field
+	tsFields = []string{field}
+	for _, f := range []string{DefaultTimestampField, "ts"} {
+		if f != field {
+			tsFields = append(tsFields, f)
+		}
+	}
+	name, _ := json.Marshal(field)
+	encodePrefix = []byte(fmt.Sprintf(`{%s:`, name))
+	return nil
+}
+
+// TimestampField returns the field that MarshalJSON writes the
+// timestamp to.
+func TimestampField() string {
+	return timestampField
+}
+
+// DefaultTimestampLayouts are the layouts that timestamp strings are
+// parsed with, in order, unless AddTimestampLayout adds more. Layouts
+// without a time zone (ISO 8601 without one, and the same with a
+// space instead of the T) are read as UTC; the last one is the common
+// log format's.
+var DefaultTimestampLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC1123Z,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	"02/Jan/2006:15:04:05 -0700",
+}
+
+// timestampLayouts are the layouts that timestamp strings are parsed
+// with, in order.
+var timestampLayouts = append([]string(nil), DefaultTimestampLayouts...)
+
+// AddTimestampLayout adds a layout (as in time.Parse) to try after
+// the others when parsing timestamp strings. It returns an error if
+// the layout has no date or time elements, so that it would only
+// ever parse itself.
+func AddTimestampLayout(layout string) error {
+	if time.Unix(0, 0).UTC().Format(layout) == layout {
+		return fmt.Errorf("timestamp layout %q has no date or time elements", layout)
+	}
+	timestampLayouts = append(timestampLayouts, layout)
+	return nil
+}
+
+// TimestampLayouts returns the layouts that timestamp strings are
+// parsed with, in order.
+func TimestampLayouts() []string {
+	return append([]string(nil), timestampLayouts...)
+}
+
 // Timestamp returns the timestamp of a log line; if a timestamp is
 // set on the line, Timestamp will attempt to interpret it
 // (integers/floats as UNIX epochs with fractional sub-second
-// components, and strings first according to time.RFC3339Nano and
-// then time.RFC1123Z). If no timestamp is present, or the present
-// time stamp can not be parsed, Timestamp returns the current time.
+// components, and strings according to the first of the
+// TimestampLayouts that parses them). If no timestamp is present, or
+// the present time stamp can not be parsed, Timestamp returns the
+// current time.
 func (j *LogLine) Timestamp() time.Time {
+	if ts, ok := j.EventTime(); ok {
+		return ts
+	}
+	return time.Now()
+}
+
+// EventTime interprets the timestamp of a log line like Timestamp
+// does, but reports whether it found one it could parse instead of
+// falling back to the current time.
+func (j *LogLine) EventTime() (time.Time, bool) {
+	ts, fallback := j.ParseEventTime()
+	return ts, fallback == ""
+}
+
+// Reasons that a log line has no usable timestamp, as returned by
+// ParseEventTime.
+const (
+	// TimestampMissing means the line has no timestamp field.
+	TimestampMissing = "missing"
+	// TimestampUnparseable means the line has a timestamp field,
+	// but it can't be interpreted.
+	TimestampUnparseable = "unparseable"
+)
+
+// ParseEventTime interprets the timestamp of a log line like
+// EventTime does. If there is no timestamp it can parse, it returns
+// the reason (TimestampMissing or TimestampUnparseable) that Timestamp
+// would fall back to the current time for the line; otherwise, the
+// reason is "".
+func (j *LogLine) ParseEventTime() (time.Time, string) {
+	fallback := TimestampMissing
 	for _, tsField := range tsFields {
 		if tsS, ok := (*j)[tsField]; ok {
 			// We support two different kinds of
 			// timestamps here: UNIX epoch timestamps as
-			// floats, and RFC3339Nano for strings:
+			// floats, and the timestampLayouts for strings:
 			switch tsV := tsS.(type) {
 			case string:
-				ts, err := time.Parse(time.RFC3339Nano, tsV)
-				if err == nil {
-					return ts
-				}
-				ts, err = time.Parse(time.RFC1123Z, tsV)
-				if err == nil {
-					return ts
+				for _, layout := range timestampLayouts {
+					if ts, err := time.Parse(layout, tsV); err == nil {
+						return ts, ""
+					}
 				}
+				fallback = TimestampUnparseable
 			case float64:
-				epochInt := int64(tsV)
-				nsec := int64((tsV - float64(epochInt)) * 1000000000)
-				return time.Unix(epochInt, nsec)
+				return epochTime(tsV), ""
 			default:
-				return time.Now()
+				return time.Time{}, TimestampUnparseable
 			}
 		}
 	}
-	return time.Now()
+	return time.Time{}, fallback
+}
+
+// Epoch timestamps at least this large (in magnitude) aren't seconds,
+// but milliseconds, microseconds or nanoseconds, which producers also
+// emit as integers. As seconds, each threshold would be more than
+// 3000 years from now, and as the next smaller unit, about 1973; so
+// every timestamp from 1973 to 5138 is read in the right unit.
+const (
+	epochMillisThreshold = 1e11
+	epochMicrosThreshold = 1e14
+	epochNanosThreshold  = 1e17
+)
+
+// epochTime interprets an epoch timestamp: as seconds, with a
+// fractional sub-second component, unless it's too large for that
+// (see epochMillisThreshold).
+func epochTime(epoch float64) time.Time {
+	switch abs := math.Abs(epoch); {
+	case abs >= epochNanosThreshold:
+		epoch /= 1e9
+	case abs >= epochMicrosThreshold:
+		epoch /= 1e6
+	case abs >= epochMillisThreshold:
+		epoch /= 1e3
+	}
+	epochInt := int64(epoch)
+	nsec := int64((epoch - float64(epochInt)) * 1000000000)
+	return time.Unix(epochInt, nsec)
+}
+
+// timestampDigits is the number of fractional (sub-second) digits
+// that MarshalJSON emits for the timestamp field.
+var timestampDigits = 9
+
+// timestampPrecisions maps the names accepted by
+// SetTimestampPrecision to a number of fractional digits.
+var timestampPrecisions = map[string]int{
+	"s":  0,
+	"ms": 3,
+	"us": 6,
+	"ns": 9,
+}
+
+// SetTimestampPrecision configures the precision of the float epoch
+// timestamps that MarshalJSON emits. Valid precisions are "s", "ms",
+// "us" and "ns" (the default). Timestamps are truncated, not rounded,
+// to the requested precision.
+func SetTimestampPrecision(precision string) error {
+	digits, ok := timestampPrecisions[precision]
+	if !ok {
+		return fmt.Errorf("invalid timestamp precision %q (valid: s, ms, us, ns)", precision)
+	}
+	timestampDigits = digits
+	return nil
+}
+
+// writeTimestamp writes ts as a float UNIX epoch with
+// timestampDigits fractional digits to b.
+func writeTimestamp(b *bytes.Buffer, ts time.Time) {
+	nsepoch := ts.UnixNano()
+	sec := time.Duration(nsepoch) / time.Second
+	nsec := (time.Duration(nsepoch) - (sec * time.Second)) / time.Nanosecond
+	if timestampDigits == 0 {
+		fmt.Fprintf(b, "%d", sec)
+		return
+	}
+	for i := timestampDigits; i < 9; i++ {
+		nsec /= 10
+	}
+	fmt.Fprintf(b, "%d.%0*d", sec, timestampDigits, nsec)
 }
 
 // Holds the starting `{`, timestamp field name and field separator
@@ -81,35 +262,74 @@ func (j *LogLine) Timestamp() time.Time {
 var encodePrefix []byte
 
 func init() {
-	encodePrefix = []byte(fmt.Sprintf(`{"%s":`, timestampField))
+	SetTimestampField(DefaultTimestampField)
+}
+
+// fieldOrder holds the keys that MarshalJSON writes first (after the
+// timestamp), in order.
+var fieldOrder []string
+
+// SetFieldOrder configures MarshalJSON to write the given keys, if
+// present, right after the timestamp and in the given order. The
+// remaining keys follow them.
+func SetFieldOrder(keys []string) {
+	fieldOrder = keys
 }
 
 // MarshalJSON writes the log line in a specific format that's
 // optimized for splunk ingestion: First, it writes the timestamp as a
-// float UNIX epoch, followed by all the other fields.
+// float UNIX epoch, followed by the fields configured with
+// SetFieldOrder, followed by all the other fields in alphabetical
+// order, so that a line is always written out the same way.
 func (j LogLine) MarshalJSON() ([]byte, error) {
 	b := bytes.NewBuffer(encodePrefix)
 	b.Grow(len(j) * 15) // very naive assumption: average key/value pair is 15 bytes long.
 
-	nsepoch := j.Timestamp().UnixNano()
-	sec := time.Duration(nsepoch) / time.Second
-	usec := (time.Duration(nsepoch) - (sec * time.Second)) / time.Nanosecond
-	fmt.Fprintf(b, "%d.%09d", sec, usec)
+	writeTimestamp(b, j.Timestamp())
 
-	for k, v := range j {
+	written := 0
+	for _, k := range fieldOrder {
+		if v, ok := j[k]; ok && k != timestampField {
+			writeField(b, k, v)
+			written++
+		}
+	}
+
+	keys := make([]string, 0, len(j))
+	for k := range j {
 		if k == timestampField {
 			continue
 		}
-		b.WriteString(",")
-		kJSON, _ := json.Marshal(k)
-		vJSON, err := json.Marshal(v)
-		if err != nil {
-			vJSON, _ = json.Marshal(fmt.Sprintf(`[unilog json marshal error: %v]`, err))
+		if written > 0 && isOrdered(k) {
+			continue
 		}
-		b.Write(kJSON)
-		b.WriteString(":")
-		b.Write(vJSON)
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		writeField(b, k, j[k])
 	}
 	b.WriteString("}")
 	return b.Bytes(), nil
 }
+
+func isOrdered(k string) bool {
+	for _, o := range fieldOrder {
+		if k == o {
+			return true
+		}
+	}
+	return false
+}
+
+func writeField(b *bytes.Buffer, k string, v interface{}) {
+	b.WriteString(",")
+	kJSON, _ := json.Marshal(k)
+	vJSON, err := json.Marshal(v)
+	if err != nil {
+		vJSON, _ = json.Marshal(fmt.Sprintf(`[unilog json marshal error: %v]`, err))
+	}
+	b.Write(kJSON)
+	b.WriteString(":")
+	b.Write(vJSON)
+}
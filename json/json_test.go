@@ -1,6 +1,7 @@
 package json
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"strings"
@@ -21,6 +22,9 @@ func TestTS(t *testing.T) {
 		{`"2006-01-02T15:04:05.999999999Z"`, false, 1136214245, 999999999},
 		{`"2006-01-02T15:04:05Z"`, false, 1136214245, 0},
 		{`"Mon, 02 Jan 2006 15:04:05 -0700"`, false, 1136239445, 0},
+		{`"2006-01-02T15:04:05.5"`, false, 1136214245, 500000000},
+		{`"2006-01-02 15:04:05"`, false, 1136214245, 0},
+		{`"02/Jan/2006:15:04:05 -0700"`, false, 1136239445, 0},
 		{`"gibberish"`, true, 0, 0},
 		{`1550493962.283873`, false, 1550493962, 283873010},
 		{`1550493962`, false, 1550493962, 0},
@@ -37,6 +41,8 @@ func TestTS(t *testing.T) {
 			require.NoError(t, err)
 
 			ts := line.Timestamp()
+			_, ok := line.EventTime()
+			assert.Equal(t, !test.now, ok)
 			if !test.now {
 				assert.False(t, nowish.Before(ts),
 					"timestamp %v should be an actual timestamp, not time.Now()",
@@ -64,6 +70,93 @@ func TestTS(t *testing.T) {
 	}
 }
 
+func TestTimestampLayouts(t *testing.T) {
+	defer func() { timestampLayouts = append([]string(nil), DefaultTimestampLayouts...) }()
+	line := LogLine{"ts": "Jan 2 2006 15:04:05"}
+	_, fallback := line.ParseEventTime()
+	assert.Equal(t, TimestampUnparseable, fallback)
+
+	require.NoError(t, AddTimestampLayout("Jan _2 2006 15:04:05"))
+	ts, ok := line.EventTime()
+	require.True(t, ok)
+	assert.True(t, time.Unix(1136214245, 0).Equal(ts), ts.String())
+	assert.Equal(t, "Jan _2 2006 15:04:05", TimestampLayouts()[len(DefaultTimestampLayouts)])
+	assert.Error(t, AddTimestampLayout("no elements"))
+
+	// Timestamps without a zone are read as UTC
+	line = LogLine{"ts": "2006-01-02T15:04:05"}
+	ts, ok = line.EventTime()
+	require.True(t, ok)
+	assert.Equal(t, time.UTC, ts.Location())
+
+	line = LogLine{"ts": "gibberish"}
+	_, fallback = line.ParseEventTime()
+	assert.Equal(t, TimestampUnparseable, fallback)
+}
+
+func TestEpochUnits(t *testing.T) {
+	want := time.Unix(1792215208, 123456789)
+	tests := []struct {
+		name  string
+		epoch string
+		exact time.Duration
+	}{
+		{"seconds", `1792215208.123456789`, time.Microsecond},
+		{"integer seconds", `1792215208`, time.Second},
+		{"millis", `1792215208123`, time.Millisecond},
+		{"fractional millis", `1792215208123.456`, time.Microsecond},
+		{"micros", `1792215208123456`, time.Microsecond},
+		{"nanos", `1792215208123456789`, time.Microsecond},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var line LogLine
+			require.NoError(t, json.Unmarshal([]byte(`{"ts":`+test.epoch+`}`), &line))
+			ts, ok := line.EventTime()
+			require.True(t, ok)
+			assert.True(t, ts.Year() >= 2020 && ts.Year() < 2030, ts.String())
+			assert.WithinDuration(t, want, ts, test.exact)
+		})
+	}
+
+	// Just under each threshold, timestamps are still read in the
+	// larger unit
+	for _, threshold := range []float64{epochMillisThreshold, epochMicrosThreshold, epochNanosThreshold} {
+		epoch := threshold * 0.999
+		assert.True(t, epochTime(epoch).Year() > 5000, epoch)
+	}
+	assert.Equal(t, 1973, epochTime(epochMillisThreshold).Year())
+}
+
+func TestParseEventTime(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		fallback string
+	}{
+		{"valid", `{"timestamp":"2006-01-02T15:04:05Z"}`, ""},
+		{"valid ts", `{"ts":1550493962.283873}`, ""},
+		{"missing", `{"message":"hi"}`, TimestampMissing},
+		{"unparseable", `{"timestamp":"gibberish"}`, TimestampUnparseable},
+		{"wrong type", `{"timestamp":true}`, TimestampUnparseable},
+		{"unparseable with valid ts", `{"timestamp":"gibberish","ts":1550493962}`, ""},
+		{"null", `{"timestamp":null}`, TimestampUnparseable},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var line LogLine
+			require.NoError(t, json.Unmarshal([]byte(test.line), &line))
+			ts, fallback := line.ParseEventTime()
+			assert.Equal(t, test.fallback, fallback)
+			_, ok := line.EventTime()
+			assert.Equal(t, test.fallback == "", ok)
+			if test.fallback == "" {
+				assert.Equal(t, ts, line.Timestamp())
+			}
+		})
+	}
+}
+
 func TestMarshal(t *testing.T) {
 	tests := []struct {
 		in string
@@ -92,6 +185,112 @@ func TestMarshal(t *testing.T) {
 	}
 }
 
+func TestTimestampPrecision(t *testing.T) {
+	defer SetTimestampPrecision("ns")
+
+	ts := time.Unix(1136214245, 123456789)
+	tests := []struct {
+		precision string
+		expected  string
+		epochNS   int64
+	}{
+		{"s", `{"timestamp":1136214245}`, 0},
+		{"ms", `{"timestamp":1136214245.123}`, 123000000},
+		{"us", `{"timestamp":1136214245.123456}`, 123456000},
+		{"ns", `{"timestamp":1136214245.123456789}`, 123456789},
+	}
+	for _, test := range tests {
+		t.Run(test.precision, func(t *testing.T) {
+			require.NoError(t, SetTimestampPrecision(test.precision))
+
+			line := LogLine{"timestamp": ts.Format(time.RFC3339Nano)}
+			b, err := json.Marshal(line)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, string(b))
+
+			var roundtrip LogLine
+			err = json.Unmarshal(b, &roundtrip)
+			require.NoError(t, err)
+			assert.WithinDuration(t, time.Unix(1136214245, test.epochNS), roundtrip.Timestamp(), time.Microsecond)
+		})
+	}
+
+	assert.Error(t, SetTimestampPrecision("fortnights"))
+}
+
+func TestTimestampField(t *testing.T) {
+	require.NoError(t, SetTimestampField("@timestamp"))
+	defer SetTimestampField(DefaultTimestampField)
+	assert.Equal(t, "@timestamp", TimestampField())
+
+	for _, in := range []string{
+		`{"@timestamp":"2006-01-02T15:04:05Z","message":"hi"}`,
+		// The default fields are still understood
+		`{"ts":1136214245,"message":"hi"}`,
+	} {
+		var line LogLine
+		require.NoError(t, json.Unmarshal([]byte(in), &line))
+		b, err := json.Marshal(line)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(b), `{"@timestamp":1136214245.000000000,`), string(b))
+		assert.Equal(t, 1, strings.Count(string(b), `"@timestamp"`), string(b))
+
+		// and the output parses back the same
+		var roundtrip LogLine
+		require.NoError(t, json.Unmarshal(b, &roundtrip))
+		assert.Equal(t, time.Unix(1136214245, 0), roundtrip.Timestamp())
+		again, err := json.Marshal(roundtrip)
+		require.NoError(t, err)
+		assert.JSONEq(t, string(b), string(again))
+	}
+
+	// The configured field wins over the defaults; those are kept
+	line := LogLine{"@timestamp": 1136214245.0, "timestamp": 1.0}
+	b, err := json.Marshal(line)
+	require.NoError(t, err)
+	assert.Equal(t, `{"@timestamp":1136214245.000000000,"timestamp":1}`, string(b))
+
+	require.NoError(t, SetTimestampField(`odd"name`))
+	b, err = json.Marshal(LogLine{"message": "hi"})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(b), `{"odd\"name":`), string(b))
+
+	assert.Error(t, SetTimestampField(""))
+}
+
+func TestFieldOrder(t *testing.T) {
+	SetFieldOrder([]string{"service", "message", "missing"})
+	defer SetFieldOrder(nil)
+
+	line := LogLine{
+		"timestamp": "2006-01-02T15:04:05Z",
+		"zzz":       1,
+		"message":   "hi",
+		"aaa":       2,
+		"service":   "api",
+	}
+	for i := 0; i < 20; i++ {
+		b, err := json.Marshal(line)
+		require.NoError(t, err)
+		assert.Equal(t, `{"timestamp":1136214245.000000000,"service":"api","message":"hi","aaa":2,"zzz":1}`, string(b))
+	}
+}
+
+func TestMarshalDeterministic(t *testing.T) {
+	var line LogLine
+	require.NoError(t, json.Unmarshal([]byte(lineWithTimestamp), &line))
+	first, err := json.Marshal(line)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(first),
+		`{"timestamp":1136214245.999999999,"duration_ns":44890,"end_timestamp":1554825181.8588665,"error":false,"id":"6045760440938957111",`),
+		string(first))
+	for i := 0; i < 100; i++ {
+		b, err := json.Marshal(line)
+		require.NoError(t, err)
+		assert.Equal(t, string(first), string(b))
+	}
+}
+
 type unwritable struct{}
 
 func (j unwritable) MarshalJSON() ([]byte, error) {
@@ -150,6 +349,58 @@ func BenchmarkLineRoundtrip(b *testing.B) {
 	}
 }
 
+// BenchmarkMarshal serializes a parsed log event, with its fields in
+// order; BenchmarkMarshalUnsorted is the reference point of the same
+// serialization in map iteration order, as MarshalJSON used to write
+// it, to gauge what sorting the fields costs.
+func BenchmarkMarshal(b *testing.B) {
+	var ll LogLine
+	require.NoError(b, json.Unmarshal([]byte(lineWithTimestamp), &ll))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := json.Marshal(ll)
+		require.NoError(b, err)
+	}
+}
+
+func BenchmarkMarshalUnsorted(b *testing.B) {
+	var ll LogLine
+	require.NoError(b, json.Unmarshal([]byte(lineWithTimestamp), &ll))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		marshalUnsorted(ll)
+	}
+}
+
+// marshalUnsorted is MarshalJSON without sorting the fields that
+// aren't in the field order.
+func marshalUnsorted(j LogLine) []byte {
+	b := bytes.NewBuffer(encodePrefix)
+	b.Grow(len(j) * 15)
+
+	writeTimestamp(b, j.Timestamp())
+
+	written := 0
+	for _, k := range fieldOrder {
+		if v, ok := j[k]; ok && k != timestampField {
+			writeField(b, k, v)
+			written++
+		}
+	}
+
+	for k, v := range j {
+		if k == timestampField {
+			continue
+		}
+		if written > 0 && isOrdered(k) {
+			continue
+		}
+		writeField(b, k, v)
+	}
+	b.WriteString("}")
+	return b.Bytes()
+}
+
 // BenchmarkLineRoundtripPlain roundtrips each log event through
 // json.Unmarshal/Marshal for a plain map[string]interface{},
 // simulating a unilog that doesn't parse/extract timestamps and
@@ -0,0 +1,89 @@
+package json
+
+import "fmt"
+
+// Policies for resolving collisions when a field is merged into a log
+// line that already has it (see LogLine.Merge).
+const (
+	// CollisionFirstWins keeps the existing value.
+	CollisionFirstWins = "first-wins"
+	// CollisionLastWins replaces the existing value.
+	CollisionLastWins = "last-wins"
+	// CollisionArrayMerge keeps both values, in an array of the
+	// existing value (or its elements, if it's an array) followed
+	// by the new value (or its elements).
+	CollisionArrayMerge = "array-merge"
+)
+
+// collisionPolicy is the policy that LogLine.Merge applies.
+var collisionPolicy = CollisionFirstWins
+
+// SetCollisionPolicy configures how LogLine.Merge resolves
+// collisions: CollisionFirstWins (the default), CollisionLastWins or
+// CollisionArrayMerge.
+func SetCollisionPolicy(policy string) error {
+	switch policy {
+	case CollisionFirstWins, CollisionLastWins, CollisionArrayMerge:
+		collisionPolicy = policy
+		return nil
+	}
+	return fmt.Errorf("invalid collision policy %q (valid: %s, %s, %s)", policy, CollisionFirstWins, CollisionLastWins, CollisionArrayMerge)
+}
+
+// Merge sets the field key to value. If the line already has the
+// field, the collision is resolved by the policy configured with
+// SetCollisionPolicy, except that two objects are always merged
+// field by field (recursively, with the same policy). Filters that add
+// fields to lines should use Merge, so that collisions are handled
+// the same way everywhere.
+func (j LogLine) Merge(key string, value interface{}) {
+	mergeField(j, key, value, collisionPolicy)
+}
+
+func mergeField(fields map[string]interface{}, key string, value interface{}, policy string) {
+	old, ok := fields[key]
+	if !ok {
+		fields[key] = value
+		return
+	}
+	oldObj, oldIsObj := asObject(old)
+	newObj, newIsObj := asObject(value)
+	if oldIsObj && newIsObj {
+		merged := make(map[string]interface{}, len(oldObj)+len(newObj))
+		for k, v := range oldObj {
+			merged[k] = v
+		}
+		for k, v := range newObj {
+			mergeField(merged, k, v, policy)
+		}
+		fields[key] = merged
+		return
+	}
+
+	switch policy {
+	case CollisionLastWins:
+		fields[key] = value
+	case CollisionArrayMerge:
+		fields[key] = append(asArray(old), asArray(value)...)
+	}
+}
+
+// asObject returns v as a JSON object, if it is one.
+func asObject(v interface{}) (map[string]interface{}, bool) {
+	switch obj := v.(type) {
+	case map[string]interface{}:
+		return obj, true
+	case LogLine:
+		return obj, true
+	}
+	return nil, false
+}
+
+// asArray returns a copy of v if it's a JSON array, or else an array
+// of just v.
+func asArray(v interface{}) []interface{} {
+	if arr, ok := v.([]interface{}); ok {
+		return append([]interface{}(nil), arr...)
+	}
+	return []interface{}{v}
+}
@@ -0,0 +1,69 @@
+package json
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerge(t *testing.T) {
+	defer SetCollisionPolicy(CollisionFirstWins)
+
+	tests := []struct {
+		name   string
+		policy string
+		line   string
+		key    string
+		value  interface{}
+		want   string
+	}{
+		{"new field", CollisionFirstWins, `{"a":1}`, "b", "x", `{"a":1,"b":"x"}`},
+		{"first wins", CollisionFirstWins, `{"a":1}`, "a", "x", `{"a":1}`},
+		{"last wins", CollisionLastWins, `{"a":1}`, "a", "x", `{"a":"x"}`},
+		{"array merge", CollisionArrayMerge, `{"a":1}`, "a", "x", `{"a":[1,"x"]}`},
+		{"array merge arrays", CollisionArrayMerge, `{"a":[1,2]}`, "a", []interface{}{"x", "y"}, `{"a":[1,2,"x","y"]}`},
+		{"array merge nulls", CollisionArrayMerge, `{"a":null}`, "a", "x", `{"a":[null,"x"]}`},
+		{"objects first wins", CollisionFirstWins, `{"a":{"b":1,"c":2}}`, "a", map[string]interface{}{"c": 3, "d": 4}, `{"a":{"b":1,"c":2,"d":4}}`},
+		{"objects last wins", CollisionLastWins, `{"a":{"b":1,"c":2}}`, "a", map[string]interface{}{"c": 3, "d": 4}, `{"a":{"b":1,"c":3,"d":4}}`},
+		{"objects array merge", CollisionArrayMerge, `{"a":{"b":1,"c":{"e":5}}}`, "a", LogLine{"b": 2, "c": map[string]interface{}{"f": 6}}, `{"a":{"b":[1,2],"c":{"e":5,"f":6}}}`},
+		{"object and scalar", CollisionLastWins, `{"a":{"b":1}}`, "a", "x", `{"a":"x"}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.NoError(t, SetCollisionPolicy(test.policy))
+			var line map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(test.line), &line))
+			LogLine(line).Merge(test.key, test.value)
+
+			var want map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(test.want), &want))
+			// Compare through JSON, so numbers have the same types
+			b, err := json.Marshal(line)
+			require.NoError(t, err)
+			var got map[string]interface{}
+			require.NoError(t, json.Unmarshal(b, &got))
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+func TestMergeArrayCopies(t *testing.T) {
+	defer SetCollisionPolicy(CollisionFirstWins)
+	require.NoError(t, SetCollisionPolicy(CollisionArrayMerge))
+
+	tags := []interface{}{"a", "b"}
+	line := LogLine{"tags": tags}
+	line.Merge("tags", "c")
+	assert.Equal(t, []interface{}{"a", "b", "c"}, line["tags"])
+	assert.Equal(t, []interface{}{"a", "b"}, tags)
+}
+
+func TestSetCollisionPolicy(t *testing.T) {
+	defer SetCollisionPolicy(CollisionFirstWins)
+	assert.Error(t, SetCollisionPolicy("random-wins"))
+	assert.Equal(t, CollisionFirstWins, collisionPolicy)
+	assert.NoError(t, SetCollisionPolicy(CollisionLastWins))
+	assert.Equal(t, CollisionLastWins, collisionPolicy)
+}
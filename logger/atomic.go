@@ -0,0 +1,59 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+)
+
+// atomicSuffix is appended to the target's name to get the file that
+// is written to in Atomic mode.
+const atomicSuffix = ".tmp"
+
+// targetPath returns the path of the file that lines are written to:
+// the target itself, or its temporary file in Atomic mode.
+func (u *Unilog) targetPath() string {
+	if u.Atomic {
+		return u.target + atomicSuffix
+	}
+	return u.target
+}
+
+// checkAtomic returns an error if Atomic can't be used with the
+// target.
+func (u *Unilog) checkAtomic() error {
+	switch {
+	case u.Syslog != "" || u.OTLPEndpoint != "":
+		return fmt.Errorf("-atomic needs a target file")
+	case u.target == "-":
+		return fmt.Errorf("-atomic can't be used with a target of -")
+	case isTemplate(u.target):
+		return fmt.Errorf("-atomic can't be used with a templated target")
+	case u.MaxFileBytes > 0:
+		return fmt.Errorf("-atomic can't be used with -maxbytes")
+	}
+	return nil
+}
+
+// finishAtomic ends an Atomic run: if it stopped cleanly at the end
+// of its input and every line made it to the temporary file, that
+// replaces the target; otherwise it is removed, leaving any previous
+// target alone. Either way, the temporary file is closed.
+func (u *Unilog) finishAtomic() error {
+	if !u.Atomic {
+		return nil
+	}
+	tmp := u.targetPath()
+	var err error
+	if u.file != nil {
+		err = u.file.Close()
+		u.file = nil
+	}
+	if err != nil || u.writeFailed || exitCode(u.shutdownReason) != ExitOK {
+		os.Remove(tmp)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%s is incomplete, not renaming it to %s", tmp, u.target)
+	}
+	return os.Rename(tmp, u.target)
+}
@@ -0,0 +1,82 @@
+package logger
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "out.log")
+	// Left over from a crashed run
+	require.NoError(t, ioutil.WriteFile(target+".tmp", []byte("partial\n"), 0644))
+
+	lines := make(chan string, 2)
+	u := &Unilog{Atomic: true, target: target, lines: lines}
+	require.NoError(t, u.reopen())
+	lines <- "one"
+	lines <- "two"
+	assert.True(t, u.tick())
+	assert.True(t, u.tick())
+
+	// Nothing shows up at the target until the end of the input
+	_, err = os.Stat(target)
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, "one\ntwo\n", readFile(t, target+".tmp"))
+
+	close(lines)
+	u.run()
+	require.NoError(t, u.finishAtomic())
+	assert.Equal(t, "one\ntwo\n", readFile(t, target))
+	_, err = os.Stat(target + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestAtomicError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "out.log")
+	require.NoError(t, ioutil.WriteFile(target, []byte("previous\n"), 0644))
+
+	// Reading the input failed
+	lines := make(chan string, 1)
+	errs := make(chan error, 1)
+	u := &Unilog{Atomic: true, target: target, lines: lines, errs: errs}
+	require.NoError(t, u.reopen())
+	lines <- "one"
+	assert.True(t, u.tick())
+	errs <- errors.New("read failed")
+	u.run()
+	assert.Error(t, u.finishAtomic())
+	assert.Equal(t, "previous\n", readFile(t, target))
+	_, err = os.Stat(target + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+
+	// The input was read to the end, but a line couldn't be written
+	lines = make(chan string)
+	u = &Unilog{Atomic: true, target: target, lines: lines}
+	require.NoError(t, u.reopen())
+	u.handleError("write_to_log", errors.New("disk full"))
+	close(lines)
+	u.run()
+	assert.Error(t, u.finishAtomic())
+	assert.Equal(t, "previous\n", readFile(t, target))
+	_, err = os.Stat(target + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCheckAtomic(t *testing.T) {
+	assert.NoError(t, (&Unilog{Atomic: true, target: "/var/log/out.log"}).checkAtomic())
+	assert.Error(t, (&Unilog{Atomic: true, target: "-"}).checkAtomic())
+	assert.Error(t, (&Unilog{Atomic: true, target: "/var/log/{date}.log"}).checkAtomic())
+	assert.Error(t, (&Unilog{Atomic: true, Syslog: "/dev/log"}).checkAtomic())
+}
@@ -0,0 +1,53 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// inputCharsets are the character sets InputCharset may name, besides
+// UTF-8.
+var inputCharsets = map[string]encoding.Encoding{
+	"latin1":     charmap.ISO8859_1,
+	"iso-8859-1": charmap.ISO8859_1,
+	"iso8859-1":  charmap.ISO8859_1,
+}
+
+// inputEncoding returns the encoding InputCharset names, or nil if the
+// input is UTF-8.
+func (u *Unilog) inputEncoding() (encoding.Encoding, error) {
+	name := strings.ToLower(u.InputCharset)
+	switch name {
+	case "", "utf-8", "utf8":
+		return nil, nil
+	}
+	enc, ok := inputCharsets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown input charset %q", u.InputCharset)
+	}
+	return enc, nil
+}
+
+// transcode converts line from InputCharset to UTF-8. Lines that can't
+// be transcoded are counted in unilog.transcode.errors, and passed
+// through unchanged (to be sanitized, if SanitizeUTF8 is set).
+func (u *Unilog) transcode(line string) string {
+	if u.decoder == nil {
+		enc, err := u.inputEncoding()
+		if enc == nil || err != nil {
+			return line
+		}
+		u.decoder = enc.NewDecoder()
+	}
+	s, err := u.decoder.String(line)
+	if err != nil {
+		if stats := u.stats(); stats != nil {
+			IndependentCount(stats, "unilog.transcode.errors", 1, nil, 1)
+		}
+		return line
+	}
+	return s
+}
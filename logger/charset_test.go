@@ -0,0 +1,28 @@
+package logger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInputCharset(t *testing.T) {
+	// "café crème, 1½ ¥" in latin1
+	latin1 := "caf\xe9 cr\xe8me, 1\xbd \xa5"
+	want := "café crème, 1½ ¥\n"
+
+	assert.Equal(t, latin1+"\n", getLogLine(&Unilog{}, latin1))
+	assert.Equal(t, want, getLogLine(&Unilog{InputCharset: "latin1"}, latin1))
+	assert.Equal(t, want, getLogLine(&Unilog{InputCharset: "ISO-8859-1", SanitizeUTF8: true}, latin1))
+	// Valid UTF-8 is latin1 too, so it's transcoded all the same
+	assert.Equal(t, "cafÃ©\n", getLogLine(&Unilog{InputCharset: "latin1"}, "café"))
+	assert.Equal(t, "café\n", getLogLine(&Unilog{InputCharset: "utf-8"}, "café"))
+
+	out := getLogJSON(&Unilog{InputCharset: "latin1", JSON: true}, `{"message":"`+latin1+`"}`)
+	assert.Regexp(t, `"message":"caf\x{e9} cr\x{e8}me, 1\x{bd} \x{a5}"`, out)
+
+	u := &Unilog{InputCharset: "ebcdic"}
+	_, err := u.inputEncoding()
+	assert.Error(t, err)
+	assert.Equal(t, "hi\n", getLogLine(u, "hi"))
+}
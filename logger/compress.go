@@ -0,0 +1,115 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// compressor gzips rotated log files in the background, so that
+// compression never blocks the event loop. Only one file is compressed
+// at a time, to avoid CPU storms when many files are rotated at once.
+// The zero value is ready to use.
+type compressor struct {
+	// held while compressing a file
+	mtx sync.Mutex
+	wg  sync.WaitGroup
+	// the number of compressions started but not done yet
+	pending int32
+	// errors from background compressions, to be handled on the
+	// event loop
+	errs chan error
+	// where to report metrics to, if anywhere
+	stats Client
+}
+
+// compress starts gzipping path to path.gz in the background, and
+// removes path once that is done. If done is non-nil, it is called
+// with the compressed file's path after successful compression.
+func (c *compressor) compress(path string, done func(string)) {
+	if c.errs == nil {
+		c.errs = make(chan error, 1)
+	}
+	stats := c.stats
+	c.wg.Add(1)
+	atomic.AddInt32(&c.pending, 1)
+	go func() {
+		defer c.wg.Done()
+		defer atomic.AddInt32(&c.pending, -1)
+		c.mtx.Lock()
+		defer c.mtx.Unlock()
+
+		start := time.Now()
+		if err := gzipFile(path); err != nil {
+			select {
+			case c.errs <- err:
+			default:
+				// An error is already waiting to be
+				// reported; don't block on it.
+			}
+			return
+		}
+		if stats != nil {
+			IndependentTiming(stats, "unilog.rotate.compress.duration", time.Since(start), nil, 1)
+		}
+		if done != nil {
+			done(path + ".gz")
+		}
+	}()
+}
+
+// busy reports whether any compressions are in flight, without
+// waiting for them.
+func (c *compressor) busy() bool {
+	return atomic.LoadInt32(&c.pending) > 0
+}
+
+// wait blocks until all in-flight compressions are done.
+func (c *compressor) wait() {
+	c.wg.Wait()
+}
+
+// gzipFile compresses path to path.gz and removes path. The
+// compressed data is written to a temporary file that is only renamed
+// to path.gz once it is complete, so a crash or error never leaves a
+// truncated path.gz behind.
+func gzipFile(path string) (err error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			dst.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	gz := gzip.NewWriter(dst)
+	if _, err = io.Copy(gz, src); err != nil {
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		return err
+	}
+	if err = dst.Sync(); err != nil {
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,112 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c compressor
+	contents := map[string]string{
+		"log.1": "first\n",
+		"log.2": "second\n",
+	}
+	for name, content := range contents {
+		path := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+		c.compress(path, nil)
+	}
+	c.wait()
+
+	for name, content := range contents {
+		path := filepath.Join(dir, name)
+		_, err := os.Stat(path)
+		assert.True(t, os.IsNotExist(err), "%s should have been removed", name)
+		_, err = os.Stat(path + ".gz.tmp")
+		assert.True(t, os.IsNotExist(err), "%s.gz.tmp should have been removed", name)
+
+		f, err := os.Open(path + ".gz")
+		require.NoError(t, err)
+		gz, err := gzip.NewReader(f)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(gz)
+		require.NoError(t, err)
+		assert.Equal(t, content, string(b))
+		f.Close()
+	}
+	assert.Equal(t, 0, len(c.errs))
+}
+
+func TestCompressorError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var c compressor
+	c.compress(filepath.Join(dir, "missing"), nil)
+	c.wait()
+	assert.Error(t, <-c.errs)
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func readGzip(t *testing.T, path string) string {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(gz)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestCompressRotated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "log")
+
+	u := &Unilog{MaxFileBytes: 10, CompressBackups: true, target: target}
+	require.NoError(t, u.reopen())
+	u.logLine("one")
+	u.logLine("two")
+	u.logLine("three")
+	u.compressor.wait()
+	assert.ElementsMatch(t, []string{"log", "log.1.gz"}, listDir(t, dir))
+	assert.Equal(t, "one\ntwo\nthree\n", readGzip(t, target+".1.gz"))
+
+	// While a compression is in flight, the target isn't rotated
+	atomic.AddInt32(&u.compressor.pending, 1)
+	u.logLine("four")
+	u.logLine("five")
+	assert.ElementsMatch(t, []string{"log", "log.1.gz"}, listDir(t, dir))
+	atomic.AddInt32(&u.compressor.pending, -1)
+	u.logLine("six")
+	u.compressor.wait()
+	assert.ElementsMatch(t, []string{"log", "log.1.gz", "log.2.gz"}, listDir(t, dir))
+	assert.Equal(t, "four\nfive\nsix\n", readGzip(t, target+".1.gz"))
+	assert.Equal(t, "one\ntwo\nthree\n", readGzip(t, target+".2.gz"))
+}
+
+func TestCompressRotatedError(t *testing.T) {
+	client := &MockClient{Counts: make(map[string]int64)}
+	u := &Unilog{Metrics: client}
+	u.compressor.compress("/nonexistent/log.1", nil)
+	u.compressor.wait()
+	assert.True(t, u.tick())
+	assert.Equal(t, int64(1), client.Counts["[err_action:compress_backup]unilog.errors_total"])
+}
@@ -0,0 +1,138 @@
+package logger
+
+import (
+	encjson "encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+	flag "launchpad.net/gnuflag"
+)
+
+// Config is the contents of a -config file: flag values, keyed by the
+// flags' names (without the dashes), so a config file can set any
+// flag, including the filters'. For example, in YAML:
+//
+//	name: api
+//	mailto: oncall@example.com
+//	idle-flush: 1s
+//	clevel-pattern:
+//	  - '\bpri=(\d)'
+//
+// Values can be strings, numbers or booleans; a list sets a repeatable
+// flag once for each item. Flags given on the command line take
+// precedence over the config file, whichever of their names they're
+// given with (e.g. -v for verbose).
+//
+// It's a map rather than a struct because the flags aren't all
+// Unilog's: filters register their own, and a config file has to
+// cover those as well as any added later, under the same names as on
+// the command line. Only what a flag's Set accepts is checked, when
+// the config is applied, and the values' types come from the file
+// (so "1" and 1 are the same to an int flag).
+type Config map[string]interface{}
+
+// LoadConfig reads the config file at path: JSON if its name ends in
+// .json, YAML otherwise.
+func LoadConfig(path string) (Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = encjson.Unmarshal(b, &c)
+	} else {
+		err = yaml.Unmarshal(b, &c)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return c, nil
+}
+
+// Apply sets the flags in fs to the config's values, unless they were
+// set on the command line (fs having been parsed already).
+func (c Config) Apply(fs *flag.FlagSet) error {
+	var set []*flag.Flag
+	fs.Visit(func(f *flag.Flag) {
+		set = append(set, f)
+	})
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	// Set repeatable flags in a predictable order
+	sort.Strings(names)
+	for _, name := range names {
+		f := fs.Lookup(name)
+		if f == nil {
+			return fmt.Errorf("unknown flag %q in config", name)
+		}
+		if flagSet(f, set) {
+			continue
+		}
+		values, ok := c[name].([]interface{})
+		if !ok {
+			values = []interface{}{c[name]}
+		}
+		for _, v := range values {
+			s, err := configValue(v)
+			if err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+			if err := fs.Set(name, s); err != nil {
+				return fmt.Errorf("invalid value %q for %s in config: %s", s, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// flagSet reports whether f, or another name for it, is among the
+// flags that were set. Two flags are names for the same thing if
+// their values are the same pointer (as with StringVar and friends
+// for the same variable), or equal values of a comparable type (as
+// with signalsFlag or regexpValue wrapping the same field). Values
+// that can't be compared, or distinct pointers to wrappers of the
+// same field, aren't recognized, so aliases have to be registered
+// with the same Value.
+func flagSet(f *flag.Flag, set []*flag.Flag) bool {
+	for _, s := range set {
+		if s.Name == f.Name {
+			return true
+		}
+		a, b := reflect.ValueOf(f.Value), reflect.ValueOf(s.Value)
+		if a.Type() != b.Type() {
+			continue
+		}
+		if a.Kind() == reflect.Ptr {
+			if a.Pointer() == b.Pointer() {
+				return true
+			}
+		} else if a.Comparable() && f.Value == s.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// configValue returns the flag value for a scalar config value.
+func configValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", v)
+}
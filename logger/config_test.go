@@ -0,0 +1,139 @@
+package logger
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	flag "launchpad.net/gnuflag"
+)
+
+// configFlags returns a flag set with some of Unilog's flags, bound to
+// u's fields like addFlags does.
+func configFlags(u *Unilog) *flag.FlagSet {
+	fs := flag.NewFlagSet("unilog", flag.ContinueOnError)
+	fs.StringVar(&u.Name, "name", "", "")
+	fs.StringVar(&u.Name, "a", "", "")
+	fs.BoolVar(&u.Verbose, "verbose", false, "")
+	fs.BoolVar(&u.Verbose, "v", false, "")
+	fs.StringVar(&u.MailTo, "mailto", "", "")
+	fs.IntVar(&u.BufferLines, "buffer-lines", 0, "")
+	fs.DurationVar(&u.IdleFlush, "idle-flush", 0, "")
+	fs.Float64Var(&u.SampleRate, "sample-rate", 0, "")
+	fs.StringVar(&u.SentryDSN, "sentrydsn", "", "")
+	fs.DurationVar(&u.SyncInterval, "syncinterval", 0, "")
+	u.StatsdAddress = "127.0.0.1:8200"
+	fs.Var(&statsdAddressFlag{u: u}, "statsdaddress", "")
+	return fs
+}
+
+func writeConfig(t *testing.T, dir, name, contents string) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0644))
+	return path
+}
+
+func TestConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	yamlPath := writeConfig(t, dir, "unilog.yaml", `
+name: api
+verbose: true
+mailto: oncall@example.com
+buffer-lines: 100
+idle-flush: 1s
+sample-rate: 0.5
+statsdaddress:
+  - 127.0.0.1:8125
+  - udp://10.0.0.1:8125
+`)
+	jsonPath := writeConfig(t, dir, "unilog.json", `{
+	"name": "api",
+	"verbose": true,
+	"mailto": "oncall@example.com",
+	"buffer-lines": 100,
+	"idle-flush": "1s",
+	"sample-rate": 0.5,
+	"statsdaddress": ["127.0.0.1:8125", "udp://10.0.0.1:8125"]
+}`)
+	for _, path := range []string{yamlPath, jsonPath} {
+		t.Run(filepath.Ext(path), func(t *testing.T) {
+			u := &Unilog{}
+			fs := configFlags(u)
+			require.NoError(t, fs.Parse(true, nil))
+			config, err := LoadConfig(path)
+			require.NoError(t, err)
+			require.NoError(t, config.Apply(fs))
+
+			assert.Equal(t, "api", u.Name)
+			assert.True(t, u.Verbose)
+			assert.Equal(t, "oncall@example.com", u.MailTo)
+			assert.Equal(t, 100, u.BufferLines)
+			assert.Equal(t, time.Second, u.IdleFlush)
+			assert.Equal(t, 0.5, u.SampleRate)
+			assert.Equal(t, "127.0.0.1:8125", u.StatsdAddress)
+			assert.Equal(t, []string{"udp://10.0.0.1:8125"}, u.ExtraStatsdAddresses)
+		})
+	}
+
+	// Flags on the command line take precedence, under either name
+	u := &Unilog{}
+	fs := configFlags(u)
+	require.NoError(t, fs.Parse(true, []string{"-a", "worker", "--verbose=false"}))
+	config, err := LoadConfig(yamlPath)
+	require.NoError(t, err)
+	require.NoError(t, config.Apply(fs))
+	assert.Equal(t, "worker", u.Name)
+	assert.False(t, u.Verbose)
+	assert.Equal(t, "oncall@example.com", u.MailTo)
+}
+
+func TestConfigAliases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Aliases whose value isn't a pointer count as set too
+	u := &Unilog{}
+	fs := configFlags(u)
+	fs.Var(signalsFlag{&u.TermSignals}, "term-signals", "")
+	fs.Var(signalsFlag{&u.TermSignals}, "t", "")
+	fs.Var(signalsFlag{&u.QuitSignals}, "quit-signals", "")
+	require.NoError(t, fs.Parse(true, []string{"-t", "INT"}))
+	config, err := LoadConfig(writeConfig(t, dir, "unilog.yaml", `
+term-signals: TERM
+quit-signals: USR1
+`))
+	require.NoError(t, err)
+	require.NoError(t, config.Apply(fs))
+	assert.Equal(t, "INT", u.TermSignals)
+	// but not flags for other fields of the same type
+	assert.Equal(t, "USR1", u.QuitSignals)
+}
+
+func TestConfigErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = LoadConfig(filepath.Join(dir, "missing.yaml"))
+	assert.Error(t, err)
+	_, err = LoadConfig(writeConfig(t, dir, "bad.json", `{"name":`))
+	assert.Error(t, err)
+
+	for _, contents := range []string{
+		"nosuchflag: 1",
+		"buffer-lines: lots",
+		"name: {nested: map}",
+	} {
+		config, err := LoadConfig(writeConfig(t, dir, "unilog.yaml", contents))
+		require.NoError(t, err)
+		assert.Error(t, config.Apply(configFlags(&Unilog{})), contents)
+	}
+}
@@ -0,0 +1,58 @@
+package logger
+
+import (
+	encjson "encoding/json"
+	"os"
+
+	"github.com/stripe/unilog/json"
+)
+
+// Reasons that lines are dead-lettered for.
+const (
+	DeadLetterSchemaInvalid = "schema_invalid"
+	DeadLetterJSONMarshal   = "json_marshal"
+	DeadLetterJSONParse     = "json_parse"
+)
+
+// reopenDeadLetter (re)opens the dead-letter file, if one is
+// configured.
+func (u *Unilog) reopenDeadLetter() error {
+	if u.DeadLetterPath == "" {
+		return nil
+	}
+	if u.deadLetter != nil {
+		u.deadLetter.Close()
+		u.deadLetter = nil
+	}
+	f, err := os.OpenFile(u.DeadLetterPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	u.deadLetter = f
+	return nil
+}
+
+// DeadLetter writes a line that unilog could not process normally to
+// the dead-letter file, if one is configured, as a JSON object with
+// the time it was dead-lettered, the reason and the original line:
+//
+//	{"timestamp":1550493962.283873000,"line":"...","reason":"schema_invalid"}
+//
+// Like everything else that writes, DeadLetter must only be called
+// from unilog's event loop (filters are run on it).
+func (u *Unilog) DeadLetter(line, reason string) {
+	if stats := u.stats(); stats != nil {
+		IndependentCount(stats, "unilog.deadletter", 1, []string{"reason:" + reason}, 1)
+	}
+	if u.deadLetter == nil {
+		return
+	}
+
+	b, err := encjson.Marshal(json.LogLine{"reason": reason, "line": line})
+	if err != nil {
+		return
+	}
+	if _, err = u.deadLetter.Write(append(b, '\n')); err != nil {
+		u.handleError("write_to_deadletter", err)
+	}
+}
@@ -0,0 +1,54 @@
+package logger
+
+import (
+	encjson "encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/unilog/filters"
+	"github.com/stripe/unilog/json"
+)
+
+func TestDeadLetter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sf := &filters.SchemaFilter{Required: []string{"service"}, Policy: filters.SchemaDeadLetter}
+	u := &Unilog{
+		DeadLetterPath: filepath.Join(dir, "deadletter"),
+		Filters:        []Filter{sf},
+	}
+	sf.DeadLetter = u.DeadLetter
+	require.NoError(t, u.reopenDeadLetter())
+
+	assert.Equal(t, "", getLogJSON(u, `{"message":"hi"}`))
+	// Reopening appends to the same file
+	require.NoError(t, u.reopenDeadLetter())
+	assert.Equal(t, "", getLogJSON(u, `{"message":"there"}`))
+	u.deadLetter.Close()
+
+	b, err := ioutil.ReadFile(u.DeadLetterPath)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	require.Len(t, lines, 2)
+
+	for i, msg := range []string{"hi", "there"} {
+		var dead json.LogLine
+		require.NoError(t, encjson.Unmarshal([]byte(lines[i]), &dead))
+		assert.Equal(t, filters.SchemaDeadLetterReason, dead["reason"])
+		assert.Contains(t, dead["line"], `"message":"`+msg+`"`)
+		assert.Contains(t, dead, "timestamp")
+	}
+}
+
+func TestDeadLetterUnconfigured(t *testing.T) {
+	u := &Unilog{}
+	// Should not panic
+	u.DeadLetter("hi", DeadLetterJSONMarshal)
+}
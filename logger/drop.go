@@ -0,0 +1,111 @@
+package logger
+
+import "github.com/stripe/unilog/filters"
+
+// Reasons a line can be dropped, which tag the unilog.lines.dropped
+// metric. Lines that can't be written right away aren't dropped:
+// readlines blocks the input (or spills to SpillDir) instead.
+const (
+	// DropInvalidUTF8 is a line with invalid UTF-8, with
+	// DropInvalidUTF8 set.
+	DropInvalidUTF8 = "invalid_utf8"
+	// DropParseFailure is a line that isn't valid JSON, with the
+	// JSONParseFailureDrop policy.
+	DropParseFailure = "parse_failure"
+	// DropExpired is a line older than MaxLineAge.
+	DropExpired = "expired"
+	// DropFiltered is a JSON line that a filter dropped entirely.
+	DropFiltered = "filtered"
+	// DropShed is a line whose contents AusterityFilter shed.
+	DropShed = "shed"
+	// DropSampledOut is a text line whose contents
+	// HashSampleFilter sampled out.
+	DropSampledOut = "sampled_out"
+	// DropRateLimited is a line whose contents RateLimitFilter
+	// dropped.
+	DropRateLimited = "rate_limited"
+	// DropCircuitOpen is a line discarded while the circuit
+	// breaker is open.
+	DropCircuitOpen = "circuit_open"
+	// DropTargetUnavailable is a line discarded because the
+	// target couldn't be opened.
+	DropTargetUnavailable = "target_unavailable"
+	// DropWriteError is a line that couldn't be written to the
+	// target.
+	DropWriteError = "write_error"
+	// DropBufferLost is a line that was in the WriteBuffer when
+	// writing it out to the target failed.
+	DropBufferLost = "buffer_lost"
+	// DropSpillLost is a line in the spill file when reading the
+	// spill file back failed.
+	DropSpillLost = "spill_lost"
+)
+
+// drop counts line as dropped in the unilog.lines.dropped metric, and
+// passes it to OnDrop. All of unilog's decisions to lose a line go
+// through here, or through dropLines, so the metric is the one place
+// to tell whether any data is being lost.
+func (u *Unilog) drop(line, reason string) {
+	if stats := u.stats(); stats != nil {
+		IndependentCount(stats, "unilog.lines.dropped", 1, []string{"reason:" + reason}, 1)
+	}
+	if u.OnDrop != nil {
+		u.OnDrop(line)
+	}
+}
+
+// dropLines counts n lines as dropped, like drop, for lines whose
+// contents are already gone (DropBufferLost and DropSpillLost), and
+// so can't be passed to OnDrop.
+func dropLines(stats Client, n int, reason string) {
+	if stats != nil && n > 0 {
+		IndependentCount(stats, "unilog.lines.dropped", int64(n), []string{"reason:" + reason}, 1)
+	}
+}
+
+// textDropReason returns the reason a filter replaced a text line's
+// contents with filtered, if it did.
+func textDropReason(filtered string) string {
+	switch filtered {
+	case filters.ShedLine:
+		return DropShed
+	case filters.SampledOutLine:
+		return DropSampledOut
+	case filters.RateLimitedLine:
+		return DropRateLimited
+	}
+	return ""
+}
+
+// jsonDropMarkers maps the fields that filters set on JSON lines
+// whose contents they dropped to the reasons they dropped them.
+var jsonDropMarkers = map[string]string{
+	"shedded":                DropShed,
+	filters.RateLimitedField: DropRateLimited,
+}
+
+// jsonDropMarked returns which of jsonDropMarkers line already has,
+// before it's filtered.
+func jsonDropMarked(line map[string]interface{}) map[string]bool {
+	var marked map[string]bool
+	for field := range jsonDropMarkers {
+		if _, ok := line[field]; ok {
+			if marked == nil {
+				marked = make(map[string]bool)
+			}
+			marked[field] = true
+		}
+	}
+	return marked
+}
+
+// jsonDropReason returns the reason a filter dropped a JSON line's
+// contents, if one set a marker on it that it didn't already have.
+func jsonDropReason(line map[string]interface{}, marked map[string]bool) string {
+	for field, reason := range jsonDropMarkers {
+		if _, ok := line[field]; ok && !marked[field] {
+			return reason
+		}
+	}
+	return ""
+}
@@ -0,0 +1,58 @@
+package logger
+
+import (
+	encjson "encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// applyDryRun sets u up for DryRun: lines are written to stdout
+// instead of the target (or syslog, or an OTLP collector), and
+// nothing else is written to or reported anywhere.
+func (u *Unilog) applyDryRun() {
+	u.target = "-"
+	u.targets = nil
+	u.Syslog = ""
+	u.OTLPEndpoint = ""
+	u.Atomic = false
+	u.MaxFileBytes = 0
+	u.CompressBackups = false
+	u.DeadLetterPath = ""
+	u.SampleTarget = ""
+	u.SentryDSN = ""
+	u.MailFrom = ""
+	u.MailTo = ""
+	u.WebhookURL = ""
+	u.Notifiers = nil
+}
+
+// dryRunWriter returns where DryRun shows lines before and after
+// filtering.
+func (u *Unilog) dryRunWriter() io.Writer {
+	if u.dryRunOut != nil {
+		return u.dryRunOut
+	}
+	return os.Stderr
+}
+
+// showDryRun shows the line that was read, and what the filters made
+// of it.
+func (u *Unilog) showDryRun(in, out string) {
+	fmt.Fprintf(u.dryRunWriter(), "IN: %s OUT: %s\n", in, out)
+}
+
+// showDryRunJSON shows the JSON line that was read, and what the
+// filters made of it; nil if they dropped it.
+func (u *Unilog) showDryRunJSON(in string, out interface{}) {
+	if out == nil {
+		u.showDryRun(in, "(dropped)")
+		return
+	}
+	b, err := encjson.Marshal(out)
+	if err != nil {
+		u.showDryRun(in, fmt.Sprintf("(%s)", err))
+		return
+	}
+	u.showDryRun(in, string(b))
+}
@@ -0,0 +1,49 @@
+package logger
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/unilog/json"
+)
+
+func TestDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "log")
+
+	var shown, stdout bytes.Buffer
+	upper := FilterFunc(strings.ToUpper)
+	u := &Unilog{DryRun: true, target: target, SentryDSN: "https://public@sentry.example.com/1", Filters: []Filter{upper}}
+	u.applyDryRun()
+	assert.Equal(t, "-", u.target)
+	assert.Empty(t, u.SentryDSN)
+	// What would be stdout
+	u.file = mockFile{buf: &stdout}
+	u.dryRunOut = &shown
+
+	u.logLine("hello")
+	assert.Equal(t, "IN: hello OUT: HELLO\n", shown.String())
+	assert.Equal(t, "HELLO\n", stdout.String())
+	_, err = os.Stat(target)
+	assert.True(t, os.IsNotExist(err))
+
+	shown.Reset()
+	u.JSON = true
+	u.Filters = []Filter{&dropJSONFilter{}}
+	u.logJSON(`{"message":"bye"}`)
+	assert.Equal(t, `IN: {"message":"bye"} OUT: (dropped)`+"\n", shown.String())
+}
+
+type dropJSONFilter struct{}
+
+func (dropJSONFilter) FilterLine(line string) string { return line }
+
+func (dropJSONFilter) FilterJSON(line *json.LogLine) { *line = nil }
@@ -0,0 +1,86 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+
+	flag "launchpad.net/gnuflag"
+)
+
+// EnvPrefix is the prefix of the environment variables flags fall back
+// to.
+const EnvPrefix = "UNILOG_"
+
+// envIgnored are the flags that aren't read from the environment:
+// UNILOG_VERSION is as likely to be the deployed version as a request
+// to print it.
+var envIgnored = map[string]bool{"version": true}
+
+// envAliases are the words of the older flags whose names run them
+// together, so that they can be read from, e.g., UNILOG_SENTRY_DSN as
+// well as UNILOG_SENTRYDSN.
+var envAliases = map[string]string{
+	"austerityfile":          "austerity-file",
+	"deadletter":             "dead-letter",
+	"dryrun":                 "dry-run",
+	"emergencyausterityfile": "emergency-austerity-file",
+	"independenttags":        "independent-tags",
+	"mailfrom":               "mail-from",
+	"mailto":                 "mail-to",
+	"notifythrottle":         "notify-throttle",
+	"sentrydsn":              "sentry-dsn",
+	"statsdaddress":          "statsd-address",
+	"syncinterval":           "sync-interval",
+	"synconbreak":            "sync-on-break",
+	"writebuffer":            "write-buffer",
+}
+
+// envName returns the environment variable for the flag name: the
+// name, upper-cased with dashes as underscores, after EnvPrefix (e.g.
+// UNILOG_SENTRYDSN for -sentrydsn, UNILOG_IDLE_FLUSH for -idle-flush).
+func envName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// envNames returns the environment variables for the flag name, in
+// the order they're looked up: its envName, then that of its alias in
+// envAliases, if it has one.
+func envNames(name string) []string {
+	names := []string{envName(name)}
+	if alias, ok := envAliases[name]; ok {
+		names = append(names, envName(alias))
+	}
+	return names
+}
+
+// ApplyEnv sets the flags in fs that weren't set on the command line
+// (fs having been parsed already) from their environment variables,
+// looked up with lookup (normally os.LookupEnv) under envNames. One-
+// letter aliases like -v don't have their own variables; their flags'
+// long names do.
+//
+// Flags set this way count as set, so they take precedence over a
+// Config applied afterwards.
+func ApplyEnv(fs *flag.FlagSet, lookup func(string) (string, bool)) error {
+	var set []*flag.Flag
+	fs.Visit(func(f *flag.Flag) {
+		set = append(set, f)
+	})
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || len(f.Name) == 1 || envIgnored[f.Name] || flagSet(f, set) {
+			return
+		}
+		for _, name := range envNames(f.Name) {
+			v, ok := lookup(name)
+			if !ok {
+				continue
+			}
+			if e := fs.Set(f.Name, v); e != nil {
+				err = fmt.Errorf("invalid value %q for %s: %s", v, name, e)
+			}
+			return
+		}
+	})
+	return err
+}
@@ -0,0 +1,69 @@
+package logger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvName(t *testing.T) {
+	assert.Equal(t, "UNILOG_SENTRYDSN", envName("sentrydsn"))
+	assert.Equal(t, "UNILOG_IDLE_FLUSH", envName("idle-flush"))
+	assert.Equal(t, []string{"UNILOG_SENTRYDSN", "UNILOG_SENTRY_DSN"}, envNames("sentrydsn"))
+	assert.Equal(t, []string{"UNILOG_IDLE_FLUSH"}, envNames("idle-flush"))
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"UNILOG_NAME":           "api",
+		"UNILOG_VERBOSE":        "true",
+		"UNILOG_MAILTO":         "oncall@example.com",
+		"UNILOG_BUFFER_LINES":   "100",
+		"UNILOG_IDLE_FLUSH":     "1s",
+		"UNILOG_STATSD_ADDRESS": "127.0.0.1:8125",
+		"UNILOG_SENTRY_DSN":     "https://key@sentry.example.com/1",
+		"UNILOG_SYNCINTERVAL":   "2s",
+		// The flag's own name wins over its alias
+		"UNILOG_SYNC_INTERVAL": "3s",
+		// One-letter aliases don't have variables of their own
+		"UNILOG_A": "ignored",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	u := &Unilog{}
+	fs := configFlags(u)
+	require.NoError(t, fs.Parse(true, nil))
+	require.NoError(t, ApplyEnv(fs, lookup))
+	assert.Equal(t, "api", u.Name)
+	assert.True(t, u.Verbose)
+	assert.Equal(t, "oncall@example.com", u.MailTo)
+	assert.Equal(t, 100, u.BufferLines)
+	assert.Equal(t, time.Second, u.IdleFlush)
+	assert.Equal(t, "127.0.0.1:8125", u.StatsdAddress)
+	assert.Equal(t, "https://key@sentry.example.com/1", u.SentryDSN)
+	assert.Equal(t, 2*time.Second, u.SyncInterval)
+	assert.Zero(t, u.SampleRate)
+
+	// Flags on the command line take precedence, under either name
+	u = &Unilog{}
+	fs = configFlags(u)
+	require.NoError(t, fs.Parse(true, []string{"-a", "worker", "--verbose=false"}))
+	require.NoError(t, ApplyEnv(fs, lookup))
+	assert.Equal(t, "worker", u.Name)
+	assert.False(t, u.Verbose)
+	assert.Equal(t, "oncall@example.com", u.MailTo)
+
+	// and the environment over a config file
+	config := Config{"mailto": "config@example.com", "sample-rate": 0.5}
+	require.NoError(t, config.Apply(fs))
+	assert.Equal(t, "oncall@example.com", u.MailTo)
+	assert.Equal(t, 0.5, u.SampleRate)
+
+	env["UNILOG_BUFFER_LINES"] = "lots"
+	assert.Error(t, ApplyEnv(configFlags(&Unilog{}), lookup))
+}
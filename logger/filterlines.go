@@ -0,0 +1,106 @@
+package logger
+
+import "time"
+
+// A MultiFilter is a Filter that can turn a text line into any number
+// of lines: none, to drop it, or several, to split it or to emit lines
+// of its own along with it. Each of them is passed through the filters
+// after it. unilog calls FilterLines instead of FilterLine on such
+// filters; FilterJSON is called as usual.
+type MultiFilter interface {
+	Filter
+	FilterLines(line string) []string
+}
+
+// A HoldingFilter is a MultiFilter that holds lines back, to write
+// them (or lines in their place) later. They are returned by Flush,
+// which unilog calls once FlushAfter says it's time to (as long as it
+// returns true), and when it stops.
+type HoldingFilter interface {
+	MultiFilter
+	Flush() []string
+	FlushAfter() (time.Duration, bool)
+}
+
+// filteredLine is a text line that went through the Filters.
+type filteredLine struct {
+	// the line as it entered the filters: the line read from the
+	// input, or one emitted by a MultiFilter
+	source string
+	line   string
+	// why its contents were dropped, if a filter shed or sampled
+	// them out (see textDropReason)
+	reason string
+}
+
+// filterLines applies the Filters to a text line, and returns the
+// lines that result.
+func (u *Unilog) filterLines(line string) []filteredLine {
+	return u.filterFrom(0, filteredLine{source: line, line: line}, nil)
+}
+
+// filterFrom applies the Filters from the i'th on to f, appending the
+// results to out.
+func (u *Unilog) filterFrom(i int, f filteredLine, out []filteredLine) []filteredLine {
+	for ; i < len(u.Filters); i++ {
+		filter := u.Filters[i]
+		if filter == nil {
+			continue
+		}
+		if m, ok := filter.(MultiFilter); ok {
+			for _, line := range m.FilterLines(f.line) {
+				source := f.source
+				if line != f.line {
+					source = line
+				}
+				out = u.filterFrom(i+1, filteredLine{source: source, line: line, reason: f.reason}, out)
+			}
+			return out
+		}
+		f.line = filter.FilterLine(f.line)
+		if r := textDropReason(f.line); r != "" {
+			f.reason = r
+		}
+	}
+	return append(out, f)
+}
+
+// flushFilters writes out the lines that HoldingFilters are holding
+// back. If due is set, only filters whose FlushAfter has passed are
+// flushed.
+func (u *Unilog) flushFilters(due bool) {
+	if u.JSON || u.Raw {
+		return
+	}
+	for i, filter := range u.Filters {
+		m, ok := filter.(HoldingFilter)
+		if !ok {
+			continue
+		}
+		if d, pending := m.FlushAfter(); !pending || due && d > 0 {
+			continue
+		}
+		for _, line := range m.Flush() {
+			for _, f := range u.filterFrom(i+1, filteredLine{source: line, line: line}, nil) {
+				u.writeFiltered(f)
+			}
+		}
+	}
+}
+
+// filterFlushAfter returns how long until a HoldingFilter is due to be
+// flushed, if any is holding lines back.
+func (u *Unilog) filterFlushAfter() (time.Duration, bool) {
+	var after time.Duration
+	pending := false
+	for _, filter := range u.Filters {
+		m, ok := filter.(HoldingFilter)
+		if !ok {
+			continue
+		}
+		if d, ok := m.FlushAfter(); ok && (!pending || d < after) {
+			after, pending = d, true
+		}
+	}
+	return after, pending
+}
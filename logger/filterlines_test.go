@@ -0,0 +1,111 @@
+package logger
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/unilog/filters"
+	"github.com/stripe/unilog/json"
+)
+
+var _ HoldingFilter = &filters.DedupFilter{}
+
+// splitFilter splits text lines on commas, and drops empty ones.
+type splitFilter struct{}
+
+func (splitFilter) FilterLine(line string) string {
+	return line
+}
+func (splitFilter) FilterJSON(line *json.LogLine) {}
+func (splitFilter) FilterLines(line string) []string {
+	if line == "" {
+		return nil
+	}
+	return strings.Split(line, ",")
+}
+
+func TestMultiFilter(t *testing.T) {
+	var buf bytes.Buffer
+	u := &Unilog{Filters: []Filter{
+		splitFilter{},
+		FilterFunc(func(line string) string { return "<" + line + ">" }),
+	}}
+	u.file = mockFile{buf: &buf}
+
+	u.logLine("one,two,three")
+	assert.Equal(t, "<one>\n<two>\n<three>\n", buf.String())
+	assert.Equal(t, "<one>\n<two>\n<three>\n", u.format("one,two,three"))
+	assert.Equal(t, "<just one>\n", u.format("just one"))
+	assert.Equal(t, "", u.format(""))
+
+	// JSON lines are filtered as usual
+	u.JSON = true
+	out := getLogJSON(u, `{"message":"a,b"}`)
+	assert.Contains(t, out, `"message":"a,b"`)
+	assert.Equal(t, 1, strings.Count(out, "\n"))
+}
+
+func TestHoldingFilter(t *testing.T) {
+	var buf bytes.Buffer
+	lines := make(chan string, 10)
+	u := &Unilog{
+		lines: lines,
+		Filters: []Filter{
+			&filters.DedupFilter{Window: 20 * time.Millisecond},
+			// Lines the dedup filter emits go through the
+			// filters after it
+			FilterFunc(strings.ToUpper),
+		},
+	}
+	u.file = mockFile{buf: &buf}
+
+	for _, line := range []string{"a", "a", "a", "b"} {
+		lines <- line
+		assert.True(t, u.tick())
+	}
+	assert.Equal(t, "A\nLAST MESSAGE REPEATED 2 TIMES\nB\n", buf.String())
+
+	// Repeats are summarized once the window is up
+	buf.Reset()
+	lines <- "b"
+	assert.True(t, u.tick())
+	assert.Empty(t, buf.String())
+	assert.True(t, u.tick())
+	assert.Equal(t, "LAST MESSAGE REPEATED 1 TIME\n", buf.String())
+
+	// and when unilog stops
+	buf.Reset()
+	lines <- "b"
+	lines <- "b"
+	close(lines)
+	u.run()
+	assert.Equal(t, "LAST MESSAGE REPEATED 2 TIMES\n", buf.String())
+
+	assert.Equal(t, "A\n", u.format("a"))
+	assert.Equal(t, "", u.format("a"))
+}
+
+func TestUnterminatedTrace(t *testing.T) {
+	var buf bytes.Buffer
+	lines := make(chan string, 10)
+	u := &Unilog{
+		lines:   lines,
+		Filters: []Filter{&filters.MultilineFilter{Pattern: regexp.MustCompile(`^\s`), Timeout: time.Hour}},
+	}
+	u.file = mockFile{buf: &buf}
+
+	lines <- "panic: boom"
+	lines <- "\tmain.go:12"
+	assert.True(t, u.tick())
+	assert.True(t, u.tick())
+	assert.Empty(t, buf.String())
+
+	// The input ends in the middle of the trace
+	close(lines)
+	u.run()
+	assert.Equal(t, `panic: boom\n`+"\tmain.go:12\n", buf.String())
+}
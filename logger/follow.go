@@ -0,0 +1,118 @@
+package logger
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// followPollInterval is how often a follower checks for new data (and
+// for the file being rotated) once it has caught up.
+const followPollInterval = 250 * time.Millisecond
+
+// follower is an io.Reader that follows a growing file, like tail -F:
+// it reads the file from the start, waits for more data at EOF, and
+// reopens the path when the file is rotated (its inode changes) or
+// truncated. Each reopen is reported in the unilog.follow.reopens
+// metric.
+//
+// A follower only returns EOF once stop has been called and it has
+// read everything written to the file so far.
+type follower struct {
+	path string
+	poll time.Duration
+	// where to report metrics to, if anywhere
+	stats Client
+
+	f    *os.File
+	info os.FileInfo
+
+	mtx     sync.Mutex
+	stopped bool
+}
+
+func newFollower(path string) (*follower, error) {
+	fl := &follower{path: path, poll: followPollInterval}
+	if err := fl.open(); err != nil {
+		return nil, err
+	}
+	return fl, nil
+}
+
+func (fl *follower) open() error {
+	f, err := os.Open(fl.path)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if fl.f != nil {
+		fl.f.Close()
+	}
+	fl.f = f
+	fl.info = info
+	return nil
+}
+
+// stop makes the follower return EOF once it has caught up with the
+// file, instead of waiting for more data.
+func (fl *follower) stop() {
+	fl.mtx.Lock()
+	defer fl.mtx.Unlock()
+	fl.stopped = true
+}
+
+func (fl *follower) isStopped() bool {
+	fl.mtx.Lock()
+	defer fl.mtx.Unlock()
+	return fl.stopped
+}
+
+func (fl *follower) Read(buf []byte) (int, error) {
+	for {
+		n, err := fl.f.Read(buf)
+		if n > 0 || (err != nil && err != io.EOF) {
+			return n, err
+		}
+
+		// We're caught up with the file we have open; by now, it
+		// has been read to the end even if it was rotated.
+		if fl.isStopped() {
+			return 0, io.EOF
+		}
+		if reason := fl.changed(); reason != "" {
+			if err := fl.open(); err == nil {
+				if fl.stats != nil {
+					IndependentCount(fl.stats, "unilog.follow.reopens", 1, []string{"reason:" + reason}, 1)
+				}
+				continue
+			}
+			// The new file may not have been created yet
+		}
+		time.Sleep(fl.poll)
+	}
+}
+
+// changed returns why the file at the path should be reopened
+// ("rotated" or "truncated"), or "" if it shouldn't.
+func (fl *follower) changed() string {
+	info, err := os.Stat(fl.path)
+	if err != nil {
+		return ""
+	}
+	if !os.SameFile(fl.info, info) {
+		return "rotated"
+	}
+	if pos, err := fl.f.Seek(0, io.SeekCurrent); err == nil && info.Size() < pos {
+		return "truncated"
+	}
+	return ""
+}
+
+func (fl *follower) Close() error {
+	return fl.f.Close()
+}
@@ -0,0 +1,94 @@
+package logger
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func appendFile(t *testing.T, path, data string) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func TestFollow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "in")
+	appendFile(t, path, "one\n")
+
+	fl, err := newFollower(path)
+	require.NoError(t, err)
+	defer fl.Close()
+	fl.poll = time.Millisecond
+
+	lines := make(chan string)
+	go func() {
+		r := bufio.NewReader(fl)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			lines <- line
+		}
+	}()
+	assert.Equal(t, "one\n", <-lines)
+
+	// Waits for more data
+	appendFile(t, path, "two\n")
+	assert.Equal(t, "two\n", <-lines)
+
+	// Rotation: the rest of the old file is read before the new one
+	require.NoError(t, os.Rename(path, path+".1"))
+	appendFile(t, path+".1", "three\n")
+	appendFile(t, path, "four\n")
+	assert.Equal(t, "three\n", <-lines)
+	assert.Equal(t, "four\n", <-lines)
+
+	// Truncation
+	require.NoError(t, os.Truncate(path, 0))
+	appendFile(t, path, "5\n")
+	assert.Equal(t, "5\n", <-lines)
+
+	appendFile(t, path, "six\n")
+	fl.stop()
+	assert.Equal(t, "six\n", <-lines)
+	_, ok := <-lines
+	assert.False(t, ok)
+}
+
+func TestFollowStop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "in")
+	appendFile(t, path, "one\ntwo\n")
+
+	fl, err := newFollower(path)
+	require.NoError(t, err)
+	defer fl.Close()
+	fl.stop()
+
+	b, err := ioutil.ReadAll(fl)
+	require.NoError(t, err)
+	assert.Equal(t, "one\ntwo\n", string(b))
+	n, err := fl.Read(make([]byte, 1))
+	assert.Equal(t, 0, n)
+	assert.Equal(t, io.EOF, err)
+
+	_, err = newFollower(filepath.Join(dir, "nonexistent"))
+	assert.Error(t, err)
+}
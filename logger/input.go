@@ -0,0 +1,25 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+)
+
+// openInput opens the Input file or FIFO to read lines from instead of
+// stdin. Opening a FIFO waits for a writer to open it.
+func (u *Unilog) openInput() (*os.File, error) {
+	f, err := os.Open(u.Input)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fi.IsDir() {
+		f.Close()
+		return nil, fmt.Errorf("%s is a directory", u.Input)
+	}
+	return f, nil
+}
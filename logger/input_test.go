@@ -0,0 +1,75 @@
+package logger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readInput reads all the lines of u's Input.
+func readInput(t *testing.T, u *Unilog) []string {
+	f, err := u.openInput()
+	require.NoError(t, err)
+	defer f.Close()
+	ch := make(chan struct{})
+	defer close(ch)
+	u.shutdown = ch
+	lines, _ := u.readlines(f)
+	var read []string
+	for line := range lines {
+		read = append(read, line)
+	}
+	return read
+}
+
+func TestInputFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "input.log")
+	require.NoError(t, ioutil.WriteFile(input, []byte("one\ntwo\n"), 0644))
+
+	assert.Equal(t, []string{"one", "two"}, readInput(t, &Unilog{Input: input}))
+
+	_, err = (&Unilog{Input: dir}).openInput()
+	assert.Error(t, err)
+	_, err = (&Unilog{Input: filepath.Join(dir, "missing")}).openInput()
+	assert.Error(t, err)
+}
+
+func TestInputPipe(t *testing.T) {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	defer r.Close()
+	go func() {
+		fmt.Fprintf(w, "one\ntwo\n")
+		w.Close()
+	}()
+	input := fmt.Sprintf("/dev/fd/%d", r.Fd())
+	assert.Equal(t, []string{"one", "two"}, readInput(t, &Unilog{Input: input}))
+}
+
+func TestInputFIFO(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	fifo := filepath.Join(dir, "fifo")
+	require.NoError(t, syscall.Mkfifo(fifo, 0600))
+
+	// Opening the FIFO waits for the writer
+	go func() {
+		w, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "one\ntwo\n")
+		w.Close()
+	}()
+	assert.Equal(t, []string{"one", "two"}, readInput(t, &Unilog{Input: fifo}))
+}
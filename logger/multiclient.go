@@ -0,0 +1,117 @@
+package logger
+
+import (
+	"strings"
+	"time"
+)
+
+// StatsdClient is the statsd client interface that MultiClient fans
+// out to; *statsd.Client implements it.
+type StatsdClient interface {
+	Client
+	Close() error
+}
+
+// MultiClient sends every metric to each of several statsd clients,
+// e.g. to dual-write metrics while migrating from one statsd agent to
+// another. Failing to send to one client doesn't keep the metric from
+// being sent to the others; the first error is returned.
+//
+// Each client samples metrics with a rate below 1 independently, so
+// they may not all receive the same samples.
+type MultiClient []StatsdClient
+
+func (m MultiClient) Count(name string, value int64, tags []string, rate float64) error {
+	var first error
+	for _, c := range m {
+		if err := c.Count(name, value, tags, rate); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (m MultiClient) Gauge(name string, value float64, tags []string, rate float64) error {
+	var first error
+	for _, c := range m {
+		if err := c.Gauge(name, value, tags, rate); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (m MultiClient) Histogram(name string, value float64, tags []string, rate float64) error {
+	var first error
+	for _, c := range m {
+		if err := c.Histogram(name, value, tags, rate); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (m MultiClient) Timing(name string, value time.Duration, tags []string, rate float64) error {
+	var first error
+	for _, c := range m {
+		if err := c.Timing(name, value, tags, rate); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (m MultiClient) Close() error {
+	var first error
+	for _, c := range m {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// setupStatsdClients sets up a statsd client (see setupStatsd) for
+// each address, and returns a MultiClient that sends to all of them.
+func setupStatsdClients(addresses []string, fileName, tags string) (MultiClient, error) {
+	var m MultiClient
+	for _, address := range addresses {
+		c, err := setupStatsd(address, fileName, tags)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		m = append(m, c)
+	}
+	return m, nil
+}
+
+// statsdAddressFlag is the flag.Value for -statsdaddress, which can be
+// repeated: the first value replaces the default StatsdAddress, and
+// any later ones are added to ExtraStatsdAddresses.
+type statsdAddressFlag struct {
+	u   *Unilog
+	set bool
+}
+
+func (f *statsdAddressFlag) Set(address string) error {
+	if _, err := parseStatsdAddress(address); err != nil {
+		return err
+	}
+	if !f.set {
+		f.u.StatsdAddress = address
+		f.set = true
+		return nil
+	}
+	f.u.ExtraStatsdAddresses = append(f.u.ExtraStatsdAddresses, address)
+	return nil
+}
+
+func (f *statsdAddressFlag) String() string {
+	return strings.Join(f.u.statsdAddresses(), ",")
+}
+
+// statsdAddresses returns all the addresses to send metrics to.
+func (u *Unilog) statsdAddresses() []string {
+	return append([]string{u.StatsdAddress}, u.ExtraStatsdAddresses...)
+}
@@ -0,0 +1,110 @@
+package logger
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingClient records the names of the metrics sent to it, and
+// fails every call if err is set.
+type recordingClient struct {
+	sent []string
+	err  error
+}
+
+func (c *recordingClient) record(name string) error {
+	c.sent = append(c.sent, name)
+	return c.err
+}
+
+func (c *recordingClient) Count(name string, value int64, tags []string, rate float64) error {
+	return c.record(name)
+}
+
+func (c *recordingClient) Gauge(name string, value float64, tags []string, rate float64) error {
+	return c.record(name)
+}
+
+func (c *recordingClient) Histogram(name string, value float64, tags []string, rate float64) error {
+	return c.record(name)
+}
+
+func (c *recordingClient) Timing(name string, value time.Duration, tags []string, rate float64) error {
+	return c.record(name)
+}
+
+func (c *recordingClient) Close() error {
+	return c.record("close")
+}
+
+func TestMultiClient(t *testing.T) {
+	failing := &recordingClient{err: errors.New("agent on fire")}
+	ok := &recordingClient{}
+	m := MultiClient{failing, ok}
+
+	assert.Error(t, m.Count("count", 1, nil, 1))
+	assert.Error(t, m.Gauge("gauge", 1, nil, 1))
+	assert.Error(t, m.Histogram("histogram", 1, nil, 1))
+	assert.Error(t, m.Timing("timing", time.Second, nil, 1))
+	assert.Error(t, m.Close())
+
+	want := []string{"count", "gauge", "histogram", "timing", "close"}
+	assert.Equal(t, want, failing.sent)
+	// The failure doesn't stop the other client from getting metrics
+	assert.Equal(t, want, ok.sent)
+
+	assert.NoError(t, MultiClient{ok}.Count("count", 1, nil, 1))
+	assert.NoError(t, MultiClient{}.Count("count", 1, nil, 1))
+}
+
+func TestSetupStatsdClients(t *testing.T) {
+	var conns []net.PacketConn
+	var addresses []string
+	for i := 0; i < 2; i++ {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer conn.Close()
+		conns = append(conns, conn)
+		addresses = append(addresses, conn.LocalAddr().String())
+	}
+
+	m, err := setupStatsdClients(addresses, "log", "a:b")
+	require.NoError(t, err)
+	defer m.Close()
+	require.Len(t, m, 2)
+
+	u := &Unilog{Metrics: m}
+	IndependentCount(u.stats(), "unilog.test", 1, nil, 1)
+	for _, conn := range conns {
+		buf := make([]byte, 1024)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.Equal(t, "unilog.test:1|c|#a:b", string(buf[:n]))
+	}
+
+	_, err = setupStatsdClients([]string{addresses[0], "unix:///var/run/statsd.sock"}, "log", "")
+	assert.Error(t, err)
+}
+
+func TestStatsdAddressFlag(t *testing.T) {
+	u := &Unilog{StatsdAddress: "127.0.0.1:8200"}
+	f := &statsdAddressFlag{u: u}
+	assert.Equal(t, "127.0.0.1:8200", f.String())
+
+	require.NoError(t, f.Set("udp://10.0.0.1:8125"))
+	assert.Equal(t, "udp://10.0.0.1:8125", u.StatsdAddress)
+	assert.Empty(t, u.ExtraStatsdAddresses)
+
+	require.NoError(t, f.Set("[::1]:8125"))
+	assert.Equal(t, []string{"udp://10.0.0.1:8125", "[::1]:8125"}, u.statsdAddresses())
+	assert.Equal(t, "udp://10.0.0.1:8125,[::1]:8125", f.String())
+
+	assert.Error(t, f.Set("nonsense"))
+	assert.Len(t, u.ExtraStatsdAddresses, 1)
+}
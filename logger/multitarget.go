@@ -0,0 +1,239 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Error actions for the individual targets of a multiTarget. Unlike
+// write_to_log and reopen_file, they don't count towards the circuit
+// breaker, since the other targets may still be writable.
+const (
+	multiTargetOpenAction  = "reopen_target"
+	multiTargetWriteAction = "write_to_target"
+)
+
+// errAllTargetsFailed is returned by a multiTarget when a line
+// couldn't be written to any of its targets.
+var errAllTargetsFailed = errors.New("could not write to any target")
+
+// multiTarget writes each line to several targets: files, - for
+// stdout, or network targets. It's used when unilog is given more than one target, e.g.
+// to write to a local file and to a sidecar collector reading stdout.
+//
+// A failure to write to (or open) one of the targets is reported
+// with the target's name and the write_to_target (or reopen_target)
+// action, and the line is still written to the others; only if it
+// couldn't be written to any of them does Write fail. A file target
+// that couldn't be opened, or whose reader went away (EPIPE), is
+// retried after PipeRetryDelay. Reopening reopens all the file
+// targets.
+//
+// With WriteBuffer, each file target has its own buffer; the lines lost
+// when writing one out fails are counted until takeLost, including
+// those of targets closed since.
+//
+// The unilog.bytes and unilog.write.duration metrics are reported for
+// each target's writes, tagged with the target's name (so unilog.bytes
+// counts the bytes written to it, rather than those read).
+//
+// Options that need a single target file (templates, MaxFileBytes,
+// Atomic and compressing stdout) don't apply.
+type multiTarget struct {
+	u       *Unilog
+	targets []*fanoutTarget
+	// the lines lost with the buffers of closed targets
+	lost int
+}
+
+type fanoutTarget struct {
+	name string
+	// nil while the target is closed after an error
+	w           io.WriteCloser
+	opened      bool
+	reopenAfter time.Time
+}
+
+// newMultiTarget opens the targets. As with a single target, it fails
+// if one of them is locked by another unilog; otherwise, targets that
+// can't be opened are retried on later writes.
+func (u *Unilog) newMultiTarget(names []string) (*multiTarget, error) {
+	m := &multiTarget{u: u}
+	for _, name := range names {
+		m.targets = append(m.targets, &fanoutTarget{name: name})
+	}
+	if err := m.reopen(); errors.Is(err, errTargetLocked) {
+		m.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// checkMultiTarget returns an error if options that need a single
+// target are set.
+func (u *Unilog) checkMultiTarget() error {
+	for _, name := range u.targets {
+		if isTemplate(name) {
+			return fmt.Errorf("templated target %s can't be used with other targets", name)
+		}
+	}
+	switch {
+	case u.Atomic:
+		return fmt.Errorf("-atomic can't be used with more than one target")
+	case u.MaxFileBytes > 0:
+		return fmt.Errorf("-maxbytes can't be used with more than one target")
+	case u.CompressBackups:
+		return fmt.Errorf("-compress can't be used with more than one target")
+	}
+	return nil
+}
+
+// reopen (re)opens all the file targets, and returns the first error
+// opening one of them.
+func (m *multiTarget) reopen() error {
+	var err error
+	for _, t := range m.targets {
+		if t.name == "-" {
+			t.w = os.Stdout
+			continue
+		}
+		if t.w != nil {
+			m.close(t)
+		}
+		if e := m.open(t); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (m *multiTarget) open(t *fanoutTarget) error {
+	var f io.WriteCloser
+	var err error
+	if network, addr, ok := parseNetworkTarget(t.name); ok {
+		f, err = dialNetworkTarget(network, addr, m.u.PipeRetryDelay)
+	} else {
+		f, err = m.u.openFile(t.name, !t.opened)
+	}
+	if err != nil {
+		t.reopenAfter = time.Now().Add(m.u.PipeRetryDelay)
+		m.u.handleError(multiTargetOpenAction, fmt.Errorf("%s: %w", t.name, err))
+		return err
+	}
+	t.opened = true
+	t.w = f
+	return nil
+}
+
+// Write writes p to each of the targets.
+func (m *multiTarget) Write(p []byte) (int, error) {
+	written := 0
+	for _, t := range m.targets {
+		if m.write(t, p) {
+			written++
+		}
+	}
+	if written == 0 {
+		return 0, errAllTargetsFailed
+	}
+	return len(p), nil
+}
+
+// write writes p to t, and reports whether it did.
+func (m *multiTarget) write(t *fanoutTarget, p []byte) bool {
+	if t.w == nil {
+		if time.Now().Before(t.reopenAfter) || m.open(t) != nil {
+			return false
+		}
+	}
+	start := time.Now()
+	n, err := t.w.Write(p)
+	if stats := m.u.stats(); stats != nil {
+		target := "target:" + t.name
+		IndependentCount(stats, "unilog.bytes", int64(n), []string{"mode:" + m.u.inputMode(), target}, .1)
+		if m.u.WriteTimingRate > 0 {
+			IndependentTiming(stats, "unilog.write.duration", time.Since(start), []string{target}, m.u.WriteTimingRate)
+		}
+	}
+	if err != nil {
+		if errors.Is(err, syscall.EPIPE) && t.name != "-" {
+			m.close(t)
+			t.reopenAfter = time.Now().Add(m.u.PipeRetryDelay)
+		}
+		m.u.handleError(multiTargetWriteAction, fmt.Errorf("%s: %w", t.name, err))
+		return false
+	}
+	return true
+}
+
+// Flush writes out the buffers of all the open file targets (see
+// WriteBuffer), and returns the first error.
+func (m *multiTarget) Flush() error {
+	var err error
+	for _, t := range m.targets {
+		f, ok := t.w.(flusher)
+		if !ok {
+			continue
+		}
+		if e := f.Flush(); e != nil && err == nil {
+			err = fmt.Errorf("%s: %w", t.name, e)
+		}
+	}
+	return err
+}
+
+// Sync syncs all the open file targets, and returns the first error.
+func (m *multiTarget) Sync() error {
+	var err error
+	for _, t := range m.targets {
+		f, ok := t.w.(syncer)
+		if !ok || t.name == "-" {
+			continue
+		}
+		if e := f.Sync(); e != nil && err == nil {
+			err = fmt.Errorf("%s: %w", t.name, e)
+		}
+	}
+	return err
+}
+
+// Close closes all the file targets.
+func (m *multiTarget) Close() error {
+	var err error
+	for _, t := range m.targets {
+		if t.w != nil && t.name != "-" {
+			if e := m.close(t); e != nil && err == nil {
+				err = e
+			}
+		}
+		t.w = nil
+	}
+	return err
+}
+
+// close closes t, keeping count of the lines lost with its buffer.
+func (m *multiTarget) close(t *fanoutTarget) error {
+	err := t.w.Close()
+	if b, ok := t.w.(bufferedTarget); ok {
+		m.lost += b.takeLost()
+	}
+	t.w = nil
+	return err
+}
+
+// takeLost returns the number of lines lost with the targets' buffers
+// since the last call.
+func (m *multiTarget) takeLost() int {
+	n := m.lost
+	m.lost = 0
+	for _, t := range m.targets {
+		if b, ok := t.w.(bufferedTarget); ok {
+			n += b.takeLost()
+		}
+	}
+	return n
+}
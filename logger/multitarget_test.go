@@ -0,0 +1,159 @@
+package logger
+
+import (
+	"bytes"
+	encjson "encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiTarget(t *testing.T) {
+	var a, b bytes.Buffer
+	u := &Unilog{}
+	u.file = &multiTarget{u: u, targets: []*fanoutTarget{
+		{name: "a.log", w: mockFile{buf: &a}},
+		{name: "b.log", w: mockFile{buf: &b}},
+	}}
+	u.logLine("hello")
+	assert.Equal(t, "hello\n", a.String())
+	assert.Equal(t, "hello\n", b.String())
+
+	a.Reset()
+	b.Reset()
+	u.JSON = true
+	u.jsonEncoder = encjson.NewEncoder(u.file)
+	u.logJSON(`{"message":"hello"}`)
+	assert.Contains(t, a.String(), `"message":"hello"`)
+	assert.Equal(t, a.String(), b.String())
+}
+
+func TestMultiTargetFailure(t *testing.T) {
+	var b bytes.Buffer
+	var writes int
+	client := &MockClient{Counts: make(map[string]int64)}
+	u := &Unilog{Metrics: client}
+	u.file = &multiTarget{u: u, targets: []*fanoutTarget{
+		{name: "a.log", w: failingFile{writes: &writes}},
+		{name: "b.log", w: mockFile{buf: &b}},
+	}}
+	u.logLine("one")
+	u.logLine("two")
+
+	// The line still makes it to b.log, and the other target's failure
+	// doesn't count as a failure to write the line
+	assert.Equal(t, "one\ntwo\n", b.String())
+	assert.Equal(t, int64(2), client.Counts["[err_action:write_to_target]unilog.errors_total"])
+	assert.Zero(t, client.Counts["[err_action:write_to_log]unilog.errors_total"])
+	assert.False(t, u.writeFailed)
+
+	// unless every target fails
+	u.file.(*multiTarget).targets[1].w = failingFile{writes: &writes}
+	u.logLine("three")
+	assert.Equal(t, int64(1), client.Counts["[err_action:write_to_log]unilog.errors_total"])
+	assert.True(t, u.writeFailed)
+}
+
+func TestMultiTargetReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	a := filepath.Join(dir, "a.log")
+	b := filepath.Join(dir, "b.log")
+
+	u := &Unilog{target: a, targets: []string{a, b}}
+	require.NoError(t, u.reopen())
+	defer u.file.Close()
+	u.logLine("one")
+
+	// Both files are rotated out, and reopened on SIGHUP
+	require.NoError(t, os.Rename(a, a+".1"))
+	require.NoError(t, os.Rename(b, b+".1"))
+	require.NoError(t, u.reopen())
+	u.logLine("two")
+
+	assert.Equal(t, "one\n", readFile(t, a+".1"))
+	assert.Equal(t, "one\n", readFile(t, b+".1"))
+	assert.Equal(t, "two\n", readFile(t, a))
+	assert.Equal(t, "two\n", readFile(t, b))
+}
+
+func TestCheckMultiTarget(t *testing.T) {
+	assert.NoError(t, (&Unilog{targets: []string{"/var/log/out.log", "-"}}).checkMultiTarget())
+	assert.Error(t, (&Unilog{targets: []string{"/var/log/{date}.log", "-"}}).checkMultiTarget())
+	assert.Error(t, (&Unilog{Atomic: true, targets: []string{"a.log", "b.log"}}).checkMultiTarget())
+	assert.Error(t, (&Unilog{MaxFileBytes: 10, targets: []string{"a.log", "b.log"}}).checkMultiTarget())
+}
+
+func TestMultiTargetMetrics(t *testing.T) {
+	var a, b bytes.Buffer
+	var writes int
+	client := &MockClient{
+		Counts:     make(map[string]int64),
+		Histograms: make(map[string]float64),
+		Timings:    make(map[string]time.Duration),
+		Gauges:     make(map[string]float64),
+	}
+	u := &Unilog{Metrics: client, WriteTimingRate: 1}
+	u.file = &multiTarget{u: u, targets: []*fanoutTarget{
+		{name: "a.log", w: mockFile{buf: &a}},
+		{name: "b.log", w: mockFile{buf: &b}},
+		{name: "c.log", w: failingFile{writes: &writes}},
+	}}
+	u.logLine("hello")
+
+	// Each target's writes are tagged with the target
+	assert.Equal(t, int64(6), client.Counts["[mode:text][target:a.log]unilog.bytes"])
+	assert.Equal(t, int64(6), client.Counts["[mode:text][target:b.log]unilog.bytes"])
+	assert.Equal(t, int64(0), client.Counts["[mode:text][target:c.log]unilog.bytes"])
+	assert.Contains(t, client.Timings, "[target:a.log]unilog.write.duration")
+	assert.Contains(t, client.Timings, "[target:c.log]unilog.write.duration")
+	assert.NotContains(t, client.Timings, "unilog.write.duration")
+
+	// and the bytes read aren't counted as well
+	u.targets = []string{"a.log", "b.log", "c.log"}
+	u.shutdown = make(chan struct{})
+	defer close(u.shutdown)
+	lc, _ := u.readlines(strings.NewReader("hello\n"))
+	for range lc {
+	}
+	assert.Equal(t, int64(0), client.Counts["[mode:text]unilog.bytes"])
+
+	// while a single target's aren't
+	client.Timings = make(map[string]time.Duration)
+	getLogLine(u, "hello")
+	assert.Contains(t, client.Timings, "unilog.write.duration")
+}
+
+func TestMultiTargetWriteBufferLost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	client := &MockClient{Counts: make(map[string]int64), Histograms: make(map[string]float64), Timings: make(map[string]time.Duration)}
+	a, b := filepath.Join(dir, "a.log"), filepath.Join(dir, "b.log")
+	u := &Unilog{WriteBuffer: 16, targets: []string{a, b}, Metrics: client}
+	require.NoError(t, u.reopen())
+	m := u.file.(*multiTarget)
+
+	u.logLine("one")
+	u.logLine("two")
+	require.NoError(t, m.targets[0].w.(*sizedFile).f.Close())
+
+	// The lines buffered for a.log are lost when it needs writing
+	// out to make room, even though the line makes it to b.log
+	u.logLine(strings.Repeat("x", 20))
+	assert.Equal(t, int64(2), client.Counts["[reason:buffer_lost]unilog.lines.dropped"])
+	assert.False(t, u.writeFailed)
+
+	// and so are those of a target closed by reopening
+	u.logLine("three")
+	require.NoError(t, u.reopen())
+	assert.Equal(t, int64(3), client.Counts["[reason:buffer_lost]unilog.lines.dropped"])
+}
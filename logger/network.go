@@ -0,0 +1,130 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// networkTargetPrefixes maps the prefixes of network targets to their
+// networks.
+var networkTargetPrefixes = map[string]string{
+	"tcp://": "tcp",
+	"udp://": "udp",
+}
+
+// parseNetworkTarget returns the network and address of a
+// tcp://host:port or udp://host:port target, or ok = false if target
+// isn't one.
+func parseNetworkTarget(target string) (network, addr string, ok bool) {
+	for prefix, network := range networkTargetPrefixes {
+		if strings.HasPrefix(target, prefix) {
+			return network, strings.TrimPrefix(target, prefix), true
+		}
+	}
+	return "", "", false
+}
+
+// netTarget is a target that writes lines to a remote aggregator over
+// a TCP connection, or as one UDP datagram each.
+//
+// If a write fails, or doesn't finish within writeTimeout, the
+// connection is closed (and the error returned, so it's reported like
+// any other write failure); the first write after retryDelay dials it
+// again, and the ones before then fail with errNetTargetDown.
+type netTarget struct {
+	network      string
+	addr         string
+	retryDelay   time.Duration
+	writeTimeout time.Duration
+	// nil after a failed write or dial
+	conn        net.Conn
+	redialAfter time.Time
+}
+
+// netDialTimeout and netWriteTimeout are how long dialing a network
+// target, and writing a line to it, can take, since both block the
+// event loop.
+const (
+	netDialTimeout  = 5 * time.Second
+	netWriteTimeout = 5 * time.Second
+)
+
+// isTimeout returns whether err is a network operation timing out.
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// errNetTargetDown is the error writing to a netTarget that's waiting
+// to be dialed again.
+var errNetTargetDown = errors.New("not connected, waiting to dial again")
+
+// checkNetworkTarget returns an error if the target is a network one
+// that is invalid, or used with options that need a target file.
+func (u *Unilog) checkNetworkTarget() error {
+	network, addr, ok := parseNetworkTarget(u.target)
+	if !ok {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return fmt.Errorf("invalid %s target %q: %s", network, u.target, err)
+	}
+	switch {
+	case u.Atomic:
+		return fmt.Errorf("-atomic can't be used with a %s target", network)
+	case u.MaxFileBytes > 0:
+		return fmt.Errorf("-maxbytes can't be used with a %s target", network)
+	}
+	return nil
+}
+
+// dialNetworkTarget dials a network target that waits retryDelay
+// before dialing again after a failure.
+func dialNetworkTarget(network, addr string, retryDelay time.Duration) (*netTarget, error) {
+	t := &netTarget{network: network, addr: addr, retryDelay: retryDelay, writeTimeout: netWriteTimeout}
+	if err := t.dial(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *netTarget) dial() error {
+	conn, err := net.DialTimeout(t.network, t.addr, netDialTimeout)
+	if err != nil {
+		t.redialAfter = time.Now().Add(t.retryDelay)
+		return err
+	}
+	t.conn = conn
+	return nil
+}
+
+func (t *netTarget) Write(p []byte) (int, error) {
+	if t.conn == nil {
+		if time.Now().Before(t.redialAfter) {
+			return 0, errNetTargetDown
+		}
+		if err := t.dial(); err != nil {
+			return 0, err
+		}
+	}
+	t.conn.SetWriteDeadline(time.Now().Add(t.writeTimeout))
+	n, err := t.conn.Write(p)
+	if err != nil {
+		t.conn.Close()
+		t.conn = nil
+		t.redialAfter = time.Now().Add(t.retryDelay)
+	}
+	return n, err
+}
+
+func (t *netTarget) Close() error {
+	if t.conn == nil {
+		return nil
+	}
+	err := t.conn.Close()
+	t.conn = nil
+	return err
+}
@@ -0,0 +1,140 @@
+package logger
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNetworkTarget(t *testing.T) {
+	network, addr, ok := parseNetworkTarget("tcp://logs.example.com:5170")
+	assert.True(t, ok)
+	assert.Equal(t, "tcp", network)
+	assert.Equal(t, "logs.example.com:5170", addr)
+	network, _, ok = parseNetworkTarget("udp://127.0.0.1:5170")
+	assert.True(t, ok)
+	assert.Equal(t, "udp", network)
+	_, _, ok = parseNetworkTarget("/var/log/tcp://.log")
+	assert.False(t, ok)
+
+	assert.NoError(t, (&Unilog{target: "/var/log/out.log", Atomic: true}).checkNetworkTarget())
+	assert.NoError(t, (&Unilog{target: "tcp://127.0.0.1:5170"}).checkNetworkTarget())
+	assert.Error(t, (&Unilog{target: "tcp://127.0.0.1"}).checkNetworkTarget())
+	assert.Error(t, (&Unilog{target: "udp://127.0.0.1:5170", Atomic: true}).checkNetworkTarget())
+}
+
+func TestTCPTarget(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	client := &MockClient{Counts: make(map[string]int64)}
+	u := &Unilog{target: "tcp://" + l.Addr().String(), Metrics: client}
+	require.NoError(t, u.reopen())
+	defer u.file.Close()
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	u.logLine("one")
+	u.logLine("two, with some more text")
+	for _, want := range []string{"one\n", "two, with some more text\n"} {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, want, line)
+	}
+
+	// A broken connection fails the write, and is dialed again for the
+	// next line
+	require.NoError(t, u.file.(*netTarget).conn.(*net.TCPConn).CloseWrite())
+	u.logLine("three")
+	assert.Equal(t, int64(1), client.Counts["[err_action:write_to_log][reason:epipe]unilog.errors_total"])
+	assert.Equal(t, int64(1), client.Counts["[reason:write_error]unilog.lines.dropped"])
+
+	u.logLine("four")
+	conn2, err := l.Accept()
+	require.NoError(t, err)
+	defer conn2.Close()
+	line, err := bufio.NewReader(conn2).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "four\n", line)
+}
+
+func TestUDPTarget(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	u := &Unilog{target: "udp://" + conn.LocalAddr().String()}
+	require.NoError(t, u.reopen())
+	defer u.file.Close()
+	u.logLine("one")
+	u.logLine("two")
+
+	// Each line is a datagram
+	buf := make([]byte, 1024)
+	for _, want := range []string{"one\n", "two\n"} {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(buf[:n]))
+	}
+}
+
+func TestNetworkTargetDeadListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	// A target that can't be dialed isn't dialed again for every line
+	client := &MockClient{Counts: make(map[string]int64)}
+	u := &Unilog{target: "tcp://" + addr, Metrics: client, PipeRetryDelay: time.Hour}
+	assert.Error(t, u.reopen())
+	assert.Nil(t, u.file)
+	u.logLine("one")
+	u.logLine("two")
+	assert.Equal(t, int64(0), client.Counts["[err_action:reopen_file]unilog.errors_total"])
+	assert.Equal(t, int64(2), client.Counts["[reason:target_unavailable]unilog.lines.dropped"])
+
+	// Nor is one whose connection went away
+	nt := &netTarget{network: "tcp", addr: addr, retryDelay: time.Hour}
+	_, err = nt.Write([]byte("one\n"))
+	assert.Error(t, err)
+	assert.NotEqual(t, errNetTargetDown, err)
+	_, err = nt.Write([]byte("two\n"))
+	assert.Equal(t, errNetTargetDown, err)
+}
+
+func TestNetworkTargetWriteTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	client := &MockClient{Counts: make(map[string]int64)}
+	u := &Unilog{target: "tcp://" + l.Addr().String(), Metrics: client, CircuitFailures: 10, CircuitCooldown: time.Hour}
+	require.NoError(t, u.reopen())
+	defer u.file.Close()
+	// The peer never reads
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	nt := u.file.(*netTarget)
+	nt.writeTimeout = 10 * time.Millisecond
+	line := strings.Repeat("x", 1<<16)
+	for i := 0; i < 1000 && nt.conn != nil; i++ {
+		u.logLine(line)
+	}
+	require.Nil(t, nt.conn, "writes never blocked")
+
+	// The first timeout trips the circuit breaker
+	assert.Equal(t, int64(1), client.Counts["[err_action:write_to_log][reason:timeout]unilog.errors_total"])
+	assert.True(t, u.circuitOpen())
+}
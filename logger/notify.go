@@ -0,0 +1,203 @@
+package logger
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"text/template"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// BreakContext describes a breakage (unilog failing to do something,
+// like writing a line to its target) for a Notifier.
+type BreakContext struct {
+	Hostname string
+	// The Unilog's Name and target
+	Name   string
+	Target string
+	// What unilog failed to do, like "write_to_log"
+	Action string
+	// The error's text, cut down to MaxErrorContext bytes
+	Error   string
+	Version string
+	// How long notifiers won't be told about further errors for,
+	// unless writes succeed in the meantime (see NotifyThrottle)
+	Throttle time.Duration
+
+	// The error itself
+	Err error
+	// Whether the breakage is a panic in the event loop. Notify is
+	// then called while the panic is being recovered (so the stack
+	// is the panic's), and unilog crashes right after it returns.
+	Panic bool
+}
+
+// Notifier is implemented by anything that tells people about
+// breakages. unilog notifies each of its Notifiers about the first
+// error after writes worked, and then at most once per
+// NotifyThrottle until they work again; panics are always notified.
+type Notifier interface {
+	Notify(ctx BreakContext)
+}
+
+// breakContext returns the BreakContext of the failure of action.
+func (u *Unilog) breakContext(action, errText string, e error) BreakContext {
+	hostname, _ := os.Hostname()
+	return BreakContext{
+		Hostname: hostname,
+		Name:     u.Name,
+		Target:   u.target,
+		Action:   action,
+		Error:    u.truncateContext(errText),
+		Version:  Version,
+		Throttle: u.notifyThrottle(),
+		Err:      e,
+	}
+}
+
+// notify notifies the Notifiers, along with the ones set up from
+// SentryDSN, MailFrom and MailTo, and WebhookURL, about a breakage.
+func (u *Unilog) notify(ctx BreakContext) {
+	if u.notifiers == nil {
+		u.notifiers = u.builtinNotifiers()
+	}
+	for _, n := range u.notifiers {
+		n.Notify(ctx)
+	}
+	for _, n := range u.Notifiers {
+		n.Notify(ctx)
+	}
+}
+
+// builtinNotifiers returns the notifiers that are configured by u's
+// fields (and so by flags).
+func (u *Unilog) builtinNotifiers() []Notifier {
+	notifiers := []Notifier{}
+	if u.SentryDSN != "" {
+		notifiers = append(notifiers, &SentryNotifier{
+			RateLimit:       u.SentryRateLimit,
+			MaxErrorContext: u.MaxErrorContext,
+			Stats:           u.stats(),
+		})
+	}
+	if u.MailFrom != "" && u.MailTo != "" {
+		notifiers = append(notifiers, EmailNotifier{From: u.MailFrom, To: u.MailTo})
+	}
+	if u.WebhookURL != "" {
+		notifiers = append(notifiers, WebhookNotifier{URL: u.WebhookURL, Debug: u.Debug})
+	}
+	return notifiers
+}
+
+// SentryNotifier reports breakages to Sentry (which must have been
+// set up with sentry.Init), tagged with the BreakContext's fields.
+//
+// No more than RateLimit errors are reported per minute, if it's set;
+// any more are dropped (and counted in unilog.sentry.dropped, if Stats
+// is set). Panics are always reported, and Notify waits (briefly) for
+// those to be sent.
+type SentryNotifier struct {
+	RateLimit int
+	// The most bytes of the exception's value to report, like
+	// Unilog's MaxErrorContext
+	MaxErrorContext int
+	Stats           Client
+
+	limit *tokenBucket
+}
+
+func (s *SentryNotifier) Notify(ctx BreakContext) {
+	if !ctx.Panic && s.RateLimit > 0 {
+		if s.limit == nil {
+			s.limit = newTokenBucket(s.RateLimit, time.Minute)
+		}
+		if !s.limit.take(time.Now()) {
+			if s.Stats != nil {
+				IndependentCount(s.Stats, "unilog.sentry.dropped", 1, []string{"err_action:" + ctx.Action}, 1)
+			}
+			return
+		}
+	}
+	sentry.WithScope(func(scope *sentry.Scope) {
+		s.setTags(scope, ctx)
+		if ctx.Panic {
+			// Called while recovering, so the stack trace
+			// includes where the panic happened
+			sentry.CurrentHub().Recover(ctx.Err)
+		} else {
+			sentry.CaptureException(ctx.Err)
+		}
+	})
+	if ctx.Panic {
+		sentry.Flush(sentryFlushTimeout)
+	}
+}
+
+func (s *SentryNotifier) setTags(scope *sentry.Scope, ctx BreakContext) {
+	scope.SetTags(map[string]string{
+		"Hostname": ctx.Hostname,
+		"Action":   ctx.Action,
+		"Name":     ctx.Name,
+		"Target":   ctx.Target,
+		"Error":    truncateContext(ctx.Err.Error(), s.MaxErrorContext),
+		"Version":  ctx.Version,
+	})
+	scope.AddEventProcessor(func(event *sentry.Event, hint *sentry.EventHint) *sentry.Event {
+		for i := range event.Exception {
+			event.Exception[i].Value = truncateContext(event.Exception[i].Value, s.MaxErrorContext)
+		}
+		return event
+	})
+}
+
+// sentryFlushTimeout is how long a SentryNotifier waits for a panic
+// to be reported.
+const sentryFlushTimeout = 5 * time.Second
+
+// EmailNotifier emails breakages from From to To with sendmail.
+type EmailNotifier struct {
+	From string
+	To   string
+}
+
+func (n EmailNotifier) Notify(ctx BreakContext) {
+	cmd := exec.Command("sendmail", "-t")
+	cmd.Stdin = n.message(ctx)
+	cmd.Run()
+}
+
+var emailTemplate = template.Must(template.New("email").Parse(`From: {{.From}}
+To: {{.To}}
+Subject: [unilog] {{.Name}} could not {{.Action}}
+
+Hi there,
+
+This is unilog reporting from {{.Hostname}}. I'm sad to report that
+{{.Name}} is having some troubles writing to its log. I got caught up
+trying to log a line to {{.Target}}.
+
+To avoid spamming you, I'm going to shut up for {{.Throttle}}. Please fix me.
+
+{{.Error}}
+--
+Sent from unilog {{.Version}}
+`))
+
+// message returns the email about ctx.
+func (n EmailNotifier) message(ctx BreakContext) *bytes.Buffer {
+	message := new(bytes.Buffer)
+	emailTemplate.Execute(message, map[string]string{
+		"Hostname": ctx.Hostname,
+		"From":     n.From,
+		"To":       n.To,
+		"Action":   ctx.Action,
+		"Name":     ctx.Name,
+		"Target":   ctx.Target,
+		"Error":    ctx.Error,
+		"Version":  ctx.Version,
+		"Throttle": formatThrottle(ctx.Throttle),
+	})
+	return message
+}
@@ -0,0 +1,70 @@
+package logger
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingNotifier struct {
+	notified []BreakContext
+}
+
+func (n *recordingNotifier) Notify(ctx BreakContext) {
+	n.notified = append(n.notified, ctx)
+}
+
+func TestNotifiers(t *testing.T) {
+	n := &recordingNotifier{}
+	u := &Unilog{Name: "app", target: "/var/log/app.log", Notifiers: []Notifier{n}}
+	u.handleError("write_to_log", errors.New("disk on fire"))
+	u.handleError("write_to_log", errors.New("disk still on fire"))
+	require.Len(t, n.notified, 1)
+	ctx := n.notified[0]
+	assert.Equal(t, "app", ctx.Name)
+	assert.Equal(t, "/var/log/app.log", ctx.Target)
+	assert.Equal(t, "write_to_log", ctx.Action)
+	assert.Equal(t, "disk on fire", ctx.Error)
+	assert.EqualError(t, ctx.Err, "disk on fire")
+	assert.Equal(t, Version, ctx.Version)
+	assert.Equal(t, DefaultNotifyThrottle, ctx.Throttle)
+	assert.False(t, ctx.Panic)
+
+	// Once writes work again, the next error is a new breakage
+	u.resetBreaker()
+	u.handleError("reopen_file", errors.New("no such file"))
+	require.Len(t, n.notified, 2)
+	assert.Equal(t, "reopen_file", n.notified[1].Action)
+
+	// Panics are always notified
+	func() {
+		defer func() {
+			u.reportPanic(recover())
+		}()
+		panic("oops")
+	}()
+	require.Len(t, n.notified, 3)
+	assert.True(t, n.notified[2].Panic)
+	assert.Equal(t, panicAction, n.notified[2].Action)
+	assert.Contains(t, n.notified[2].Error, "panic: oops")
+}
+
+func TestNotifyThrottle(t *testing.T) {
+	n := &recordingNotifier{}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	u := &Unilog{Notifiers: []Notifier{n}, NotifyThrottle: 10 * time.Minute, now: func() time.Time { return now }}
+	for i := 0; i < 30; i++ {
+		u.handleError("write_to_log", errors.New("disk on fire"))
+		now = now.Add(time.Minute)
+	}
+	// At 0, just after 10 and just after 20 minutes
+	assert.Len(t, n.notified, 3)
+
+	assert.Contains(t, EmailNotifier{}.message(u.breakContext("write_to_log", "disk on fire", nil)).String(), "shut up for 10m. Please")
+	assert.Contains(t, EmailNotifier{}.message((&Unilog{}).breakContext("write_to_log", "disk on fire", nil)).String(), "shut up for 1h. Please")
+	assert.Equal(t, "1h30m", formatThrottle(90*time.Minute))
+	assert.Equal(t, "45s", formatThrottle(45*time.Second))
+}
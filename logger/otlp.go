@@ -0,0 +1,377 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/stripe/unilog/clevels"
+	"github.com/stripe/unilog/json"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	otellog "go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// Error actions for OTLP targets, which are reported instead of
+// reopen_file and write_to_log so that export failures can be told
+// apart.
+const (
+	otlpOpenAction  = "open_otlp"
+	otlpWriteAction = "export_otlp"
+)
+
+// DefaultOTLPBatchSize is the default for OTLPBatchSize, and
+// DefaultOTLPFlushInterval the IdleFlush used with an OTLP endpoint
+// if none is set.
+const (
+	DefaultOTLPBatchSize     = 512
+	DefaultOTLPFlushInterval = time.Second
+)
+
+// otlpTimeout bounds each export request, so that a hung collector
+// can't hold up the batches behind it for longer than a slow disk
+// would.
+const otlpTimeout = 10 * time.Second
+
+// otlpQueueSize is how many full batches can wait to be exported
+// before adding another blocks.
+const otlpQueueSize = 4
+
+// otlpLogsPath is where OTLP/HTTP collectors receive logs.
+const otlpLogsPath = "/v1/logs"
+
+// otlpURL returns the URL to export logs to at endpoint: endpoint
+// itself if it has a path, or else its otlpLogsPath.
+func otlpURL(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid OTLP endpoint %q: %v", endpoint, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid OTLP endpoint %q (expected http://host:port or https://host:port)", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = otlpLogsPath
+	}
+	return u.String(), nil
+}
+
+// otlpSeverities maps criticality levels to OpenTelemetry severities
+// (and their short names), like criticalitySeverities does for
+// syslog.
+var otlpSeverities = map[clevels.AusterityLevel]struct {
+	number otellog.Severity
+	text   string
+}{
+	clevels.Sheddable:     {otellog.SeverityDebug, "DEBUG"},
+	clevels.SheddablePlus: {otellog.SeverityInfo, "INFO"},
+	clevels.Critical:      {otellog.SeverityWarn, "WARN"},
+	clevels.CriticalPlus:  {otellog.SeverityError, "ERROR"},
+}
+
+// otlpSink is a target that exports lines to an OpenTelemetry
+// collector as OTLP log records, with the OpenTelemetry SDK's
+// OTLP/HTTP exporter. logJSON adds each JSON line's record with
+// export; lines written as text (in text mode, or heartbeats) become
+// records whose body is the line.
+//
+// Records are emitted through an SDK LoggerProvider, so that they
+// carry its resource and scope, with the sink as its only processor,
+// which batches them up. They are sent in batches of up to batchSize,
+// when a batch fills up, when the target is flushed (after IdleFlush)
+// and when it is closed. Batches are exported in the background, so
+// that a slow collector doesn't hold up reading input; once
+// otlpQueueSize batches are waiting, adding another blocks, as a slow
+// disk would. The results come back on results, for the event loop to
+// pass to finish: if a batch couldn't be exported, its lines are
+// passed to dropped, and the error to failed, to be handled like any
+// other write error. The exporter doesn't retry, since failed batches
+// are reported that way. Close waits for all the batches to be
+// exported.
+type otlpSink struct {
+	provider  *sdklog.LoggerProvider
+	logger    otellog.Logger
+	exporter  sdklog.Exporter
+	batchSize int
+	// otlpRecordFields, and the configured json.TimestampField
+	recordFields map[string]bool
+	stats        Client
+	dropped      func(line, reason string)
+	failed       func(err error)
+
+	records []sdklog.Record
+	// the lines records were made from, for dropped
+	lines []string
+
+	// nil once the sink is closed
+	batches chan otlpBatch
+	results chan otlpResult
+}
+
+type otlpBatch struct {
+	records []sdklog.Record
+	lines   []string
+}
+
+type otlpResult struct {
+	otlpBatch
+	err error
+}
+
+// otlpValue converts a decoded JSON value to an OTLP value. Integral
+// numbers become ints, and nulls empty values.
+func otlpValue(v interface{}) attribute.Value {
+	switch v := v.(type) {
+	case string:
+		return attribute.StringValue(v)
+	case bool:
+		return attribute.BoolValue(v)
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return attribute.Int64Value(int64(v))
+		}
+		return attribute.Float64Value(v)
+	case []interface{}:
+		values := make([]attribute.Value, len(v))
+		for i, e := range v {
+			values[i] = otlpValue(e)
+		}
+		return attribute.SliceValue(values...)
+	case map[string]interface{}:
+		return attribute.MapValue(otlpAttributes(v, nil)...)
+	case nil:
+		return attribute.Value{}
+	}
+	return attribute.StringValue(fmt.Sprint(v))
+}
+
+// otlpAttributes converts fields, except for the skipped ones, to
+// OTLP attributes, sorted by key.
+func otlpAttributes(fields map[string]interface{}, skip map[string]bool) []attribute.KeyValue {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		if !skip[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	attrs := make([]attribute.KeyValue, len(keys))
+	for i, k := range keys {
+		attrs[i] = attribute.KeyValue{Key: attribute.Key(k), Value: otlpValue(fields[k])}
+	}
+	return attrs
+}
+
+// otlpRecordFields are the fields of JSON lines that records have
+// their own place for, rather than being attributes.
+var otlpRecordFields = map[string]bool{
+	"message":   true,
+	"timestamp": true,
+	"ts":        true,
+}
+
+func (u *Unilog) newOTLPSink() (*otlpSink, error) {
+	target, err := otlpURL(u.OTLPEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	batchSize := u.OTLPBatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultOTLPBatchSize
+	}
+	exporter, err := otlploghttp.New(context.Background(),
+		otlploghttp.WithEndpointURL(target),
+		otlploghttp.WithTimeout(otlpTimeout),
+		otlploghttp.WithRetry(otlploghttp.RetryConfig{Enabled: false}))
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q: %v", u.OTLPEndpoint, err)
+	}
+	res := resource.Empty()
+	if u.Name != "" {
+		res = resource.NewSchemaless(attribute.String("service.name", u.Name))
+	}
+	recordFields := map[string]bool{json.TimestampField(): true}
+	for k := range otlpRecordFields {
+		recordFields[k] = true
+	}
+	o := &otlpSink{
+		exporter:     exporter,
+		batchSize:    batchSize,
+		recordFields: recordFields,
+		stats:        u.stats(),
+		dropped:      u.drop,
+		failed:       func(err error) { u.handleError(otlpWriteAction, err) },
+		batches:      make(chan otlpBatch, otlpQueueSize),
+		results:      make(chan otlpResult),
+	}
+	o.provider = sdklog.NewLoggerProvider(
+		sdklog.WithResource(res),
+		sdklog.WithProcessor(o),
+		// Lines can have any number of fields
+		sdklog.WithAttributeCountLimit(-1),
+	)
+	o.logger = o.provider.Logger("unilog", otellog.WithInstrumentationVersion(Version))
+	go o.run(o.batches, o.results)
+	return o, nil
+}
+
+// otlpResults returns the channel the OTLP target, if that's what
+// the target is, reports its exports' results on.
+func (u *Unilog) otlpResults() <-chan otlpResult {
+	if o, ok := u.file.(*otlpSink); ok {
+		return o.results
+	}
+	return nil
+}
+
+// closeOTLP exports any batched records, and waits for them to be
+// exported, if the target is an OTLP collector.
+func (u *Unilog) closeOTLP() {
+	if o, ok := u.file.(*otlpSink); ok {
+		o.Close()
+		u.file = nil
+	}
+}
+
+// export adds a JSON line's record to the batch. The message is the
+// body, the event timestamp the record's time, the criticality its
+// severity, and all other fields become attributes.
+func (o *otlpSink) export(line json.LogLine, raw string) error {
+	severity := otlpSeverities[clevels.JSONCriticality(line)]
+	var record otellog.Record
+	record.SetTimestamp(line.Timestamp())
+	record.SetObservedTimestamp(time.Now())
+	record.SetSeverity(severity.number)
+	record.SetSeverityText(severity.text)
+	record.AddAttributes(otlpAttributes(line, o.recordFields)...)
+	if message, ok := line["message"]; ok {
+		record.SetBody(otlpValue(message))
+	}
+	return o.add(record, raw)
+}
+
+// Write adds a text line's record to the batch.
+func (o *otlpSink) Write(p []byte) (int, error) {
+	line := strings.TrimRight(string(p), "\n")
+	now := time.Now()
+	severity := otlpSeverities[clevels.Criticality(line)]
+	var record otellog.Record
+	record.SetTimestamp(now)
+	record.SetObservedTimestamp(now)
+	record.SetSeverity(severity.number)
+	record.SetSeverityText(severity.text)
+	record.SetBody(attribute.StringValue(line))
+	if err := o.add(record, line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// add emits record, which comes back to OnEmit with the provider's
+// resource and scope.
+func (o *otlpSink) add(record otellog.Record, line string) error {
+	o.logger.Emit(context.Background(), record)
+	o.lines = append(o.lines, line)
+	if len(o.records) < o.batchSize {
+		return nil
+	}
+	return o.Flush()
+}
+
+// OnEmit adds an emitted record to the batch. It's part of
+// sdklog.Processor, along with Enabled, ForceFlush and Shutdown.
+func (o *otlpSink) OnEmit(_ context.Context, record *sdklog.Record) error {
+	o.records = append(o.records, record.Clone())
+	return nil
+}
+
+func (o *otlpSink) Enabled(context.Context, sdklog.EnabledParameters) bool {
+	return true
+}
+
+func (o *otlpSink) ForceFlush(context.Context) error {
+	return o.Flush()
+}
+
+func (o *otlpSink) Shutdown(context.Context) error {
+	return nil
+}
+
+// Flush queues the batched records to be exported, blocking while
+// the queue is full. Export errors aren't returned, but passed to
+// failed once the batch has been tried.
+func (o *otlpSink) Flush() error {
+	if len(o.records) == 0 || o.batches == nil {
+		return nil
+	}
+	b := otlpBatch{o.records, o.lines}
+	o.records, o.lines = nil, nil
+	for {
+		select {
+		case o.batches <- b:
+			return nil
+		case r := <-o.results:
+			// Keep the exporter going while we wait
+			o.finish(r)
+		}
+	}
+}
+
+// run exports the batches queued on batches, until it's closed.
+func (o *otlpSink) run(batches <-chan otlpBatch, results chan<- otlpResult) {
+	defer close(results)
+	for b := range batches {
+		results <- otlpResult{b, o.send(b.records)}
+	}
+}
+
+// finish reports the result of exporting a batch.
+func (o *otlpSink) finish(r otlpResult) {
+	if o.stats != nil {
+		status := "ok"
+		if r.err != nil {
+			status = "error"
+		}
+		IndependentCount(o.stats, "unilog.otlp.records", int64(len(r.records)), []string{"status:" + status}, 1)
+	}
+	if r.err == nil {
+		return
+	}
+	if o.dropped != nil {
+		for _, line := range r.lines {
+			o.dropped(line, DropWriteError)
+		}
+	}
+	if o.failed != nil {
+		o.failed(r.err)
+	}
+}
+
+func (o *otlpSink) send(records []sdklog.Record) error {
+	if err := o.exporter.Export(context.Background(), records); err != nil {
+		return fmt.Errorf("exporting %d records: %v", len(records), err)
+	}
+	return nil
+}
+
+// Close exports any batched records, and waits for all the queued
+// batches to be exported.
+func (o *otlpSink) Close() error {
+	if o.batches == nil {
+		return nil
+	}
+	o.Flush()
+	close(o.batches)
+	o.batches = nil
+	for r := range o.results {
+		o.finish(r)
+	}
+	o.provider.Shutdown(context.Background())
+	return o.exporter.Shutdown(context.Background())
+}
@@ -0,0 +1,202 @@
+package logger
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpCollector records the export requests it receives, and fails
+// them with status if it's set.
+type otlpCollector struct {
+	mu       sync.Mutex
+	paths    []string
+	requests []*collogspb.ExportLogsServiceRequest
+	status   int
+}
+
+func (c *otlpCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paths = append(c.paths, r.URL.Path)
+	if c.status != 0 {
+		w.WriteHeader(c.status)
+		return
+	}
+	req := &collogspb.ExportLogsServiceRequest{}
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := proto.Unmarshal(body, req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.requests = append(c.requests, req)
+}
+
+func (c *otlpCollector) records() []*logspb.LogRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var records []*logspb.LogRecord
+	for _, req := range c.requests {
+		records = append(records, req.ResourceLogs[0].ScopeLogs[0].LogRecords...)
+	}
+	return records
+}
+
+func TestOTLPExport(t *testing.T) {
+	collector := &otlpCollector{}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+
+	u := &Unilog{JSON: true, Name: "api", OTLPEndpoint: srv.URL, OTLPBatchSize: 2}
+	require.NoError(t, u.reopen())
+
+	u.logJSON(`{"timestamp":"2016-11-10T19:18:05Z","message":"one","clevel":"critical","user":"alice","count":3,"tags":["a"]}`)
+	assert.Empty(t, collector.records(), "the batch isn't full yet")
+	u.logJSON(`{"timestamp":"2016-11-10T19:18:06Z","message":"two"}`)
+	u.logJSON(`{"message":"three"}`)
+	// Full batches are exported in the background
+	assert.Eventually(t, func() bool { return len(collector.records()) == 2 }, 5*time.Second, time.Millisecond)
+	u.flush()
+	require.NoError(t, u.file.Close())
+
+	records := collector.records()
+	require.Len(t, records, 3)
+	assert.Equal(t, []string{"/v1/logs", "/v1/logs"}, collector.paths)
+	resource := collector.requests[0].ResourceLogs[0].Resource
+	require.Len(t, resource.Attributes, 1)
+	assert.Equal(t, "service.name", resource.Attributes[0].Key)
+	assert.Equal(t, "api", resource.Attributes[0].Value.GetStringValue())
+	scope := collector.requests[0].ResourceLogs[0].ScopeLogs[0].Scope
+	assert.Equal(t, "unilog", scope.Name)
+	assert.Equal(t, Version, scope.Version)
+
+	one := records[0]
+	assert.Equal(t, uint64(time.Date(2016, 11, 10, 19, 18, 5, 0, time.UTC).UnixNano()), one.TimeUnixNano)
+	assert.Equal(t, "one", one.Body.GetStringValue())
+	assert.Equal(t, logspb.SeverityNumber_SEVERITY_NUMBER_WARN, one.SeverityNumber)
+	assert.Equal(t, "WARN", one.SeverityText)
+	require.Len(t, one.Attributes, 4)
+	assert.Equal(t, "clevel", one.Attributes[0].Key)
+	assert.Equal(t, "count", one.Attributes[1].Key)
+	assert.Equal(t, int64(3), one.Attributes[1].Value.GetIntValue())
+	assert.Equal(t, "tags", one.Attributes[2].Key)
+	assert.Equal(t, "a", one.Attributes[2].Value.GetArrayValue().Values[0].GetStringValue())
+	assert.Equal(t, "user", one.Attributes[3].Key)
+	assert.Equal(t, "alice", one.Attributes[3].Value.GetStringValue())
+
+	assert.Equal(t, "INFO", records[1].SeverityText)
+	assert.Empty(t, records[1].Attributes)
+	assert.Equal(t, "three", records[2].Body.GetStringValue())
+}
+
+func TestOTLPExportText(t *testing.T) {
+	collector := &otlpCollector{}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+
+	u := &Unilog{OTLPEndpoint: srv.URL + "/custom/logs"}
+	require.NoError(t, u.reopen())
+	u.logLine("hi there")
+	require.NoError(t, u.file.Close())
+
+	records := collector.records()
+	require.Len(t, records, 1)
+	assert.Equal(t, []string{"/custom/logs"}, collector.paths)
+	assert.Equal(t, "hi there", records[0].Body.GetStringValue())
+	assert.Empty(t, collector.requests[0].ResourceLogs[0].Resource.GetAttributes())
+	assert.Equal(t, records[0].TimeUnixNano, records[0].ObservedTimeUnixNano)
+}
+
+func TestOTLPExportFailure(t *testing.T) {
+	collector := &otlpCollector{status: http.StatusServiceUnavailable}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+
+	client := &MockClient{Counts: make(map[string]int64), Histograms: make(map[string]float64), Timings: make(map[string]time.Duration)}
+	var dropped []string
+	u := &Unilog{
+		JSON:          true,
+		OTLPEndpoint:  srv.URL,
+		OTLPBatchSize: 2,
+		Metrics:       client,
+		OnDrop:        func(line string) { dropped = append(dropped, line) },
+	}
+	require.NoError(t, u.reopen())
+
+	u.logJSON(`{"message":"one"}`)
+	u.logJSON(`{"message":"two"}`)
+	// The result comes back to the event loop
+	assert.Empty(t, dropped)
+	assert.True(t, u.tick())
+	assert.Equal(t, []string{`{"message":"one"}`, `{"message":"two"}`}, dropped)
+	assert.Equal(t, int64(2), client.Counts["[reason:write_error]unilog.lines.dropped"])
+	assert.Equal(t, int64(2), client.Counts["[status:error]unilog.otlp.records"])
+	assert.Equal(t, int64(1), client.Counts["[err_action:export_otlp]unilog.errors_total"])
+
+	// Once the collector is back, later lines get through
+	collector.mu.Lock()
+	collector.status = 0
+	collector.mu.Unlock()
+	u.logJSON(`{"message":"three"}`)
+	u.closeOTLP()
+	require.Len(t, collector.records(), 1)
+	assert.Equal(t, int64(1), client.Counts["[status:ok]unilog.otlp.records"])
+}
+
+func TestOTLPURL(t *testing.T) {
+	for endpoint, expected := range map[string]string{
+		"http://localhost:4318":          "http://localhost:4318/v1/logs",
+		"https://collector:4318/":        "https://collector:4318/v1/logs",
+		"http://collector:4318/otlp/log": "http://collector:4318/otlp/log",
+	} {
+		actual, err := otlpURL(endpoint)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, actual)
+	}
+	for _, endpoint := range []string{"localhost:4318", "udp://localhost:4318", "http://", "http://%zz"} {
+		_, err := otlpURL(endpoint)
+		assert.Error(t, err, endpoint)
+	}
+}
+
+func TestOTLPExportSlowCollector(t *testing.T) {
+	release := make(chan struct{})
+	collector := &otlpCollector{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		collector.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	u := &Unilog{OTLPEndpoint: srv.URL, OTLPBatchSize: 1}
+	require.NoError(t, u.reopen())
+
+	// Lines aren't held up while the collector is slow, until the
+	// queue fills up
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < otlpQueueSize; i++ {
+			u.logLine("hi")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("logging blocked on the collector")
+	}
+
+	close(release)
+	u.closeOTLP()
+	assert.Len(t, collector.records(), otlpQueueSize)
+	assert.Nil(t, u.file)
+}
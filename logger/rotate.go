@@ -0,0 +1,295 @@
+package logger
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// backupIndex returns N if path is a rotated backup of target, named
+// target.N or target.N.gz.
+func backupIndex(target, path string) (int, bool) {
+	suffix := strings.TrimPrefix(path, target+".")
+	if suffix == path {
+		return 0, false
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(suffix, ".gz"))
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// pruneBackups deletes rotated backups of the target beyond
+// MaxBackups or older than MaxAge. Each deletion is reported in the
+// unilog.rotate.pruned metric.
+func (u *Unilog) pruneBackups() {
+	if u.MaxBackups <= 0 && u.MaxAge <= 0 {
+		return
+	}
+	// The target's name may contain glob metacharacters
+	paths, err := filepath.Glob(escapeGlob(u.target) + ".*")
+	if err != nil {
+		return
+	}
+	for _, path := range paths {
+		n, ok := backupIndex(u.target, path)
+		if !ok {
+			continue
+		}
+
+		var reason string
+		if u.MaxBackups > 0 && n > u.MaxBackups {
+			reason = "count"
+		} else if u.MaxAge > 0 {
+			fi, err := os.Stat(path)
+			if err == nil && time.Since(fi.ModTime()) > u.MaxAge {
+				reason = "age"
+			}
+		}
+		if reason == "" {
+			continue
+		}
+
+		if err := os.Remove(path); err != nil {
+			u.handleError("prune_backup", err)
+			continue
+		}
+		if u.Debug {
+			fmt.Fprintf(os.Stderr, "Pruned rotated log file %s (%s)\n", path, reason)
+		}
+		if stats := u.stats(); stats != nil {
+			IndependentCount(stats, "unilog.rotate.pruned", 1, []string{"reason:" + reason}, 1)
+		}
+	}
+}
+
+// escapeGlob escapes the characters in path that filepath.Match
+// treats specially.
+func escapeGlob(path string) string {
+	var b strings.Builder
+	for _, r := range path {
+		switch r {
+		case '*', '?', '[', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// rotated is called once a file has been rotated out to path. It
+// prunes old backups, then compresses the file (if CompressBackups
+// is set) and runs the PostRotateCmd (if any), both in the
+// background.
+func (u *Unilog) rotated(path string) {
+	u.pruneBackups()
+	if u.CompressBackups {
+		u.compressor.stats = u.stats()
+		u.compressor.compress(path, u.runPostRotateCmd)
+		return
+	}
+	u.runPostRotateCmd(path)
+}
+
+// runPostRotateCmd runs PostRotateCmd for the rotated file at path
+// in the background. The command is run with sh -c, with the path as
+// its first argument ($1) and in the UNILOG_ROTATED_FILE environment
+// variable. Its exit status is reported in the
+// unilog.rotate.post_cmd metric, and (with Debug) its output is
+// written to stderr; a failing command never affects logging.
+func (u *Unilog) runPostRotateCmd(path string) {
+	if u.PostRotateCmd == "" {
+		return
+	}
+	u.hooks.Add(1)
+	go func() {
+		defer u.hooks.Done()
+		cmd := exec.Command("sh", "-c", u.PostRotateCmd, "unilog-post-rotate", path)
+		cmd.Env = append(os.Environ(), "UNILOG_ROTATED_FILE="+path)
+		out, err := cmd.CombinedOutput()
+
+		exitCode := 0
+		if err != nil {
+			exitCode = -1
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				exitCode = exitErr.ExitCode()
+			}
+		}
+		if u.Debug {
+			fmt.Fprintf(os.Stderr, "Post-rotate command for %s exited with %d: %s\n", path, exitCode, out)
+		}
+		if stats := u.stats(); stats != nil {
+			IndependentCount(stats, "unilog.rotate.post_cmd", 1, []string{"exit_code:" + strconv.Itoa(exitCode)}, 1)
+		}
+	}()
+}
+
+// sizedFile is a target file that keeps track of its size, so that
+// it can be rotated once it reaches MaxFileBytes.
+//
+// If buf is set, writes go through it (and size counts what was
+// buffered, too); it's flushed by Flush, Sync and Close. Since a
+// bufio.Writer stops accepting writes after one fails, the buffer is
+// discarded when writing it out fails, so that later writes get to
+// try again. Each write is a line, and the lines discarded with the
+// buffer are counted until takeLost.
+type sizedFile struct {
+	f    *os.File
+	buf  *bufio.Writer
+	size int64
+	// the lines in buf, and those discarded with it
+	buffered int
+	lost     int
+}
+
+// openSizedFile wraps f, starting from its current size.
+func openSizedFile(f *os.File) (*sizedFile, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &sizedFile{f: f, size: fi.Size()}, nil
+}
+
+func (s *sizedFile) Write(p []byte) (int, error) {
+	if s.buf == nil {
+		n, err := s.f.Write(p)
+		s.size += int64(n)
+		return n, err
+	}
+	before := s.buf.Buffered()
+	n, err := s.buf.Write(p)
+	s.size += int64(n)
+	if err != nil {
+		// p itself is the caller's to count
+		s.discard()
+		return n, err
+	}
+	if s.buf.Buffered() < before+len(p) {
+		// The earlier lines were written out to make room
+		s.buffered = 0
+	}
+	if s.buf.Buffered() > 0 {
+		s.buffered++
+	}
+	return n, err
+}
+
+// Flush writes out the buffer, if any.
+func (s *sizedFile) Flush() error {
+	if s.buf == nil {
+		return nil
+	}
+	err := s.buf.Flush()
+	if err != nil {
+		s.discard()
+	}
+	s.buffered = 0
+	return err
+}
+
+func (s *sizedFile) discard() {
+	s.buf.Reset(s.f)
+	s.lost += s.buffered
+	s.buffered = 0
+}
+
+// takeLost returns the number of lines discarded with the buffer since
+// the last call.
+func (s *sizedFile) takeLost() int {
+	n := s.lost
+	s.lost = 0
+	return n
+}
+
+func (s *sizedFile) Sync() error {
+	if err := s.Flush(); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+func (s *sizedFile) Close() error {
+	err := s.Flush()
+	if e := s.f.Close(); e != nil {
+		return e
+	}
+	return err
+}
+
+// rotateIfFull rotates the target out if it has reached MaxFileBytes.
+// The size is that of the file, rather than what this unilog wrote
+// to it, so it carries over reopening the same file (on SIGHUP), and
+// starts over when the file was rotated away by someone else.
+//
+// Backups that are still being compressed can't be shifted from under
+// the compressor, and waiting for it would block the event loop, so
+// while it's busy the target is left to grow past MaxFileBytes, and
+// rotated after the first write once it's done.
+func (u *Unilog) rotateIfFull() {
+	f, ok := u.file.(*sizedFile)
+	if !ok || u.MaxFileBytes <= 0 || f.size < u.MaxFileBytes || u.compressor.busy() {
+		return
+	}
+	if err := u.rotate(); err != nil {
+		u.handleError("rotate", err)
+	}
+}
+
+// rotate moves the target to target.1, after shifting existing
+// backups up by one (target.1 to target.2, and so on), and reopens
+// it. Backups beyond MaxBackups are then pruned by rotated.
+func (u *Unilog) rotate() error {
+	if err := shiftBackups(u.target); err != nil {
+		return err
+	}
+	rotated := u.target + ".1"
+	if err := os.Rename(u.target, rotated); err != nil {
+		return err
+	}
+	if stats := u.stats(); stats != nil {
+		IndependentCount(stats, "unilog.rotate", 1, []string{"reason:size"}, 1)
+	}
+	if err := u.reopen(); err != nil {
+		u.handleError("reopen_file", err)
+	}
+	u.rotated(rotated)
+	return nil
+}
+
+// shiftBackups renames each rotated backup of target, target.N or
+// target.N.gz, to target.N+1 (or target.N+1.gz), highest first.
+func shiftBackups(target string) error {
+	paths, err := filepath.Glob(escapeGlob(target) + ".*")
+	if err != nil {
+		return err
+	}
+	type backup struct {
+		path string
+		n    int
+	}
+	var backups []backup
+	for _, path := range paths {
+		if n, ok := backupIndex(target, path); ok {
+			backups = append(backups, backup{path, n})
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].n > backups[j].n })
+	for _, b := range backups {
+		shifted := target + "." + strconv.Itoa(b.n+1)
+		if strings.HasSuffix(b.path, ".gz") {
+			shifted += ".gz"
+		}
+		if err := os.Rename(b.path, shifted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
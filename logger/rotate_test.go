@@ -0,0 +1,170 @@
+package logger
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostRotateCmd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	rotated := filepath.Join(dir, "log.1")
+	require.NoError(t, ioutil.WriteFile(rotated, []byte("hi\n"), 0644))
+	out := filepath.Join(dir, "out")
+
+	u := &Unilog{PostRotateCmd: `echo "$1 $UNILOG_ROTATED_FILE" > ` + out}
+	u.rotated(rotated)
+	u.hooks.Wait()
+
+	b, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, rotated+" "+rotated+"\n", string(b))
+}
+
+func TestPostRotateCmdAfterCompression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	rotated := filepath.Join(dir, "log.1")
+	require.NoError(t, ioutil.WriteFile(rotated, []byte("hi\n"), 0644))
+	out := filepath.Join(dir, "out")
+
+	u := &Unilog{CompressBackups: true, PostRotateCmd: `ls "$1" > ` + out}
+	u.rotated(rotated)
+	u.compressor.wait()
+	u.hooks.Wait()
+
+	b, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, rotated+".gz\n", string(b))
+}
+
+func TestPostRotateCmdFailure(t *testing.T) {
+	u := &Unilog{PostRotateCmd: "exit 3"}
+	// Should neither block nor panic
+	u.rotated("/nonexistent")
+	u.hooks.Wait()
+}
+
+func TestPruneBackupsByCount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "log")
+
+	for _, name := range []string{"log", "log.1", "log.2.gz", "log.3", "log.4.gz", "log.4.gz.tmp", "log.old"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), nil, 0644))
+	}
+
+	u := &Unilog{MaxBackups: 2, target: target}
+	u.pruneBackups()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	for i := range files {
+		files[i] = filepath.Base(files[i])
+	}
+	assert.ElementsMatch(t, []string{"log", "log.1", "log.2.gz", "log.4.gz.tmp", "log.old"}, files)
+}
+
+func TestPruneBackupsByAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "log")
+
+	old := time.Now().Add(-2 * time.Hour)
+	for _, name := range []string{"log", "log.1", "log.2.gz", "log.3"} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(path, nil, 0644))
+		if name != "log.1" {
+			require.NoError(t, os.Chtimes(path, old, old))
+		}
+	}
+
+	u := &Unilog{MaxAge: time.Hour, target: target}
+	u.rotated(filepath.Join(dir, "log.1"))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	for i := range files {
+		files[i] = filepath.Base(files[i])
+	}
+	// The target itself is never pruned
+	assert.ElementsMatch(t, []string{"log", "log.1"}, files)
+}
+
+func listDir(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	for i := range files {
+		files[i] = filepath.Base(files[i])
+	}
+	return files
+}
+
+func TestShiftBackups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "log")
+
+	for _, name := range []string{"log", "log.1", "log.2.gz", "log.3", "log.old"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644))
+	}
+	require.NoError(t, shiftBackups(target))
+
+	assert.ElementsMatch(t, []string{"log", "log.2", "log.3.gz", "log.4", "log.old"}, listDir(t, dir))
+	assert.Equal(t, "log.1", readFile(t, filepath.Join(dir, "log.2")))
+	assert.Equal(t, "log.2.gz", readFile(t, filepath.Join(dir, "log.3.gz")))
+	assert.Equal(t, "log.3", readFile(t, filepath.Join(dir, "log.4")))
+}
+
+func TestSizeRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "log")
+
+	u := &Unilog{MaxFileBytes: 10, MaxBackups: 2, target: target}
+	require.NoError(t, u.reopen())
+	u.logLine("one")
+	u.logLine("two")
+	assert.ElementsMatch(t, []string{"log"}, listDir(t, dir))
+	u.logLine("three")
+	assert.ElementsMatch(t, []string{"log", "log.1"}, listDir(t, dir))
+	assert.Equal(t, "one\ntwo\nthree\n", readFile(t, target+".1"))
+	assert.Equal(t, "", readFile(t, target))
+
+	// Reopening the same file (as on SIGHUP) keeps counting from
+	// its size, rather than starting over
+	u.logLine("four")
+	require.NoError(t, u.reopen())
+	u.logLine("five")
+	assert.ElementsMatch(t, []string{"log", "log.1", "log.2"}, listDir(t, dir))
+	assert.Equal(t, "four\nfive\n", readFile(t, target+".1"))
+	assert.Equal(t, "one\ntwo\nthree\n", readFile(t, target+".2"))
+
+	// Backups beyond MaxBackups are pruned
+	u.logLine("six")
+	u.logLine("seven eight")
+	assert.ElementsMatch(t, []string{"log", "log.1", "log.2"}, listDir(t, dir))
+	assert.Equal(t, "six\nseven eight\n", readFile(t, target+".1"))
+	assert.Equal(t, "four\nfive\n", readFile(t, target+".2"))
+
+	// JSON lines are counted too
+	u.JSON = true
+	require.NoError(t, u.reopen())
+	u.logJSON(`{"message":"a long enough line"}`)
+	assert.Contains(t, readFile(t, target+".1"), "a long enough line")
+	assert.Equal(t, "", readFile(t, target))
+}
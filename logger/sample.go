@@ -0,0 +1,111 @@
+package logger
+
+import (
+	encjson "encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"syscall"
+
+	"github.com/stripe/unilog/json"
+)
+
+// sampleWriteAction is the error action of failed writes to the
+// sample target.
+const sampleWriteAction = "write_to_sample"
+
+// DefaultSampleRate is the default fraction of lines copied to the
+// SampleTarget.
+const DefaultSampleRate = 0.01
+
+// Stages at which lines are copied to the SampleTarget.
+const (
+	SampleStagePre  = "pre"
+	SampleStagePost = "post"
+)
+
+// reopenSample (re)opens the sample target, if one is configured.
+func (u *Unilog) reopenSample() error {
+	if u.SampleTarget == "" {
+		return nil
+	}
+	if u.sample != nil {
+		u.sample.Close()
+		u.sample = nil
+	}
+	f, err := os.OpenFile(u.SampleTarget, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	u.sample = f
+	return nil
+}
+
+// checkSampleStage validates SampleStage.
+func (u *Unilog) checkSampleStage() error {
+	switch u.SampleStage {
+	case "", SampleStagePre, SampleStagePost:
+		return nil
+	}
+	return fmt.Errorf("invalid sample stage %q (must be %q or %q)", u.SampleStage, SampleStagePre, SampleStagePost)
+}
+
+// sampling reports whether the line at the given stage should be
+// copied to the sample target. Each line is sampled independently,
+// with probability SampleRate.
+func (u *Unilog) sampling(stage string) bool {
+	if u.sample == nil {
+		return false
+	}
+	configured := u.SampleStage
+	if configured == "" {
+		configured = SampleStagePost
+	}
+	if stage != configured {
+		return false
+	}
+	return rand.Float64() < u.SampleRate
+}
+
+// sampleJSON copies a filtered JSON line to the sample target, as the
+// target would get it.
+func (u *Unilog) sampleJSON(line json.LogLine) {
+	b, err := encjson.Marshal(line)
+	if err != nil {
+		return
+	}
+	u.writeSample(string(b) + u.outputDelimiter())
+}
+
+// writeSample writes s to the sample target, and counts it in the
+// unilog.sample.bytes metric.
+//
+// Failing to write it never affects the main target: the error is
+// only counted in unilog.errors_total (and printed with Debug),
+// without going through handleError, so it doesn't set off
+// notifications or reset their throttle. If the sample target's
+// reader went away (EPIPE), it's closed until the next reopen.
+func (u *Unilog) writeSample(s string) {
+	n, err := io.WriteString(u.sample, s)
+	stats := u.stats()
+	if stats != nil {
+		IndependentCount(stats, "unilog.sample.bytes", int64(n), nil, .1)
+	}
+	if err == nil {
+		return
+	}
+	if u.Debug {
+		fmt.Fprintf(os.Stderr, "Could not %s: %s\n", sampleWriteAction, err)
+	}
+	tags := []string{"err_action:" + sampleWriteAction}
+	if errors.Is(err, syscall.EPIPE) {
+		tags = append(tags, "reason:epipe")
+		u.sample.Close()
+		u.sample = nil
+	}
+	if stats != nil {
+		IndependentCount(stats, "unilog.errors_total", 1, tags, 1)
+	}
+}
@@ -0,0 +1,99 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSampleTarget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sample")
+
+	client := &MockClient{Counts: make(map[string]int64)}
+	written := bytes.Buffer{}
+	u := &Unilog{
+		SampleTarget: path,
+		SampleRate:   .1,
+		Metrics:      client,
+		Filters:      []Filter{FilterFunc(strings.ToUpper)},
+		file:         mockFile{&written},
+	}
+	require.NoError(t, u.reopenSample())
+	for i := 0; i < 10000; i++ {
+		u.logLine(fmt.Sprintf("line %d", i))
+	}
+
+	// The main stream is unaffected
+	assert.Equal(t, 10000, strings.Count(written.String(), "\n"))
+	sampled, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(sampled), "\n"), "\n")
+	assert.InDelta(t, 1000, len(lines), 150)
+	// Sampled lines are copied after filtering, by default
+	for _, line := range lines {
+		assert.Regexp(t, `^LINE \d+$`, line)
+	}
+	assert.Equal(t, int64(len(sampled)), client.Counts["unilog.sample.bytes"])
+
+	// Before filtering, with -sample-stage=pre
+	require.NoError(t, os.Remove(path))
+	require.NoError(t, u.reopenSample())
+	u.SampleStage = SampleStagePre
+	u.SampleRate = 1
+	u.logLine("hi")
+	sampled, err = ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "hi\n", string(sampled))
+
+	u.SampleStage = "both"
+	assert.Error(t, u.checkSampleStage())
+}
+
+func TestSampleTargetJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sample")
+
+	u := &Unilog{JSON: true, SampleTarget: path, SampleRate: 1, AddHost: true}
+	require.NoError(t, u.reopenSample())
+	out := getLogJSON(u, `{"message":"hi","timestamp":"2016-11-10T19:18:05Z"}`)
+	sampled, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, out, string(sampled))
+
+	u.SampleRate = 0
+	getLogJSON(u, `{"message":"hi"}`)
+	sampled, err = ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, out, string(sampled))
+}
+
+func TestSampleTargetBrokenPipe(t *testing.T) {
+	var written bytes.Buffer
+	client := &MockClient{Counts: make(map[string]int64)}
+	u := &Unilog{SampleRate: 1, Metrics: client, PipeRetryDelay: time.Hour}
+	u.file = mockFile{&written}
+	u.sample = brokenPipe{}
+
+	// The sample target is closed, but the main target and the
+	// breaker are left alone
+	u.logLine("one")
+	u.logLine("two")
+	assert.Equal(t, "one\ntwo\n", written.String())
+	assert.Nil(t, u.sample)
+	assert.NotNil(t, u.file)
+	assert.False(t, u.b.broken)
+	assert.Equal(t, int64(1), client.Counts["[err_action:write_to_sample][reason:epipe]unilog.errors_total"])
+}
@@ -0,0 +1,163 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// The default signals that make unilog reopen its target, shut down
+// and exit right away, as set by ReopenSignals, TermSignals and
+// QuitSignals.
+const (
+	DefaultReopenSignals = "HUP,ALRM"
+	DefaultTermSignals   = "TERM,INT"
+	DefaultQuitSignals   = "QUIT"
+)
+
+// NoSignals, as ReopenSignals, TermSignals or QuitSignals, is an empty
+// set of signals (where an empty string means the default set). The
+// flags take an explicitly empty value to mean NoSignals too.
+const NoSignals = "none"
+
+// signalNames maps the names accepted in ReopenSignals, TermSignals
+// and QuitSignals to signals.
+var signalNames = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"ALRM": syscall.SIGALRM,
+	"TERM": syscall.SIGTERM,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// parseSignals parses a comma-separated list of signal names, with or
+// without the SIG prefix (like "HUP,SIGALRM"). An empty list, or
+// NoSignals, is no signals.
+func parseSignals(list string) ([]os.Signal, error) {
+	if strings.EqualFold(strings.TrimSpace(list), NoSignals) {
+		return nil, nil
+	}
+	var signals []os.Signal
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		sig, ok := signalNames[strings.TrimPrefix(name, "SIG")]
+		if !ok {
+			return nil, fmt.Errorf("unknown signal %q", name)
+		}
+		signals = append(signals, sig)
+	}
+	return signals, nil
+}
+
+// signalSets parses ReopenSignals, TermSignals and QuitSignals
+// (empty ones get their defaults), and checks that no signal is in
+// more than one of them.
+func (u *Unilog) signalSets() (reopen, term, quit []os.Signal, err error) {
+	sets := []struct {
+		flag, list, def string
+		signals         *[]os.Signal
+	}{
+		{"reopen-signals", u.ReopenSignals, DefaultReopenSignals, &reopen},
+		{"term-signals", u.TermSignals, DefaultTermSignals, &term},
+		{"quit-signals", u.QuitSignals, DefaultQuitSignals, &quit},
+	}
+	seen := make(map[os.Signal]string)
+	for _, set := range sets {
+		list := set.list
+		if list == "" {
+			list = set.def
+		}
+		signals, err := parseSignals(list)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid -%s: %v", set.flag, err)
+		}
+		for _, sig := range signals {
+			if other, ok := seen[sig]; ok {
+				return nil, nil, nil, fmt.Errorf("%v is in both -%s and -%s", sig, other, set.flag)
+			}
+			seen[sig] = set.flag
+		}
+		*set.signals = signals
+	}
+	return reopen, term, quit, nil
+}
+
+// notifySignals routes the signals in ReopenSignals, TermSignals and
+// QuitSignals to the channels tick listens to. Signals that are in
+// one of the default sets, but that have been left out of all of
+// them, are ignored, rather than getting their default handling
+// (which, for all of them, is to kill unilog without draining).
+func (u *Unilog) notifySignals() error {
+	reopenSignals, termSignals, quitSignals, err := u.signalSets()
+	if err != nil {
+		return err
+	}
+	if ignored := ignoredSignals(reopenSignals, termSignals, quitSignals); len(ignored) > 0 {
+		signal.Ignore(ignored...)
+	}
+	u.sigReopen = notify(reopenSignals)
+	u.sigTerm = notify(termSignals)
+	u.sigQuit = notify(quitSignals)
+	return nil
+}
+
+// ignoredSignals returns the signals in the default sets that aren't
+// in any of sets.
+func ignoredSignals(sets ...[]os.Signal) []os.Signal {
+	handled := make(map[os.Signal]bool)
+	for _, set := range sets {
+		for _, sig := range set {
+			handled[sig] = true
+		}
+	}
+	var ignored []os.Signal
+	for _, list := range []string{DefaultReopenSignals, DefaultTermSignals, DefaultQuitSignals} {
+		defaults, _ := parseSignals(list)
+		for _, sig := range defaults {
+			if !handled[sig] {
+				ignored = append(ignored, sig)
+			}
+		}
+	}
+	return ignored
+}
+
+// signalsFlag is the flag.Value for ReopenSignals, TermSignals and
+// QuitSignals, which takes an empty value to mean NoSignals.
+type signalsFlag struct {
+	list *string
+}
+
+func (f signalsFlag) String() string {
+	if f.list == nil {
+		return ""
+	}
+	return *f.list
+}
+
+func (f signalsFlag) Set(value string) error {
+	if strings.TrimSpace(value) == "" {
+		value = NoSignals
+	}
+	*f.list = value
+	return nil
+}
+
+// notify returns a channel that receives signals, or nil (which
+// never receives anything) if there are none: signal.Notify with no
+// signals would relay all of them.
+func notify(signals []os.Signal) <-chan os.Signal {
+	if len(signals) == 0 {
+		return nil
+	}
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, signals...)
+	return c
+}
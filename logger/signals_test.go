@@ -0,0 +1,101 @@
+package logger
+
+import (
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignalSets(t *testing.T) {
+	reopen, term, quit, err := (&Unilog{}).signalSets()
+	require.NoError(t, err)
+	assert.Equal(t, []os.Signal{syscall.SIGHUP, syscall.SIGALRM}, reopen)
+	assert.Equal(t, []os.Signal{syscall.SIGTERM, syscall.SIGINT}, term)
+	assert.Equal(t, []os.Signal{syscall.SIGQUIT}, quit)
+
+	reopen, term, quit, err = (&Unilog{ReopenSignals: "hup, SIGINT", TermSignals: "TERM", QuitSignals: "usr2"}).signalSets()
+	require.NoError(t, err)
+	assert.Equal(t, []os.Signal{syscall.SIGHUP, syscall.SIGINT}, reopen)
+	assert.Equal(t, []os.Signal{syscall.SIGTERM}, term)
+	assert.Equal(t, []os.Signal{syscall.SIGUSR2}, quit)
+
+	_, term, _, err = (&Unilog{TermSignals: NoSignals}).signalSets()
+	require.NoError(t, err)
+	assert.Empty(t, term)
+
+	_, _, _, err = (&Unilog{ReopenSignals: "HUP,INT"}).signalSets()
+	assert.EqualError(t, err, "interrupt is in both -reopen-signals and -term-signals")
+	_, _, _, err = (&Unilog{QuitSignals: "KILL"}).signalSets()
+	assert.EqualError(t, err, `invalid -quit-signals: unknown signal "KILL"`)
+}
+
+func TestCustomSignals(t *testing.T) {
+	defer signal.Reset(syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGHUP, syscall.SIGALRM, syscall.SIGQUIT)
+
+	f, err := ioutil.TempFile("", "unilog")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	f.Close()
+
+	u := &Unilog{
+		ReopenSignals: "USR1",
+		TermSignals:   "USR2",
+		QuitSignals:   "HUP",
+		target:        f.Name(),
+		shutdown:      make(chan struct{}, 1),
+	}
+	require.NoError(t, u.notifySignals())
+
+	// USR1 reopens the target
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGUSR1))
+	assert.True(t, u.tick())
+	require.NotNil(t, u.file)
+	defer u.file.Close()
+	assert.False(t, u.shouldShutdown)
+
+	// USR2 shuts down
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGUSR2))
+	assert.True(t, u.tick())
+	assert.True(t, u.shouldShutdown)
+
+	// and HUP, which would otherwise reopen, exits
+	code := -1
+	u.exit = func(c int) { code = c }
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	assert.False(t, u.tick())
+	assert.Equal(t, ExitQuit, code)
+}
+
+func TestIgnoredSignals(t *testing.T) {
+	reopen, term, quit, err := (&Unilog{}).signalSets()
+	require.NoError(t, err)
+	assert.Empty(t, ignoredSignals(reopen, term, quit))
+
+	reopen, term, quit, err = (&Unilog{TermSignals: "TERM", QuitSignals: NoSignals}).signalSets()
+	require.NoError(t, err)
+	assert.Equal(t, []os.Signal{syscall.SIGINT, syscall.SIGQUIT}, ignoredSignals(reopen, term, quit))
+
+	// so they don't kill unilog without draining
+	defer signal.Reset(syscall.SIGINT, syscall.SIGQUIT, syscall.SIGHUP, syscall.SIGALRM, syscall.SIGTERM)
+	u := &Unilog{TermSignals: "TERM", QuitSignals: NoSignals}
+	require.NoError(t, u.notifySignals())
+	assert.True(t, signal.Ignored(syscall.SIGINT))
+	assert.True(t, signal.Ignored(syscall.SIGQUIT))
+	assert.False(t, signal.Ignored(syscall.SIGTERM))
+	assert.Nil(t, u.sigQuit)
+}
+
+func TestSignalsFlag(t *testing.T) {
+	list := DefaultTermSignals
+	f := signalsFlag{&list}
+	assert.Equal(t, DefaultTermSignals, f.String())
+	require.NoError(t, f.Set("TERM"))
+	assert.Equal(t, "TERM", list)
+	require.NoError(t, f.Set(""))
+	assert.Equal(t, NoSignals, list)
+}
@@ -0,0 +1,195 @@
+package logger
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// DefaultSpillMaxBytes is the default limit on the size of the spill
+// file.
+const DefaultSpillMaxBytes = 1 << 30
+
+// spillBuffer extends the in-memory line buffer with a file on disk,
+// for producers that are too bursty for BufferLines (and the kernel
+// pipe buffer) to absorb. When the in-memory buffer is full, lines
+// are appended to the spill file instead of blocking the producer,
+// and fed back into the in-memory buffer as the writer catches up.
+//
+// Lines are always delivered in the order they were read: once a
+// line has been spilled, every line after it is spilled too, until
+// the spill file has been drained. The spill file holds at most max
+// bytes; once it is full, reading the input blocks (as it would
+// without a spill file) until there is room again.
+//
+// The spill file is deleted as soon as it's created, so it never
+// outlives unilog. The bytes spilled are reported in the
+// unilog.spill.bytes metric, and each time the spill file fills up
+// in unilog.spill.full.
+type spillBuffer struct {
+	max int64
+	// where to report metrics to, if anywhere
+	stats Client
+
+	w  *os.File
+	rf *os.File
+	r  *bufio.Reader
+	// the bytes in the spill file
+	size int64
+	// the number of lines in the spill file that haven't been
+	// delivered yet
+	pending int
+	// the next line to deliver, if loaded is set
+	next   string
+	loaded bool
+}
+
+func newSpillBuffer(dir string, max int64) (*spillBuffer, error) {
+	w, err := ioutil.TempFile(dir, "unilog-spill")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(w.Name())
+	r, err := os.Open(w.Name())
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	if max <= 0 {
+		max = DefaultSpillMaxBytes
+	}
+	return &spillBuffer{max: max, w: w, rf: r, r: bufio.NewReader(r)}, nil
+}
+
+// run moves lines from in to out, spilling them to disk while out is
+// full, until in is closed. It then delivers the remaining spilled
+// lines, and closes out.
+func (sb *spillBuffer) run(in <-chan string, out chan<- string) {
+	defer close(out)
+	defer sb.close()
+	for {
+		if sb.pending == 0 {
+			s, ok := <-in
+			if !ok {
+				return
+			}
+			select {
+			case out <- s:
+			default:
+				sb.spill(s, out)
+			}
+			continue
+		}
+
+		next, err := sb.peek()
+		if err != nil {
+			// Can't get the spilled lines back; start over
+			sb.lose()
+			continue
+		}
+		recv := in
+		if sb.size >= sb.max {
+			recv = nil
+		}
+		select {
+		case out <- next:
+			sb.pop()
+		case s, ok := <-recv:
+			if !ok {
+				sb.drain(out)
+				return
+			}
+			sb.spill(s, out)
+		}
+	}
+}
+
+// spill appends s to the spill file. If that fails, it delivers the
+// spilled lines and s to out instead, blocking until it has.
+func (sb *spillBuffer) spill(s string, out chan<- string) {
+	n, err := io.WriteString(sb.w, s+"\n")
+	if err != nil {
+		sb.reportError()
+		// Don't leave a partial line behind
+		sb.w.Truncate(sb.size)
+		sb.w.Seek(sb.size, io.SeekStart)
+		sb.drain(out)
+		out <- s
+		return
+	}
+	sb.size += int64(n)
+	sb.pending++
+	if sb.stats != nil {
+		IndependentCount(sb.stats, "unilog.spill.bytes", int64(n), nil, .1)
+		if sb.size >= sb.max {
+			IndependentCount(sb.stats, "unilog.spill.full", 1, nil, 1)
+		}
+	}
+}
+
+// peek returns the next spilled line to deliver.
+func (sb *spillBuffer) peek() (string, error) {
+	if !sb.loaded {
+		line, err := sb.r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		sb.next = strings.TrimSuffix(line, "\n")
+		sb.loaded = true
+	}
+	return sb.next, nil
+}
+
+// pop drops the line returned by peek, once it's been delivered.
+func (sb *spillBuffer) pop() {
+	sb.loaded = false
+	sb.pending--
+	if sb.pending == 0 {
+		sb.reset()
+	}
+}
+
+// drain delivers all the spilled lines to out.
+func (sb *spillBuffer) drain(out chan<- string) {
+	for sb.pending > 0 {
+		next, err := sb.peek()
+		if err != nil {
+			sb.lose()
+			return
+		}
+		out <- next
+		sb.pop()
+	}
+}
+
+// reset empties the spill file.
+func (sb *spillBuffer) reset() {
+	sb.w.Truncate(0)
+	sb.w.Seek(0, io.SeekStart)
+	sb.rf.Seek(0, io.SeekStart)
+	sb.r.Reset(sb.rf)
+	sb.size = 0
+	sb.pending = 0
+	sb.loaded = false
+}
+
+// lose empties the spill file after reading it back failed, counting
+// the lines still in it as dropped.
+func (sb *spillBuffer) lose() {
+	sb.reportError()
+	dropLines(sb.stats, sb.pending, DropSpillLost)
+	sb.reset()
+}
+
+func (sb *spillBuffer) reportError() {
+	if sb.stats != nil {
+		IndependentCount(sb.stats, "unilog.spill.errors", 1, nil, 1)
+	}
+}
+
+func (sb *spillBuffer) close() {
+	sb.w.Close()
+	sb.rf.Close()
+}
@@ -0,0 +1,152 @@
+package logger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpillBuffer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	client := &MockClient{Counts: make(map[string]int64)}
+	sb, err := newSpillBuffer(dir, 0)
+	require.NoError(t, err)
+	sb.stats = client
+	// The spill file is already gone
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	in := make(chan string)
+	out := make(chan string, 2)
+	done := make(chan struct{})
+	go func() {
+		sb.run(in, out)
+		close(done)
+	}()
+
+	// Nobody is reading, but the producer never blocks
+	for i := 0; i < 100; i++ {
+		in <- fmt.Sprintf("line %d", i)
+	}
+	for i := 0; i < 50; i++ {
+		assert.Equal(t, fmt.Sprintf("line %d", i), <-out)
+	}
+	// Lines read while some are still spilled are spilled too
+	for i := 100; i < 110; i++ {
+		in <- fmt.Sprintf("line %d", i)
+	}
+	for i := 50; i < 110; i++ {
+		assert.Equal(t, fmt.Sprintf("line %d", i), <-out)
+	}
+
+	// And once drained, it starts over
+	in <- "again"
+	in <- "and again"
+	in <- "and again and again"
+	close(in)
+	assert.Equal(t, "again", <-out)
+	assert.Equal(t, "and again", <-out)
+	assert.Equal(t, "and again and again", <-out)
+	_, ok := <-out
+	assert.False(t, ok)
+	<-done
+	assert.True(t, client.Counts["unilog.spill.bytes"] > 0)
+}
+
+func TestSpillBufferLost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	client := &MockClient{Counts: make(map[string]int64)}
+	sb, err := newSpillBuffer(dir, 0)
+	require.NoError(t, err)
+	sb.stats = client
+	out := make(chan string, 3)
+	for _, line := range []string{"one", "two", "three"} {
+		sb.spill(line, out)
+	}
+
+	// Spilled lines that can't be read back are dropped
+	require.NoError(t, sb.rf.Close())
+	sb.drain(out)
+	assert.Empty(t, out)
+	assert.Equal(t, int64(3), client.Counts["[reason:spill_lost]unilog.lines.dropped"])
+	assert.Equal(t, int64(1), client.Counts["unilog.spill.errors"])
+	assert.Zero(t, sb.pending)
+}
+
+func TestSpillBufferFull(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	client := &MockClient{Counts: make(map[string]int64)}
+	sb, err := newSpillBuffer(dir, 10)
+	require.NoError(t, err)
+	sb.stats = client
+
+	in := make(chan string)
+	out := make(chan string, 1)
+	go sb.run(in, out)
+
+	in <- "zero"
+	in <- "one!"
+	in <- "two!"
+	// The spill file is full, so the producer blocks
+	select {
+	case in <- "three":
+		t.Fatal("spilled past the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	assert.Equal(t, "zero", <-out)
+	assert.Equal(t, "one!", <-out)
+	assert.Equal(t, "two!", <-out)
+	in <- "three"
+	close(in)
+	assert.Equal(t, "three", <-out)
+	_, ok := <-out
+	assert.False(t, ok)
+	assert.Equal(t, int64(1), client.Counts["unilog.spill.full"])
+}
+
+func TestReadlinesSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sb, err := newSpillBuffer(dir, 0)
+	require.NoError(t, err)
+	ch := make(chan struct{})
+	defer close(ch)
+	client := &MockClient{Counts: make(map[string]int64), Histograms: make(map[string]float64)}
+	u := &Unilog{BufferLines: 1, shutdown: ch, spill: sb, Metrics: client}
+
+	var input strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&input, "line %d\n", i)
+	}
+	u.lines, _ = u.readlines(strings.NewReader(input.String()))
+	u.reportBufferStats()
+	assert.Equal(t, float64(1), client.Gauges["[mode:text]unilog.buffer.capacity"])
+	i := 0
+	for line := range u.lines {
+		assert.Equal(t, fmt.Sprintf("line %d", i), line)
+		i++
+	}
+	assert.Equal(t, 1000, i)
+
+	// Handing lines to the spill buffer isn't a full buffer
+	assert.NotContains(t, client.Counts, "[mode:text]unilog.buffer.full")
+}
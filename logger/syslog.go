@@ -0,0 +1,357 @@
+package logger
+
+import (
+	"fmt"
+	"log/syslog"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/stripe/unilog/clevels"
+	"github.com/stripe/unilog/filters"
+	"github.com/stripe/unilog/json"
+)
+
+// Error actions for syslog targets, which are reported instead of
+// reopen_file and write_to_log so that syslog failures can be told
+// apart.
+const (
+	syslogOpenAction  = "open_syslog"
+	syslogWriteAction = "write_to_syslog"
+)
+
+// DefaultSyslogTag is the syslog tag used if neither SyslogTag nor
+// Name is set.
+const DefaultSyslogTag = "unilog"
+
+// The message formats accepted by SyslogFormat.
+const (
+	SyslogFormatRFC3164 = "rfc3164"
+	SyslogFormatRFC5424 = "rfc5424"
+)
+
+// syslogFacilities maps the names accepted by SyslogFacility to
+// facilities.
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":     syslog.LOG_KERN,
+	"user":     syslog.LOG_USER,
+	"mail":     syslog.LOG_MAIL,
+	"daemon":   syslog.LOG_DAEMON,
+	"auth":     syslog.LOG_AUTH,
+	"syslog":   syslog.LOG_SYSLOG,
+	"lpr":      syslog.LOG_LPR,
+	"news":     syslog.LOG_NEWS,
+	"uucp":     syslog.LOG_UUCP,
+	"cron":     syslog.LOG_CRON,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"ftp":      syslog.LOG_FTP,
+	"local0":   syslog.LOG_LOCAL0,
+	"local1":   syslog.LOG_LOCAL1,
+	"local2":   syslog.LOG_LOCAL2,
+	"local3":   syslog.LOG_LOCAL3,
+	"local4":   syslog.LOG_LOCAL4,
+	"local5":   syslog.LOG_LOCAL5,
+	"local6":   syslog.LOG_LOCAL6,
+	"local7":   syslog.LOG_LOCAL7,
+}
+
+// parseSyslogAddress splits a syslog address into a network and an
+// address for syslog.Dial. Addresses can be local socket paths (like
+// /dev/log), or syslog://host:port (UDP), udp://host:port or
+// tcp://host:port.
+func parseSyslogAddress(address string) (network, addr string, err error) {
+	switch {
+	case strings.HasPrefix(address, "/"):
+		return "unixgram", address, nil
+	case strings.HasPrefix(address, "syslog://"):
+		network, addr = "udp", strings.TrimPrefix(address, "syslog://")
+	case strings.HasPrefix(address, "udp://"):
+		network, addr = "udp", strings.TrimPrefix(address, "udp://")
+	case strings.HasPrefix(address, "tcp://"):
+		network, addr = "tcp", strings.TrimPrefix(address, "tcp://")
+	default:
+		return "", "", fmt.Errorf("invalid syslog address %q (expected a socket path, syslog://host:port, udp://host:port or tcp://host:port)", address)
+	}
+	if !strings.Contains(addr, ":") {
+		return "", "", fmt.Errorf("invalid syslog address %q: missing port", address)
+	}
+	return network, addr, nil
+}
+
+// syslogSink is a target that writes each line it is given as a
+// syslog message, at the severity of the line being written, which
+// logLine and logJSON set before each write.
+type syslogSink struct {
+	w *syslog.Writer
+	// the output delimiter, which is stripped from lines
+	delim    string
+	severity syslog.Priority
+
+	// With SyslogFormat rfc5424, messages are written to conn
+	// (instead of w) by the sink itself, dialing it and writing to
+	// it with the same timeouts as network targets.
+	rfc5424      bool
+	network      string
+	addr         string
+	conn         net.Conn
+	writeTimeout time.Duration
+	facility     syslog.Priority
+	hostname     string
+	tag          string
+}
+
+// checkSyslog returns an error if the syslog options are invalid.
+func (u *Unilog) checkSyslog() error {
+	if _, _, err := parseSyslogAddress(u.Syslog); err != nil {
+		return err
+	}
+	switch u.SyslogFormat {
+	case "", SyslogFormatRFC3164, SyslogFormatRFC5424:
+	default:
+		return fmt.Errorf("unknown syslog format %q (expected %s or %s)", u.SyslogFormat, SyslogFormatRFC3164, SyslogFormatRFC5424)
+	}
+	_, err := u.syslogFacility()
+	return err
+}
+
+func (u *Unilog) syslogFacility() (syslog.Priority, error) {
+	if u.SyslogFacility == "" {
+		return syslog.LOG_USER, nil
+	}
+	facility, ok := syslogFacilities[strings.ToLower(u.SyslogFacility)]
+	if !ok {
+		return 0, fmt.Errorf("unknown syslog facility %q", u.SyslogFacility)
+	}
+	return facility, nil
+}
+
+func (u *Unilog) openSyslog() (*syslogSink, error) {
+	network, addr, err := parseSyslogAddress(u.Syslog)
+	if err != nil {
+		return nil, err
+	}
+	facility, err := u.syslogFacility()
+	if err != nil {
+		return nil, err
+	}
+	tag := u.SyslogTag
+	if tag == "" {
+		tag = u.Name
+	}
+	if tag == "" {
+		tag = DefaultSyslogTag
+	}
+
+	if u.SyslogFormat == SyslogFormatRFC5424 {
+		hostname, _ := os.Hostname()
+		s := &syslogSink{
+			delim:        u.outputDelimiter(),
+			severity:     syslog.LOG_INFO,
+			rfc5424:      true,
+			network:      network,
+			addr:         addr,
+			writeTimeout: netWriteTimeout,
+			facility:     facility,
+			hostname:     hostname,
+			tag:          tag,
+		}
+		if err := s.connect(); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+
+	w, err := syslog.Dial(network, addr, facility|syslog.LOG_INFO, tag)
+	if err != nil && network == "unixgram" {
+		// Some syslog daemons listen on stream sockets
+		w, err = syslog.Dial("unix", addr, facility|syslog.LOG_INFO, tag)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{w: w, delim: u.outputDelimiter(), severity: syslog.LOG_INFO}, nil
+}
+
+func (s *syslogSink) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(strings.TrimSuffix(string(p), "\n"), s.delim)
+	if s.rfc5424 {
+		if err := s.writeRFC5424(msg); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	var err error
+	switch s.severity {
+	case syslog.LOG_EMERG:
+		err = s.w.Emerg(msg)
+	case syslog.LOG_ALERT:
+		err = s.w.Alert(msg)
+	case syslog.LOG_CRIT:
+		err = s.w.Crit(msg)
+	case syslog.LOG_ERR:
+		err = s.w.Err(msg)
+	case syslog.LOG_WARNING:
+		err = s.w.Warning(msg)
+	case syslog.LOG_NOTICE:
+		err = s.w.Notice(msg)
+	case syslog.LOG_DEBUG:
+		err = s.w.Debug(msg)
+	default:
+		err = s.w.Info(msg)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *syslogSink) Close() error {
+	if s.rfc5424 {
+		if s.conn == nil {
+			return nil
+		}
+		err := s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return s.w.Close()
+}
+
+// connect (re)connects an rfc5424 sink to its syslog daemon.
+func (s *syslogSink) connect() error {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	conn, err := net.DialTimeout(s.network, s.addr, netDialTimeout)
+	if err != nil && s.network == "unixgram" {
+		// Some syslog daemons listen on stream sockets
+		s.network = "unix"
+		conn, err = net.DialTimeout(s.network, s.addr, netDialTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+// writeRFC5424 writes msg as an RFC 5424 message. Like log/syslog, it
+// reconnects and tries again once if the write fails, unless it timed
+// out (which the daemon would likely just do again).
+func (s *syslogSink) writeRFC5424(msg string) error {
+	b := []byte(formatRFC5424(s.facility|s.severity, time.Now(), s.hostname, s.tag, os.Getpid(), msg))
+	if s.network == "tcp" || s.network == "unix" {
+		// Stream sockets use octet counting (RFC 6587)
+		b = append([]byte(fmt.Sprintf("%d ", len(b))), b...)
+	}
+	if s.conn != nil {
+		err := s.write(b)
+		if err == nil {
+			return nil
+		}
+		if isTimeout(err) {
+			s.conn.Close()
+			s.conn = nil
+			return err
+		}
+	}
+	if err := s.connect(); err != nil {
+		return err
+	}
+	return s.write(b)
+}
+
+func (s *syslogSink) write(b []byte) error {
+	s.conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+	_, err := s.conn.Write(b)
+	return err
+}
+
+// formatRFC5424 formats an RFC 5424 syslog message, without
+// structured data or a message ID.
+func formatRFC5424(priority syslog.Priority, t time.Time, hostname, app string, pid int, msg string) string {
+	return fmt.Sprintf("<%d>1 %s %s %s %d - - %s",
+		priority,
+		t.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHeaderField(hostname, 255),
+		syslogHeaderField(app, 48),
+		pid,
+		msg)
+}
+
+// syslogHeaderField returns s as an RFC 5424 header field: printable
+// ASCII, without spaces, of at most max characters, or - if empty.
+func syslogHeaderField(s string, max int) string {
+	field := strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, s)
+	if len(field) > max {
+		field = field[:max]
+	}
+	if field == "" {
+		return "-"
+	}
+	return field
+}
+
+// errorActions returns the actions to report failures to open and to
+// write to the target as.
+func (u *Unilog) errorActions() (open, write string) {
+	if u.OTLPEndpoint != "" {
+		return otlpOpenAction, otlpWriteAction
+	}
+	if u.Syslog != "" {
+		return syslogOpenAction, syslogWriteAction
+	}
+	return "reopen_file", "write_to_log"
+}
+
+// criticalitySeverities maps criticality levels to the syslog
+// severities of lines without a level.
+var criticalitySeverities = map[clevels.AusterityLevel]syslog.Priority{
+	clevels.Sheddable:     syslog.LOG_DEBUG,
+	clevels.SheddablePlus: syslog.LOG_INFO,
+	clevels.Critical:      syslog.LOG_WARNING,
+	clevels.CriticalPlus:  syslog.LOG_CRIT,
+}
+
+// levelSeverity returns the syslog severity for a level rank, as
+// returned by filters.ParseLevel.
+func levelSeverity(rank int) syslog.Priority {
+	switch {
+	case rank >= 80:
+		return syslog.LOG_EMERG
+	case rank >= 70:
+		return syslog.LOG_ALERT
+	case rank >= 60:
+		return syslog.LOG_CRIT
+	case rank >= 50:
+		return syslog.LOG_ERR
+	case rank >= 40:
+		return syslog.LOG_WARNING
+	case rank >= 30:
+		return syslog.LOG_NOTICE
+	case rank >= 20:
+		return syslog.LOG_INFO
+	}
+	return syslog.LOG_DEBUG
+}
+
+// jsonSeverity returns the syslog severity of a JSON line: the one
+// named by its "level" or "severity" field if it has a known level
+// (see filters.ParseLevel), or else the one for its criticality.
+func jsonSeverity(line json.LogLine) syslog.Priority {
+	for _, field := range []string{"level", "severity"} {
+		if name, ok := line[field].(string); ok {
+			if rank, ok := filters.ParseLevel(name); ok {
+				return levelSeverity(rank)
+			}
+		}
+	}
+	return criticalitySeverities[clevels.JSONCriticality(line)]
+}
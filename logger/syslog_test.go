@@ -0,0 +1,200 @@
+package logger
+
+import (
+	"bufio"
+	"io"
+	"log/syslog"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/unilog/clevels"
+)
+
+func TestParseSyslogAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		network string
+		addr    string
+		err     bool
+	}{
+		{"/dev/log", "unixgram", "/dev/log", false},
+		{"syslog://logs.example.com:514", "udp", "logs.example.com:514", false},
+		{"udp://127.0.0.1:514", "udp", "127.0.0.1:514", false},
+		{"tcp://[::1]:601", "tcp", "[::1]:601", false},
+		{"syslog://logs.example.com", "", "", true},
+		{"logs.example.com:514", "", "", true},
+		{"", "", "", true},
+	}
+	for _, test := range tests {
+		network, addr, err := parseSyslogAddress(test.address)
+		if test.err {
+			assert.Error(t, err, test.address)
+			continue
+		}
+		require.NoError(t, err, test.address)
+		assert.Equal(t, test.network, network, test.address)
+		assert.Equal(t, test.addr, addr, test.address)
+	}
+
+	u := &Unilog{Syslog: "/dev/log", SyslogFacility: "local9"}
+	assert.Error(t, u.checkSyslog())
+	u.SyslogFacility = "LOCAL3"
+	assert.NoError(t, u.checkSyslog())
+}
+
+// readSyslog reads a syslog message from conn.
+func readSyslog(t *testing.T, conn net.PacketConn) string {
+	buf := make([]byte, 1024)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	return string(buf[:n])
+}
+
+func TestSyslogTarget(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	address := "syslog://" + conn.LocalAddr().String()
+
+	u := &Unilog{Syslog: address, SyslogFacility: "local0", SyslogTag: "svc"}
+	u.logLine("hi clevel=criticalplus")
+	msg := readSyslog(t, conn)
+	// local0 (16) * 8 + crit (2)
+	assert.Regexp(t, `^<130>.* svc\[\d+\]: hi clevel=criticalplus\n$`, msg)
+
+	u.logLine("just a line")
+	assert.Regexp(t, `^<134>.*: just a line\n$`, readSyslog(t, conn))
+
+	// Reopening is a no-op
+	file := u.file
+	require.NoError(t, u.reopen())
+	assert.Equal(t, file, u.file)
+
+	u = &Unilog{Syslog: address, Name: "api", JSON: true}
+	u.logJSON(`{"level":"error","message":"oops"}`)
+	// user (1) * 8 + err (3)
+	assert.Regexp(t, `^<11>.* api\[\d+\]: \{.*"message":"oops".*\}\n$`, readSyslog(t, conn))
+	u.logJSON(`{"severity":"debug","message":"hmm"}`)
+	assert.Regexp(t, `^<15>`, readSyslog(t, conn))
+	u.logJSON(`{"canonical":true}`)
+	assert.Regexp(t, `^<10>`, readSyslog(t, conn))
+}
+
+func TestSyslogErrors(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := "tcp://" + l.Addr().String()
+	// Nothing is listening anymore
+	l.Close()
+
+	client := &MockClient{Counts: make(map[string]int64)}
+	u := &Unilog{Syslog: address, Metrics: client}
+	u.logLine("hi")
+	assert.Nil(t, u.file)
+	assert.Equal(t, int64(1), client.Counts["[err_action:open_syslog]unilog.errors_total"])
+}
+
+func TestCriticalitySeverities(t *testing.T) {
+	tests := map[clevels.AusterityLevel]syslog.Priority{
+		clevels.Sheddable:     syslog.LOG_DEBUG,
+		clevels.SheddablePlus: syslog.LOG_INFO,
+		clevels.Critical:      syslog.LOG_WARNING,
+		clevels.CriticalPlus:  syslog.LOG_CRIT,
+	}
+	for level, severity := range tests {
+		assert.Equal(t, severity, criticalitySeverities[level], level.String())
+	}
+	// Lines without a clevel are sheddableplus
+	assert.Equal(t, syslog.LOG_INFO, criticalitySeverities[clevels.Criticality("no clevel")])
+}
+
+func TestFormatRFC5424(t *testing.T) {
+	ts := time.Date(2026, 10, 17, 12, 30, 0, 1500, time.FixedZone("PDT", -7*3600))
+	assert.Equal(t,
+		"<131>1 2026-10-17T19:30:00.000001Z web-1 api 42 - - oops",
+		formatRFC5424(syslog.LOG_LOCAL0|syslog.LOG_ERR, ts, "web-1", "api", 42, "oops"))
+	assert.Equal(t,
+		"<14>1 2026-10-17T19:30:00.000001Z - my_app 42 - - hi",
+		formatRFC5424(syslog.LOG_USER|syslog.LOG_INFO, ts, "", "my app", 42, "hi"))
+}
+
+func TestSyslogRFC5424(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	u := &Unilog{Syslog: "syslog://" + conn.LocalAddr().String(), SyslogFormat: SyslogFormatRFC5424, Name: "api"}
+	require.NoError(t, u.checkSyslog())
+	u.logLine("hi clevel=critical")
+	// user (1) * 8 + warning (4)
+	assert.Regexp(t, `^<12>1 \d{4}-\d\d-\d\dT\S+Z \S+ api \d+ - - hi clevel=critical$`, readSyslog(t, conn))
+
+	assert.Error(t, (&Unilog{Syslog: "/dev/log", SyslogFormat: "rfc9999"}).checkSyslog())
+}
+
+// readOctetCounted reads an octet-counted syslog message from r.
+func readOctetCounted(t *testing.T, r *bufio.Reader) string {
+	prefix, err := r.ReadString(' ')
+	require.NoError(t, err)
+	n, err := strconv.Atoi(strings.TrimSuffix(prefix, " "))
+	require.NoError(t, err)
+	msg := make([]byte, n)
+	_, err = io.ReadFull(r, msg)
+	require.NoError(t, err)
+	return string(msg)
+}
+
+func TestSyslogRFC5424Reconnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	u := &Unilog{Syslog: "tcp://" + l.Addr().String(), SyslogFormat: SyslogFormatRFC5424}
+	u.logLine("one")
+	c, err := l.Accept()
+	require.NoError(t, err)
+	defer c.Close()
+	assert.Regexp(t, `^<14>1 .* unilog \d+ - - one$`, readOctetCounted(t, bufio.NewReader(c)))
+
+	// Once the connection is broken, the sink reconnects rather than
+	// failing the write
+	require.NoError(t, u.file.(*syslogSink).conn.(*net.TCPConn).CloseWrite())
+	u.logLine("two")
+	c2, err := l.Accept()
+	require.NoError(t, err)
+	defer c2.Close()
+	assert.Regexp(t, `^<14>1 .* - - two$`, readOctetCounted(t, bufio.NewReader(c2)))
+	assert.False(t, u.writeFailed)
+}
+
+func TestSyslogRFC5424WriteTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	client := &MockClient{Counts: make(map[string]int64)}
+	u := &Unilog{Syslog: "tcp://" + l.Addr().String(), SyslogFormat: SyslogFormatRFC5424, Metrics: client, CircuitFailures: 10, CircuitCooldown: time.Hour}
+	require.NoError(t, u.reopen())
+	// The daemon never reads
+	c, err := l.Accept()
+	require.NoError(t, err)
+	defer c.Close()
+
+	s := u.file.(*syslogSink)
+	s.writeTimeout = 10 * time.Millisecond
+	line := strings.Repeat("x", 1<<16)
+	for i := 0; i < 1000 && s.conn != nil; i++ {
+		u.logLine(line)
+	}
+	require.Nil(t, s.conn, "writes never blocked")
+
+	// A timeout isn't retried, and trips the circuit breaker
+	assert.Equal(t, int64(1), client.Counts["[err_action:write_to_syslog][reason:timeout]unilog.errors_total"])
+	assert.True(t, u.circuitOpen())
+}
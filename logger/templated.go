@@ -0,0 +1,262 @@
+package logger
+
+import (
+	"bufio"
+	"container/list"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/stripe/unilog/filters"
+	"github.com/stripe/unilog/json"
+)
+
+// DefaultMaxOpenFiles and DefaultIdleFileTimeout are the defaults for
+// MaxOpenFiles and IdleFileTimeout.
+const (
+	DefaultMaxOpenFiles    = 64
+	DefaultIdleFileTimeout = 5 * time.Minute
+)
+
+// targetPlaceholders are the placeholders a templated target may
+// contain, see templatedTarget.
+var targetPlaceholders = []string{"{service}", "{date}"}
+
+// isTemplate reports whether target is a template, rather than the
+// path of a single file.
+func isTemplate(target string) bool {
+	for _, p := range targetPlaceholders {
+		if strings.Contains(target, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTemplatedTarget returns an error if the target is a template
+// used with options that need a single target file.
+func (u *Unilog) checkTemplatedTarget() error {
+	if !isTemplate(u.target) {
+		return nil
+	}
+	if u.MaxFileBytes > 0 {
+		return fmt.Errorf("-maxbytes can't be used with a templated target")
+	}
+	return nil
+}
+
+// templatedTarget writes each line to a file whose path depends on
+// the line, e.g. /var/log/{service}/{date}.log. {service} is a JSON
+// line's "service" field (text lines use Name), and {date} is the
+// day (2006-01-02, in local time) of the line's event timestamp (the
+// time prefix of text lines, or the current time if they don't have
+// one). Missing directories are created.
+//
+// The path of the line about to be written is set in path, much like
+// a syslogSink's severity. Files are kept open between lines, up to
+// max at a time: opening another one closes the least recently
+// written one. Files that haven't been written to in idle are closed
+// too (when the next line is written). Either is counted in the
+// unilog.files.evicted metric, and the number of open files is
+// reported in the unilog.files.open histogram whenever it changes.
+//
+// With WriteBuffer, each open file has its own buffer, like a single
+// target file's; the lines lost when writing one out fails (which a
+// file being closed can do) are counted until takeLost.
+//
+// Reopening (on SIGHUP/SIGALRM) closes all the files, so they are
+// reopened by the next line written to them. Lock and Truncate don't
+// apply to templated targets, and MaxFileBytes is refused with one
+// (see checkTemplatedTarget), since nothing rotates its files.
+type templatedTarget struct {
+	template string
+	max      int
+	idle     time.Duration
+	bufSize  int
+	stats    Client
+
+	path string
+
+	// the open files, most recently written first
+	lru   *list.List
+	files map[string]*list.Element
+	// the lines lost with the buffers of closed files
+	lost int
+}
+
+type openFile struct {
+	path     string
+	f        *sizedFile
+	lastUsed time.Time
+}
+
+func newTemplatedTarget(template string, max int, idle time.Duration, bufSize int, stats Client) *templatedTarget {
+	if max <= 0 {
+		max = DefaultMaxOpenFiles
+	}
+	if idle <= 0 {
+		idle = DefaultIdleFileTimeout
+	}
+	return &templatedTarget{
+		template: template,
+		max:      max,
+		idle:     idle,
+		bufSize:  bufSize,
+		stats:    stats,
+		lru:      list.New(),
+		files:    make(map[string]*list.Element),
+	}
+}
+
+// resolveText sets the path a text line is written to.
+func (t *templatedTarget) resolveText(line, name string) {
+	ts, ok := filters.ParseTimePrefix(line)
+	if !ok {
+		ts = time.Now()
+	}
+	t.path = t.resolve(name, ts)
+}
+
+// resolveJSON sets the path a JSON line is written to.
+func (t *templatedTarget) resolveJSON(line json.LogLine) {
+	service, _ := line["service"].(string)
+	t.path = t.resolve(service, line.Timestamp())
+}
+
+func (t *templatedTarget) resolve(service string, ts time.Time) string {
+	return strings.NewReplacer(
+		"{service}", pathComponent(service),
+		"{date}", ts.Local().Format("2006-01-02"),
+	).Replace(t.template)
+}
+
+// pathComponent makes s safe to use as (part of) a single path
+// component, so that a line can't direct output outside of the
+// template's directories.
+func pathComponent(s string) string {
+	s = strings.Replace(s, string(filepath.Separator), "_", -1)
+	switch s {
+	case "", ".", "..":
+		return "unknown"
+	}
+	return s
+}
+
+// Write writes p to the file at path.
+func (t *templatedTarget) Write(p []byte) (int, error) {
+	now := time.Now()
+	t.closeIdle(now)
+	f, err := t.open(t.path)
+	if err != nil {
+		return 0, err
+	}
+	f.lastUsed = now
+	return f.f.Write(p)
+}
+
+// open returns the open file at path, opening it if need be.
+func (t *templatedTarget) open(path string) (*openFile, error) {
+	if e, ok := t.files[path]; ok {
+		t.lru.MoveToFront(e)
+		return e.Value.(*openFile), nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	sf, err := openSizedFile(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if t.bufSize > 0 {
+		sf.buf = bufio.NewWriterSize(f, t.bufSize)
+	}
+	for t.lru.Len() >= t.max {
+		t.evict(t.lru.Back(), "lru")
+	}
+	of := &openFile{path: path, f: sf}
+	t.files[path] = t.lru.PushFront(of)
+	t.reportOpen()
+	return of, nil
+}
+
+// closeIdle closes the files that haven't been written to since idle
+// before now.
+func (t *templatedTarget) closeIdle(now time.Time) {
+	for e := t.lru.Back(); e != nil; e = t.lru.Back() {
+		if now.Sub(e.Value.(*openFile).lastUsed) < t.idle {
+			return
+		}
+		t.evict(e, "idle")
+	}
+}
+
+func (t *templatedTarget) evict(e *list.Element, reason string) {
+	t.remove(e)
+	if t.stats != nil {
+		IndependentCount(t.stats, "unilog.files.evicted", 1, []string{"reason:" + reason}, 1)
+	}
+	t.reportOpen()
+}
+
+func (t *templatedTarget) remove(e *list.Element) {
+	of := t.lru.Remove(e).(*openFile)
+	delete(t.files, of.path)
+	of.f.Close()
+	t.lost += of.f.takeLost()
+}
+
+func (t *templatedTarget) reportOpen() {
+	if t.stats != nil {
+		IndependentHistogram(t.stats, "unilog.files.open", float64(t.lru.Len()), nil, 1)
+	}
+}
+
+// Flush writes out the buffers of all the open files, and returns the
+// first error.
+func (t *templatedTarget) Flush() error {
+	var err error
+	for el := t.lru.Front(); el != nil; el = el.Next() {
+		if e := el.Value.(*openFile).f.Flush(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// takeLost returns the number of lines lost with the buffers of the
+// files since the last call.
+func (t *templatedTarget) takeLost() int {
+	n := t.lost
+	t.lost = 0
+	for el := t.lru.Front(); el != nil; el = el.Next() {
+		n += el.Value.(*openFile).f.takeLost()
+	}
+	return n
+}
+
+// Sync syncs all the open files, and returns the first error.
+func (t *templatedTarget) Sync() error {
+	var err error
+	for el := t.lru.Front(); el != nil; el = el.Next() {
+		if e := el.Value.(*openFile).f.Sync(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Close closes all the open files.
+func (t *templatedTarget) Close() error {
+	for e := t.lru.Front(); e != nil; e = t.lru.Front() {
+		t.remove(e)
+	}
+	t.reportOpen()
+	return nil
+}
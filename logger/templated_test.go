@@ -0,0 +1,151 @@
+package logger
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	encjson "encoding/json"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readFile(t *testing.T, path string) string {
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestTemplatedTarget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	client := &MockClient{Counts: make(map[string]int64), Histograms: make(map[string]float64)}
+	u := &Unilog{
+		JSON:         true,
+		target:       filepath.Join(dir, "{service}", "{date}.log"),
+		MaxOpenFiles: 2,
+		Metrics:      client,
+	}
+	require.NoError(t, u.reopen())
+	tt, ok := u.file.(*templatedTarget)
+	require.True(t, ok)
+
+	u.logJSON(`{"service":"api","timestamp":"2016-11-10T19:18:05Z","message":"one"}`)
+	u.logJSON(`{"service":"api","timestamp":"2016-11-11T19:18:05Z","message":"two"}`)
+	u.logJSON(`{"service":"../../etc","timestamp":"2016-11-10T19:18:05Z","message":"three"}`)
+	u.logJSON(`{"timestamp":"2016-11-10T19:18:05Z","message":"four"}`)
+	u.logJSON(`{"service":"api","timestamp":"2016-11-10T19:18:05Z","message":"five"}`)
+
+	day := func(d int) string {
+		return time.Date(2016, 11, d, 19, 18, 5, 0, time.UTC).Local().Format("2006-01-02")
+	}
+	api := readFile(t, filepath.Join(dir, "api", day(10)+".log"))
+	assert.Contains(t, api, `"message":"one"`)
+	assert.Contains(t, api, `"message":"five"`)
+	assert.Contains(t, readFile(t, filepath.Join(dir, "api", day(11)+".log")), `"message":"two"`)
+	assert.Contains(t, readFile(t, filepath.Join(dir, ".._.._etc", day(10)+".log")), `"message":"three"`)
+	assert.Contains(t, readFile(t, filepath.Join(dir, "unknown", day(10)+".log")), `"message":"four"`)
+
+	// Only two files were ever open at once
+	assert.Equal(t, 2, tt.lru.Len())
+	assert.Equal(t, int64(3), client.Counts["[reason:lru]unilog.files.evicted"])
+
+	// Reopening closes them all, and they're opened again as needed
+	require.NoError(t, u.reopen())
+	assert.Equal(t, tt, u.file)
+	assert.Equal(t, 0, tt.lru.Len())
+	u.logJSON(`{"service":"api","timestamp":"2016-11-10T19:18:05Z","message":"six"}`)
+	assert.Contains(t, readFile(t, filepath.Join(dir, "api", day(10)+".log")), `"message":"six"`)
+	assert.Equal(t, 1, tt.lru.Len())
+}
+
+func TestTemplatedTargetText(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	u := &Unilog{Name: "api", target: filepath.Join(dir, "{service}-{date}.log")}
+	require.NoError(t, u.reopen())
+	u.logLine("[2016-11-10 19:18:05.000000] hi")
+	u.logLine("no timestamp")
+
+	assert.Equal(t, "[2016-11-10 19:18:05.000000] hi\n", readFile(t, filepath.Join(dir, "api-2016-11-10.log")))
+	assert.Equal(t, "no timestamp\n", readFile(t, filepath.Join(dir, "api-"+time.Now().Format("2006-01-02")+".log")))
+}
+
+func TestTemplatedTargetIdle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	client := &MockClient{Counts: make(map[string]int64), Histograms: make(map[string]float64)}
+	tt := newTemplatedTarget(filepath.Join(dir, "{service}.log"), 0, 0, 0, client)
+	assert.Equal(t, DefaultMaxOpenFiles, tt.max)
+	tt.idle = time.Millisecond
+	enc := encjson.NewEncoder(tt)
+
+	tt.path = tt.resolve("a", time.Now())
+	require.NoError(t, enc.Encode("one"))
+	tt.path = tt.resolve("b", time.Now())
+	require.NoError(t, enc.Encode("two"))
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, enc.Encode("three"))
+
+	// a went idle, but b was opened again
+	assert.Equal(t, 1, tt.lru.Len())
+	assert.Equal(t, int64(2), client.Counts["[reason:idle]unilog.files.evicted"])
+	assert.Equal(t, "\"two\"\n\"three\"\n", readFile(t, filepath.Join(dir, "b.log")))
+	require.NoError(t, tt.Close())
+	assert.Equal(t, 0, tt.lru.Len())
+}
+
+func TestTemplatedTargetWriteBufferLost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	client := &MockClient{Counts: make(map[string]int64), Histograms: make(map[string]float64)}
+	u := &Unilog{
+		JSON:         true,
+		target:       filepath.Join(dir, "{service}.log"),
+		MaxOpenFiles: 1,
+		WriteBuffer:  1 << 10,
+		Metrics:      client,
+	}
+	require.NoError(t, u.reopen())
+	tt := u.file.(*templatedTarget)
+
+	// Each file has its own buffer
+	u.logJSON(`{"service":"a","message":"one"}`)
+	u.logJSON(`{"service":"a","message":"two"}`)
+	assert.Empty(t, readFile(t, filepath.Join(dir, "a.log")))
+
+	// Evicting a file whose buffer can't be written out loses its
+	// lines, even though the line that evicted it was written
+	require.NoError(t, tt.lru.Front().Value.(*openFile).f.f.Close())
+	u.logJSON(`{"service":"b","message":"three"}`)
+	assert.Equal(t, int64(2), client.Counts["[reason:buffer_lost]unilog.lines.dropped"])
+	assert.False(t, u.writeFailed)
+
+	u.flush()
+	assert.Contains(t, readFile(t, filepath.Join(dir, "b.log")), `"message":"three"`)
+}
+
+func TestIsTemplate(t *testing.T) {
+	assert.True(t, isTemplate("/var/log/{service}/{date}.log"))
+	assert.True(t, isTemplate("/var/log/{date}.log"))
+	assert.False(t, isTemplate("/var/log/app.log"))
+	assert.False(t, isTemplate("/var/log/{other}.log"))
+	assert.False(t, isTemplate("-"))
+}
+
+func TestCheckTemplatedTarget(t *testing.T) {
+	assert.NoError(t, (&Unilog{target: "/var/log/{date}.log"}).checkTemplatedTarget())
+	assert.NoError(t, (&Unilog{target: "/var/log/out.log", MaxFileBytes: 10}).checkTemplatedTarget())
+	assert.Error(t, (&Unilog{target: "/var/log/{date}.log", MaxFileBytes: 10}).checkTemplatedTarget())
+}
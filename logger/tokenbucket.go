@@ -0,0 +1,42 @@
+package logger
+
+import "time"
+
+// tokenBucket is a token bucket rate limiter: it holds up to burst
+// tokens, which are refilled at a steady rate of burst per period.
+type tokenBucket struct {
+	burst float64
+	// tokens added per second
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a full bucket that allows n events per
+// period.
+func newTokenBucket(n int, period time.Duration) *tokenBucket {
+	return &tokenBucket{
+		burst:  float64(n),
+		rate:   float64(n) / period.Seconds(),
+		tokens: float64(n),
+	}
+}
+
+// take reports whether an event may happen at now, and if so, uses up
+// a token for it.
+func (b *tokenBucket) take(now time.Time) bool {
+	if b.last.IsZero() {
+		b.last = now
+	} else if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,27 @@
+package logger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(2, time.Minute)
+	now := time.Now()
+	assert.True(t, b.take(now))
+	assert.True(t, b.take(now))
+	assert.False(t, b.take(now))
+
+	// One token comes back every 30s
+	assert.False(t, b.take(now.Add(20*time.Second)))
+	assert.True(t, b.take(now.Add(30*time.Second)))
+	assert.False(t, b.take(now.Add(30*time.Second)))
+
+	// And no more than two accumulate
+	later := now.Add(time.Hour)
+	assert.True(t, b.take(later))
+	assert.True(t, b.take(later))
+	assert.False(t, b.take(later))
+}
@@ -2,17 +2,19 @@ package logger
 
 import (
 	"bufio"
-	"bytes"
+	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
-	"os/exec"
-	"os/signal"
+	"runtime/debug"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
-	"text/template"
 	"time"
+	"unicode/utf8"
 
 	"github.com/DataDog/datadog-go/statsd"
 	"github.com/getsentry/sentry-go"
@@ -20,8 +22,10 @@ import (
 	encjson "encoding/json"
 
 	"github.com/stripe/unilog/clevels"
+	"github.com/stripe/unilog/filters"
 	"github.com/stripe/unilog/json"
 	"github.com/stripe/unilog/reader"
+	"golang.org/x/text/encoding"
 	flag "launchpad.net/gnuflag"
 )
 
@@ -34,15 +38,44 @@ var cleveltags string
 // hold the argument passed with "-independenttags"
 var independenttags string
 
+// hold the argument passed with "-output-delimiter"
+var outputdelimiter string
+
+// hold the argument passed with "-field-order"
+var fieldorder string
+
+// hold the argument passed with "-json-timestamp-field"
+var timestampfield string
+
+// hold the argument passed with "-json-collisions"
+var jsoncollisions string
+
 // Filter takes in a log line and applies a transformation prior to logging
 // them. Since Unilog can operate on JSON or on string content, there are two
 // methods that a filter must implement (so unilog can cut down on time spent
 // parsing the log line).
+//
+// FilterJSON can drop a line entirely by setting it to nil; no further
+// filters are applied to it and it is not written. Filters that turn
+// a text line into more (or fewer) than one implement MultiFilter.
 type Filter interface {
 	FilterLine(line string) string
 	FilterJSON(line *json.LogLine)
 }
 
+// FilterFunc adapts a plain text transformation (the filter type
+// used by the original, pre-logger unilog package) to the Filter
+// interface. FilterJSON is a no-op.
+type FilterFunc func(line string) string
+
+// FilterLine calls f(line).
+func (f FilterFunc) FilterLine(line string) string {
+	return f(line)
+}
+
+// FilterJSON does nothing.
+func (f FilterFunc) FilterJSON(line *json.LogLine) {}
+
 // Unilog represents a unilog process. unilog is intended to be used
 // as a standalone application, but is exported as a package to allow
 // users to perform compile-time configuration to simplify deployment.
@@ -50,9 +83,26 @@ type Unilog struct {
 	// Sentry DSN for reporting Unilog errors
 	// If this is unset, unilog will not report errors to Sentry
 	SentryDSN string
+	// The most errors reported to Sentry per minute, across all
+	// actions; any more are dropped (and counted in
+	// unilog.sentry.dropped). 0 means no limit (though Main's
+	// -sentry-rate-limit flag defaults it to DefaultSentryRateLimit).
+	// Panics are always reported.
+	SentryRateLimit int
+	// The most bytes of context (the error message, and a panic's
+	// stack trace) included in error emails and Sentry reports;
+	// anything longer is truncated, ending in a marker. Defaults to
+	// DefaultMaxErrorContext.
+	MaxErrorContext int
 	// StatsdAddress for sending metrics
 	// If this is unset, it wlil default to "127.0.0.1:8200" -> TODO: is this what we want?
 	StatsdAddress string
+	// More addresses to send every metric to as well, e.g. to
+	// dual-write metrics while migrating between statsd agents.
+	ExtraStatsdAddresses []string
+	// The client to report metrics to, instead of the Stats
+	// statsd client. Mainly useful for tests.
+	Metrics Client
 	// The email address from which unilog will send mail on
 	// errors
 	MailTo string
@@ -60,10 +110,35 @@ type Unilog struct {
 	// either MailTo or MailFrom is unset, unilog will not
 	// generate email.
 	MailFrom string
+	// A URL (like a Slack incoming webhook) to POST breakages to as
+	// JSON, as often as they're emailed.
+	WebhookURL string
+	// After notifying about a breakage (by email, webhook and
+	// Sentry), don't notify about further errors until writes have
+	// succeeded again or NotifyThrottle has passed. Defaults to
+	// DefaultNotifyThrottle.
+	NotifyThrottle time.Duration
 
 	// A series of filters which will be applied to each log line
 	// in order
 	Filters []Filter
+	// Notifiers to tell about breakages, in addition to the ones
+	// set up from SentryDSN, MailFrom and MailTo, and WebhookURL
+	Notifiers []Notifier
+
+	// If set, PreProcess is applied to each line as it is read
+	// from the input, before anything else happens to it: before
+	// JSON parsing, UTF-8 sanitization and all of the Filters
+	// (including AusterityFilter's criticality detection, which
+	// therefore sees the preprocessed line). Lines unilog writes
+	// itself, like heartbeats, aren't preprocessed.
+	PreProcess func(line string) string
+	// If set, OnDrop is called with every line unilog loses (as
+	// it was read, or as far as it got), after it's counted in
+	// the unilog.lines.dropped metric. See the Drop* constants for
+	// the reasons a line can be dropped. It's called from the
+	// goroutine that writes lines, so it shouldn't block.
+	OnDrop func(line string)
 
 	// The version that unilog will report on the command-line and
 	// in error emails. Defaults to the toplevel Version constant.
@@ -73,15 +148,294 @@ type Unilog struct {
 	// to unilog over a pipe, the kernel also maintains an
 	// in-kernel pipe buffer, sized 64kb on Linux.
 	BufferLines int
+	// Lines longer than MaxLineBytes (not counting the newline)
+	// are cut down to that many bytes, followed by
+	// TruncatedLineMarker, and counted in unilog.lines.truncated;
+	// the rest of the line is read and discarded, rather than
+	// kept in memory. A truncated JSON line is no longer valid
+	// JSON, so it's handled according to JSONParseFailure. 0 means
+	// no limit.
+	MaxLineBytes int
+	// How often to report how full that buffer is, with the
+	// unilog.buffer.depth and unilog.buffer.capacity gauges.
+	// Defaults to DefaultBufferStatsInterval; negative disables
+	// the gauges.
+	BufferStatsInterval time.Duration
 	// Whether unilog expects log line input as JSON or as plain
 	// text.
 	JSON        bool
 	jsonEncoder *encjson.Encoder
+	// Whether to write the input through byte for byte, including
+	// the newline at the end of each line (if any), without
+	// trimming and re-adding it. Raw mode skips everything else
+	// unilog does to lines: the Filters, UTF-8 sanitization and
+	// transcoding, JSON and MaxLineAge are all ignored. Only
+	// PreProcess, which the program embedding unilog sets, is still
+	// applied. It can't be used with SpillDir.
+	Raw bool
+
+	// For trying out filters against sample input: lines are
+	// written to stdout instead of the target, each line read and
+	// what the filters made of it are shown on stderr, and metrics,
+	// Sentry, email and other notifications, the dead-letter file
+	// and the sample target are all disabled.
+	DryRun bool
+	// Whether to wrap plain text input lines in JSON objects, as
+	// {"message": line}, and process and write them as JSON lines.
+	// Implies JSON.
+	WrapJSON bool
+	// What to do with input lines that aren't valid JSON in JSON
+	// mode: one of the JSONParseFailure* policies. Defaults to
+	// JSONParseFailureText.
+	JSONParseFailure string
+	// The byte(s) written after each line. Defaults to "\n",
+	// regardless of how input lines are delimited.
+	OutputDelimiter string
+	// The precision of the float epoch timestamps written in JSON
+	// mode: one of "s", "ms", "us" or "ns". Defaults to "ns".
+	TimestampPrecision string
+
+	// The rate at which to sample the time each write to the
+	// target takes, reported as the unilog.write.duration timing.
+	// Defaults to DefaultWriteTimingRate; negative disables the
+	// metric.
+	WriteTimingRate float64
+
+	// Whether to check each line for invalid UTF-8 before writing
+	// it. Invalid sequences are replaced with U+FFFD, or, if
+	// DropInvalidUTF8 is set, the whole line is dropped.
+	SanitizeUTF8    bool
+	DropInvalidUTF8 bool
+
+	// Set each JSON line's "host" field, unless it already has
+	// one, to the hostname, before any of the Filters run.
+	AddHost bool
+
+	// The character set of the input (see inputCharsets). Lines are
+	// transcoded from it to UTF-8 right after PreProcess (which
+	// therefore sees them untranscoded), before anything else is
+	// done with them. Empty (the default) means the input is UTF-8
+	// already.
+	InputCharset string
+
+	// Drop lines whose event timestamp (a JSON line's timestamp,
+	// or a text line's "[2006-01-02 15:04:05.000000] " prefix) is
+	// more than MaxLineAge old by the time they are about to be
+	// written, e.g. after a long write stall. Lines without a
+	// timestamp are always written. 0 disables expiry.
+	MaxLineAge time.Duration
+
+	// If no line has been written for HeartbeatInterval, write
+	// HeartbeatLine (which goes through the filters like any other
+	// line), so that downstream monitoring can tell the log is
+	// alive. HeartbeatLine defaults to DefaultHeartbeatLine, or
+	// DefaultJSONHeartbeatLine in JSON mode. 0 disables heartbeats.
+	HeartbeatInterval time.Duration
+	HeartbeatLine     string
+
+	// If the target buffers output (like the gzip stream written
+	// to stdout when the target is "-" and CompressBackups is set)
+	// and nothing has been written to it for IdleFlush, flush it,
+	// so that lines don't sit in the buffer indefinitely when the
+	// volume is low. 0 disables idle flushes.
+	IdleFlush time.Duration
+
+	// Output to a target file is buffered in WriteBuffer bytes (0
+	// writes every line straight through, with a write(2) each),
+	// and flushed at most WriteFlushInterval (default
+	// DefaultWriteFlushInterval) after the first line that was
+	// buffered, as well as on reopening and on exit. A failure to
+	// write the buffer out is reported with the flush action, and
+	// the lines in it are lost.
+	WriteBuffer        int
+	WriteFlushInterval time.Duration
+
+	// If SyncInterval is positive, the target file is synced
+	// (fsync'd) to disk that often, so a crash loses at most that
+	// much of what was written. With SyncOnBreak, it's also synced
+	// by the first successful write after writes failed. Either
+	// only applies to file targets, not to stdout or network
+	// targets.
+	SyncInterval time.Duration
+	SyncOnBreak  bool
+
+	// How long to wait before reopening a pipe target whose reader
+	// went away (i.e. writing to it failed with EPIPE), or dialing
+	// a network target again after a failure. Lines logged in the
+	// meantime are discarded.
+	PipeRetryDelay time.Duration
+
+	// After CircuitFailures consecutive failures to write to the
+	// target (or after the first write to a network or syslog
+	// target that times out), stop attempting writes for
+	// CircuitCooldown. Lines
+	// logged while the circuit is open are discarded, unless
+	// CircuitBuffer is set, in which case unilog stops reading
+	// input (leaving lines in the in-process and pipe buffers)
+	// until the cool-down is over. 0 disables the circuit breaker.
+	CircuitFailures int
+	CircuitCooldown time.Duration
+	CircuitBuffer   bool
 
 	Name    string
 	Verbose bool
 	Debug   bool
 
+	// Where Verbose echoes lines to. Defaults to stdout, which
+	// doesn't mix well with a target of "-". /dev/fd/N paths can be
+	// used to echo to an inherited file descriptor.
+	VerboseFile string
+
+	// Verbose output is buffered in VerboseBuffer bytes (0 writes
+	// every line straight through, though Main's -verbose-buffer
+	// flag defaults it to DefaultVerboseBuffer), and flushed at most
+	// VerboseFlushInterval after the first line that was buffered.
+	VerboseBuffer        int
+	VerboseFlushInterval time.Duration
+
+	// After a SIGTERM, unilog drains its input up to the end of the
+	// current line, and exits at EOF. If that hasn't happened within
+	// TermDrainTimeout (e.g. because the producer went quiet
+	// mid-line), unilog writes out the lines it has already read and
+	// exits, without waiting for a SIGQUIT. 0 (the default) waits
+	// for EOF or a SIGQUIT, however long that takes.
+	TermDrainTimeout time.Duration
+
+	// The signals that make unilog reopen its target (like
+	// SIGHUP), shut down (like SIGTERM) and exit right away after
+	// a shutdown signal (like SIGQUIT), as comma-separated names
+	// like "HUP,ALRM", or NoSignals. Each defaults to the
+	// Default*Signals constant if it's empty, and no signal can be
+	// in more than one of them. A default signal left out of all
+	// of them is ignored. Signals aren't handled at all outside of
+	// Main.
+	ReopenSignals string
+	TermSignals   string
+	QuitSignals   string
+
+	// Spill lines read while the in-memory buffer (BufferLines)
+	// is full to a file in SpillDir, instead of leaving them in
+	// the kernel pipe buffer and eventually blocking the producer;
+	// see spillBuffer. The spill file holds at most SpillMaxBytes
+	// (DefaultSpillMaxBytes if 0). Lines are always written in the
+	// order they were read. If TermDrainTimeout runs out, lines
+	// that are still spilled are lost.
+	SpillDir      string
+	SpillMaxBytes int64
+
+	// Read input by following the file at this path (like tail
+	// -F) instead of reading stdin: the file is read from the start,
+	// unilog waits for more data at its end, and the path is
+	// reopened when the file is rotated or truncated. On SIGTERM,
+	// unilog stops reading, as with stdin, after the next newline:
+	// it finishes the line it is in the middle of, or, if the file
+	// ends before that newline, stops at the current end of the
+	// file rather than waiting for the rest.
+	Follow string
+	// Read input from the file or FIFO at this path instead of
+	// stdin, until its end (or until the last writer of the FIFO
+	// closes it). Use Follow to wait for more data at the end of a
+	// file instead. Both name the one input unilog reads, so only
+	// one of them can be set.
+	Input string
+
+	// Don't prefix text lines with a timestamp. This is the
+	// legacy spelling of filters.TimePrefixFilter's Omit option
+	// (-omit-timestamps): when set, Omit is set on every
+	// TimePrefixFilter in Filters.
+	NoTimestamp bool
+
+	// A file to write lines that unilog couldn't process normally
+	// to (see DeadLetter). Optional.
+	DeadLetterPath string
+
+	// A file to copy a random SampleRate fraction of the lines to,
+	// e.g. to build sampled datasets, in addition to writing them
+	// to the target as usual. Lines are copied as they were read
+	// (with a SampleStage of "pre"), or as they are written to the
+	// target, after the Filters (with "post", the default).
+	SampleTarget string
+	SampleRate   float64
+	SampleStage  string
+
+	// Whether to gzip files after they are rotated out. Compression
+	// happens in the background, one file at a time. If the target
+	// is "-", the output written to stdout is gzipped as it is
+	// written instead (see closeStdout).
+	CompressBackups bool
+
+	// A shell command to run (in the background) after a file is
+	// rotated out, e.g. to upload it. See runPostRotateCmd.
+	PostRotateCmd string
+
+	// After each rotation, delete rotated backups (target.N or
+	// target.N.gz) numbered higher than MaxBackups, or last
+	// modified longer than MaxAge ago. 0 disables either limit.
+	MaxBackups int
+	MaxAge     time.Duration
+
+	// Rotate the target (to target.1, shifting older backups to
+	// target.2 and so on) once it is MaxFileBytes long, for boxes
+	// without logrotate. 0 (the default) leaves rotating the target
+	// to something else, which sends a SIGHUP afterwards. Only
+	// applies to target files, and unilog refuses to start with it
+	// and a templated target.
+	MaxFileBytes int64
+
+	// If the target is a template (see templatedTarget), at most
+	// MaxOpenFiles files are kept open at once, and files that
+	// haven't been written to in IdleFileTimeout are closed.
+	MaxOpenFiles    int
+	IdleFileTimeout time.Duration
+
+	// Take an exclusive advisory lock (flock) on the target file,
+	// so that two unilog processes can't write to the same file.
+	// Unilog refuses to start if another process holds the lock.
+	Lock bool
+
+	// Truncate the target when it is first opened, instead of
+	// appending to it. Subsequent reopens (on SIGHUP/SIGALRM, or
+	// after errors) always append, so that lines written during a
+	// log rotation handoff are never clobbered.
+	Truncate bool
+
+	// Write to target.tmp instead of the target, and only rename
+	// it to the target once unilog exits cleanly at the end of its
+	// input, having written every line; otherwise, target.tmp is
+	// removed. This is for batch runs over a finished input, so
+	// that consumers of the target never see a partial file. The
+	// target is replaced, rather than appended to. It needs a
+	// target file (not -, syslog, OTLP or a template).
+	Atomic bool
+
+	// Write lines to syslog at this address instead of to a file:
+	// either a local socket, like /dev/log, or syslog://host:port
+	// (or udp:// or tcp://). Each line is sent as a message with
+	// SyslogFacility (default "user") and SyslogTag (default Name,
+	// or DefaultSyslogTag), at a severity taken from a JSON line's
+	// "level" or "severity" field, or else from the line's
+	// criticality. Reopening and rotating are no-ops, since the
+	// syslog writer reconnects by itself.
+	//
+	// Messages are framed as in RFC 3164 (as written by log/syslog),
+	// unless SyslogFormat is SyslogFormatRFC5424.
+	Syslog         string
+	SyslogFacility string
+	SyslogTag      string
+	SyslogFormat   string
+
+	// Export lines to an OpenTelemetry collector at this endpoint
+	// (like http://localhost:4318) over OTLP/HTTP, instead of
+	// writing them to a file. JSON lines become log records with
+	// their message as the body, their event timestamp as the
+	// time, their criticality as the severity and their other
+	// fields as attributes; text lines become records with just a
+	// body. Records are sent in batches of up to OTLPBatchSize
+	// (default DefaultOTLPBatchSize), or after IdleFlush (default
+	// DefaultOTLPFlushInterval) without a new line.
+	OTLPEndpoint  string
+	OTLPBatchSize int
+
 	lines     <-chan string
 	errs      <-chan error
 	sigReopen <-chan os.Signal
@@ -90,6 +444,54 @@ type Unilog struct {
 	shutdown  chan struct{}
 	file      io.WriteCloser
 	target    string
+	// all the targets, if there's more than one (see multiTarget)
+	targets []string
+
+	deadLetter io.WriteCloser
+	sample     io.WriteCloser
+	// the input, if following a file
+	follow *follower
+	spill  *spillBuffer
+	// fires TermDrainTimeout after a SIGTERM
+	drainTimeout <-chan time.Time
+	// VerboseFile, if set, once opened
+	verbose    io.WriteCloser
+	verboseBuf *bufio.Writer
+	compressor compressor
+	// transcodes from InputCharset, once it's been looked up
+	decoder *encoding.Decoder
+	// fires VerboseFlushInterval after verboseBuf was first written to
+	verboseFlush <-chan time.Time
+	// in-flight post-rotate commands
+	hooks sync.WaitGroup
+	// fires HeartbeatInterval after the last write
+	heartbeat *time.Timer
+	// fires IdleFlush after the last write
+	idleFlush *time.Timer
+	// fires every BufferStatsInterval, if there's a metrics client
+	bufferStats *time.Ticker
+	// fires every SyncInterval
+	syncTicker *time.Ticker
+	// fires WriteFlushInterval after the target's buffer was first
+	// written to
+	writeFlush <-chan time.Time
+	// the hostname, once looked up for AddHost
+	hostname string
+	// the notifiers set up from SentryDSN, MailFrom and MailTo,
+	// and WebhookURL, once there's something to notify about
+	notifiers []Notifier
+	// where DryRun shows lines, instead of stderr (for tests)
+	dryRunOut io.Writer
+	// returns the current time, if set, instead of time.Now (for
+	// tests)
+	now func() time.Time
+	// don't attempt to reopen the target before this time
+	reopenAfter time.Time
+	// whether the target has been successfully opened before
+	opened bool
+	// whether writing to (or opening) the target has ever failed,
+	// for Atomic
+	writeFailed bool
 
 	b struct {
 		broken bool
@@ -97,8 +499,17 @@ type Unilog struct {
 		count  int
 	}
 
+	circuit struct {
+		// consecutive write failures
+		failures  int
+		open      bool
+		openUntil time.Time
+	}
+
 	exit           func(int)
 	shouldShutdown bool
+	// why the event loop stopped; one of the Shutdown* reasons
+	shutdownReason string
 }
 
 func stringFlag(val *string, longname, shortname, init, help string) {
@@ -111,6 +522,43 @@ func boolFlag(val *bool, longname, shortname string, init bool, help string) {
 	flag.BoolVar(val, shortname, init, help)
 }
 
+// clevelPatternValue is a flag.Value that adds each value it's set to
+// as a clevels criticality pattern, so invalid patterns are rejected
+// at startup.
+type clevelPatternValue struct {
+	patterns []string
+}
+
+func (v *clevelPatternValue) Set(pattern string) error {
+	if err := clevels.AddCriticalityPattern(pattern); err != nil {
+		return err
+	}
+	v.patterns = append(v.patterns, pattern)
+	return nil
+}
+
+func (v *clevelPatternValue) String() string {
+	return strings.Join(v.patterns, " ")
+}
+
+// timestampLayoutValue is a flag.Value that adds each value it's set
+// to as a json timestamp layout.
+type timestampLayoutValue struct {
+	layouts []string
+}
+
+func (v *timestampLayoutValue) Set(layout string) error {
+	if err := json.AddTimestampLayout(layout); err != nil {
+		return err
+	}
+	v.layouts = append(v.layouts, layout)
+	return nil
+}
+
+func (v *timestampLayoutValue) String() string {
+	return strings.Join(v.layouts, " ")
+}
+
 func (u *Unilog) fillDefaults() {
 	u.exit = os.Exit
 	if u.Version == "" {
@@ -119,38 +567,235 @@ func (u *Unilog) fillDefaults() {
 	if u.BufferLines == 0 {
 		u.BufferLines = DefaultBuffer
 	}
+	if u.BufferStatsInterval == 0 {
+		u.BufferStatsInterval = DefaultBufferStatsInterval
+	}
+	if u.PipeRetryDelay == 0 {
+		u.PipeRetryDelay = DefaultPipeRetryDelay
+	}
+	if u.CircuitCooldown == 0 {
+		u.CircuitCooldown = DefaultCircuitCooldown
+	}
+	if u.JSONParseFailure == "" {
+		u.JSONParseFailure = JSONParseFailureText
+	}
+	if u.OutputDelimiter == "" {
+		u.OutputDelimiter = "\n"
+	}
+	if u.WriteTimingRate == 0 {
+		u.WriteTimingRate = DefaultWriteTimingRate
+	}
+	if u.TimestampPrecision == "" {
+		u.TimestampPrecision = "ns"
+	}
+}
+
+// fillFlagDefaults sets the fields that Main's flags default to
+// something other than their zero value, unless the embedding program
+// has set them already.
+func (u *Unilog) fillFlagDefaults() {
+	if u.ReopenSignals == "" {
+		u.ReopenSignals = DefaultReopenSignals
+	}
+	if u.TermSignals == "" {
+		u.TermSignals = DefaultTermSignals
+	}
+	if u.QuitSignals == "" {
+		u.QuitSignals = DefaultQuitSignals
+	}
+	if u.VerboseBuffer == 0 {
+		u.VerboseBuffer = DefaultVerboseBuffer
+	}
+	if u.VerboseFlushInterval == 0 {
+		u.VerboseFlushInterval = DefaultVerboseFlushInterval
+	}
+	if u.SampleRate == 0 {
+		u.SampleRate = DefaultSampleRate
+	}
+	if u.SampleStage == "" {
+		u.SampleStage = SampleStagePost
+	}
+	if u.MaxOpenFiles == 0 {
+		u.MaxOpenFiles = DefaultMaxOpenFiles
+	}
+	if u.IdleFileTimeout == 0 {
+		u.IdleFileTimeout = DefaultIdleFileTimeout
+	}
+	if u.SpillMaxBytes == 0 {
+		u.SpillMaxBytes = DefaultSpillMaxBytes
+	}
+	if u.NotifyThrottle == 0 {
+		u.NotifyThrottle = DefaultNotifyThrottle
+	}
+	if u.SyslogFacility == "" {
+		u.SyslogFacility = "user"
+	}
+	if u.SyslogFormat == "" {
+		u.SyslogFormat = SyslogFormatRFC3164
+	}
+	if u.OTLPBatchSize == 0 {
+		u.OTLPBatchSize = DefaultOTLPBatchSize
+	}
+	if u.SentryRateLimit == 0 {
+		u.SentryRateLimit = DefaultSentryRateLimit
+	}
+	if u.MaxErrorContext == 0 {
+		u.MaxErrorContext = DefaultMaxErrorContext
+	}
+	if u.WriteFlushInterval == 0 {
+		u.WriteFlushInterval = DefaultWriteFlushInterval
+	}
 }
 
 func (u *Unilog) addFlags() {
+	u.fillFlagDefaults()
 	stringFlag(&u.Name, "name", "a", "", "Name of logged program")
 	boolFlag(&u.Verbose, "verbose", "v", false, "Echo lines to stdout")
 	boolFlag(&u.Debug, "debug", "d", false, "Print debug messages")
+	flag.StringVar(&u.VerboseFile, "verbose-file", u.VerboseFile, "(optional) File to echo lines to in verbose mode, instead of stdout")
+	flag.IntVar(&u.VerboseBuffer, "verbose-buffer", u.VerboseBuffer, "Bytes of verbose output to buffer; 0 writes every line immediately")
+	flag.DurationVar(&u.VerboseFlushInterval, "verbose-flush-interval", u.VerboseFlushInterval, "Longest time verbose output is buffered for")
+	boolFlag(&u.NoTimestamp, "notimestamp", "n", u.NoTimestamp, "Don't prefix lines with a timestamp (same as -omit-timestamps)")
+	flag.StringVar(&u.DeadLetterPath, "deadletter", u.DeadLetterPath, "(optional) File to write lines that couldn't be processed to")
+	flag.StringVar(&u.SampleTarget, "sample-target", u.SampleTarget, "(optional) File to copy a random sample of lines to")
+	flag.Float64Var(&u.SampleRate, "sample-rate", u.SampleRate, "Fraction of lines to copy to -sample-target")
+	flag.StringVar(&u.SampleStage, "sample-stage", u.SampleStage, `Copy lines to -sample-target as read ("pre") or as written, after filtering ("post")`)
+	flag.BoolVar(&u.CompressBackups, "compress", u.CompressBackups, "Gzip rotated log files in the background")
+	flag.StringVar(&u.PostRotateCmd, "post-rotate-cmd", u.PostRotateCmd, `(optional) Shell command to run after a file is rotated out; it gets the file's path as "$1" and $UNILOG_ROTATED_FILE`)
+	flag.IntVar(&u.MaxBackups, "max-backups", u.MaxBackups, "(optional) Number of rotated log files to keep")
+	flag.Int64Var(&u.MaxFileBytes, "maxbytes", u.MaxFileBytes, "(optional) Rotate the target to dstfile.1 once it is this many bytes long")
+	flag.IntVar(&u.MaxOpenFiles, "max-open-files", u.MaxOpenFiles, "With a templated target, maximum number of files to keep open")
+	flag.DurationVar(&u.IdleFileTimeout, "idle-file-timeout", u.IdleFileTimeout, "With a templated target, close files that haven't been written to for this long")
+	flag.DurationVar(&u.MaxAge, "max-age", u.MaxAge, "(optional) Delete rotated log files older than this")
+	flag.Var(signalsFlag{&u.ReopenSignals}, "reopen-signals", "Comma-separated signals that make unilog reopen its target (empty for none)")
+	flag.Var(signalsFlag{&u.TermSignals}, "term-signals", "Comma-separated signals that make unilog drain its input and exit (empty for none)")
+	flag.Var(signalsFlag{&u.QuitSignals}, "quit-signals", "Comma-separated signals that make unilog exit right away after a -term-signals signal (empty for none)")
+	flag.DurationVar(&u.TermDrainTimeout, "term-drain-timeout", u.TermDrainTimeout, "(optional) After SIGTERM, exit once this much time has passed, even if the input hasn't been drained and no SIGQUIT was received")
+	flag.StringVar(&u.SpillDir, "spill-dir", u.SpillDir, "(optional) Directory to spill lines to while the in-memory buffer is full, instead of blocking the producer")
+	flag.Int64Var(&u.SpillMaxBytes, "spill-max-bytes", u.SpillMaxBytes, "Maximum size of the spill file, in bytes")
+	flag.StringVar(&u.Follow, "follow", u.Follow, "(optional) Follow this file as the input, like tail -F, instead of reading stdin")
+	flag.StringVar(&u.Input, "input", u.Input, "(optional) Read this file or FIFO instead of stdin (to wait for more data at its end, pass it to -follow instead)")
+	flag.BoolVar(&u.Lock, "lock", u.Lock, "Take an exclusive lock on the target file, and exit if another process holds it")
+	flag.BoolVar(&u.Truncate, "truncate", u.Truncate, "Truncate the target when first opening it (reopens always append)")
+	flag.BoolVar(&u.Atomic, "atomic", u.Atomic, "Write to dstfile.tmp, and rename it to dstfile only after cleanly reaching the end of the input")
 	flag.StringVar(&u.MailFrom, "mailfrom", u.MailFrom, "Address to send error emails from")
 	flag.StringVar(&u.MailTo, "mailto", u.MailTo, "Address to send error emails to")
+	flag.DurationVar(&u.NotifyThrottle, "notifythrottle", u.NotifyThrottle, "After emailing about an error, don't email about further ones for this long (unless writes succeed in the meantime)")
+	flag.StringVar(&u.WebhookURL, "webhook-url", u.WebhookURL, "(optional) URL (e.g. a Slack incoming webhook) to POST errors to as JSON, as often as they're emailed")
+	flag.StringVar(&u.Syslog, "syslog", u.Syslog, "(optional) Write lines to syslog at this address (e.g. /dev/log or syslog://host:514) instead of to dstfile")
+	flag.StringVar(&u.SyslogFacility, "syslog-facility", u.SyslogFacility, "Syslog facility to write lines with (e.g. daemon, local0)")
+	flag.StringVar(&u.SyslogTag, "syslog-tag", u.SyslogTag, "Syslog tag to write lines with (defaults to -name, or unilog)")
+	flag.StringVar(&u.SyslogFormat, "syslog-format", u.SyslogFormat, "Syslog message format: rfc3164 or rfc5424")
+	flag.StringVar(&u.OTLPEndpoint, "otlp-endpoint", u.OTLPEndpoint, "(optional) Export lines to an OpenTelemetry collector at this OTLP/HTTP endpoint (e.g. http://localhost:4318) instead of to dstfile")
+	flag.IntVar(&u.OTLPBatchSize, "otlp-batch-size", u.OTLPBatchSize, "Maximum number of log records to export to the OTLP endpoint at once")
 	flag.StringVar(&u.SentryDSN, "sentrydsn", u.SentryDSN, "Sentry DSN to send errors to")
-	flag.StringVar(&u.StatsdAddress, "statsdaddress", "127.0.0.1:8200", "Address to send statsd metrics to")
+	flag.IntVar(&u.SentryRateLimit, "sentry-rate-limit", u.SentryRateLimit, "Maximum errors to send to Sentry per minute; 0 means no limit")
+	flag.IntVar(&u.MaxErrorContext, "max-error-context", u.MaxErrorContext, "Maximum bytes of error context to include in error emails and Sentry reports")
+	u.StatsdAddress = "127.0.0.1:8200"
+	flag.Var(&statsdAddressFlag{u: u}, "statsdaddress", "Address to send statsd metrics to (host:port, udp://host:port or [ipv6]:port; only UDP is supported); repeat to send them to several addresses")
+	flag.BoolVar(&u.DryRun, "dryrun", u.DryRun, "Write lines to stdout instead of the target, show each line before and after filtering on stderr, and send no metrics or notifications")
+	flag.BoolVar(&u.Raw, "raw", u.Raw, "Write input through unchanged, byte for byte (skips all filters)")
+	flag.BoolVar(&u.WrapJSON, "wrap-json", u.WrapJSON, `Wrap text lines in JSON objects ({"message": line}) and write them as JSON`)
+	flag.StringVar(&u.JSONParseFailure, "json-parse-failure", u.JSONParseFailure, "What to do with lines that aren't valid JSON: text, drop, deadletter or error")
+	quoted := strconv.Quote(u.OutputDelimiter)
+	flag.StringVar(&outputdelimiter, "output-delimiter", quoted[1:len(quoted)-1], `Byte(s) to terminate each written line with, with Go escapes (e.g. "\x1e")`)
+	flag.StringVar(&fieldorder, "field-order", "", `(optional) JSON fields to write first, after the timestamp, in order (format: "service,message")`)
+	flag.StringVar(&jsoncollisions, "json-collisions", json.CollisionFirstWins, "How filters that add fields to JSON lines resolve collisions with existing fields: first-wins, last-wins or array-merge")
+	flag.StringVar(&u.TimestampPrecision, "ts-precision", u.TimestampPrecision, "Precision of JSON timestamps (s, ms, us or ns)")
+	flag.Var(&timestampLayoutValue{}, "ts-layout", `(optional) Additional layout (in Go's reference time) to parse JSON timestamp strings with, e.g. "Jan _2 2006 15:04:05"; may be repeated`)
+	flag.StringVar(&timestampfield, "json-timestamp-field", json.DefaultTimestampField, `Field to write JSON timestamps to (e.g. "@timestamp")`)
+	flag.Float64Var(&u.WriteTimingRate, "write-timing-rate", u.WriteTimingRate, "Sample rate for the unilog.write.duration metric (negative disables it)")
+	flag.BoolVar(&u.SanitizeUTF8, "sanitize-utf8", u.SanitizeUTF8, "Replace invalid UTF-8 sequences in lines with U+FFFD")
+	flag.BoolVar(&u.DropInvalidUTF8, "drop-invalid-utf8", u.DropInvalidUTF8, "With -sanitize-utf8, drop lines containing invalid UTF-8 instead")
+	flag.BoolVar(&u.AddHost, "add-host", u.AddHost, `Set a "host" field with the hostname on JSON lines without one`)
+	flag.StringVar(&u.InputCharset, "input-charset", u.InputCharset, "(optional) Character set of the input (e.g. latin1), to transcode to UTF-8")
+	flag.DurationVar(&u.MaxLineAge, "max-line-age", u.MaxLineAge, "(optional) Drop lines whose timestamp is older than this when they are written")
+	flag.DurationVar(&u.HeartbeatInterval, "heartbeat-interval", u.HeartbeatInterval, "(optional) Write a heartbeat line if no lines were written for this long")
+	flag.StringVar(&u.HeartbeatLine, "heartbeat-line", u.HeartbeatLine, "The heartbeat line to write (defaults to "+DefaultHeartbeatLine+", or "+DefaultJSONHeartbeatLine+" in JSON mode)")
+	flag.IntVar(&u.MaxLineBytes, "max-line-bytes", u.MaxLineBytes, "(optional) Truncate lines longer than this many bytes")
+	flag.DurationVar(&u.BufferStatsInterval, "buffer-stats-interval", u.BufferStatsInterval, "How often to report the depth of the in-memory line buffer (negative disables it)")
+	flag.DurationVar(&u.IdleFlush, "idle-flush", u.IdleFlush, "(optional) Flush buffered output (e.g. with -compress and a target of -) if nothing was written for this long")
+	flag.IntVar(&u.WriteBuffer, "writebuffer", u.WriteBuffer, "(optional) Bytes of output to the target file to buffer; 0 writes every line immediately")
+	flag.DurationVar(&u.WriteFlushInterval, "write-flush-interval", u.WriteFlushInterval, "Longest time output to the target file is buffered for, with -writebuffer")
+	flag.DurationVar(&u.SyncInterval, "syncinterval", u.SyncInterval, "(optional) Sync the target file to disk this often")
+	flag.BoolVar(&u.SyncOnBreak, "synconbreak", u.SyncOnBreak, "Sync the target file to disk once writing to it works again after failing")
+	flag.DurationVar(&u.PipeRetryDelay, "pipe-retry-delay", u.PipeRetryDelay, "How long to wait before reopening a pipe target after its reader went away, or dialing a network target again")
+	flag.IntVar(&u.CircuitFailures, "circuit-failures", u.CircuitFailures, "Stop writing for a cool-down period after this many consecutive write failures (0 disables)")
+	flag.DurationVar(&u.CircuitCooldown, "circuit-cooldown", u.CircuitCooldown, "How long to stop writing for once -circuit-failures is reached")
+	flag.BoolVar(&u.CircuitBuffer, "circuit-buffer", u.CircuitBuffer, "Stop reading input instead of discarding lines while writes are stopped")
 	flag.StringVar(&clevels.AusterityFile, "austerityfile", clevels.AusterityFile, "(optional) Location of file to read austerity level from")
+	flag.StringVar(&clevels.EmergencyAusterityFile, "emergencyausterityfile", clevels.EmergencyAusterityFile, "(optional) Location of file to read an emergency austerity level from; it can only raise the austerity level")
+	flag.Var(&clevelPatternValue{}, "clevel-pattern", `(optional) Regular expression whose first capture group is a text line's criticality level, by name or 0-3 (e.g. "\bseverity=(\w+)"); may be repeated`)
 	stringFlag(&statstags, "statstags", "s", "", `(optional) tags to include with all statsd metrics except those about the box's austerity levels (format: "foo:bar,baz:quz")`)
 	flag.StringVar(&independenttags, "independenttags", "", `(optional) tags to emit an independent metric for (format: "foo:bar,baz:quz" results in metrics "metricName.foo" and "metricName.baz")`)
 	stringFlag(&cleveltags, "cleveltags", "", "", `(optional) tags to include with austerity statsd metrics. This applies to the "unilog.errors.load_level" and "unilog.austerity.box" metrics.`)
 }
 
-var emailTemplate = template.Must(template.New("email").Parse(`From: {{.From}}
-To: {{.To}}
-Subject: [unilog] {{.Name}} could not {{.Action}}
-
-Hi there,
+// Policies for handling lines that can't be parsed as JSON in JSON
+// mode.
+const (
+	// JSONParseFailureText writes the line as plain text.
+	JSONParseFailureText = "text"
+	// JSONParseFailureDrop discards the line.
+	JSONParseFailureDrop = "drop"
+	// JSONParseFailureDeadLetter writes the line to the
+	// dead-letter file.
+	JSONParseFailureDeadLetter = "deadletter"
+	// JSONParseFailureError discards the line and reports it as
+	// an error (see handleError).
+	JSONParseFailureError = "error"
+)
 
-This is unilog reporting from {{.Hostname}}. I'm sad to report that
-{{.Name}} is having some troubles writing to its log. I got caught up
-trying to log a line to {{.Target}}.
+// Reasons for the event loop to stop, as reported in the
+// unilog.shutdown metric.
+const (
+	// ShutdownEOF means the input was closed.
+	ShutdownEOF = "eof"
+	// ShutdownDrained means the input was read up to the end of
+	// a line after a SIGTERM.
+	ShutdownDrained = "drained"
+	// ShutdownQuit means a SIGQUIT was received after a SIGTERM.
+	ShutdownQuit = "quit"
+	// ShutdownReadError means reading the input failed.
+	ShutdownReadError = "read_error"
+	// ShutdownDrainTimeout means the input wasn't drained within
+	// TermDrainTimeout of a SIGTERM.
+	ShutdownDrainTimeout = "drain_timeout"
+)
 
-To avoid spamming you, I'm going to shut up for an hour. Please fix me.
+// Exit codes, which let a supervisor distinguish a clean stop from a
+// forced one or a crash. Flag and setup errors exit with 1, and a Go
+// runtime panic exits with 2, so none of these use them.
+const (
+	// ExitOK is returned after the input reached EOF, including
+	// after draining on SIGTERM.
+	ExitOK = 0
+	// ExitQuit is returned when a SIGQUIT following a SIGTERM
+	// forced unilog to exit before draining its input.
+	ExitQuit = 6
+	// ExitReadError is returned when reading the input failed.
+	ExitReadError = 3
+	// ExitDrainTimeout is returned when the input wasn't drained
+	// within TermDrainTimeout of a SIGTERM.
+	ExitDrainTimeout = 4
+	// ExitAtomicIncomplete is returned when the input reached EOF
+	// in Atomic mode, but not every line could be written, so the
+	// target wasn't replaced.
+	ExitAtomicIncomplete = 5
+)
 
-{{.Error}}
---
-Sent from unilog {{.Version}}
-`))
+// exitCode returns the exit code for a shutdown reason.
+func exitCode(reason string) int {
+	switch reason {
+	case ShutdownQuit:
+		return ExitQuit
+	case ShutdownReadError:
+		return ExitReadError
+	case ShutdownDrainTimeout:
+		return ExitDrainTimeout
+	}
+	return ExitOK
+}
 
 const (
 	// Version is the Unilog version. Reported in emails and in
@@ -160,6 +805,41 @@ const (
 	// DefaultBuffer is the default size (in lines) of the
 	// in-process line buffer
 	DefaultBuffer = 1 << 12
+	// DefaultBufferStatsInterval is the default interval between
+	// reports of the line buffer's depth
+	DefaultBufferStatsInterval = 10 * time.Second
+	// TruncatedLineMarker ends lines that were cut down to
+	// MaxLineBytes
+	TruncatedLineMarker = "…(truncated)"
+	// DefaultPipeRetryDelay is the default time to wait before
+	// reopening a pipe target that returned EPIPE
+	DefaultPipeRetryDelay = 5 * time.Second
+	// DefaultCircuitCooldown is the default time to stop writing
+	// for once the circuit breaker opens
+	DefaultCircuitCooldown = 30 * time.Second
+	// DefaultWriteTimingRate is the default sample rate for the
+	// unilog.write.duration metric
+	DefaultWriteTimingRate = 0.01
+	// DefaultHeartbeatLine and DefaultJSONHeartbeatLine are the
+	// default heartbeat lines in text and JSON mode
+	DefaultHeartbeatLine     = "(heartbeat)"
+	DefaultJSONHeartbeatLine = `{"heartbeat":true}`
+	// DefaultSentryRateLimit is the default limit on errors sent
+	// to Sentry per minute
+	DefaultSentryRateLimit = 10
+	// DefaultMaxErrorContext is the default limit on the context
+	// included in error emails and Sentry reports
+	DefaultMaxErrorContext = 8 << 10
+	// DefaultVerboseBuffer and DefaultVerboseFlushInterval are the
+	// default buffer size and flush interval of verbose output
+	DefaultVerboseBuffer        = 1 << 16
+	DefaultVerboseFlushInterval = time.Second
+	// DefaultNotifyThrottle is the default time to stay quiet for
+	// after notifying about a breakage
+	DefaultNotifyThrottle = time.Hour
+	// DefaultWriteFlushInterval is the default longest time output
+	// is buffered for, with WriteBuffer
+	DefaultWriteFlushInterval = 100 * time.Millisecond
 )
 
 var (
@@ -178,8 +858,22 @@ func SetCommitDate(date time.Time) {
 	commitDate = date.Format("2006-01-02T15:04:05Z")
 }
 
-// Stats is Unilog's statsd client.
-var Stats *statsd.Client
+// Stats is Unilog's statsd client. Main sets it up (from
+// -statsdaddress), and uses it for any Unilog without a Metrics client
+// of its own.
+var Stats Client
+
+// stats returns the client to report u's metrics to, or nil if there
+// is none.
+func (u *Unilog) stats() Client {
+	if u.Metrics != nil {
+		return u.Metrics
+	}
+	if Stats != nil {
+		return Stats
+	}
+	return nil
+}
 
 // tagPair is a simple pair of a tag t and the full metric name n
 type tagPair struct {
@@ -199,7 +893,19 @@ type independentTags struct {
 }
 
 func newIndependentTags(tags []string) *independentTags {
-	return &independentTags{Tags: tags, metricsTable: make(map[string][]tagPair)}
+	trimmed := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		parts := strings.SplitN(tag, ":", 2)
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		// Skip entirely-empty entries, e.g. from a trailing comma
+		if parts[0] == "" {
+			continue
+		}
+		trimmed = append(trimmed, strings.Join(parts, ":"))
+	}
+	return &independentTags{Tags: trimmed, metricsTable: make(map[string][]tagPair)}
 }
 
 func setupIndependentTags() *independentTags {
@@ -218,7 +924,7 @@ func (it *independentTags) GetTags(metricName string) []tagPair {
 	}
 	tags = make([]tagPair, 0, len(it.Tags))
 	for _, tag := range it.Tags {
-		prefix := strings.Split(tag, ":")[0]
+		prefix := strings.TrimSpace(strings.Split(tag, ":")[0])
 		// If we don't get a token (e.g. we are passed the empty string), skip this tag
 		if len(prefix) == 0 {
 			continue
@@ -235,9 +941,11 @@ func (it *independentTags) GetTags(metricName string) []tagPair {
 var tagState *independentTags
 
 // Client is the interface for our metrics client for use in independent metric emission
-// Currently, only IndependentCount is implemented
 type Client interface {
 	Count(name string, value int64, tags []string, rate float64) error
+	Gauge(name string, value float64, tags []string, rate float64) error
+	Histogram(name string, value float64, tags []string, rate float64) error
+	Timing(name string, value time.Duration, tags []string, rate float64) error
 }
 
 // IndependentCount is a wrapper for the statsd.Count method. It will emit the normal metric
@@ -262,24 +970,89 @@ func IndependentCount(client Client, name string, value int64, tags []string, ra
 	return nil
 }
 
-func readlines(in io.Reader, bufsize int, shutdown chan struct{}) (<-chan string, <-chan error) {
-	linec := make(chan string, bufsize)
+// IndependentHistogram is a wrapper for the statsd.Histogram method,
+// emitting independent metrics the same way IndependentCount does.
+func IndependentHistogram(client Client, name string, value float64, tags []string, rate float64) error {
+	err := client.Histogram(name, value, tags, rate)
+	if err != nil {
+		return err
+	}
+	for _, pair := range tagState.GetTags(name) {
+		err = client.Histogram(pair.n, value, append(tags, pair.t), rate)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IndependentGauge is a wrapper for the statsd.Gauge method,
+// emitting independent metrics the same way IndependentCount does.
+func IndependentGauge(client Client, name string, value float64, tags []string, rate float64) error {
+	err := client.Gauge(name, value, tags, rate)
+	if err != nil {
+		return err
+	}
+	for _, pair := range tagState.GetTags(name) {
+		err = client.Gauge(pair.n, value, append(tags, pair.t), rate)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IndependentTiming is a wrapper for the statsd.Timing method,
+// emitting independent metrics the same way IndependentCount does.
+func IndependentTiming(client Client, name string, value time.Duration, tags []string, rate float64) error {
+	err := client.Timing(name, value, tags, rate)
+	if err != nil {
+		return err
+	}
+	for _, pair := range tagState.GetTags(name) {
+		err = client.Timing(pair.n, value, append(tags, pair.t), rate)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readlines reads lines from in in the background, stopping
+// gracefully once u.shutdown becomes readable.
+func (u *Unilog) readlines(in io.Reader) (<-chan string, <-chan error) {
+	tags := []string{"mode:" + u.inputMode()}
+	stats := u.stats()
+	linec := make(chan string, u.BufferLines)
 	errc := make(chan error, 1)
 
-	u := reader.NewReader(in, shutdown)
-	r := bufio.NewReader(u)
+	out := linec
+	if u.spill != nil {
+		out = make(chan string)
+		go u.spill.run(out, linec)
+	}
+
+	r := bufio.NewReader(reader.NewReader(in, u.shutdown))
 
 	go func() {
 		var err error
 		var s string
 
 		for err == nil {
-			s, err = r.ReadString('\n')
+			var truncated bool
+			s, truncated, err = readLine(r, u.MaxLineBytes)
+			if truncated && stats != nil {
+				IndependentCount(stats, "unilog.lines.truncated", 1, tags, 1)
+			}
 			if s != "" {
-				s = strings.TrimRight(s, "\n")
-				linec <- s
-				if Stats != nil {
-					IndependentCount(Stats, "unilog.bytes", int64(len(s)), nil, .1)
+				if !u.Raw {
+					s = strings.TrimRight(s, "\n")
+				}
+				send(out, s, stats, tags)
+				// (a multiTarget counts the bytes written to
+				// each of its targets instead)
+				if stats != nil && len(u.targets) <= 1 {
+					IndependentCount(stats, "unilog.bytes", int64(len(s)), tags, .1)
 				}
 			}
 		}
@@ -287,28 +1060,221 @@ func readlines(in io.Reader, bufsize int, shutdown chan struct{}) (<-chan string
 		if err != io.EOF {
 			errc <- err
 		}
-		close(linec)
+		close(out)
 	}()
 
 	return linec, errc
 }
 
+// readLine reads a line from r, including the newline (if any). If
+// max is positive and the line is longer than that, it's cut down to
+// max bytes (at a UTF-8 character boundary) followed by
+// TruncatedLineMarker, and the rest of it is discarded as it's read,
+// so the next call starts at the next line.
+func readLine(r *bufio.Reader, max int) (line string, truncated bool, err error) {
+	if max <= 0 {
+		line, err = r.ReadString('\n')
+		return line, false, err
+	}
+	var buf []byte
+	nl := false
+	for {
+		frag, e := r.ReadSlice('\n')
+		if e == nil {
+			frag = frag[:len(frag)-1]
+			nl = true
+		}
+		if !truncated {
+			if room := max - len(buf); len(frag) > room {
+				cut := room
+				for cut > 0 && !utf8.RuneStart(frag[cut]) {
+					cut--
+				}
+				buf = append(buf, frag[:cut]...)
+				buf = append(buf, TruncatedLineMarker...)
+				truncated = true
+			} else {
+				buf = append(buf, frag...)
+			}
+		}
+		if e != bufio.ErrBufferFull {
+			err = e
+			break
+		}
+	}
+	if nl {
+		buf = append(buf, '\n')
+	}
+	return string(buf), truncated, err
+}
+
+// reportBufferStats reports how many lines are waiting in the
+// in-memory buffer, and how many it can hold.
+func (u *Unilog) reportBufferStats() {
+	stats := u.stats()
+	if stats == nil {
+		return
+	}
+	tags := []string{"mode:" + u.inputMode()}
+	IndependentGauge(stats, "unilog.buffer.depth", float64(len(u.lines)), tags, 1)
+	IndependentGauge(stats, "unilog.buffer.capacity", float64(cap(u.lines)), tags, 1)
+}
+
+// send sends s to out, blocking until there is room for it. The time
+// spent blocked, if any, is reported in the
+// unilog.reader.blocked_seconds histogram, which shows when the
+// producer is being throttled by a slow target, and the line counted
+// in unilog.buffer.full.
+//
+// With SpillDir, out is unbuffered, and nearly every line waits for
+// the spill buffer to take it, usually only briefly; the time is
+// reported like a sampled per-line metric then, and nothing is
+// counted as full.
+func send(out chan<- string, s string, stats Client, tags []string) {
+	if cap(out) == 0 {
+		start := time.Now()
+		out <- s
+		if stats != nil {
+			IndependentHistogram(stats, "unilog.reader.blocked_seconds", time.Since(start).Seconds(), tags, .1)
+		}
+		return
+	}
+	select {
+	case out <- s:
+		return
+	default:
+	}
+	if stats != nil {
+		// A bounded write would have dropped the line here
+		IndependentCount(stats, "unilog.buffer.full", 1, tags, 1)
+	}
+	start := time.Now()
+	out <- s
+	if stats != nil {
+		IndependentHistogram(stats, "unilog.reader.blocked_seconds", time.Since(start).Seconds(), tags, 1)
+	}
+}
+
+// closeStdout finishes the gzip stream written to stdout, if the
+// target is "-" and CompressBackups is set, so that it is valid gzip
+// (with a trailer) for whatever is reading it.
+func (u *Unilog) closeStdout() {
+	if gz, ok := u.file.(*gzip.Writer); ok {
+		if err := gz.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not finish gzipped output: %s\n", err)
+		}
+		u.file = nil
+	}
+}
+
+// errTargetLocked is returned by reopen if Lock is set and another
+// process holds the lock on the target.
+var errTargetLocked = errors.New("target is locked by another process")
+
 func (u *Unilog) reopen() error {
+	if u.OTLPEndpoint != "" {
+		if u.file != nil {
+			return nil
+		}
+		o, e := u.newOTLPSink()
+		if e != nil {
+			return e
+		}
+		u.file = o
+		return nil
+	}
+
+	if u.Syslog != "" {
+		if u.file != nil {
+			return nil
+		}
+		s, e := u.openSyslog()
+		if e != nil {
+			return e
+		}
+		u.file = s
+		if u.JSON {
+			u.jsonEncoder = encjson.NewEncoder(u.file)
+		}
+		return nil
+	}
+
+	if m, ok := u.file.(*multiTarget); ok {
+		m.reopen()
+		u.dropBuffered()
+		return nil
+	}
+	if len(u.targets) > 1 {
+		m, e := u.newMultiTarget(u.targets)
+		if e != nil {
+			return e
+		}
+		u.file = m
+		if u.JSON {
+			u.jsonEncoder = encjson.NewEncoder(u.file)
+		}
+		return nil
+	}
+
+	if network, addr, ok := parseNetworkTarget(u.target); ok {
+		// Reopening reconnects
+		if u.file != nil {
+			u.file.Close()
+			u.file = nil
+		}
+		t, e := dialNetworkTarget(network, addr, u.PipeRetryDelay)
+		if e != nil {
+			// Don't dial again for every line
+			u.reopenAfter = time.Now().Add(u.PipeRetryDelay)
+			return e
+		}
+		u.file = t
+		if u.JSON {
+			u.jsonEncoder = encjson.NewEncoder(u.file)
+		}
+		return nil
+	}
+
 	if u.target == "-" {
-		u.file = os.Stdout
+		if !u.CompressBackups {
+			u.file = os.Stdout
+		} else if _, ok := u.file.(*gzip.Writer); !ok {
+			// Reopening (on SIGHUP) keeps writing the same
+			// gzip stream.
+			u.file = gzip.NewWriter(os.Stdout)
+		}
+		if u.JSON {
+			u.jsonEncoder = encjson.NewEncoder(u.file)
+		}
+		return nil
+	}
+
+	if isTemplate(u.target) {
+		if t, ok := u.file.(*templatedTarget); ok {
+			// The files are reopened as they're written to
+			t.Close()
+			u.dropBuffered()
+		} else {
+			u.file = newTemplatedTarget(u.target, u.MaxOpenFiles, u.IdleFileTimeout, u.WriteBuffer, u.stats())
+		}
+		if u.JSON {
+			u.jsonEncoder = encjson.NewEncoder(u.file)
+		}
 		return nil
 	}
 
 	if u.file != nil {
+		u.flush()
 		u.file.Close()
 		u.file = nil
 	}
 
-	var e error
-	if u.file, e = os.OpenFile(u.target, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644); e != nil {
-		u.file = nil
+	sf, e := u.openFile(u.targetPath(), !u.opened)
+	if e != nil {
 		return e
 	}
+	u.opened = true
+	u.file = sf
 
 	if u.JSON {
 		u.jsonEncoder = encjson.NewEncoder(u.file)
@@ -316,123 +1282,804 @@ func (u *Unilog) reopen() error {
 	return nil
 }
 
-func (u *Unilog) format(line string) string {
-	for _, filter := range u.Filters {
-		if filter != nil {
-			line = filter.FilterLine(line)
+// openFile opens the target file at path for appending, locking it
+// if Lock is set, and truncating it if this is the first time it's
+// opened and Truncate or Atomic is set.
+func (u *Unilog) openFile(path string, first bool) (*sizedFile, error) {
+	f, e := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if e != nil {
+		return nil, e
+	}
+	if u.Lock {
+		// The lock is released when the file is closed (on
+		// reopen or exit).
+		if e = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); e != nil {
+			f.Close()
+			if e == syscall.EWOULDBLOCK {
+				return nil, fmt.Errorf("%s: %w", path, errTargetLocked)
+			}
+			return nil, e
 		}
 	}
-	return line + "\n"
+	if (u.Truncate || u.Atomic) && first {
+		// Truncate only after taking the lock, so we never
+		// clobber a file another unilog is writing to. An
+		// atomic run's temporary file may be left over from an
+		// earlier run that crashed.
+		if e = f.Truncate(0); e != nil {
+			f.Close()
+			return nil, e
+		}
+	}
+	sf, e := openSizedFile(f)
+	if e != nil {
+		f.Close()
+		return nil, e
+	}
+	if u.WriteBuffer > 0 {
+		sf.buf = bufio.NewWriterSize(f, u.WriteBuffer)
+	}
+	return sf, nil
 }
 
-func (u *Unilog) logLine(line string) {
-	formatted := u.format(line)
-	if u.Verbose {
-		defer io.WriteString(os.Stdout, formatted)
+// sanitize transcodes line from InputCharset, and checks it for
+// invalid UTF-8 if SanitizeUTF8 is set. It returns the line with any
+// byte order mark stripped and any invalid sequences replaced, and
+// false if the line should be dropped instead.
+func (u *Unilog) sanitize(line string) (string, bool) {
+	line = u.transcode(line)
+	if !u.SanitizeUTF8 {
+		return line, true
+	}
+	line = strings.TrimPrefix(line, "\uFEFF")
+	if utf8.ValidString(line) {
+		return line, true
 	}
 
-	var e error
-	if u.file == nil {
-		e = u.reopen()
+	action := "replace"
+	if u.DropInvalidUTF8 {
+		action = "drop"
 	}
-	if e != nil {
-		u.handleError("reopen_file", e)
-		return
+	if stats := u.stats(); stats != nil {
+		IndependentCount(stats, "unilog.invalid_utf8", 1, []string{"action:" + action}, 1)
 	}
-	_, e = io.WriteString(u.file, formatted)
-	if e != nil {
-		u.handleError("write_to_log", e)
-	} else {
-		u.b.broken = false
+	if u.DropInvalidUTF8 {
+		u.drop(line, DropInvalidUTF8)
+		return "", false
 	}
+	return strings.ToValidUTF8(line, "\uFFFD"), true
 }
 
-func (u *Unilog) run() {
-	for {
-		if !u.tick() {
-			return
-		}
+func (u *Unilog) format(line string) string {
+	var b strings.Builder
+	for _, f := range u.filterLines(line) {
+		b.WriteString(f.line + u.outputDelimiter())
 	}
+	return b.String()
 }
 
-func (u *Unilog) logJSON(jsonLine string) {
-	var line json.LogLine
-	err := encjson.Unmarshal(([]byte)(jsonLine), &line)
+func (u *Unilog) outputDelimiter() string {
+	if u.OutputDelimiter == "" {
+		return "\n"
+	}
+	return u.OutputDelimiter
+}
+
+// encodeJSON writes line to the target, followed by the output
+// delimiter.
+func (u *Unilog) encodeJSON(line json.LogLine) error {
+	delim := u.outputDelimiter()
+	if delim == "\n" {
+		return u.jsonEncoder.Encode(line)
+	}
+	b, err := encjson.Marshal(line)
 	if err != nil {
-		// It won't parse, treat it as yolo text:
-		u.logLine(jsonLine)
+		return err
+	}
+	_, err = u.file.Write(append(b, delim...))
+	return err
+}
+
+func (u *Unilog) logLine(line string) {
+	line, ok := u.sanitize(line)
+	if !ok {
 		return
 	}
+	if u.sampling(SampleStagePre) {
+		u.writeSample(line + u.outputDelimiter())
+	}
+	if ts, ok := filters.ParseTimePrefix(line); ok && u.expired(ts) {
+		u.drop(line, DropExpired)
+		return
+	}
+	for _, f := range u.filterLines(line) {
+		u.writeFiltered(f)
+	}
+}
 
+// writeFiltered writes a text line that went through the Filters.
+func (u *Unilog) writeFiltered(f filteredLine) {
+	if f.reason != "" {
+		u.drop(f.source, f.reason)
+	}
+	if u.DryRun {
+		u.showDryRun(f.source, f.line)
+	}
+	formatted := f.line + u.outputDelimiter()
 	if u.Verbose {
-		defer fmt.Printf("%v\n", line)
+		u.echo(formatted)
 	}
-	for _, filter := range u.Filters {
-		if filter != nil {
-			filter.FilterJSON(&line)
-		}
+	if u.sampling(SampleStagePost) {
+		u.writeSample(formatted)
+	}
+	u.writeText(f.source, formatted)
+}
+
+// logRaw writes line to the target exactly as it was read, for Raw
+// mode.
+func (u *Unilog) logRaw(line string) {
+	if u.Verbose {
+		u.echo(line)
 	}
+	u.writeText(line, line)
+}
 
+// writeText writes the formatted text line to the target.
+func (u *Unilog) writeText(line, formatted string) {
+	if u.circuitOpen() {
+		u.drop(line, DropCircuitOpen)
+		return
+	}
+	if u.file == nil && time.Now().Before(u.reopenAfter) {
+		u.drop(line, DropTargetUnavailable)
+		return
+	}
+	openAction, writeAction := u.errorActions()
 	var e error
 	if u.file == nil {
 		e = u.reopen()
 	}
 	if e != nil {
-		u.handleError("reopen_file", e)
+		u.handleError(openAction, e)
+		u.drop(line, DropTargetUnavailable)
 		return
 	}
-	e = u.jsonEncoder.Encode(line)
+	if s, ok := u.file.(*syslogSink); ok {
+		s.severity = criticalitySeverities[clevels.Criticality(line)]
+	}
+	if t, ok := u.file.(*templatedTarget); ok {
+		t.resolveText(line, u.Name)
+	}
+	start := time.Now()
+	_, e = io.WriteString(u.file, formatted)
+	u.reportWriteDuration(start)
+	// With several targets or a template, a write can lose the
+	// buffer of one file even if it succeeds
+	u.dropBuffered()
 	if e != nil {
-		u.handleError("write_to_log", e)
+		u.handleError(writeAction, e)
+		if _, ok := u.file.(*otlpSink); !ok {
+			// The OTLP sink drops its whole batch itself
+			u.drop(line, DropWriteError)
+		}
 	} else {
-		u.b.broken = false
+		u.resetBreaker()
+		u.closeCircuit()
+		u.resetHeartbeat()
+		u.resetIdleFlush()
+		u.scheduleWriteFlush()
+		u.rotateIfFull()
 	}
 }
 
-// "tick" is Unilog's event loop
-// returns true if Unilog should keep running,
-// and false if it should stop.
-func (u *Unilog) tick() bool {
-	select {
-	case e := <-u.errs:
-		if e != nil && e != io.EOF {
-			panic(e)
-		} else {
-			return false
+// resetHeartbeat (re)starts the heartbeat timer after a write.
+func (u *Unilog) resetHeartbeat() {
+	if u.HeartbeatInterval <= 0 {
+		return
+	}
+	if u.heartbeat == nil {
+		u.heartbeat = time.NewTimer(u.HeartbeatInterval)
+		return
+	}
+	if !u.heartbeat.Stop() {
+		// Don't leave a stale tick behind
+		select {
+		case <-u.heartbeat.C:
+		default:
 		}
-	case <-u.sigReopen:
+	}
+	u.heartbeat.Reset(u.HeartbeatInterval)
+}
+
+// resetIdleFlush (re)starts the idle flush timer after a write.
+func (u *Unilog) resetIdleFlush() {
+	if u.IdleFlush <= 0 {
+		return
+	}
+	if u.idleFlush == nil {
+		u.idleFlush = time.NewTimer(u.IdleFlush)
+		return
+	}
+	if !u.idleFlush.Stop() {
+		select {
+		case <-u.idleFlush.C:
+		default:
+		}
+	}
+	u.idleFlush.Reset(u.IdleFlush)
+}
+
+// flusher is implemented by targets that buffer output.
+type flusher interface {
+	Flush() error
+}
+
+// flush writes out any output the target has buffered.
+func (u *Unilog) flush() {
+	f, ok := u.file.(flusher)
+	if !ok {
+		return
+	}
+	e := f.Flush()
+	// Whatever was buffered is lost, in every file that failed
+	u.dropBuffered()
+	if e != nil {
+		u.writeFailed = true
+		u.handleError("flush", e)
+	}
+}
+
+// bufferedTarget is implemented by targets that can lose the lines in
+// their WriteBuffer (or the buffers of the files behind them) when
+// writing it out fails.
+type bufferedTarget interface {
+	// takeLost returns the number of lines lost since the last
+	// call.
+	takeLost() int
+}
+
+// dropBuffered counts the lines that were lost with the target's
+// WriteBuffer when writing it out failed.
+func (u *Unilog) dropBuffered() {
+	if b, ok := u.file.(bufferedTarget); ok {
+		dropLines(u.stats(), b.takeLost(), DropBufferLost)
+	}
+}
+
+// scheduleWriteFlush starts the timer to flush the target's buffer,
+// if WriteBuffer is set and it isn't already running.
+func (u *Unilog) scheduleWriteFlush() {
+	if u.WriteBuffer <= 0 || u.writeFlush != nil {
+		return
+	}
+	interval := u.WriteFlushInterval
+	if interval <= 0 {
+		interval = DefaultWriteFlushInterval
+	}
+	u.writeFlush = time.After(interval)
+}
+
+// syncer is implemented by file targets that can be synced to disk.
+type syncer interface {
+	Sync() error
+}
+
+// sync syncs the target to disk, if it's a file.
+func (u *Unilog) sync() {
+	f, ok := u.file.(syncer)
+	if !ok || u.target == "-" {
+		return
+	}
+	if e := f.Sync(); e != nil {
+		u.handleError("sync", e)
+	}
+}
+
+// resetBreaker resets the error breaker after a successful write,
+// syncing the target if writes had been failing and SyncOnBreak is
+// set.
+func (u *Unilog) resetBreaker() {
+	broken := u.b.broken
+	u.b.broken = false
+	if broken && u.SyncOnBreak {
+		u.sync()
+	}
+}
+
+// writeHeartbeat writes a heartbeat line.
+func (u *Unilog) writeHeartbeat() {
+	line := u.HeartbeatLine
+	if !u.JSON {
+		if line == "" {
+			line = DefaultHeartbeatLine
+		}
+		u.logLine(line)
+	} else {
+		if line == "" {
+			line = DefaultJSONHeartbeatLine
+		}
+		u.logJSON(line)
+	}
+	// Keep beating even if the write failed
+	u.resetHeartbeat()
+}
+
+// expired returns true (and reports it) if a line with the event
+// timestamp ts is too old to be worth writing.
+func (u *Unilog) expired(ts time.Time) bool {
+	if u.MaxLineAge <= 0 || time.Since(ts) <= u.MaxLineAge {
+		return false
+	}
+	if stats := u.stats(); stats != nil {
+		IndependentCount(stats, "unilog.lines.expired", 1, nil, 1)
+	}
+	return true
+}
+
+// countSynthesized reports a JSON line without a usable timestamp,
+// which will be written with the current time instead; fallback is
+// why (json.TimestampMissing or json.TimestampUnparseable). Like
+// unilog.lines_total, it's sampled, since with -wrap-json it counts
+// every line.
+func (u *Unilog) countSynthesized(fallback string) {
+	if stats := u.stats(); stats != nil {
+		IndependentCount(stats, "unilog.timestamp.synthesized", 1, []string{"reason:" + fallback}, .1)
+	}
+}
+
+// reportWriteDuration reports the time since start, when a write to
+// the target began. A multiTarget reports each of its targets' writes
+// itself, tagged with the target.
+func (u *Unilog) reportWriteDuration(start time.Time) {
+	if _, ok := u.file.(*multiTarget); ok {
+		return
+	}
+	if stats := u.stats(); stats != nil && u.WriteTimingRate > 0 {
+		IndependentTiming(stats, "unilog.write.duration", time.Since(start), nil, u.WriteTimingRate)
+	}
+}
+
+// circuitOpen returns true if writes should not be attempted right
+// now because the circuit breaker is open.
+func (u *Unilog) circuitOpen() bool {
+	return u.circuit.open && time.Now().Before(u.circuit.openUntil)
+}
+
+// recordWriteFailure counts a failed write to the target, and opens
+// the circuit breaker (or keeps it open for another cool-down
+// period, if the first write after a cool-down failed) once
+// CircuitFailures consecutive writes have failed.
+func (u *Unilog) recordWriteFailure() {
+	u.circuit.failures++
+	if u.CircuitFailures <= 0 || u.circuit.failures < u.CircuitFailures {
+		return
+	}
+	u.tripCircuit()
+}
+
+// tripCircuit opens the circuit breaker for CircuitCooldown.
+func (u *Unilog) tripCircuit() {
+	u.circuit.openUntil = time.Now().Add(u.CircuitCooldown)
+	if !u.circuit.open {
+		u.circuit.open = true
+		if stats := u.stats(); stats != nil {
+			IndependentCount(stats, "unilog.circuit.open", 1, nil, 1)
+		}
+	}
+}
+
+// closeCircuit resets the circuit breaker after a successful write.
+func (u *Unilog) closeCircuit() {
+	u.circuit.failures = 0
+	if u.circuit.open {
+		u.circuit.open = false
+		if stats := u.stats(); stats != nil {
+			IndependentCount(stats, "unilog.circuit.close", 1, nil, 1)
+		}
+	}
+}
+
+func (u *Unilog) run() {
+	defer func() {
+		// Make sure crashes don't go unnoticed, even if nobody
+		// is looking at stderr
+		if r := recover(); r != nil {
+			u.reportPanic(r)
+			panic(r)
+		}
+	}()
+	for {
+		if !u.tick() {
+			break
+		}
+	}
+	// Write out the lines filters are holding back
+	u.flushFilters(false)
+}
+
+func (u *Unilog) logJSON(jsonLine string) {
+	jsonLine, ok := u.sanitize(jsonLine)
+	if !ok {
+		return
+	}
+	if u.sampling(SampleStagePre) {
+		u.writeSample(jsonLine + u.outputDelimiter())
+	}
+
+	var line json.LogLine
+	err := encjson.Unmarshal(([]byte)(jsonLine), &line)
+	if err != nil {
+		u.handleParseFailure(jsonLine, err)
+		return
+	}
+	u.countLine(ModeJSON)
+	if ts, fallback := line.ParseEventTime(); fallback != "" {
+		u.countSynthesized(fallback)
+	} else if u.expired(ts) {
+		u.drop(jsonLine, DropExpired)
+		return
+	}
+	if u.AddHost {
+		u.addHost(line)
+	}
+
+	marked := jsonDropMarked(line)
+	for _, filter := range u.Filters {
+		if filter != nil {
+			filter.FilterJSON(&line)
+		}
+		if line == nil {
+			if u.DryRun {
+				u.showDryRunJSON(jsonLine, nil)
+			}
+			u.drop(jsonLine, DropFiltered)
+			return
+		}
+	}
+	if reason := jsonDropReason(line, marked); reason != "" {
+		u.drop(jsonLine, reason)
+	}
+	if u.DryRun {
+		u.showDryRunJSON(jsonLine, line)
+	}
+	if u.Verbose {
+		u.echo(fmt.Sprintf("%v\n", line))
+	}
+	if u.sampling(SampleStagePost) {
+		u.sampleJSON(line)
+	}
+
+	if u.circuitOpen() {
+		u.drop(jsonLine, DropCircuitOpen)
+		return
+	}
+	if u.file == nil && time.Now().Before(u.reopenAfter) {
+		u.drop(jsonLine, DropTargetUnavailable)
+		return
+	}
+	openAction, writeAction := u.errorActions()
+	var e error
+	if u.file == nil {
+		e = u.reopen()
+	}
+	if e != nil {
+		u.handleError(openAction, e)
+		u.drop(jsonLine, DropTargetUnavailable)
+		return
+	}
+	if s, ok := u.file.(*syslogSink); ok {
+		s.severity = jsonSeverity(line)
+	}
+	if t, ok := u.file.(*templatedTarget); ok {
+		t.resolveJSON(line)
+	}
+	start := time.Now()
+	o, otlp := u.file.(*otlpSink)
+	if otlp {
+		e = o.export(line, jsonLine)
+	} else {
+		e = u.encodeJSON(line)
+	}
+	u.reportWriteDuration(start)
+	u.dropBuffered()
+	var me *encjson.MarshalerError
+	if errors.As(e, &me) {
+		// The line never made it to the target, so there's
+		// nothing wrong with it:
+		u.DeadLetter(jsonLine, DeadLetterJSONMarshal)
+	} else if e != nil {
+		u.handleError(writeAction, e)
+		if !otlp {
+			u.drop(jsonLine, DropWriteError)
+		}
+	} else {
+		u.resetBreaker()
+		u.closeCircuit()
+		u.resetHeartbeat()
+		u.resetIdleFlush()
+		u.scheduleWriteFlush()
+		u.rotateIfFull()
+	}
+}
+
+// addHost sets line's "host" field to the hostname, unless it already
+// has one. The hostname is only looked up once.
+func (u *Unilog) addHost(line json.LogLine) {
+	if _, ok := line["host"]; ok {
+		return
+	}
+	if u.hostname == "" {
+		u.hostname, _ = os.Hostname()
+		if u.hostname == "" {
+			return
+		}
+	}
+	line["host"] = u.hostname
+}
+
+// Processing modes, which the unilog.lines_total metric is tagged
+// with (as mode:<mode>).
+const (
+	// ModeText lines were read in text mode.
+	ModeText = "text"
+	// ModeJSON lines were read (and parsed) in JSON mode.
+	ModeJSON = "json"
+	// ModeFallback lines were read in JSON mode, but weren't valid
+	// JSON; see JSONParseFailure.
+	ModeFallback = "fallback"
+)
+
+// inputMode returns the mode that input is read in: ModeJSON or
+// ModeText.
+func (u *Unilog) inputMode() string {
+	if u.JSON {
+		return ModeJSON
+	}
+	return ModeText
+}
+
+// countLine counts a line processed in the given mode.
+func (u *Unilog) countLine(mode string) {
+	if stats := u.stats(); stats != nil {
+		IndependentCount(stats, "unilog.lines_total", 1, []string{"mode:" + mode}, .1)
+	}
+}
+
+// handleParseFailure applies the JSONParseFailure policy to a line
+// that isn't valid JSON.
+func (u *Unilog) handleParseFailure(jsonLine string, err error) {
+	policy := u.JSONParseFailure
+	if policy == "" {
+		policy = JSONParseFailureText
+	}
+	if stats := u.stats(); stats != nil {
+		IndependentCount(stats, "unilog.json.parse_failures", 1, []string{"policy:" + policy}, 1)
+	}
+	u.countLine(ModeFallback)
+
+	switch policy {
+	case JSONParseFailureDrop:
+		u.drop(jsonLine, DropParseFailure)
+	case JSONParseFailureDeadLetter:
+		u.DeadLetter(jsonLine, DeadLetterJSONParse)
+	case JSONParseFailureError:
+		u.handleError("parse_json", err)
+	default:
+		// It won't parse, treat it as yolo text:
+		u.logLine(jsonLine)
+	}
+}
+
+// "tick" is Unilog's event loop
+// returns true if Unilog should keep running,
+// and false if it should stop.
+func (u *Unilog) tick() bool {
+	lines := u.lines
+	var cooldown <-chan time.Time
+	if u.CircuitBuffer && u.circuitOpen() {
+		// Leave lines in the buffers until we can write again
+		lines = nil
+		cooldown = time.After(time.Until(u.circuit.openUntil))
+	}
+
+	if u.HeartbeatInterval > 0 && u.heartbeat == nil {
+		u.resetHeartbeat()
+	}
+	var heartbeat <-chan time.Time
+	if u.heartbeat != nil {
+		heartbeat = u.heartbeat.C
+	}
+	var idleFlush <-chan time.Time
+	if u.idleFlush != nil {
+		idleFlush = u.idleFlush.C
+	}
+	if u.BufferStatsInterval > 0 && u.bufferStats == nil && u.stats() != nil {
+		u.bufferStats = time.NewTicker(u.BufferStatsInterval)
+	}
+	var bufferStats <-chan time.Time
+	if u.bufferStats != nil {
+		bufferStats = u.bufferStats.C
+	}
+	if u.SyncInterval > 0 && u.syncTicker == nil {
+		u.syncTicker = time.NewTicker(u.SyncInterval)
+	}
+	var syncTick <-chan time.Time
+	if u.syncTicker != nil {
+		syncTick = u.syncTicker.C
+	}
+	var filterFlush <-chan time.Time
+	if d, ok := u.filterFlushAfter(); ok {
+		filterFlush = time.After(d)
+	}
+
+	select {
+	case <-heartbeat:
+		u.writeHeartbeat()
+	case <-idleFlush:
+		// The timer is restarted by the next write
+		u.flush()
+	case <-u.verboseFlush:
+		u.flushVerbose()
+	case <-u.writeFlush:
+		u.writeFlush = nil
+		u.flush()
+	case <-filterFlush:
+		u.flushFilters(true)
+	case <-bufferStats:
+		u.reportBufferStats()
+	case <-syncTick:
+		u.sync()
+	case <-cooldown:
+	case e := <-u.errs:
+		if e != nil && e != io.EOF {
+			fmt.Fprintf(os.Stderr, "Error reading input: %s\n", e)
+			u.stop(ShutdownReadError)
+		} else {
+			u.stop(u.eofReason())
+		}
+		return false
+	case e := <-u.compressor.errs:
+		u.handleError("compress_backup", e)
+	case r := <-u.otlpResults():
+		u.file.(*otlpSink).finish(r)
+	case <-u.sigReopen:
 		u.reopen()
+		if e := u.reopenDeadLetter(); e != nil {
+			u.handleError("reopen_deadletter", e)
+		}
+		if e := u.reopenSample(); e != nil {
+			u.handleError("reopen_sample", e)
+		}
 	case <-u.sigTerm:
 		select {
 		case u.shutdown <- struct{}{}:
 			u.shouldShutdown = true
+			if u.TermDrainTimeout > 0 {
+				u.drainTimeout = time.After(u.TermDrainTimeout)
+			}
 		default:
 		}
+		if u.follow != nil {
+			// Don't wait for more data once caught up
+			u.follow.stop()
+		}
 	case <-u.sigQuit:
 		if u.shouldShutdown {
-			u.exit(1)
+			u.stop(ShutdownQuit)
+			u.flushFilters(false)
+			u.flush()
+			u.closeStdout()
+			u.finishAtomic()
+			u.flushVerbose()
+			u.exit(exitCode(ShutdownQuit))
 			return false
 		}
-	case line, ok := <-u.lines:
+	case <-u.drainTimeout:
+		u.flushLines()
+		u.stop(ShutdownDrainTimeout)
+		return false
+	case line, ok := <-lines:
 		if !ok {
+			u.stop(u.eofReason())
 			return false
 		}
-		if !u.JSON {
-			u.logLine(line)
-		} else {
-			u.logJSON(line)
-		}
+		u.process(line)
 	}
 	return true
 }
 
+// process logs a line read from the input.
+func (u *Unilog) process(line string) {
+	if u.PreProcess != nil {
+		line = u.PreProcess(line)
+	}
+	if u.Raw {
+		u.countLine(ModeText)
+		u.logRaw(line)
+	} else if u.WrapJSON {
+		u.logJSON(wrapLine(line))
+	} else if !u.JSON {
+		u.countLine(ModeText)
+		u.logLine(line)
+	} else {
+		u.logJSON(line)
+	}
+}
+
+// wrapLine wraps a text line in a JSON object, for WrapJSON.
+func wrapLine(line string) string {
+	b, _ := encjson.Marshal(map[string]string{"message": line})
+	return string(b)
+}
+
+// flushLines logs the lines that have already been read from the
+// input, without waiting for more.
+func (u *Unilog) flushLines() {
+	for {
+		select {
+		case line, ok := <-u.lines:
+			if !ok {
+				return
+			}
+			u.process(line)
+		default:
+			return
+		}
+	}
+}
+
+// eofReason returns the reason for shutting down on reaching the end
+// of input.
+func (u *Unilog) eofReason() string {
+	if u.shouldShutdown {
+		return ShutdownDrained
+	}
+	return ShutdownEOF
+}
+
+// stop records and reports why the event loop is stopping.
+func (u *Unilog) stop(reason string) {
+	u.shutdownReason = reason
+	if u.Debug {
+		fmt.Fprintf(os.Stderr, "Shutting down: %s\n", reason)
+	}
+	if stats := u.stats(); stats != nil {
+		IndependentCount(stats, "unilog.shutdown", 1, []string{"reason:" + reason}, 1)
+	}
+}
+
+// notifyThrottle returns NotifyThrottle, or its default.
+func (u *Unilog) notifyThrottle() time.Duration {
+	if u.NotifyThrottle <= 0 {
+		return DefaultNotifyThrottle
+	}
+	return u.NotifyThrottle
+}
+
+// formatThrottle formats d for the error email, without trailing zero
+// units ("1h" rather than "1h0m0s").
+func formatThrottle(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
 func (u *Unilog) handleError(action string, e error) {
+	now := time.Now()
+	if u.now != nil {
+		now = u.now()
+	}
 	if !u.b.broken {
 		u.b.broken = true
-		u.b.at = time.Now()
+		u.b.at = now
 		u.b.count = 0
-	} else if time.Since(u.b.at) > time.Hour {
-		u.b.at = time.Now()
+	} else if now.Sub(u.b.at) > u.notifyThrottle() {
+		u.b.at = now
 		u.b.count = 0
 	}
 
@@ -440,54 +2087,234 @@ func (u *Unilog) handleError(action string, e error) {
 		fmt.Fprintf(os.Stderr, "Could not %s: %s\n", action, e.Error())
 	}
 
-	if Stats != nil {
-		emsg := fmt.Sprintf("err_action:%s", action)
-		IndependentCount(Stats, "unilog.errors_total", 1, []string{emsg}, 1)
-	}
-
-	if u.b.count == 0 && u.SentryDSN != "" {
-		sentry.WithScope(func(scope *sentry.Scope) {
-			hostname, _ := os.Hostname()
-			scope.SetTags(map[string]string{
-				"Hostname": hostname,
-				"Action":   action,
-				"Name":     u.Name,
-				"Target":   u.target,
-				"Error":    e.Error(),
-				"Version":  Version,
-			})
-			sentry.CaptureException(e)
-		})
+	switch action {
+	case "write_to_log", "reopen_file", syslogWriteAction, syslogOpenAction, otlpWriteAction, otlpOpenAction:
+		u.recordWriteFailure()
+		u.writeFailed = true
 	}
 
-	if u.b.count == 0 && u.MailFrom != "" && u.MailTo != "" {
-		message := new(bytes.Buffer)
-		hostname, _ := os.Hostname()
-		emailTemplate.Execute(message, map[string]string{
-			"Hostname": hostname,
-			"From":     u.MailFrom,
-			"To":       u.MailTo,
-			"Action":   action,
-			"Name":     u.Name,
-			"Target":   u.target,
-			"Error":    e.Error(),
-			"Version":  Version,
-		})
-		cmd := exec.Command("sendmail", "-t")
-		cmd.Stdin = message
-		cmd.Run()
+	tags := []string{fmt.Sprintf("err_action:%s", action)}
+	if errors.Is(e, syscall.EPIPE) {
+		// The reader of our pipe went away; writing again right
+		// away will just fail again, so wait a bit before
+		// reopening the target.
+		tags = append(tags, "reason:epipe")
+		if action != multiTargetWriteAction {
+			// (a multiTarget retries its targets itself)
+			if u.file != nil && u.target != "-" {
+				u.file.Close()
+			}
+			u.file = nil
+			u.reopenAfter = time.Now().Add(u.PipeRetryDelay)
+		}
+	} else if isTimeout(e) {
+		// A peer that stopped reading would hold up the event
+		// loop for every line, so don't wait for CircuitFailures
+		// of them
+		tags = append(tags, "reason:timeout")
+		switch action {
+		case "write_to_log", syslogWriteAction:
+			if u.CircuitFailures > 0 {
+				u.tripCircuit()
+			}
+		}
+	}
+
+	if stats := u.stats(); stats != nil {
+		IndependentCount(stats, "unilog.errors_total", 1, tags, 1)
+	}
+
+	if u.b.count == 0 {
+		u.notify(u.breakContext(action, e.Error(), e))
 	}
 
 	u.b.count++
 }
 
-func setupStatsd(address, fileName, tags string) *statsd.Client {
-	statsd, _ := statsd.New(address)
+// truncatedMarker ends error context that was truncated.
+const truncatedMarker = "\n... (truncated)"
+
+// truncateContext cuts text down to MaxErrorContext bytes, marker
+// included, so that a huge error can't make for a huge email or
+// Sentry report.
+func (u *Unilog) truncateContext(text string) string {
+	return truncateContext(text, u.MaxErrorContext)
+}
+
+// truncateContext cuts text down to max bytes (DefaultMaxErrorContext,
+// if max isn't positive), marker included.
+func truncateContext(text string, max int) string {
+	if max <= 0 {
+		max = DefaultMaxErrorContext
+	}
+	if len(text) <= max {
+		return text
+	}
+	cut := max - len(truncatedMarker)
+	if cut < 0 {
+		return text[:max]
+	}
+	// Don't split a UTF-8 sequence
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut] + truncatedMarker
+}
+
+// panicAction is the action that a panic in the event loop is
+// reported as.
+const panicAction = "keep running"
+
+// reportPanic notifies about a panic in the event loop (r being the
+// value that was recovered), with the stack trace of the panic, like
+// handleError does for errors. Unlike errors, panics are always
+// reported.
+func (u *Unilog) reportPanic(r interface{}) {
+	e, ok := r.(error)
+	if !ok {
+		e = fmt.Errorf("%v", r)
+	}
+	ctx := u.breakContext(panicAction, fmt.Sprintf("panic: %v\n\n%s", r, debug.Stack()), e)
+	ctx.Panic = true
+	u.notify(ctx)
+}
+
+// parseStatsdAddress validates a -statsdaddress value and returns the
+// UDP address to send to. Addresses are host:port pairs, optionally
+// prefixed with udp://; IPv6 hosts must be bracketed ([::1]:8200).
+// The statsd client only speaks UDP, so other schemes (like unix://)
+// are rejected.
+func parseStatsdAddress(address string) (addr string, err error) {
+	switch {
+	case strings.HasPrefix(address, "udp://"):
+		address = strings.TrimPrefix(address, "udp://")
+	case strings.Contains(address, "://"):
+		return "", fmt.Errorf("invalid statsd address %q: only UDP statsd is supported", address)
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid statsd address %q: %v", address, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return "", fmt.Errorf("invalid statsd address %q: bad port %q", address, port)
+	}
+	// Zones (fe80::1%eth0) aren't understood by ParseIP
+	if ip := strings.SplitN(host, "%", 2)[0]; strings.Contains(ip, ":") && net.ParseIP(ip) == nil {
+		return "", fmt.Errorf("invalid statsd address %q: bad IPv6 host %q", address, host)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+func setupStatsd(address, fileName, tags string) (*statsd.Client, error) {
+	addr, err := parseStatsdAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	statsd, err := statsd.New(addr)
+	if err != nil {
+		return nil, fmt.Errorf("statsd address %q: %v", address, err)
+	}
 
 	if tags != "" {
 		statsd.Tags = append(statsd.Tags, strings.Split(tags, ",")...)
 	}
-	return statsd
+	return statsd, nil
+}
+
+// openVerbose opens VerboseFile, if one is configured. Unlike the
+// target, it is opened only once: it's a debugging aid, so it isn't
+// reopened on SIGHUP.
+func (u *Unilog) openVerbose() error {
+	if u.VerboseFile == "" || u.VerboseFile == "-" {
+		return nil
+	}
+	f, err := os.OpenFile(u.VerboseFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	u.verbose = f
+	return nil
+}
+
+func (u *Unilog) closeVerbose() {
+	u.flushVerbose()
+	u.verboseBuf = nil
+	if u.verbose != nil {
+		u.verbose.Close()
+		u.verbose = nil
+	}
+}
+
+// verboseWriter returns where Verbose mode echoes lines to.
+func (u *Unilog) verboseWriter() io.Writer {
+	if u.verbose != nil {
+		return u.verbose
+	}
+	return os.Stdout
+}
+
+// echo writes s to the verbose output, through a buffer if
+// VerboseBuffer is set. Lines are echoed as they are processed,
+// before they're written to the target, so the verbose output is in
+// the same order as the target's, and includes lines that couldn't be
+// written to it.
+func (u *Unilog) echo(s string) {
+	if u.VerboseBuffer <= 0 {
+		io.WriteString(u.verboseWriter(), s)
+		return
+	}
+	if u.verboseBuf == nil {
+		u.verboseBuf = bufio.NewWriterSize(u.verboseWriter(), u.VerboseBuffer)
+	}
+	u.verboseBuf.WriteString(s)
+	if u.verboseBuf.Buffered() > 0 && u.verboseFlush == nil {
+		interval := u.VerboseFlushInterval
+		if interval <= 0 {
+			interval = DefaultVerboseFlushInterval
+		}
+		u.verboseFlush = time.After(interval)
+	}
+}
+
+// flushVerbose writes out any buffered verbose output.
+func (u *Unilog) flushVerbose() {
+	u.verboseFlush = nil
+	if u.verboseBuf != nil {
+		u.verboseBuf.Flush()
+	}
+}
+
+// applyNoTimestamp disables any time prefix filters if NoTimestamp
+// is set.
+func (u *Unilog) applyNoTimestamp() {
+	if !u.NoTimestamp {
+		return
+	}
+	for _, filter := range u.Filters {
+		if tf, ok := filter.(*filters.TimePrefixFilter); ok {
+			tf.Omit = true
+		}
+	}
+}
+
+// RunPipeline runs u's event loop over the lines read from in,
+// writing them to out, until in reaches EOF. Unlike Main, it does not
+// parse flags or set up signal handling, statsd or Sentry, which
+// makes it suitable for testing filters against the real pipeline
+// (see the unilogtest package).
+func (u *Unilog) RunPipeline(in io.Reader, out io.WriteCloser) {
+	u.fillDefaults()
+	if u.WrapJSON {
+		u.JSON = true
+	}
+	u.file = out
+	if u.JSON {
+		u.jsonEncoder = encjson.NewEncoder(out)
+	}
+	u.shutdown = make(chan struct{})
+	u.lines, u.errs = u.readlines(in)
+	u.run()
 }
 
 func (u *Unilog) setupSentry() {
@@ -508,13 +2335,15 @@ func (u *Unilog) Main() {
 	u.fillDefaults()
 
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [options] dstfile\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] dstfile|tcp://host:port|udp://host:port [...]\n       %s [options] -syslog address\n       %s [options] -otlp-endpoint url\n", os.Args[0], os.Args[0], os.Args[0])
 		flag.PrintDefaults()
 	}
 
 	u.addFlags()
 	var flagVersion bool
 	boolFlag(&flagVersion, "version", "V", false, "Print the version number and exit")
+	var configPath string
+	flag.StringVar(&configPath, "config", "", "(optional) YAML or JSON file of flag values, by flag name; flags given on the command line or in UNILOG_ environment variables override it")
 
 	flag.Parse(true)
 
@@ -522,39 +2351,227 @@ func (u *Unilog) Main() {
 		fmt.Printf("This is unilog v%s %s %s\n", Version, commitHash, commitDate)
 		return
 	}
+	if err := ApplyEnv(flag.CommandLine, os.LookupEnv); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid environment: %s\n", err)
+		os.Exit(1)
+	}
+	if configPath != "" {
+		config, err := LoadConfig(configPath)
+		if err == nil {
+			err = config.Apply(flag.CommandLine)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not load config: %s\n", err)
+			os.Exit(1)
+		}
+	}
 	args := flag.Args()
-	if len(args) != 1 {
+	// With -syslog or -otlp-endpoint, there's no need for a dstfile,
+	// and with -dryrun, it's ignored
+	if !u.DryRun && (u.Syslog == "" && u.OTLPEndpoint == "") != (len(args) > 0) {
 		flag.Usage()
 		os.Exit(1)
 	}
+	if u.OTLPEndpoint != "" {
+		if u.Syslog != "" {
+			fmt.Fprintf(os.Stderr, "-otlp-endpoint can't be used with -syslog\n")
+			os.Exit(1)
+		}
+		if _, err := otlpURL(u.OTLPEndpoint); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		if u.IdleFlush <= 0 {
+			u.IdleFlush = DefaultOTLPFlushInterval
+		}
+	}
+	if u.Syslog != "" {
+		if err := u.checkSyslog(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := u.checkSampleStage(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	if u.Input != "" && u.Follow != "" {
+		// Each names the input, and there's only one
+		fmt.Fprintf(os.Stderr, "-input can't be used with -follow (to follow a file, pass it to -follow alone)\n")
+		os.Exit(1)
+	}
+	if u.Raw && u.SpillDir != "" {
+		fmt.Fprintf(os.Stderr, "-raw can't be used with -spill-dir\n")
+		os.Exit(1)
+	}
+	if err := json.SetTimestampPrecision(u.TimestampPrecision); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	if err := json.SetTimestampField(timestampfield); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	if fieldorder != "" {
+		json.SetFieldOrder(strings.Split(fieldorder, ","))
+	}
+	if err := json.SetCollisionPolicy(jsoncollisions); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	if _, err := u.inputEncoding(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	u.applyNoTimestamp()
+	if u.WrapJSON {
+		u.JSON = true
+	}
+	switch u.JSONParseFailure {
+	case JSONParseFailureText, JSONParseFailureDrop, JSONParseFailureDeadLetter, JSONParseFailureError:
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid -json-parse-failure policy %q\n", u.JSONParseFailure)
+		os.Exit(1)
+	}
+	delim, err := strconv.Unquote(`"` + outputdelimiter + `"`)
+	if err != nil || delim == "" {
+		fmt.Fprintf(os.Stderr, "Invalid output delimiter %q\n", outputdelimiter)
+		os.Exit(1)
+	}
+	u.OutputDelimiter = delim
 
-	reopen := make(chan os.Signal, 2)
-	signal.Notify(reopen, syscall.SIGALRM, syscall.SIGHUP)
-	u.sigReopen = reopen
-
-	term := make(chan os.Signal, 2)
-	signal.Notify(term, syscall.SIGTERM, syscall.SIGINT)
-	u.sigTerm = term
+	// Opening a FIFO waits for a writer, so do it before catching the
+	// signals that only the main loop handles
+	var input *os.File
+	if u.Input != "" {
+		input, err = u.openInput()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not open input file: %s\n", err)
+			os.Exit(1)
+		}
+		defer input.Close()
+	}
 
-	quit := make(chan os.Signal, 2)
-	signal.Notify(quit, syscall.SIGQUIT)
-	u.sigQuit = quit
+	if err := u.notifySignals(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
 
 	u.shutdown = make(chan struct{})
 	u.target = flag.Arg(0)
-	u.reopen()
+	if len(args) > 1 {
+		u.targets = args
+	}
+	if u.DryRun {
+		u.applyDryRun()
+	}
+	if err := u.checkNetworkTarget(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	if err := u.checkTemplatedTarget(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	if len(u.targets) > 1 {
+		if err := u.checkMultiTarget(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+	}
+	if u.Atomic {
+		if err := u.checkAtomic(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := u.reopen(); errors.Is(err, errTargetLocked) {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	if err := u.reopenDeadLetter(); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not open dead-letter file: %s\n", err)
+		os.Exit(1)
+	}
+
+	if err := u.reopenSample(); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not open sample target: %s\n", err)
+		os.Exit(1)
+	}
+
+	if err := u.openVerbose(); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not open verbose file: %s\n", err)
+		os.Exit(1)
+	}
+	defer u.closeVerbose()
 
 	fileName := u.target
+	if u.Syslog != "" {
+		fileName = u.Syslog
+	}
 
 	tagState = setupIndependentTags()
 
-	Stats = setupStatsd(u.StatsdAddress, fileName, statstags)
+	if !u.DryRun {
+		stats, err := setupStatsdClients(u.statsdAddresses(), fileName, statstags)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		Stats = stats
+		filters.Stats = stats
 
-	clevels.Stats = setupStatsd(u.StatsdAddress, fileName, cleveltags)
+		clevels.Stats, err = setupStatsdClients(u.statsdAddresses(), fileName, cleveltags)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
 
-	u.setupSentry()
+		u.setupSentry()
+	}
 
-	u.lines, u.errs = readlines(os.Stdin, u.BufferLines, u.shutdown)
+	var in io.Reader = os.Stdin
+	if u.Follow != "" {
+		fl, err := newFollower(u.Follow)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not follow input file: %s\n", err)
+			os.Exit(1)
+		}
+		defer fl.Close()
+		fl.stats = u.stats()
+		u.follow = fl
+		in = fl
+	} else if input != nil {
+		in = input
+	}
+	if u.SpillDir != "" {
+		sb, err := newSpillBuffer(u.SpillDir, u.SpillMaxBytes)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not create spill file: %s\n", err)
+			os.Exit(1)
+		}
+		sb.stats = u.stats()
+		u.spill = sb
+	}
+	u.lines, u.errs = u.readlines(in)
 
 	u.run()
+	// Don't leave half-compressed backups behind
+	u.compressor.wait()
+	u.hooks.Wait()
+	// Export any batched OTLP records, and wait for them
+	u.flush()
+	u.closeOTLP()
+	u.closeStdout()
+	atomicErr := u.finishAtomic()
+	if atomicErr != nil {
+		fmt.Fprintf(os.Stderr, "Could not finish atomic output: %s\n", atomicErr)
+	}
+	u.flushVerbose()
+	if code := exitCode(u.shutdownReason); code != ExitOK {
+		u.exit(code)
+	} else if atomicErr != nil {
+		u.exit(ExitAtomicIncomplete)
+	}
 }
@@ -2,14 +2,24 @@ package logger
 
 import (
 	"bytes"
+	"compress/gzip"
 	encjson "encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"testing"
+	"testing/iotest"
+	"time"
+	"unicode/utf8"
 
+	"github.com/getsentry/sentry-go"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stripe/unilog/filters"
 	"github.com/stripe/unilog/json"
 )
@@ -26,7 +36,7 @@ func TestReadlines(t *testing.T) {
 	r := strings.NewReader(strings.Join(shakespeare, "\n"))
 	ch := make(chan struct{})
 	defer close(ch)
-	lc, _ := readlines(r, 1, ch)
+	lc, _ := (&Unilog{BufferLines: 1, shutdown: ch}).readlines(r)
 	var i int
 	for line := range lc {
 		if line != shakespeare[i] {
@@ -40,6 +50,55 @@ func TestReadlines(t *testing.T) {
 	}
 }
 
+func TestReadlinesBlocked(t *testing.T) {
+	ch := make(chan struct{})
+	defer close(ch)
+	client := &MockClient{Counts: make(map[string]int64), Histograms: make(map[string]float64)}
+	u := &Unilog{BufferLines: 1, shutdown: ch, Metrics: client}
+	lc, _ := u.readlines(strings.NewReader("one\ntwo\nthree\n"))
+
+	// "one" fits in the buffer, but "two" has to wait
+	time.Sleep(50 * time.Millisecond)
+	var lines []string
+	for line := range lc {
+		lines = append(lines, line)
+	}
+	assert.Equal(t, []string{"one", "two", "three"}, lines)
+	assert.True(t, client.Histograms["[mode:text]unilog.reader.blocked_seconds"] >= .05)
+}
+
+func TestBufferStats(t *testing.T) {
+	ch := make(chan struct{})
+	defer close(ch)
+	client := &MockClient{Counts: make(map[string]int64), Histograms: make(map[string]float64)}
+	u := &Unilog{BufferLines: 3, shutdown: ch, Metrics: client}
+	pr, pw := io.Pipe()
+	u.lines, u.errs = u.readlines(pr)
+
+	// The depth climbs as lines are read but not written
+	for i := 1; i <= 3; i++ {
+		_, err := pw.Write([]byte("line\n"))
+		require.NoError(t, err)
+		require.Eventually(t, func() bool { return len(u.lines) == i }, 5*time.Second, time.Millisecond)
+		u.reportBufferStats()
+		assert.Equal(t, float64(i), client.Gauges["[mode:text]unilog.buffer.depth"])
+		assert.Equal(t, float64(3), client.Gauges["[mode:text]unilog.buffer.capacity"])
+	}
+
+	// and a line that doesn't fit is counted
+	_, err := pw.Write([]byte("line\n"))
+	require.NoError(t, err)
+	pw.Close()
+	n := 0
+	for range u.lines {
+		n++
+	}
+	assert.Equal(t, 4, n)
+	assert.Equal(t, int64(1), client.Counts["[mode:text]unilog.buffer.full"])
+	u.reportBufferStats()
+	assert.Equal(t, float64(0), client.Gauges["[mode:text]unilog.buffer.depth"])
+}
+
 var big = strings.Repeat("Unique New York", 9000)
 
 func TestReadlinesWithLongLines(t *testing.T) {
@@ -47,7 +106,7 @@ func TestReadlinesWithLongLines(t *testing.T) {
 	ch := make(chan struct{})
 	defer close(ch)
 
-	lc, _ := readlines(r, 1, ch)
+	lc, _ := (&Unilog{BufferLines: 1, shutdown: ch}).readlines(r)
 	line := <-lc
 	if line != big {
 		t.Errorf("Lines do not match! Got %d bytes; expected %d",
@@ -58,6 +117,39 @@ func TestReadlinesWithLongLines(t *testing.T) {
 	}
 }
 
+func TestReadlinesMaxLineBytes(t *testing.T) {
+	giant := strings.Repeat("x", 3*4096+17)
+	tests := []struct {
+		name      string
+		line      string
+		want      string
+		truncated int64
+	}{
+		{"at the limit", "0123456789", "0123456789", 0},
+		{"just over", "0123456789a", "0123456789" + TruncatedLineMarker, 1},
+		{"giant", giant, "xxxxxxxxxx" + TruncatedLineMarker, 1},
+		{"multi-byte characters", "012345678é", "012345678" + TruncatedLineMarker, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ch := make(chan struct{})
+			defer close(ch)
+			client := &MockClient{Counts: make(map[string]int64), Histograms: make(map[string]float64)}
+			u := &Unilog{BufferLines: 10, MaxLineBytes: 10, shutdown: ch, Metrics: client}
+			// One byte per Read, so long lines span many of them
+			r := iotest.OneByteReader(strings.NewReader(test.line + "\nnext\n"))
+			lc, _ := u.readlines(r)
+			var lines []string
+			for line := range lc {
+				lines = append(lines, line)
+			}
+			// The following line is read as usual
+			assert.Equal(t, []string{test.want, "next"}, lines)
+			assert.Equal(t, test.truncated, client.Counts["[mode:text]unilog.lines.truncated"])
+		})
+	}
+}
+
 // double the first two instances of the character "e"
 type doubleEFilter struct{}
 
@@ -81,6 +173,17 @@ func (ee *isToAintFilter) FilterJSON(line *json.LogLine) {
 	(*line)["message"] = strings.Replace(msg, "is", "ain't", -1)
 }
 
+// copy the "host" field of JSON lines into "message"
+type hostToMessageFilter struct{}
+
+func (hostToMessageFilter) FilterLine(line string) string {
+	return line
+}
+
+func (hostToMessageFilter) FilterJSON(line *json.LogLine) {
+	(*line)["message"] = (*line)["host"]
+}
+
 func TestFilterFunction(t *testing.T) {
 	var input = shakespeare[0]
 	const expected = "To bee, or not to bee, that ain't the question-\n"
@@ -98,6 +201,35 @@ func TestFilterFunction(t *testing.T) {
 	}
 }
 
+func TestFilterFunc(t *testing.T) {
+	u := &Unilog{
+		Filters: []Filter{
+			FilterFunc(strings.ToUpper),
+			Filter(&doubleEFilter{}),
+		},
+	}
+	assert.Equal(t, "HI THERE\n", u.format("hi there"))
+
+	line := json.LogLine{"message": "hi there"}
+	FilterFunc(strings.ToUpper).FilterJSON(&line)
+	assert.Equal(t, json.LogLine{"message": "hi there"}, line)
+}
+
+type dropFilter struct{}
+
+func (dropFilter) FilterLine(line string) string {
+	return line
+}
+
+func (dropFilter) FilterJSON(line *json.LogLine) {
+	*line = nil
+}
+
+func TestJSONFilterDrop(t *testing.T) {
+	u := &Unilog{Filters: []Filter{dropFilter{}, &doubleEFilter{}}}
+	assert.Equal(t, "", getLogJSON(u, `{"message":"hi"}`))
+}
+
 func TestTwoStateExit(t *testing.T) {
 	u := &Unilog{}
 	exitCode := -1
@@ -121,11 +253,72 @@ func TestTwoStateExit(t *testing.T) {
 	quit <- syscall.SIGQUIT
 
 	<-exit
-	if exitCode != 1 {
+	if exitCode != ExitQuit {
 		t.Error("Did not call exit.")
 	}
 }
 
+func TestExitCodes(t *testing.T) {
+	lines := make(chan string)
+	u := &Unilog{lines: lines}
+	close(lines)
+	u.run()
+	assert.Equal(t, ExitOK, exitCode(u.shutdownReason))
+
+	errs := make(chan error, 1)
+	u = &Unilog{errs: errs}
+	errs <- errors.New("read failed")
+	u.run()
+	assert.Equal(t, ExitReadError, exitCode(u.shutdownReason))
+
+	term := make(chan os.Signal, 1)
+	quit := make(chan os.Signal, 1)
+	code := -1
+	u = &Unilog{sigTerm: term, sigQuit: quit, shutdown: make(chan struct{}, 1)}
+	u.exit = func(c int) { code = c }
+	term <- syscall.SIGTERM
+	assert.True(t, u.tick())
+	quit <- syscall.SIGQUIT
+	u.run()
+	assert.Equal(t, ExitQuit, code)
+
+	// A forced exit can be told apart from flag and setup errors (1)
+	// and panics (2)
+	assert.Equal(t, 6, ExitQuit)
+}
+
+func TestShutdownReasonEOF(t *testing.T) {
+	lines := make(chan string)
+	u := &Unilog{lines: lines}
+	close(lines)
+	u.run()
+	assert.Equal(t, ShutdownEOF, u.shutdownReason)
+}
+
+func TestShutdownReasonSignals(t *testing.T) {
+	lines := make(chan string)
+	term := make(chan os.Signal, 1)
+	quit := make(chan os.Signal, 1)
+	u := &Unilog{lines: lines, sigTerm: term, sigQuit: quit, shutdown: make(chan struct{}, 1)}
+	u.exit = func(int) {}
+
+	// SIGTERM followed by the input draining
+	term <- syscall.SIGTERM
+	assert.True(t, u.tick())
+	close(lines)
+	u.run()
+	assert.Equal(t, ShutdownDrained, u.shutdownReason)
+
+	// SIGTERM followed by SIGQUIT
+	u = &Unilog{sigTerm: term, sigQuit: quit, shutdown: make(chan struct{}, 1)}
+	u.exit = func(int) {}
+	term <- syscall.SIGTERM
+	assert.True(t, u.tick())
+	quit <- syscall.SIGQUIT
+	u.run()
+	assert.Equal(t, ShutdownQuit, u.shutdownReason)
+}
+
 func TestSigTermNoExit(t *testing.T) {
 	u := &Unilog{}
 
@@ -165,10 +358,13 @@ func TestSigQuitNoOp(t *testing.T) {
 }
 
 type MockClient struct {
-	Counts map[string]int64
+	Counts     map[string]int64
+	Histograms map[string]float64
+	Timings    map[string]time.Duration
+	Gauges     map[string]float64
 }
 
-func (mc *MockClient) Count(name string, value int64, tags []string, rate float64) error {
+func mockKey(name string, tags []string) string {
 	var buffer bytes.Buffer
 	for _, tag := range tags {
 		buffer.WriteString("[")
@@ -176,7 +372,29 @@ func (mc *MockClient) Count(name string, value int64, tags []string, rate float6
 		buffer.WriteString("]")
 	}
 	buffer.WriteString(name)
-	mc.Counts[buffer.String()] += value
+	return buffer.String()
+}
+
+func (mc *MockClient) Count(name string, value int64, tags []string, rate float64) error {
+	mc.Counts[mockKey(name, tags)] += value
+	return nil
+}
+
+func (mc *MockClient) Gauge(name string, value float64, tags []string, rate float64) error {
+	if mc.Gauges == nil {
+		mc.Gauges = make(map[string]float64)
+	}
+	mc.Gauges[mockKey(name, tags)] = value
+	return nil
+}
+
+func (mc *MockClient) Histogram(name string, value float64, tags []string, rate float64) error {
+	mc.Histograms[mockKey(name, tags)] += value
+	return nil
+}
+
+func (mc *MockClient) Timing(name string, value time.Duration, tags []string, rate float64) error {
+	mc.Timings[mockKey(name, tags)] += value
 	return nil
 }
 
@@ -234,6 +452,58 @@ func TestWithIndependentTags(t *testing.T) {
 	tagState = tmp
 }
 
+func TestHistogramsAndTimingsWithIndependentTags(t *testing.T) {
+	// Save and set tagState
+	tmp := tagState
+	tagState = newIndependentTags([]string{"veneurglobalonly:true", "owner:observability"})
+
+	client := &MockClient{
+		Histograms: make(map[string]float64),
+		Timings:    make(map[string]time.Duration),
+	}
+	for i := 0; i < 100; i++ {
+		IndependentHistogram(client, "metric", 10, nil, 1)
+		IndependentHistogram(client, "metric", 5, []string{"baz:qaz"}, 1)
+		IndependentTiming(client, "metric", 10*time.Millisecond, nil, 1)
+		IndependentTiming(client, "metric", 5*time.Millisecond, []string{"baz:qaz"}, 1)
+	}
+	var tests = map[string]float64{
+		"metric": 1000,
+		"[veneurglobalonly:true]metric.veneurglobalonly":          1000,
+		"[owner:observability]metric.owner":                       1000,
+		"[baz:qaz]metric":                                         500,
+		"[baz:qaz][veneurglobalonly:true]metric.veneurglobalonly": 500,
+		"[baz:qaz][owner:observability]metric.owner":              500,
+	}
+	for key, value := range tests {
+		if client.Histograms[key] != value {
+			t.Errorf("Histogram for %s was %v, not %v", key, client.Histograms[key], value)
+		}
+		if client.Timings[key] != time.Duration(value)*time.Millisecond {
+			t.Errorf("Timing for %s was %v, not %v", key, client.Timings[key], time.Duration(value)*time.Millisecond)
+		}
+	}
+	// Restore tagState
+	tagState = tmp
+}
+
+func TestIndependentTagsWhitespace(t *testing.T) {
+	// Save and set tagState
+	tmp := tagState
+	tagState = newIndependentTags(strings.Split(" veneurglobalonly:true, owner :observability,,", ","))
+
+	client := &MockClient{Counts: make(map[string]int64)}
+	IndependentCount(client, "metric", 10, nil, 1)
+	var tests = map[string]int64{
+		"metric": 10,
+		"[veneurglobalonly:true]metric.veneurglobalonly": 10,
+		"[owner:observability]metric.owner":              10,
+	}
+	assert.Equal(t, tests, client.Counts)
+	// Restore tagState
+	tagState = tmp
+}
+
 func TestIndependentTagRace(t *testing.T) {
 	tagState = newIndependentTags([]string{"foo:bar"})
 	for i := 0; i < 100; i++ {
@@ -316,3 +586,903 @@ func TestLogJSON(t *testing.T) {
 	out = getLogJSON(&Unilog{}, `{"message":"hi"}`)
 	assert.Regexp(t, `\{"timestamp":[\d\.]+,"message":"hi"}\n`, out)
 }
+
+func TestJSONParseFailure(t *testing.T) {
+	out := getLogJSON(&Unilog{JSONParseFailure: JSONParseFailureText}, "hi")
+	assert.Equal(t, "hi\n", out)
+
+	out = getLogJSON(&Unilog{JSONParseFailure: JSONParseFailureDrop}, "hi")
+	assert.Equal(t, "", out)
+
+	u := &Unilog{JSONParseFailure: JSONParseFailureError}
+	out = getLogJSON(u, "hi")
+	assert.Equal(t, "", out)
+	assert.True(t, u.b.broken)
+
+	var dead bytes.Buffer
+	u = &Unilog{JSONParseFailure: JSONParseFailureDeadLetter}
+	u.deadLetter = mockFile{buf: &dead}
+	out = getLogJSON(u, "hi")
+	assert.Equal(t, "", out)
+	assert.Regexp(t, `^\{"timestamp":[\d\.]+,("line":"hi"|"reason":"json_parse"|,){3}}\n$`, dead.String())
+}
+
+func TestSanitizeUTF8(t *testing.T) {
+	invalid := "caf\xe9 au lait"
+
+	out := getLogLine(&Unilog{}, invalid)
+	assert.Equal(t, invalid+"\n", out)
+
+	out = getLogLine(&Unilog{SanitizeUTF8: true}, invalid)
+	assert.Equal(t, "caf\uFFFD au lait\n", out)
+
+	out = getLogLine(&Unilog{SanitizeUTF8: true, DropInvalidUTF8: true}, invalid)
+	assert.Equal(t, "", out)
+
+	out = getLogLine(&Unilog{SanitizeUTF8: true, DropInvalidUTF8: true}, "caf\u00e9")
+	assert.Equal(t, "caf\u00e9\n", out)
+
+	out = getLogLine(&Unilog{SanitizeUTF8: true}, "\uFEFFhi")
+	assert.Equal(t, "hi\n", out)
+
+	out = getLogJSON(&Unilog{SanitizeUTF8: true}, `{"message":"`+invalid+`"}`)
+	assert.Regexp(t, `"message":"caf\x{FFFD} au lait"}\n`, out)
+
+	out = getLogJSON(&Unilog{SanitizeUTF8: true, DropInvalidUTF8: true}, `{"message":"`+invalid+`"}`)
+	assert.Equal(t, "", out)
+}
+
+type brokenPipe struct{}
+
+func (brokenPipe) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: "|1", Err: syscall.EPIPE}
+}
+func (brokenPipe) Close() error {
+	return nil
+}
+
+func TestBrokenPipeBackoff(t *testing.T) {
+	u := &Unilog{PipeRetryDelay: time.Hour}
+	u.file = brokenPipe{}
+
+	u.logLine("hi")
+	assert.True(t, u.b.broken)
+	assert.Nil(t, u.file)
+	assert.True(t, u.reopenAfter.After(time.Now()))
+
+	// Within the retry delay, lines are discarded without
+	// attempting to reopen the (empty, and so unopenable) target:
+	u.logLine("hi again")
+	assert.Nil(t, u.file)
+	assert.Equal(t, 1, u.b.count)
+}
+
+type failingFile struct {
+	writes *int
+}
+
+func (f failingFile) Write(p []byte) (int, error) {
+	*f.writes++
+	return 0, fmt.Errorf("disk on fire")
+}
+func (failingFile) Close() error {
+	return nil
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	u := &Unilog{CircuitFailures: 2, CircuitCooldown: time.Hour}
+	var writes int
+	u.file = failingFile{writes: &writes}
+
+	u.logLine("one")
+	assert.False(t, u.circuit.open)
+	u.logLine("two")
+	assert.True(t, u.circuit.open)
+	assert.Equal(t, 2, writes)
+
+	// While the circuit is open, no writes are attempted
+	u.logLine("three")
+	assert.Equal(t, 2, writes)
+
+	// After the cool-down, a failing write keeps the circuit open
+	u.circuit.openUntil = time.Now()
+	u.logLine("four")
+	assert.Equal(t, 3, writes)
+	assert.True(t, u.circuitOpen())
+
+	// ...and a successful one closes it again
+	u.circuit.openUntil = time.Now()
+	out := getLogLine(u, "five")
+	assert.Equal(t, "five\n", out)
+	assert.False(t, u.circuit.open)
+	assert.Equal(t, 0, u.circuit.failures)
+}
+
+func TestCircuitBreakerBuffers(t *testing.T) {
+	lines := make(chan string, 1)
+	u := &Unilog{CircuitBuffer: true, lines: lines}
+	u.circuit.open = true
+	u.circuit.openUntil = time.Now().Add(10 * time.Millisecond)
+	lines <- "hi"
+
+	var buf bytes.Buffer
+	u.file = mockFile{buf: &buf}
+
+	// The first tick waits out the cool-down without reading lines
+	assert.True(t, u.tick())
+	assert.Equal(t, 1, len(lines))
+	assert.True(t, u.tick())
+	assert.Equal(t, "hi\n", buf.String())
+}
+
+func TestOutputDelimiter(t *testing.T) {
+	out := getLogLine(&Unilog{OutputDelimiter: "\x1e"}, "hi")
+	assert.Equal(t, "hi\x1e", out)
+
+	out = getLogJSON(&Unilog{OutputDelimiter: "\x1e"}, `{"message":"hi"}`)
+	assert.Regexp(t, `^\{"timestamp":[\d\.]+,"message":"hi"}\x1e$`, out)
+
+	// Unparseable JSON is written as text, with the same delimiter
+	out = getLogJSON(&Unilog{OutputDelimiter: "\x1e"}, "hi")
+	assert.Equal(t, "hi\x1e", out)
+}
+
+func TestNoTimestamp(t *testing.T) {
+	u := &Unilog{
+		Filters: []Filter{
+			&filters.TimePrefixFilter{Format: "foo"},
+		},
+	}
+	u.applyNoTimestamp()
+	assert.Equal(t, "[foo] hi\n", getLogLine(u, "hi"))
+
+	u.NoTimestamp = true
+	u.applyNoTimestamp()
+	assert.Equal(t, "hi\n", getLogLine(u, "hi"))
+}
+
+func TestLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "log")
+
+	first := &Unilog{Lock: true, target: target}
+	require.NoError(t, first.reopen())
+
+	second := &Unilog{Lock: true, target: target}
+	err = second.reopen()
+	assert.True(t, errors.Is(err, errTargetLocked), "expected a lock error, got %v", err)
+	assert.Nil(t, second.file)
+
+	// Reopening releases and re-acquires the lock
+	require.NoError(t, first.reopen())
+	assert.Error(t, second.reopen())
+
+	first.file.Close()
+	assert.NoError(t, second.reopen())
+	second.file.Close()
+}
+
+func TestTruncate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "log")
+	require.NoError(t, ioutil.WriteFile(target, []byte("old\n"), 0644))
+
+	u := &Unilog{Truncate: true, target: target}
+	require.NoError(t, u.reopen())
+	u.logLine("one")
+
+	// Reopening appends rather than truncating again
+	require.NoError(t, u.reopen())
+	u.logLine("two")
+	u.file.Close()
+
+	contents, err := ioutil.ReadFile(target)
+	require.NoError(t, err)
+	assert.Equal(t, "one\ntwo\n", string(contents))
+}
+
+func TestParseStatsdAddress(t *testing.T) {
+	valid := []struct {
+		in, addr string
+	}{
+		{"127.0.0.1:8200", "127.0.0.1:8200"},
+		{"udp://127.0.0.1:8200", "127.0.0.1:8200"},
+		{"localhost:8125", "localhost:8125"},
+		{"[::1]:8125", "[::1]:8125"},
+		{"udp://[fe80::1%eth0]:8125", "[fe80::1%eth0]:8125"},
+	}
+	for _, tc := range valid {
+		addr, err := parseStatsdAddress(tc.in)
+		if assert.NoError(t, err, tc.in) {
+			assert.Equal(t, tc.addr, addr, tc.in)
+		}
+	}
+
+	for _, in := range []string{
+		"",
+		"127.0.0.1",
+		"127.0.0.1:",
+		"127.0.0.1:statsd",
+		"127.0.0.1:70000",
+		"::1:8125",
+		"[::zz]:8125",
+		"tcp://127.0.0.1:8200",
+		"unix://",
+		"unix:///var/run/statsd.sock",
+	} {
+		_, err := parseStatsdAddress(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestSetupStatsd(t *testing.T) {
+	c, err := setupStatsd("[::1]:8125", "log", "a:b,c:d")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a:b", "c:d"}, c.Tags)
+	c.Close()
+
+	_, err = setupStatsd("unix:///var/run/statsd.sock", "log", "")
+	assert.Error(t, err)
+
+	_, err = setupStatsd("127.0.0.1", "log", "a:b")
+	assert.Error(t, err)
+}
+
+func TestVerboseFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "verbose")
+
+	buf := bytes.Buffer{}
+	u := &Unilog{Verbose: true, VerboseFile: path, file: mockFile{&buf}}
+	require.NoError(t, u.openVerbose())
+	u.logLine("hello")
+	u.closeVerbose()
+
+	assert.Equal(t, "hello\n", buf.String())
+	echoed, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", string(echoed))
+}
+
+func TestVerboseOrdering(t *testing.T) {
+	// Echo and target share a buffer, to see which comes first
+	buf := bytes.Buffer{}
+	u := &Unilog{Verbose: true, verbose: mockFile{&buf}, file: mockFile{&buf}}
+	u.logLine("one")
+	u.logLine("two")
+	assert.Equal(t, "one\none\ntwo\ntwo\n", buf.String())
+
+	buf.Reset()
+	u.JSON = true
+	u.jsonEncoder = encjson.NewEncoder(u.file)
+	u.Filters = []Filter{dropFilter{}}
+	// Dropped lines aren't echoed either
+	u.logJSON(`{"message":"drop"}`)
+	u.Filters = nil
+	u.logJSON(`{"message":"keep"}`)
+	assert.Regexp(t, `^map\[message:keep\]\n\{.*"message":"keep".*\}\n$`, buf.String())
+}
+
+func TestVerboseBuffer(t *testing.T) {
+	echoed := bytes.Buffer{}
+	written := bytes.Buffer{}
+	u := &Unilog{
+		Verbose:              true,
+		VerboseBuffer:        1024,
+		VerboseFlushInterval: 10 * time.Millisecond,
+		verbose:              mockFile{&echoed},
+		file:                 mockFile{&written},
+	}
+	u.logLine("one")
+	u.logLine("two")
+	assert.Equal(t, "one\ntwo\n", written.String())
+	assert.Equal(t, "", echoed.String())
+
+	// The flush timer goes off
+	require.NotNil(t, u.verboseFlush)
+	assert.True(t, u.tick())
+	assert.Equal(t, "one\ntwo\n", echoed.String())
+	assert.Nil(t, u.verboseFlush)
+
+	u.logLine("three")
+	u.closeVerbose()
+	assert.Equal(t, "one\ntwo\nthree\n", echoed.String())
+}
+
+func TestAddHost(t *testing.T) {
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+
+	out := getLogJSON(&Unilog{JSON: true}, `{"message":"hi"}`)
+	assert.NotContains(t, out, `"host"`)
+
+	u := &Unilog{JSON: true, AddHost: true}
+	out = getLogJSON(u, `{"message":"hi"}`)
+	assert.Contains(t, out, `"host":"`+hostname+`"`)
+	out = getLogJSON(u, `{"message":"hi","host":"elsewhere"}`)
+	assert.Contains(t, out, `"host":"elsewhere"`)
+	assert.NotContains(t, out, hostname)
+
+	// Filters see the host
+	u.Filters = []Filter{hostToMessageFilter{}}
+	out = getLogJSON(u, `{"message":"hi"}`)
+	assert.Contains(t, out, `"message":"`+hostname+`"`)
+}
+
+func TestWrapJSON(t *testing.T) {
+	buf := bytes.Buffer{}
+	u := &Unilog{
+		WrapJSON: true,
+		JSON:     true,
+		Filters:  []Filter{&filters.ErrorFilter{Enabled: true}},
+		file:     mockFile{&buf},
+	}
+	u.jsonEncoder = encjson.NewEncoder(u.file)
+
+	u.process("java.io.IOException: disk full")
+	u.process(`{"message":"not parsed"}`)
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+
+	var first, second map[string]interface{}
+	require.NoError(t, encjson.Unmarshal([]byte(lines[0]), &first))
+	assert.Equal(t, "java.io.IOException: disk full", first["message"])
+	assert.Equal(t, map[string]interface{}{"class": "java.io.IOException", "message": "disk full"}, first["error"])
+	// Lines are wrapped even if they're JSON already
+	require.NoError(t, encjson.Unmarshal([]byte(lines[1]), &second))
+	assert.Equal(t, `{"message":"not parsed"}`, second["message"])
+	assert.NotContains(t, second, "error")
+}
+
+func TestRaw(t *testing.T) {
+	input := "  one  \n\n\ntwo\r\n[2016-11-10 19:18:05.000000] three\n\n\nlast"
+	ch := make(chan struct{})
+	defer close(ch)
+	buf := bytes.Buffer{}
+	u := &Unilog{
+		Raw:         true,
+		BufferLines: 1,
+		Filters:     []Filter{FilterFunc(strings.ToUpper)},
+		file:        mockFile{&buf},
+		shutdown:    ch,
+	}
+	lc, _ := u.readlines(strings.NewReader(input))
+	for line := range lc {
+		u.process(line)
+	}
+	assert.Equal(t, input, buf.String())
+}
+
+func TestMaxLineAge(t *testing.T) {
+	u := &Unilog{MaxLineAge: time.Minute}
+
+	old := time.Now().Add(-time.Hour).Format("2006-01-02 15:04:05.000000")
+	fresh := time.Now().Format("2006-01-02 15:04:05.000000")
+	assert.Equal(t, "", getLogLine(u, "["+old+"] hi"))
+	assert.Equal(t, "["+fresh+"] hi\n", getLogLine(u, "["+fresh+"] hi"))
+	// Lines without a timestamp are always written
+	assert.Equal(t, "hi\n", getLogLine(u, "hi"))
+
+	u.JSON = true
+	epoch := float64(time.Now().Add(-time.Hour).Unix())
+	assert.Equal(t, "", getLogJSON(u, fmt.Sprintf(`{"timestamp":%f}`, epoch)))
+	assert.NotEqual(t, "", getLogJSON(u, fmt.Sprintf(`{"timestamp":"%s"}`, time.Now().Format(time.RFC3339Nano))))
+	assert.NotEqual(t, "", getLogJSON(u, `{"timestamp":"yesterday"}`))
+}
+
+func TestSynthesizedTimestamps(t *testing.T) {
+	client := &MockClient{Counts: make(map[string]int64)}
+	u := &Unilog{JSON: true, Metrics: client}
+
+	getLogJSON(u, `{"message":"hi"}`)
+	getLogJSON(u, `{"timestamp":"yesterday"}`)
+	getLogJSON(u, `{"timestamp":"yesterday"}`)
+	getLogJSON(u, fmt.Sprintf(`{"timestamp":"%s"}`, time.Now().Format(time.RFC3339Nano)))
+	assert.Equal(t, int64(1), client.Counts["[reason:missing]unilog.timestamp.synthesized"])
+	assert.Equal(t, int64(2), client.Counts["[reason:unparseable]unilog.timestamp.synthesized"])
+}
+
+func TestDroppedLines(t *testing.T) {
+	client := &MockClient{Counts: make(map[string]int64)}
+	var dropped []string
+	u := &Unilog{
+		MaxLineAge: time.Minute,
+		Metrics:    client,
+		OnDrop:     func(line string) { dropped = append(dropped, line) },
+	}
+
+	old := "[" + time.Now().Add(-time.Hour).Format("2006-01-02 15:04:05.000000") + "] old"
+	getLogLine(u, old)
+	u.Filters = []Filter{FilterFunc(func(line string) string {
+		if strings.Contains(line, "shed me") {
+			return filters.ShedLine
+		}
+		return line
+	})}
+	assert.Equal(t, "(shedded)\n", getLogLine(u, "shed me"))
+	assert.Equal(t, "kept\n", getLogLine(u, "kept"))
+
+	now := time.Now()
+	limit := &filters.RateLimitFilter{Rate: 1, Now: func() time.Time { return now }}
+	u.Filters = []Filter{limit}
+	assert.Equal(t, "allowed\n", getLogLine(u, "allowed"))
+	assert.Equal(t, "(rate-limited)\n", getLogLine(u, "over the limit"))
+
+	u.JSON = true
+	u.Filters = []Filter{dropFilter{}}
+	getLogJSON(u, `{"message":"filtered"}`)
+	u.Filters = []Filter{limit}
+	assert.Contains(t, getLogJSON(u, `{"message":"json over the limit"}`), `"rate_limited":true`)
+	// Lines already marked before filtering aren't counted again
+	now = now.Add(time.Second)
+	getLogJSON(u, `{"message":"upstream","rate_limited":true}`)
+
+	assert.Equal(t, []string{old, "shed me", "over the limit", `{"message":"filtered"}`, `{"message":"json over the limit"}`}, dropped)
+	assert.Equal(t, int64(1), client.Counts["[reason:expired]unilog.lines.dropped"])
+	assert.Equal(t, int64(1), client.Counts["[reason:shed]unilog.lines.dropped"])
+	assert.Equal(t, int64(1), client.Counts["[reason:filtered]unilog.lines.dropped"])
+	assert.Equal(t, int64(2), client.Counts["[reason:rate_limited]unilog.lines.dropped"])
+}
+
+func TestHeartbeat(t *testing.T) {
+	var buf bytes.Buffer
+	lines := make(chan string, 1)
+	u := &Unilog{HeartbeatInterval: 10 * time.Millisecond, lines: lines, file: mockFile{buf: &buf}}
+
+	// Nothing to read, so the heartbeat fires
+	assert.True(t, u.tick())
+	assert.Equal(t, "(heartbeat)\n", buf.String())
+
+	buf.Reset()
+	lines <- "hi"
+	assert.True(t, u.tick())
+	assert.Equal(t, "hi\n", buf.String())
+
+	buf.Reset()
+	u.JSON = true
+	u.jsonEncoder = encjson.NewEncoder(u.file)
+	assert.True(t, u.tick())
+	assert.Contains(t, buf.String(), `"heartbeat":true`)
+
+	buf.Reset()
+	u.HeartbeatLine = `{"alive":1}`
+	assert.True(t, u.tick())
+	assert.Contains(t, buf.String(), `"alive":1`)
+}
+
+type flushingFile struct {
+	mockFile
+	flushes *int
+}
+
+func (f flushingFile) Flush() error {
+	*f.flushes++
+	return nil
+}
+
+func TestIdleFlush(t *testing.T) {
+	var buf bytes.Buffer
+	flushes := 0
+	lines := make(chan string, 2)
+	u := &Unilog{IdleFlush: 10 * time.Millisecond, lines: lines, file: flushingFile{mockFile{&buf}, &flushes}}
+
+	lines <- "hi"
+	lines <- "there"
+	assert.True(t, u.tick())
+	assert.True(t, u.tick())
+	// Writes don't flush...
+	assert.Equal(t, 0, flushes)
+	// ...but going quiet does, once
+	assert.True(t, u.tick())
+	assert.Equal(t, 1, flushes)
+	select {
+	case <-u.idleFlush.C:
+		t.Error("idle flush fired again without a write")
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	lines <- "again"
+	assert.True(t, u.tick())
+	assert.True(t, u.tick())
+	assert.Equal(t, 2, flushes)
+	assert.Equal(t, "hi\nthere\nagain\n", buf.String())
+}
+
+func TestSyncInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "log")
+
+	u := &Unilog{SyncInterval: 10 * time.Millisecond, target: target}
+	require.NoError(t, u.reopen())
+	defer u.file.Close()
+	u.logLine("one")
+	// With no lines to read, the sync ticker fires
+	assert.True(t, u.tick())
+
+	contents, err := ioutil.ReadFile(target)
+	require.NoError(t, err)
+	assert.Equal(t, "one\n", string(contents))
+}
+
+// syncingFile fails writes while fail is set, and counts syncs.
+type syncingFile struct {
+	mockFile
+	fail  *bool
+	syncs *int
+}
+
+func (f syncingFile) Write(p []byte) (int, error) {
+	if *f.fail {
+		return 0, fmt.Errorf("disk on fire")
+	}
+	return f.mockFile.Write(p)
+}
+
+func (f syncingFile) Sync() error {
+	*f.syncs++
+	return nil
+}
+
+func TestSyncOnBreak(t *testing.T) {
+	var buf bytes.Buffer
+	var fail bool
+	var syncs int
+	u := &Unilog{SyncOnBreak: true, file: syncingFile{mockFile{&buf}, &fail, &syncs}}
+	u.logLine("one")
+	assert.Equal(t, 0, syncs)
+
+	fail = true
+	u.logLine("two")
+	fail = false
+	// The first write after the failure syncs, and only that one
+	u.logLine("three")
+	u.logLine("four")
+	assert.Equal(t, 1, syncs)
+	assert.Equal(t, "one\nthree\nfour\n", buf.String())
+
+	// Stdout is never synced
+	u = &Unilog{SyncInterval: time.Millisecond, target: "-", file: syncingFile{mockFile{&buf}, &fail, &syncs}}
+	u.sync()
+	assert.Equal(t, 1, syncs)
+}
+
+func TestWriteBuffer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "log")
+
+	u := &Unilog{WriteBuffer: 1 << 16, WriteFlushInterval: 20 * time.Millisecond, target: target}
+	require.NoError(t, u.reopen())
+	defer u.file.Close()
+	start := time.Now()
+	u.logLine("one")
+	assert.Equal(t, "", readFile(t, target))
+	// With no lines to read, the flush timer fires
+	assert.True(t, u.tick())
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, "one\n", readFile(t, target))
+
+	// Reopening flushes the old file before closing it
+	u.logLine("two")
+	require.NoError(t, os.Rename(target, target+".1"))
+	require.NoError(t, u.reopen())
+	assert.Equal(t, "one\ntwo\n", readFile(t, target+".1"))
+
+	// and so does exiting on SIGQUIT after a SIGTERM
+	u.logLine("three")
+	quit := make(chan os.Signal, 1)
+	quit <- syscall.SIGQUIT
+	u.sigQuit = quit
+	u.shouldShutdown = true
+	u.exit = func(int) {}
+	assert.False(t, u.tick())
+	assert.Equal(t, "three\n", readFile(t, target))
+}
+
+func TestWriteBufferLost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	client := &MockClient{Counts: make(map[string]int64)}
+	u := &Unilog{WriteBuffer: 16, target: filepath.Join(dir, "log"), Metrics: client}
+	require.NoError(t, u.reopen())
+	f := u.file.(*sizedFile)
+
+	// Lines written out to make room aren't lost
+	for _, line := range []string{"one", "two", "three", "four"} {
+		u.logLine(line)
+	}
+	assert.Equal(t, 1, f.buffered)
+
+	// but the ones still buffered when writing out fails are
+	u.logLine("five")
+	require.NoError(t, f.f.Close())
+	u.flush()
+	assert.Equal(t, int64(2), client.Counts["[reason:buffer_lost]unilog.lines.dropped"])
+	assert.Zero(t, client.Counts["[reason:write_error]unilog.lines.dropped"])
+	assert.True(t, u.writeFailed)
+
+	// A line whose write fails is dropped as usual, and the lines
+	// before it with the buffer
+	u.logLine("six")
+	u.logLine(strings.Repeat("x", 20))
+	assert.Equal(t, int64(3), client.Counts["[reason:buffer_lost]unilog.lines.dropped"])
+	assert.Equal(t, int64(1), client.Counts["[reason:write_error]unilog.lines.dropped"])
+}
+
+func BenchmarkWrite(b *testing.B) {
+	dir, err := ioutil.TempDir("", "unilog")
+	require.NoError(b, err)
+	defer os.RemoveAll(dir)
+	line := strings.Repeat("x", 120)
+
+	for _, size := range []int{0, 1 << 16} {
+		b.Run(fmt.Sprintf("buffer=%d", size), func(b *testing.B) {
+			u := &Unilog{WriteBuffer: size, target: filepath.Join(dir, fmt.Sprintf("log.%d", size))}
+			require.NoError(b, u.reopen())
+			defer u.file.Close()
+			b.SetBytes(int64(len(line) + 1))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				u.logLine(line)
+			}
+			u.flush()
+		})
+	}
+}
+
+func TestTermDrainTimeout(t *testing.T) {
+	var buf bytes.Buffer
+	lines := make(chan string, 2)
+	term := make(chan os.Signal, 1)
+	u := &Unilog{
+		TermDrainTimeout: 10 * time.Millisecond,
+		lines:            lines,
+		sigTerm:          term,
+		shutdown:         make(chan struct{}, 1),
+		file:             mockFile{buf: &buf},
+	}
+	u.exit = func(int) { t.Error("Called exit.") }
+
+	term <- syscall.SIGTERM
+	assert.True(t, u.tick())
+	// The input never reaches EOF, but lines already read are written
+	lines <- "one"
+	lines <- "two"
+	u.run()
+	assert.Equal(t, ShutdownDrainTimeout, u.shutdownReason)
+	assert.Equal(t, ExitDrainTimeout, exitCode(u.shutdownReason))
+	assert.Equal(t, "one\ntwo\n", buf.String())
+}
+
+func TestNoTermDrainTimeout(t *testing.T) {
+	term := make(chan os.Signal, 1)
+	quit := make(chan os.Signal, 1)
+	code := -1
+	u := &Unilog{sigTerm: term, sigQuit: quit, shutdown: make(chan struct{}, 1)}
+	u.exit = func(c int) { code = c }
+
+	// Without a timeout, SIGTERM only drains, and SIGQUIT is still
+	// needed to exit early
+	term <- syscall.SIGTERM
+	assert.True(t, u.tick())
+	assert.Nil(t, u.drainTimeout)
+	quit <- syscall.SIGQUIT
+	assert.False(t, u.tick())
+	assert.Equal(t, ExitQuit, code)
+}
+
+func TestInjectedMetrics(t *testing.T) {
+	client := &MockClient{Counts: make(map[string]int64)}
+	ch := make(chan struct{})
+	defer close(ch)
+	u := &Unilog{BufferLines: 1, shutdown: ch, Metrics: client}
+
+	lc, _ := u.readlines(strings.NewReader("hello\nhi\n"))
+	for range lc {
+	}
+	assert.Equal(t, int64(7), client.Counts["[mode:text]unilog.bytes"])
+
+	writes := 0
+	u.file = failingFile{&writes}
+	u.logLine("hi")
+	assert.Equal(t, int64(1), client.Counts["[err_action:write_to_log]unilog.errors_total"])
+}
+
+func TestPreProcess(t *testing.T) {
+	var buf bytes.Buffer
+	lines := make(chan string, 1)
+	var filtered string
+	u := &Unilog{
+		PreProcess: func(line string) string {
+			return strings.TrimPrefix(line, "proxy: ")
+		},
+		Filters: []Filter{FilterFunc(func(line string) string {
+			filtered = line
+			return line
+		})},
+		lines: lines,
+		file:  mockFile{buf: &buf},
+	}
+
+	lines <- "proxy: hi [clevel: critical]"
+	assert.True(t, u.tick())
+	assert.Equal(t, "hi [clevel: critical]", filtered)
+	assert.Equal(t, "hi [clevel: critical]\n", buf.String())
+
+	buf.Reset()
+	u.JSON = true
+	u.jsonEncoder = encjson.NewEncoder(u.file)
+	lines <- `proxy: {"message":"hi"}`
+	assert.True(t, u.tick())
+	assert.Contains(t, buf.String(), `"message":"hi"`)
+}
+
+func TestCompressedStdout(t *testing.T) {
+	out, err := ioutil.TempFile("", "unilog")
+	require.NoError(t, err)
+	defer os.Remove(out.Name())
+	defer out.Close()
+	stdout := os.Stdout
+	os.Stdout = out
+	defer func() { os.Stdout = stdout }()
+
+	u := &Unilog{CompressBackups: true, target: "-"}
+	require.NoError(t, u.reopen())
+	u.logLine("one")
+	// Reopening on SIGHUP continues the same stream
+	require.NoError(t, u.reopen())
+	u.logLine("two")
+	u.closeStdout()
+
+	_, err = out.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+	r, err := gzip.NewReader(out)
+	require.NoError(t, err)
+	r.Multistream(false)
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "one\ntwo\n", string(b))
+	// There's a single stream, with nothing after its trailer
+	assert.Equal(t, io.EOF, r.Reset(out))
+}
+
+// sentryTransport records the events sent to Sentry.
+type sentryTransport struct {
+	events []*sentry.Event
+}
+
+func (t *sentryTransport) Flush(timeout time.Duration) bool {
+	return true
+}
+
+func (t *sentryTransport) Configure(options sentry.ClientOptions) {}
+
+func (t *sentryTransport) SendEvent(event *sentry.Event) {
+	t.events = append(t.events, event)
+}
+
+func TestSentryRateLimit(t *testing.T) {
+	transport := &sentryTransport{}
+	require.NoError(t, sentry.Init(sentry.ClientOptions{
+		Dsn:       "https://public@sentry.example.com/1",
+		Transport: transport,
+	}))
+	defer sentry.CurrentHub().BindClient(nil)
+
+	client := &MockClient{Counts: make(map[string]int64)}
+	u := &Unilog{
+		SentryDSN:       "https://public@sentry.example.com/1",
+		SentryRateLimit: 3,
+		Metrics:         client,
+	}
+	actions := []string{"reopen_file", "write_to_log", "flush"}
+	for i := 0; i < 20; i++ {
+		u.handleError(actions[i%len(actions)], errors.New("storm"))
+		// Each error is the first since the last successful write
+		u.b.broken = false
+	}
+	assert.Len(t, transport.events, 3)
+	dropped := int64(0)
+	for _, action := range actions {
+		dropped += client.Counts["[err_action:"+action+"]unilog.sentry.dropped"]
+	}
+	assert.Equal(t, int64(17), dropped)
+
+	// Once the storm is over, errors are reported again
+	u.notifiers[0].(*SentryNotifier).limit.last = time.Now().Add(-time.Minute)
+	u.handleError("write_to_log", errors.New("oops"))
+	assert.Len(t, transport.events, 4)
+}
+
+func TestMaxErrorContext(t *testing.T) {
+	transport := &sentryTransport{}
+	require.NoError(t, sentry.Init(sentry.ClientOptions{
+		Dsn:       "https://public@sentry.example.com/1",
+		Transport: transport,
+	}))
+	defer sentry.CurrentHub().BindClient(nil)
+
+	u := &Unilog{SentryDSN: "https://public@sentry.example.com/1", MaxErrorContext: 100}
+	huge := strings.Repeat("é", 1000)
+	u.handleError("write_to_log", errors.New(huge))
+
+	require.Len(t, transport.events, 1)
+	event := transport.events[0]
+	for _, context := range []string{event.Tags["Error"], event.Exception[0].Value} {
+		assert.True(t, len(context) <= 100, "%d bytes", len(context))
+		assert.True(t, strings.HasSuffix(context, "... (truncated)"))
+		assert.True(t, utf8.ValidString(context))
+		assert.True(t, strings.HasPrefix(huge, strings.TrimSuffix(context, "\n... (truncated)")))
+	}
+
+	email := EmailNotifier{}.message(u.breakContext("write_to_log", huge, nil)).String()
+	assert.Contains(t, email, "\n... (truncated)\n")
+	assert.NotContains(t, email, huge[:200])
+
+	// Short errors are left alone
+	assert.Equal(t, "disk full", u.truncateContext("disk full"))
+	assert.Contains(t, EmailNotifier{}.message((&Unilog{}).breakContext("write_to_log", huge, nil)).String(), huge)
+}
+
+func TestPanicReporting(t *testing.T) {
+	transport := &sentryTransport{}
+	require.NoError(t, sentry.Init(sentry.ClientOptions{
+		Dsn:       "https://public@sentry.example.com/1",
+		Transport: transport,
+	}))
+	defer sentry.CurrentHub().BindClient(nil)
+
+	lines := make(chan string, 1)
+	u := &Unilog{
+		SentryDSN: "https://public@sentry.example.com/1",
+		Name:      "api",
+		Filters: []Filter{FilterFunc(func(line string) string {
+			var fields map[string]string
+			fields["line"] = line
+			return line
+		})},
+		lines: lines,
+		file:  mockFile{buf: &bytes.Buffer{}},
+	}
+	lines <- "hi"
+	// The panic still crashes unilog...
+	assert.Panics(t, u.run)
+
+	// ...but is reported first, with where it happened
+	require.Len(t, transport.events, 1)
+	event := transport.events[0]
+	assert.Equal(t, sentry.LevelFatal, event.Level)
+	assert.Equal(t, panicAction, event.Tags["Action"])
+	assert.Equal(t, "api", event.Tags["Name"])
+	require.Len(t, event.Exception, 1)
+	assert.Contains(t, event.Exception[0].Value, "nil map")
+	require.NotNil(t, event.Exception[0].Stacktrace)
+	var functions []string
+	for _, frame := range event.Exception[0].Stacktrace.Frames {
+		functions = append(functions, frame.Function)
+	}
+	assert.Contains(t, strings.Join(functions, " "), "TestPanicReporting")
+}
+
+func TestFlagDefaultsKeepFields(t *testing.T) {
+	u := &Unilog{TermSignals: "TERM", SyslogFacility: "local0", MaxOpenFiles: 8, VerboseBuffer: 1024}
+	u.fillFlagDefaults()
+	assert.Equal(t, "TERM", u.TermSignals)
+	assert.Equal(t, DefaultReopenSignals, u.ReopenSignals)
+	assert.Equal(t, "local0", u.SyslogFacility)
+	assert.Equal(t, 8, u.MaxOpenFiles)
+	assert.Equal(t, 1024, u.VerboseBuffer)
+	assert.Equal(t, DefaultNotifyThrottle, u.NotifyThrottle)
+	assert.Equal(t, DefaultOTLPBatchSize, u.OTLPBatchSize)
+}
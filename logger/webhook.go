@@ -0,0 +1,62 @@
+package logger
+
+import (
+	"bytes"
+	encjson "encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// webhookTimeout bounds each webhook request, since it's sent from
+// the event loop.
+const webhookTimeout = 5 * time.Second
+
+// webhookPayload is the JSON body POSTed by a WebhookNotifier. Text is
+// a summary for Slack's incoming webhooks, which display that field;
+// the others are there for other consumers.
+type webhookPayload struct {
+	Text     string `json:"text"`
+	Hostname string `json:"hostname"`
+	Name     string `json:"name"`
+	Target   string `json:"target"`
+	Action   string `json:"action"`
+	Error    string `json:"error"`
+	Version  string `json:"version"`
+}
+
+// WebhookNotifier POSTs breakages to URL (like a Slack incoming
+// webhook) as JSON. Failing to send them is only reported on stderr
+// (with Debug), since reporting it like other errors would just try
+// the webhook again.
+type WebhookNotifier struct {
+	URL   string
+	Debug bool
+}
+
+func (n WebhookNotifier) Notify(ctx BreakContext) {
+	body, err := encjson.Marshal(webhookPayload{
+		Text:     fmt.Sprintf("unilog %s on %s could not %s: %s", ctx.Name, ctx.Hostname, ctx.Action, ctx.Error),
+		Hostname: ctx.Hostname,
+		Name:     ctx.Name,
+		Target:   ctx.Target,
+		Action:   ctx.Action,
+		Error:    ctx.Error,
+		Version:  ctx.Version,
+	})
+	if err == nil {
+		client := http.Client{Timeout: webhookTimeout}
+		var resp *http.Response
+		resp, err = client.Post(n.URL, "application/json", bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode/100 != 2 {
+				err = fmt.Errorf("webhook returned %s", resp.Status)
+			}
+		}
+	}
+	if err != nil && n.Debug {
+		fmt.Fprintf(os.Stderr, "Could not send webhook: %s\n", err)
+	}
+}
@@ -0,0 +1,65 @@
+package logger
+
+import (
+	encjson "encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhook(t *testing.T) {
+	var payloads []map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var p map[string]string
+		assert.NoError(t, encjson.NewDecoder(r.Body).Decode(&p))
+		payloads = append(payloads, p)
+	}))
+	defer srv.Close()
+
+	u := &Unilog{Name: "app", target: "/var/log/app.log", WebhookURL: srv.URL}
+	u.handleError("write_to_log", errors.New("disk on fire"))
+	require.Len(t, payloads, 1)
+	hostname, _ := os.Hostname()
+	assert.Equal(t, hostname, payloads[0]["hostname"])
+	assert.Equal(t, "app", payloads[0]["name"])
+	assert.Equal(t, "/var/log/app.log", payloads[0]["target"])
+	assert.Equal(t, "write_to_log", payloads[0]["action"])
+	assert.Equal(t, "disk on fire", payloads[0]["error"])
+	assert.Contains(t, payloads[0]["text"], "could not write_to_log: disk on fire")
+
+	// Like emails, only the first error is sent, until an hour has
+	// passed
+	u.handleError("write_to_log", errors.New("disk still on fire"))
+	assert.Len(t, payloads, 1)
+	u.b.at = time.Now().Add(-2 * time.Hour)
+	u.handleError("write_to_log", errors.New("disk still on fire"))
+	require.Len(t, payloads, 2)
+	assert.Equal(t, "disk still on fire", payloads[1]["error"])
+
+	// or writes have succeeded in the meantime
+	u.resetBreaker()
+	u.handleError("reopen_file", errors.New("no such file"))
+	assert.Len(t, payloads, 3)
+}
+
+func TestWebhookFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	// Failing to send the webhook isn't an error of its own
+	client := &MockClient{Counts: make(map[string]int64)}
+	u := &Unilog{WebhookURL: srv.URL, Metrics: client}
+	u.handleError("write_to_log", errors.New("disk on fire"))
+	assert.Equal(t, int64(1), client.Counts["[err_action:write_to_log]unilog.errors_total"])
+	assert.Zero(t, client.Counts["[err_action:webhook]unilog.errors_total"])
+	assert.Equal(t, 1, u.b.count)
+}
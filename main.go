@@ -6,15 +6,57 @@ import (
 )
 
 func main() {
+	jf := &filters.MultilineFilter{}
+	lim := &filters.RateLimitFilter{}
+	mf := &filters.MetadataFilter{}
+	ef := &filters.ErrorFilter{}
+	lf := &filters.LevelFilter{}
+	hf := &filters.HashSampleFilter{}
+	rf := &filters.KeyRedactFilter{}
+	xf := &filters.RegexReplaceFilter{}
+	af := &filters.AusterityFilter{}
+	sf := &filters.SchemaFilter{}
+	df := &filters.DedupFilter{}
+	nf := &filters.TimeNormalizeFilter{}
 	tf := &filters.TimePrefixFilter{}
+	cf := &filters.CanonicalFilter{}
+	pf := &filters.PrefixSuffixFilter{}
 	// Register flags so they're picked up when u.Main() calls flag.Parse() (ugh)
+	jf.AddFlags()
+	lim.AddFlags()
+	mf.AddFlags()
+	ef.AddFlags()
+	lf.AddFlags()
+	hf.AddFlags()
+	rf.AddFlags()
+	xf.AddFlags()
+	af.AddFlags()
+	sf.AddFlags()
+	df.AddFlags()
+	nf.AddFlags()
 	tf.AddFlags()
+	cf.AddFlags()
+	pf.AddFlags()
 
 	u := &logger.Unilog{
 		Filters: []logger.Filter{
-			logger.Filter(&filters.AusterityFilter{}),
+			logger.Filter(jf),
+			logger.Filter(lim),
+			logger.Filter(mf),
+			logger.Filter(ef),
+			logger.Filter(lf),
+			logger.Filter(hf),
+			logger.Filter(rf),
+			logger.Filter(xf),
+			logger.Filter(sf),
+			logger.Filter(af),
+			logger.Filter(df),
+			logger.Filter(nf),
 			logger.Filter(tf),
+			logger.Filter(cf),
+			logger.Filter(pf),
 		},
 	}
+	sf.DeadLetter = u.DeadLetter
 	u.Main()
 }
@@ -10,18 +10,24 @@ import (
 // up through the next newline character, and then start returning
 // EOF.
 type Reader struct {
-	inner io.Reader
+	inner    io.Reader
+	shutdown <-chan struct{}
 	// Was the last character read a newline?
 	nl           bool
 	shuttingDown bool
 	mtx          sync.Mutex
+
+	done     chan struct{}
+	doneOnce sync.Once
 }
 
 // NewReader creates a new Reader for use in Unilog. Once shutdown becomes readable, the
 // returned Reader will start reading from the underlying Reader one
-// byte at a time until newline, and then start returning EOF.
+// byte at a time until newline, and then start returning EOF. It never
+// reads past that newline, so that whatever comes after it is left in
+// the underlying Reader (e.g. a pipe) for whoever reads it next.
 func NewReader(in io.Reader, shutdown <-chan struct{}) io.Reader {
-	r := &Reader{inner: in}
+	r := &Reader{inner: in, shutdown: shutdown, done: make(chan struct{})}
 	go func() {
 		<-shutdown
 		r.mtx.Lock()
@@ -31,20 +37,54 @@ func NewReader(in io.Reader, shutdown <-chan struct{}) io.Reader {
 	return r
 }
 
+// Done returns a channel that is closed once the Reader has finished
+// draining: when it first returns EOF after shutdown became readable.
+func (r *Reader) Done() <-chan struct{} {
+	return r.done
+}
+
 func (r *Reader) isShuttingDown() bool {
 	r.mtx.Lock()
 	defer r.mtx.Unlock()
+	if !r.shuttingDown {
+		// Don't wait for the goroutine in NewReader to notice,
+		// so that a Read right after shutdown honors it.
+		select {
+		case <-r.shutdown:
+			r.shuttingDown = true
+		default:
+		}
+	}
 	return r.shuttingDown
 }
 
 func (r *Reader) Read(buf []byte) (int, error) {
-	if r.nl && r.isShuttingDown() {
+	shuttingDown := r.isShuttingDown()
+	if r.nl && shuttingDown {
+		r.drained()
 		return 0, io.EOF
 	}
+	if shuttingDown && len(buf) > 1 {
+		// A bigger read could take bytes past the newline
+		buf = buf[:1]
+	}
 
 	n, e := r.inner.Read(buf)
 	if n > 0 {
 		r.nl = buf[n-1] == '\n'
 	}
-	return n, e
+	return n, r.finish(e)
+}
+
+// finish returns e, after marking the Reader as drained if it is the
+// end of its input while shutting down.
+func (r *Reader) finish(e error) error {
+	if e == io.EOF && r.isShuttingDown() {
+		r.drained()
+	}
+	return e
+}
+
+func (r *Reader) drained() {
+	r.doneOnce.Do(func() { close(r.done) })
 }
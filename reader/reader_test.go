@@ -3,9 +3,12 @@ package reader
 import (
 	"bufio"
 	"io"
+	"io/ioutil"
 	"strings"
 	"testing"
-	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type op struct {
@@ -13,7 +16,7 @@ type op struct {
 	expect string
 }
 
-func testUnilogReader(t *testing.T) {
+func TestUnilogReader(t *testing.T) {
 	var twoLines = "hello world\nsecond line\n"
 	tests := []struct {
 		in  string
@@ -46,10 +49,6 @@ func testUnilogReader(t *testing.T) {
 		for _, o := range tc.ops {
 			if o.read < 0 {
 				close(shutdown)
-				// HACK: Wait for the shutdown to propagate.
-				for !rd.(*Reader).shuttingDown {
-					time.Sleep(1 * time.Millisecond)
-				}
 				continue
 			}
 			if len(buf) < o.read {
@@ -77,24 +76,71 @@ func testUnilogReader(t *testing.T) {
 				break
 			}
 		}
+		select {
+		case <-rd.(*Reader).Done():
+		default:
+			t.Errorf("test %d: reader isn't done after returning EOF", i)
+		}
 	}
 }
 
+func TestReaderDone(t *testing.T) {
+	shutdown := make(chan struct{})
+	rd := NewReader(strings.NewReader("a line\nanother line\n"), shutdown).(*Reader)
+	buf := make([]byte, 3)
+
+	_, err := rd.Read(buf)
+	require.NoError(t, err)
+	select {
+	case <-rd.Done():
+		t.Fatal("reader is done before shutdown")
+	default:
+	}
+
+	close(shutdown)
+	rest, err := ioutil.ReadAll(rd)
+	require.NoError(t, err)
+	assert.Equal(t, "ine\n", string(rest))
+	<-rd.Done()
+}
+
+func TestReaderLeavesRest(t *testing.T) {
+	shutdown := make(chan struct{})
+	in := strings.NewReader("hello world\nsecond line\n")
+	close(shutdown)
+	rest, err := ioutil.ReadAll(NewReader(in, shutdown))
+	require.NoError(t, err)
+	assert.Equal(t, "hello world\n", string(rest))
+
+	// The next line is still there for the next reader
+	next, err := ioutil.ReadAll(in)
+	require.NoError(t, err)
+	assert.Equal(t, "second line\n", string(next))
+}
+
+// OurReader reads what's sent on input, like a pipe would: what
+// doesn't fit in one read is left for the next.
 type OurReader struct {
 	input chan []byte
+	rest  []byte
 }
 
 func (or *OurReader) Read(p []byte) (n int, err error) {
-	bs, ok := <-or.input
-	if !ok {
-		return 0, io.EOF
+	if len(or.rest) == 0 {
+		bs, ok := <-or.input
+		if !ok {
+			return 0, io.EOF
+		}
+		or.rest = bs
 	}
-	return copy(p, bs), nil
+	n = copy(p, or.rest)
+	or.rest = or.rest[n:]
+	return n, nil
 }
 
 func TestReaderBasic(t *testing.T) {
 	input := make(chan []byte, 1)
-	inRdr := OurReader{input}
+	inRdr := OurReader{input: input}
 	shutdown := make(chan struct{}, 1)
 	close(shutdown)
 	rdr := NewReader(&inRdr, shutdown)
@@ -111,7 +157,7 @@ func TestReaderBasic(t *testing.T) {
 
 func TestReaderComplex(t *testing.T) {
 	input := make(chan []byte)
-	inRdr := OurReader{input}
+	inRdr := OurReader{input: input}
 	shutdown := make(chan struct{})
 	close(shutdown)
 	rdr := NewReader(&inRdr, shutdown)
@@ -0,0 +1,3 @@
+// Package unilogtest provides helpers for testing unilog filters
+// against the real logging pipeline.
+package unilogtest
@@ -0,0 +1,75 @@
+package unilogtest
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/stripe/unilog/logger"
+)
+
+// Result holds everything a pipeline run produced.
+type Result struct {
+	// Output is everything written to the log target.
+	Output string
+	// Metrics holds the statsd datagrams (e.g.
+	// "unilog.bytes:5|c|@0.100000") emitted during the run, in
+	// the order they were received.
+	Metrics []string
+}
+
+// metricsWait is how long to wait for stray statsd datagrams once the
+// pipeline run is over.
+const metricsWait = 50 * time.Millisecond
+
+type sink struct {
+	bytes.Buffer
+}
+
+func (*sink) Close() error {
+	return nil
+}
+
+// Run feeds input through u (applying its filters, JSON handling and
+// so on) and returns what it wrote. While the run is in progress, u's
+// Metrics client is pointed at an in-memory statsd server, whose
+// datagrams are returned in the Result.
+func Run(u *logger.Unilog, input string) (*Result, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	client, err := statsd.New(conn.LocalAddr().String())
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	metrics := make(chan []string)
+	go func() {
+		var received []string
+		buf := make([]byte, statsd.MaxUDPPayloadSize)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				break
+			}
+			received = append(received, strings.Split(string(buf[:n]), "\n")...)
+		}
+		metrics <- received
+	}()
+
+	oldMetrics := u.Metrics
+	u.Metrics = client
+	defer func() { u.Metrics = oldMetrics }()
+
+	out := &sink{}
+	u.RunPipeline(strings.NewReader(input), out)
+
+	conn.SetReadDeadline(time.Now().Add(metricsWait))
+	return &Result{Output: out.String(), Metrics: <-metrics}, nil
+}
@@ -0,0 +1,83 @@
+package unilogtest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/unilog/json"
+	"github.com/stripe/unilog/logger"
+)
+
+type upcaseFilter struct{}
+
+func (upcaseFilter) FilterLine(line string) string {
+	return strings.ToUpper(line)
+}
+
+func (upcaseFilter) FilterJSON(line *json.LogLine) {
+	(*line)["message"] = strings.ToUpper((*line)["message"].(string))
+}
+
+func TestRun(t *testing.T) {
+	u := &logger.Unilog{Filters: []logger.Filter{upcaseFilter{}}}
+	res, err := Run(u, "hi\nthere\n")
+	require.NoError(t, err)
+	assert.Equal(t, "HI\nTHERE\n", res.Output)
+}
+
+func TestRunJSON(t *testing.T) {
+	u := &logger.Unilog{JSON: true, Filters: []logger.Filter{upcaseFilter{}}}
+	res, err := Run(u, `{"message":"hi"}`)
+	require.NoError(t, err)
+	assert.Regexp(t, `^\{"timestamp":[\d\.]+,"message":"HI"}\n$`, res.Output)
+}
+
+func TestRunMetrics(t *testing.T) {
+	u := &logger.Unilog{SanitizeUTF8: true}
+	res, err := Run(u, "caf\xe9\n")
+	require.NoError(t, err)
+	assert.Equal(t, "caf\uFFFD\n", res.Output)
+	assert.Contains(t, res.Metrics, "unilog.invalid_utf8:1|c|#action:replace")
+}
+
+func TestRunWriteDuration(t *testing.T) {
+	u := &logger.Unilog{WriteTimingRate: 1}
+	res, err := Run(u, "hi\n")
+	require.NoError(t, err)
+
+	var found bool
+	for _, m := range res.Metrics {
+		if strings.HasPrefix(m, "unilog.write.duration:") {
+			found = true
+		}
+	}
+	assert.True(t, found, "no unilog.write.duration metric in %v", res.Metrics)
+}
+
+func TestRunModeMetrics(t *testing.T) {
+	// unilog.lines_total and unilog.bytes are sampled at 10%, so use
+	// enough lines that each mode is all but certain to show up.
+	var input strings.Builder
+	for i := 0; i < 200; i++ {
+		input.WriteString(`{"message":"hi"}` + "\n")
+		input.WriteString("not json\n")
+	}
+	u := &logger.Unilog{JSON: true}
+	res, err := Run(u, input.String())
+	require.NoError(t, err)
+
+	modes := map[string]bool{}
+	for _, m := range res.Metrics {
+		for _, mode := range []string{"json", "text", "fallback"} {
+			if strings.HasPrefix(m, "unilog.lines_total:") && strings.HasSuffix(m, "#mode:"+mode) {
+				modes["lines_total:"+mode] = true
+			}
+			if strings.HasPrefix(m, "unilog.bytes:") && strings.HasSuffix(m, "#mode:"+mode) {
+				modes["bytes:"+mode] = true
+			}
+		}
+	}
+	assert.Equal(t, map[string]bool{"lines_total:json": true, "lines_total:fallback": true, "bytes:json": true}, modes)
+}